@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/connorturlan/petrol-price-api/petrolapi"
+)
+
+const (
+	defaultCheapestLimit int     = 10
+	defaultRadiusKm      float64 = 5
+
+	// maxNearbySites is the most sites /graphql returns around a location.
+	maxNearbySites int = 100
+)
+
+// CheapestQuery picks the stations to rank, either in a postcode or within a radius of a
+// location.
+type CheapestQuery struct {
+	FuelId   int
+	Postcode string
+	Near     bool
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+	Limit    int
+}
+
+// Station is a station in the cheapest list. the distance is only set for queries near a
+// location.
+type Station struct {
+	Rank               int      `json:"Rank"`
+	SiteId             int      `json:"SiteId"`
+	Name               string   `json:"Name"`
+	Address            string   `json:"Address"`
+	Postcode           string   `json:"Postcode"`
+	Brand              string   `json:"Brand"`
+	Price              int      `json:"Price"`
+	TransactionDateUTC string   `json:"TransactionDateUTC"`
+	DistanceKm         *float64 `json:"DistanceKm,omitempty"`
+}
+
+// backend is where the commands read from, either the http api or the tables behind it.
+type backend interface {
+	Sites(ctx context.Context) ([]petrolapi.Site, error)
+	Cheapest(ctx context.Context, query CheapestQuery) ([]Station, error)
+	History(ctx context.Context, options petrolapi.HistoryOptions) ([]petrolapi.DailyPrice, error)
+	UpdateStatus(ctx context.Context) (*petrolapi.UpdateStatus, error)
+	UpdateRuns(ctx context.Context, limit int) ([]petrolapi.UpdateRun, error)
+	TriggerUpdate(ctx context.Context, target string) (*petrolapi.UpdateRun, error)
+}
+
+// rankStations orders the stations cheapest first, then nearest and then by site id, and keeps
+// up to limit of them. stations with the same price share a rank.
+func rankStations(stations []Station, limit int) []Station {
+	distance := func(station Station) float64 {
+		if station.DistanceKm == nil {
+			return 0
+		}
+		return *station.DistanceKm
+	}
+	sort.Slice(stations, func(i, j int) bool {
+		a, b := stations[i], stations[j]
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+		if distance(a) != distance(b) {
+			return distance(a) < distance(b)
+		}
+		return a.SiteId < b.SiteId
+	})
+
+	if len(stations) > limit {
+		stations = stations[:limit]
+	}
+	for n := range stations {
+		stations[n].Rank = n + 1
+		if n > 0 && stations[n].Price == stations[n-1].Price {
+			stations[n].Rank = stations[n-1].Rank
+		}
+	}
+	return stations
+}
+
+// apiBackend reads through the http api.
+type apiBackend struct {
+	client *petrolapi.Client
+}
+
+// apiBackend.Sites returns every site.
+func (api apiBackend) Sites(ctx context.Context) ([]petrolapi.Site, error) {
+	return api.client.Sites(ctx)
+}
+
+// apiBackend.Cheapest asks /cheapest for the stations in a postcode. near a location, it ranks the
+// current prices of the nearest sites /graphql returns.
+func (api apiBackend) Cheapest(ctx context.Context, query CheapestQuery) ([]Station, error) {
+	stations := []Station{}
+	if !query.Near {
+		list, err := api.client.Cheapest(ctx, petrolapi.CheapestOptions{FuelId: query.FuelId, Postcode: query.Postcode, Limit: query.Limit})
+		if err != nil {
+			return nil, err
+		}
+		for _, ranked := range list.Stations {
+			stations = append(stations, Station{
+				Rank:               ranked.Rank,
+				SiteId:             ranked.SiteId,
+				Name:               ranked.Name,
+				Address:            ranked.Address,
+				Postcode:           ranked.Postcode,
+				Brand:              ranked.Brand,
+				Price:              ranked.Price,
+				TransactionDateUTC: ranked.TransactionDateUTC,
+			})
+		}
+		return stations, nil
+	}
+
+	sites, err := api.client.NearbyPrices(ctx, petrolapi.NearbyOptions{
+		Lat:      query.Lat,
+		Lng:      query.Lng,
+		RadiusKm: query.RadiusKm,
+		FuelId:   query.FuelId,
+		Limit:    maxNearbySites,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, site := range sites {
+		for _, price := range site.Prices {
+			if price.FuelId != query.FuelId || !price.Current {
+				continue
+			}
+			distanceKm := site.DistanceKm
+			stations = append(stations, Station{
+				SiteId:             site.SiteId,
+				Name:               site.Name,
+				Address:            site.Address,
+				Postcode:           site.Postcode,
+				Brand:              site.Brand,
+				Price:              price.Price,
+				TransactionDateUTC: price.TransactionDateUTC,
+				DistanceKm:         &distanceKm,
+			})
+		}
+	}
+	return rankStations(stations, query.Limit), nil
+}
+
+// apiBackend.History returns the daily prices of a fuel type in a region.
+func (api apiBackend) History(ctx context.Context, options petrolapi.HistoryOptions) ([]petrolapi.DailyPrice, error) {
+	return api.client.History(ctx, options)
+}
+
+// apiBackend.UpdateStatus returns the latest and last successful update runs.
+func (api apiBackend) UpdateStatus(ctx context.Context) (*petrolapi.UpdateStatus, error) {
+	return api.client.UpdateStatus(ctx)
+}
+
+// apiBackend.UpdateRuns returns the most recent update runs.
+func (api apiBackend) UpdateRuns(ctx context.Context, limit int) ([]petrolapi.UpdateRun, error) {
+	return api.client.UpdateRuns(ctx, limit)
+}
+
+// apiBackend.TriggerUpdate runs a manual update, which refreshes whatever the update lambda is
+// configured to. the target can only be picked when invoking the lambda directly.
+func (api apiBackend) TriggerUpdate(ctx context.Context, target string) (*petrolapi.UpdateRun, error) {
+	if target != "" {
+		return nil, errors.New("an update target can only be picked with -store")
+	}
+	return api.client.TriggerUpdate(ctx)
+}
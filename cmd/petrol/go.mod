@@ -0,0 +1,12 @@
+module github.com/connorturlan/petrol-price-api/cmd/petrol
+
+go 1.22.0
+
+require (
+	github.com/aws/aws-sdk-go v1.50.30
+	github.com/connorturlan/petrol-price-api/petrolapi v0.0.0-00010101000000-000000000000
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+replace github.com/connorturlan/petrol-price-api/petrolapi => ../../pkg
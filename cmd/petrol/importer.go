@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/connorturlan/petrol-price-api/petrolapi"
+)
+
+const (
+	// transactionDateLayout is how the FuelPrice model holds a transaction date, always in utc.
+	transactionDateLayout string = "2006-01-02T15:04:05"
+
+	rejectColumns     string = "wrong number of columns"
+	rejectMalformed   string = "malformed csv"
+	rejectSite        string = "invalid site id"
+	rejectUnknownSite string = "unknown site"
+	rejectNoRegion    string = "site has no region"
+	rejectFuel        string = "unknown fuel type"
+	rejectTimestamp   string = "invalid timestamp"
+	rejectPrice       string = "invalid price"
+	rejectConflict    string = "conflicting duplicate"
+)
+
+// exportTimeLayouts are the timestamp formats the exports have used, which are all in utc.
+var exportTimeLayouts = []string{
+	transactionDateLayout,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2/1/2006 15:04:05",
+	"2/1/2006 15:04",
+}
+
+// exportColumns are the header names each needed column of an export goes by, compared ignoring
+// case, spaces and underscores. any other columns are ignored.
+var exportColumns = map[string][]string{
+	"site":      {"siteid"},
+	"fuel":      {"fuelid", "fueltype", "fuel"},
+	"timestamp": {"transactiondateutc", "timestamp"},
+	"price":     {"price"},
+}
+
+// FuelPrice is the price of a fuel type at a site, the same model the lambdas use. prices are in
+// tenths of a cent.
+type FuelPrice struct {
+	FuelID             int    `json:"FuelId"`
+	CollectionMethod   string `json:"CollectionMethod"`
+	TransactionDateUTC string `json:"TransactionDateUTC"`
+	Price              int    `json:"Price"`
+}
+
+// importedPrice is a row of an export, normalised to the site and its price.
+type importedPrice struct {
+	SiteId int
+	At     time.Time
+	Price  FuelPrice
+}
+
+// RejectedRow is a row of an export that couldn't be imported, and why.
+type RejectedRow struct {
+	File   string   `json:"File"`
+	Line   int      `json:"Line"`
+	Reason string   `json:"Reason"`
+	Record []string `json:"Record"`
+}
+
+// ImportReport summarises an import. duplicates are rows repeating an earlier row exactly, which
+// overlapping exports have, and are skipped without being rejected.
+type ImportReport struct {
+	Files            int            `json:"Files"`
+	Rows             int            `json:"Rows"`
+	Accepted         int            `json:"Accepted"`
+	Duplicates       int            `json:"Duplicates"`
+	Rejected         int            `json:"Rejected"`
+	RejectedByReason map[string]int `json:"RejectedByReason"`
+	Days             int            `json:"Days"`
+	Records          int            `json:"Records"`
+	Resumed          int            `json:"Resumed"`
+	Written          int            `json:"Written"`
+}
+
+// importState is the progress of an import, saved after every batch so an interrupted import can
+// carry on from where it stopped. the fingerprint ties it to the exports and dates it was for.
+type importState struct {
+	Fingerprint string `json:"Fingerprint"`
+	Written     int    `json:"Written"`
+}
+
+// priceKey identifies a price change, to find the rows repeated across exports.
+type priceKey struct {
+	siteId int
+	fuelId int
+	at     int64
+}
+
+// exportParser reads exports into normalised prices, checking their sites and fuel types against
+// the ones the store knows.
+type exportParser struct {
+	fuelIds   map[int]bool
+	fuelNames map[string]int
+	regions   map[int]int
+
+	prices   []importedPrice
+	seen     map[priceKey]int
+	rejected []RejectedRow
+	report   *ImportReport
+	hash     hash.Hash
+}
+
+// newExportParser returns a parser for the fuel type names and the region of each site.
+func newExportParser(fuels map[int]string, regions map[int]int) *exportParser {
+	parser := &exportParser{
+		fuelIds:   map[int]bool{},
+		fuelNames: map[string]int{},
+		regions:   regions,
+		seen:      map[priceKey]int{},
+		report:    &ImportReport{RejectedByReason: map[string]int{}},
+		hash:      sha256.New(),
+	}
+	for id, name := range fuels {
+		parser.fuelIds[id] = true
+		parser.fuelNames[strings.ToLower(name)] = id
+	}
+	return parser
+}
+
+// normaliseColumn strips a header name down to what exportColumns lists.
+func normaliseColumn(name string) string {
+	name = strings.TrimPrefix(name, "\ufeff")
+	return strings.NewReplacer("_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// findColumns returns the index of each needed column in the header.
+func findColumns(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for n, name := range header {
+		name = normaliseColumn(name)
+		for column, aliases := range exportColumns {
+			for _, alias := range aliases {
+				if _, found := columns[column]; !found && name == alias {
+					columns[column] = n
+				}
+			}
+		}
+	}
+
+	for _, column := range []string{"site", "fuel", "timestamp", "price"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("the header has no %s column, expected one of %v", column, exportColumns[column])
+		}
+	}
+	return columns, nil
+}
+
+// exportParser.normalise reads a row into a price, or returns why it was rejected.
+func (parser *exportParser) normalise(record []string, columns map[string]int) (importedPrice, string) {
+	var price importedPrice
+	field := func(column string) string { return strings.TrimSpace(record[columns[column]]) }
+
+	siteId, err := strconv.Atoi(field("site"))
+	if err != nil || siteId <= 0 {
+		return price, rejectSite
+	}
+	regionId, ok := parser.regions[siteId]
+	if !ok {
+		return price, rejectUnknownSite
+	}
+	if regionId == 0 {
+		return price, rejectNoRegion
+	}
+
+	// fuels are named in some exports and numbered in others.
+	fuelId, err := strconv.Atoi(field("fuel"))
+	if err != nil {
+		fuelId, ok = parser.fuelNames[strings.ToLower(field("fuel"))]
+		if !ok {
+			return price, rejectFuel
+		}
+	} else if len(parser.fuelIds) > 0 && !parser.fuelIds[fuelId] {
+		return price, rejectFuel
+	}
+
+	var at time.Time
+	for _, layout := range exportTimeLayouts {
+		at, err = time.ParseInLocation(layout, field("timestamp"), time.UTC)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return price, rejectTimestamp
+	}
+
+	// the exports are in cents per litre, and the model in tenths of a cent.
+	centsPerLitre, err := strconv.ParseFloat(field("price"), 64)
+	tenths := int(math.Round(centsPerLitre * 10))
+	if err != nil || math.IsNaN(centsPerLitre) || math.IsInf(centsPerLitre, 0) || tenths <= 0 || tenths > placeholderPrice {
+		return price, rejectPrice
+	}
+
+	return importedPrice{
+		SiteId: siteId,
+		At:     at.UTC(),
+		Price: FuelPrice{
+			FuelID:             fuelId,
+			TransactionDateUTC: at.UTC().Format(transactionDateLayout),
+			Price:              tenths,
+		},
+	}, ""
+}
+
+// exportParser.reject records a rejected row.
+func (parser *exportParser) reject(name string, line int, reason string, record []string) {
+	parser.rejected = append(parser.rejected, RejectedRow{File: name, Line: line, Reason: reason, Record: record})
+	parser.report.Rejected++
+	parser.report.RejectedByReason[reason]++
+}
+
+// exportParser.parse reads the rows of an export. rows that can't be imported are rejected, and
+// only a missing or unreadable header fails the whole file.
+func (parser *exportParser) parse(name string, in io.Reader) error {
+	parser.report.Files++
+	fmt.Fprintf(parser.hash, "%s\n", name)
+
+	reader := csv.NewReader(io.TeeReader(in, parser.hash))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	columns, err := findColumns(header)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			parser.report.Rows++
+			parser.reject(name, parseErr.StartLine, rejectMalformed, record)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		parser.report.Rows++
+		line, _ := reader.FieldPos(0)
+
+		if len(record) != len(header) {
+			parser.reject(name, line, rejectColumns, record)
+			continue
+		}
+		price, reason := parser.normalise(record, columns)
+		if reason != "" {
+			parser.reject(name, line, reason, record)
+			continue
+		}
+
+		key := priceKey{siteId: price.SiteId, fuelId: price.Price.FuelID, at: price.At.Unix()}
+		if n, ok := parser.seen[key]; ok {
+			if parser.prices[n].Price.Price != price.Price.Price {
+				parser.reject(name, line, rejectConflict, record)
+				continue
+			}
+			parser.report.Duplicates++
+			continue
+		}
+		parser.seen[key] = len(parser.prices)
+		parser.prices = append(parser.prices, price)
+		parser.report.Accepted++
+	}
+}
+
+// exportParser.fingerprint identifies the exports parsed so far and the days picked from them.
+func (parser *exportParser) fingerprint(from string, until string) string {
+	fmt.Fprintf(parser.hash, "%s\n%s\n", from, until)
+	return hex.EncodeToString(parser.hash.Sum(nil))
+}
+
+// summariseDay summarises the prices in effect at each site by fuel type and the region of their
+// site, the same way the update lambda does. placeholder prices are left out.
+func summariseDay(current map[[2]int]int, regions map[int]int, date string) []petrolapi.DailyPrice {
+	groups := map[[2]int][]int{}
+	for key, price := range current {
+		if price <= 0 || price >= placeholderPrice {
+			continue
+		}
+		group := [2]int{key[1], regions[key[0]]}
+		groups[group] = append(groups[group], price)
+	}
+
+	days := []petrolapi.DailyPrice{}
+	for key, groupPrices := range groups {
+		sort.Ints(groupPrices)
+
+		total := 0
+		for _, price := range groupPrices {
+			total += price
+		}
+
+		middle := len(groupPrices) / 2
+		median := float64(groupPrices[middle])
+		if len(groupPrices)%2 == 0 {
+			median = float64(groupPrices[middle-1]+groupPrices[middle]) / 2
+		}
+
+		days = append(days, petrolapi.DailyPrice{
+			FuelId:   key[0],
+			RegionId: key[1],
+			Date:     date,
+			Min:      groupPrices[0],
+			Median:   median,
+			Mean:     float64(total) / float64(len(groupPrices)),
+			Count:    len(groupPrices),
+		})
+	}
+
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].FuelId != days[j].FuelId {
+			return days[i].FuelId < days[j].FuelId
+		}
+		return days[i].RegionId < days[j].RegionId
+	})
+	return days
+}
+
+// historyFromPrices replays the price changes and summarises the prices in effect at the end of
+// each day, from the first day with a price to the last, ordered by day. a site's price carries
+// over until it next changes, so days before from are replayed but not returned. either date can
+// be empty to leave that end open.
+func historyFromPrices(prices []importedPrice, regions map[int]int, from string, until string) []petrolapi.DailyPrice {
+	history := []petrolapi.DailyPrice{}
+	if len(prices) == 0 {
+		return history
+	}
+
+	prices = append([]importedPrice{}, prices...)
+	sort.SliceStable(prices, func(i, j int) bool { return prices[i].At.Before(prices[j].At) })
+
+	first := prices[0].At.In(historyLocation)
+	last := prices[len(prices)-1].At.In(historyLocation).Format(historyDateLayout)
+	if until != "" && until < last {
+		last = until
+	}
+
+	current := map[[2]int]int{}
+	next := 0
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, historyLocation); ; day = day.AddDate(0, 0, 1) {
+		date := day.Format(historyDateLayout)
+		if date > last {
+			return history
+		}
+
+		end := day.AddDate(0, 0, 1)
+		for ; next < len(prices) && prices[next].At.Before(end); next++ {
+			current[[2]int{prices[next].SiteId, prices[next].Price.FuelID}] = prices[next].Price.Price
+		}
+		if from == "" || date >= from {
+			history = append(history, summariseDay(current, regions, date)...)
+		}
+	}
+}
+
+// marshalDailyPrice returns the history table's record of the day.
+func marshalDailyPrice(price petrolapi.DailyPrice) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Series": {S: aws.String(fmt.Sprintf("%d/%d", price.FuelId, price.RegionId))},
+		"Date":   {S: aws.String(price.Date)},
+		"FuelId": {N: aws.String(strconv.Itoa(price.FuelId))},
+		"Region": {N: aws.String(strconv.Itoa(price.RegionId))},
+		"Min":    {N: aws.String(strconv.Itoa(price.Min))},
+		"Median": {N: aws.String(strconv.FormatFloat(price.Median, 'f', -1, 64))},
+		"Mean":   {N: aws.String(strconv.FormatFloat(math.Round(price.Mean*100)/100, 'f', -1, 64))},
+		"Count":  {N: aws.String(strconv.Itoa(price.Count))},
+	}
+}
+
+// loadImportState reads the progress of an earlier import of the same exports. a missing file is
+// a fresh import, and a file for other exports or dates is an error rather than being ignored.
+func loadImportState(path string, fingerprint string) (importState, error) {
+	state := importState{Fingerprint: fingerprint}
+	if path == "" {
+		return state, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	var saved importState
+	err = json.Unmarshal(raw, &saved)
+	if err != nil {
+		return state, fmt.Errorf("%s: %w", path, err)
+	}
+	if saved.Fingerprint != fingerprint {
+		return state, fmt.Errorf("%s is the progress of other exports or dates, remove it to start over", path)
+	}
+	return saved, nil
+}
+
+// saveImportState writes the progress, replacing the file only once it is complete.
+func saveImportState(path string, state importState) error {
+	if path == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".tmp", raw, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// writeRejectedRows writes the rejected rows as csv, with the file, line and reason ahead of the
+// row's own fields.
+func writeRejectedRows(out io.Writer, rows []RejectedRow) error {
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"File", "Line", "Reason", "Record"})
+	for _, row := range rows {
+		writer.Write(append([]string{row.File, strconv.Itoa(row.Line), row.Reason}, row.Record...))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// storeBackend.siteRegions returns the region of every site, zero for the sites without one.
+func (store *storeBackend) siteRegions(ctx context.Context) (map[int]int, error) {
+	sites, err := store.sites(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := map[int]int{}
+	for _, site := range sites {
+		regions[site.SiteId] = site.RegionId
+	}
+	return regions, nil
+}
+
+// storeBackend.createHistoryTable creates the history table the way the update lambda does, if it
+// doesn't exist yet.
+func (store *storeBackend) createHistoryTable(ctx context.Context) error {
+	_, err := store.db.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(historyTableName)})
+	if !isMissingTable(err) {
+		return err
+	}
+
+	_, err = store.db.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(historyTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("Series"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("Date"), AttributeType: aws.String("S")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Series"), KeyType: aws.String("HASH")},
+			{AttributeName: aws.String("Date"), KeyType: aws.String("RANGE")},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return store.db.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(historyTableName)})
+}
+
+// storeBackend.writeHistory writes the days to the history table in batches, saving the progress
+// after each one. writes already in the state are skipped, and any day written twice is just
+// overwritten with the same record.
+func (store *storeBackend) writeHistory(ctx context.Context, history []petrolapi.DailyPrice, statePath string, state importState, report *ImportReport) error {
+	err := store.createHistoryTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	report.Resumed = min(state.Written, len(history))
+	for start := report.Resumed; start < len(history); start += writeBatchSize {
+		end := min(start+writeBatchSize, len(history))
+
+		items := []map[string]*dynamodb.AttributeValue{}
+		for _, day := range history[start:end] {
+			items = append(items, marshalDailyPrice(day))
+		}
+		err := writeBatches(ctx, store.db, historyTableName, items)
+		if err != nil {
+			return err
+		}
+
+		report.Written += len(items)
+		state.Written = end
+		err = saveImportState(statePath, state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/connorturlan/petrol-price-api/petrolapi"
+)
+
+func TestParseExport(t *testing.T) {
+	export := "\ufeffSiteId,Site_Name,Fuel_Type,Price,TransactionDateutc\n" +
+		"1,City,Unleaded,189.9,09/05/2024 01:00\n" + // 2
+		"2,Norwood,2,185.4,2024-05-09T02:30:00\n" + // 3
+		"99,Unknown,Unleaded,189.9,09/05/2024 01:00\n" + // 4
+		"3,Nowhere,Unleaded,189.9,09/05/2024 01:00\n" + // 5
+		"1,City,Hydrogen,189.9,09/05/2024 01:00\n" + // 6
+		"1,City,Unleaded,189.9,yesterday\n" + // 7
+		"1,City,Unleaded,free,09/05/2024 02:00\n" + // 8
+		"1,City,Unleaded,0,09/05/2024 02:00\n" + // 9
+		"1,City,unleaded,189.9,2024-05-09 01:00:00\n" + // 10, the same as line 2
+		"1,City,Unleaded,179.9,09/05/2024 01:00\n" + // 11
+		"1,City,Unleaded\n" + // 12
+		"1,\"City\"x,Unleaded,189.9,09/05/2024 01:00\n" + // 13
+		"2,Norwood,2,999.9,10/05/2024 02:00\n" // 14
+	parser := newExportParser(map[int]string{2: "Unleaded", 5: "Premium 95"}, map[int]int{1: 7, 2: 7, 3: 0})
+
+	err := parser.parse("2024-q2.csv", strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := parser.report
+	if report.Files != 1 || report.Rows != 13 || report.Accepted != 3 || report.Duplicates != 1 || report.Rejected != 9 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	expectedRejections := map[int]string{
+		4: rejectUnknownSite, 5: rejectNoRegion, 6: rejectFuel, 7: rejectTimestamp, 8: rejectPrice,
+		9: rejectPrice, 11: rejectConflict, 12: rejectColumns, 13: rejectMalformed,
+	}
+	for _, row := range parser.rejected {
+		if expectedRejections[row.Line] != row.Reason || row.File != "2024-q2.csv" {
+			t.Errorf("unexpected rejection of line %d: %s", row.Line, row.Reason)
+		}
+		delete(expectedRejections, row.Line)
+	}
+	if len(expectedRejections) > 0 {
+		t.Errorf("expected rejections weren't made: %v", expectedRejections)
+	}
+
+	expected := []importedPrice{
+		{SiteId: 1, At: time.Date(2024, 5, 9, 1, 0, 0, 0, time.UTC), Price: FuelPrice{FuelID: 2, TransactionDateUTC: "2024-05-09T01:00:00", Price: 1899}},
+		{SiteId: 2, At: time.Date(2024, 5, 9, 2, 30, 0, 0, time.UTC), Price: FuelPrice{FuelID: 2, TransactionDateUTC: "2024-05-09T02:30:00", Price: 1854}},
+		{SiteId: 2, At: time.Date(2024, 5, 10, 2, 0, 0, 0, time.UTC), Price: FuelPrice{FuelID: 2, TransactionDateUTC: "2024-05-10T02:00:00", Price: placeholderPrice}},
+	}
+	if len(parser.prices) != len(expected) {
+		t.Fatalf("expected %d prices, got %+v", len(expected), parser.prices)
+	}
+	for n, price := range parser.prices {
+		if price.SiteId != expected[n].SiteId || !price.At.Equal(expected[n].At) || price.Price != expected[n].Price {
+			t.Errorf("expected %+v, got %+v", expected[n], price)
+		}
+	}
+}
+
+func TestParseExportHeader(t *testing.T) {
+	parser := newExportParser(nil, map[int]int{1: 7})
+	err := parser.parse("prices.csv", strings.NewReader("SiteId,FuelId,Timestamp\n1,2,2024-05-09T01:00:00\n"))
+	if err == nil || !strings.Contains(err.Error(), "no price column") {
+		t.Errorf("expected the missing column in the error, got %v", err)
+	}
+
+	// without any fuel names loaded, numbered fuels are taken as they are.
+	err = parser.parse("prices.csv", strings.NewReader("Site Id,Fuel Id,Timestamp,Price\n1,2,2024-05-09T01:00:00,189.9\n"))
+	if err != nil || parser.report.Accepted != 1 {
+		t.Errorf("expected the row to be accepted, got %v and %+v", err, parser.report)
+	}
+}
+
+func TestHistoryFromPrices(t *testing.T) {
+	regions := map[int]int{1: 7, 2: 7, 3: 8}
+	at := func(raw string) time.Time {
+		date, _ := time.Parse(time.RFC3339, raw)
+		return date
+	}
+	prices := []importedPrice{
+		// 23:30 utc on the 8th is the morning of the 9th in adelaide.
+		{SiteId: 2, At: at("2024-05-08T23:30:00Z"), Price: FuelPrice{FuelID: 2, Price: 1900}},
+		{SiteId: 1, At: at("2024-05-07T01:00:00Z"), Price: FuelPrice{FuelID: 2, Price: 1800}},
+		{SiteId: 3, At: at("2024-05-07T02:00:00Z"), Price: FuelPrice{FuelID: 2, Price: 1750}},
+		{SiteId: 1, At: at("2024-05-10T01:00:00Z"), Price: FuelPrice{FuelID: 2, Price: placeholderPrice}},
+		{SiteId: 1, At: at("2024-05-12T01:00:00Z"), Price: FuelPrice{FuelID: 2, Price: 1700}},
+	}
+
+	history := historyFromPrices(prices, regions, "2024-05-08", "2024-05-10")
+	expected := []petrolapi.DailyPrice{
+		{FuelId: 2, RegionId: 7, Date: "2024-05-08", Min: 1800, Median: 1800, Mean: 1800, Count: 1},
+		{FuelId: 2, RegionId: 8, Date: "2024-05-08", Min: 1750, Median: 1750, Mean: 1750, Count: 1},
+		{FuelId: 2, RegionId: 7, Date: "2024-05-09", Min: 1800, Median: 1850, Mean: 1850, Count: 2},
+		{FuelId: 2, RegionId: 8, Date: "2024-05-09", Min: 1750, Median: 1750, Mean: 1750, Count: 1},
+		{FuelId: 2, RegionId: 7, Date: "2024-05-10", Min: 1900, Median: 1900, Mean: 1900, Count: 1},
+		{FuelId: 2, RegionId: 8, Date: "2024-05-10", Min: 1750, Median: 1750, Mean: 1750, Count: 1},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d days, got %+v", len(expected), history)
+	}
+	for n, day := range history {
+		if day != expected[n] {
+			t.Errorf("expected %+v, got %+v", expected[n], day)
+		}
+	}
+
+	// without dates, it runs from the first price to the last.
+	history = historyFromPrices(prices, regions, "", "")
+	if history[0].Date != "2024-05-07" || history[len(history)-1].Date != "2024-05-12" || history[len(history)-2].Min != 1700 {
+		t.Errorf("unexpected history: %+v", history)
+	}
+}
+
+func TestMarshalDailyPrice(t *testing.T) {
+	item := marshalDailyPrice(petrolapi.DailyPrice{FuelId: 2, RegionId: 7, Date: "2024-05-09", Min: 1799, Median: 1849.5, Mean: 1843.6666666, Count: 3})
+	expected := map[string]string{"Series": "2/7", "Date": "2024-05-09", "Median": "1849.5", "Mean": "1843.67", "Count": "3"}
+	for name, value := range expected {
+		actual := aws.StringValue(item[name].S)
+		if item[name].N != nil {
+			actual = aws.StringValue(item[name].N)
+		}
+		if actual != value {
+			t.Errorf("expected %s to be %s, got %s", name, value, actual)
+		}
+	}
+}
+
+func TestImportState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "import.json")
+
+	state, err := loadImportState(path, "a")
+	if err != nil || state.Written != 0 || state.Fingerprint != "a" {
+		t.Fatalf("expected a fresh import, got %+v and %v", state, err)
+	}
+
+	err = saveImportState(path, importState{Fingerprint: "a", Written: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err = loadImportState(path, "a")
+	if err != nil || state.Written != 50 {
+		t.Errorf("expected to resume after 50 records, got %+v and %v", state, err)
+	}
+
+	_, err = loadImportState(path, "b")
+	if err == nil {
+		t.Error("expected the progress of other exports to be refused")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(export string, from string) string {
+		parser := newExportParser(nil, map[int]int{1: 7})
+		parser.parse("prices.csv", strings.NewReader(export))
+		return parser.fingerprint(from, "2024-05-10")
+	}
+
+	export := "SiteId,FuelId,Timestamp,Price\n1,2,2024-05-09T01:00:00,189.9\n"
+	if fingerprint(export, "") != fingerprint(export, "") {
+		t.Error("expected the same exports to have the same fingerprint")
+	}
+	if fingerprint(export, "") == fingerprint(export, "2024-05-01") || fingerprint(export, "") == fingerprint(export+"1,2,2024-05-10T01:00:00,179.9\n", "") {
+		t.Error("expected other exports or dates to have another fingerprint")
+	}
+}
+
+func TestWriteRejectedRows(t *testing.T) {
+	var out bytes.Buffer
+	err := writeRejectedRows(&out, []RejectedRow{{File: "a.csv", Line: 4, Reason: rejectPrice, Record: []string{"1", "Unleaded", "free"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "File,Line,Reason,Record\na.csv,4,invalid price,1,Unleaded,free\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,608 @@
+// petrol queries and administers the petrol price api, either through the http api or directly
+// against its dynamodb tables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connorturlan/petrol-price-api/petrolapi"
+)
+
+const (
+	storeDynamoDB string = "dynamodb"
+	defaultRegion string = "ap-southeast-2"
+)
+
+const usage string = `usage: petrol [flags] <command> [command flags]
+
+commands:
+  cheapest -fuel <id> (-postcode <postcode> | -lat <lat> -lng <lng> [-radius <km>]) [-limit <n>]
+  sites
+  history -fuel <id> -region <id> [-days <n>]
+  update status
+  update runs [-limit <n>]
+  update run [-target prices|sites|both]
+  dump -table <name> [-file <path>]
+  restore -table <name> [-file <path>]
+  import [-from <date>] [-until <date>] [-state <path>] [-rejects <path>] [-dry-run] <export.csv>...
+
+flags:
+`
+
+var (
+	// errUsage is returned for a missing or unknown command, and prints the usage.
+	errUsage = errors.New("usage")
+
+	// errFlags is returned for flags that didn't parse, after the flag package printed why.
+	errFlags = errors.New("bad flags")
+)
+
+// cli holds the global flags and the streams the commands read and write.
+type cli struct {
+	api            string
+	key            string
+	adminSecret    string
+	store          string
+	region         string
+	dbEndpoint     string
+	lambdaEndpoint string
+	function       string
+	output         string
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+// run runs the command in args and returns the exit code, 2 for bad arguments and 1 for any
+// other error. flags not given fall back to the PETROL_ environment variables.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, getenv func(string) string) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("petrol", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&c.api, "api", getenv("PETROL_API_URL"), "the stage url of the api, e.g. https://<api>/Prod")
+	flags.StringVar(&c.key, "key", getenv("PETROL_API_KEY"), "the api key")
+	flags.StringVar(&c.adminSecret, "admin-secret", getenv("PETROL_ADMIN_SECRET"), "the admin secret, to trigger and check updates through the api")
+	flags.StringVar(&c.store, "store", getenv("PETROL_STORE"), "read the tables directly instead of the api, only dynamodb is supported")
+	flags.StringVar(&c.region, "region", envOr(getenv, "PETROL_REGION", defaultRegion), "the aws region of the store")
+	flags.StringVar(&c.dbEndpoint, "endpoint", getenv("PETROL_DYNAMODB_ENDPOINT"), "the dynamodb endpoint, e.g. http://localhost:8000 for dynamodb-local")
+	flags.StringVar(&c.lambdaEndpoint, "lambda-endpoint", getenv("PETROL_LAMBDA_ENDPOINT"), "the lambda endpoint, e.g. http://127.0.0.1:3001 for sam local start-lambda")
+	flags.StringVar(&c.function, "function", getenv("PETROL_UPDATE_FUNCTION"), "the name or arn of the update lambda, to trigger updates with -store")
+	flags.StringVar(&c.output, "output", outputTable, "the output format, one of table, json or csv")
+
+	err := flags.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		err = errFlags
+	}
+	if err == nil {
+		err = c.dispatch(ctx, flags.Args())
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if errors.Is(err, errUsage) {
+		flags.Usage()
+		return 2
+	}
+	if errors.Is(err, errFlags) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "petrol: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// envOr returns the environment variable, or the fallback when it is empty.
+func envOr(getenv func(string) string, name string, fallback string) string {
+	if value := getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// cli.dispatch runs the command named by the first argument.
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	err := checkOutput(c.output)
+	if err != nil {
+		return err
+	}
+
+	commands := map[string]func(ctx context.Context, args []string) error{
+		"cheapest": c.cheapest,
+		"sites":    c.sites,
+		"history":  c.history,
+		"update":   c.update,
+		"dump":     c.dump,
+		"restore":  c.restore,
+		"import":   c.importExports,
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "petrol: unknown command %q\n", args[0])
+		return errUsage
+	}
+	return command(ctx, args[1:])
+}
+
+// cli.parse parses a command's flags, and returns the names of the ones that were set.
+func (c *cli) parse(flags *flag.FlagSet, args []string) (map[string]bool, error) {
+	set, rest, err := c.parseArgs(flags, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", rest)
+	}
+	return set, nil
+}
+
+// cli.parseArgs parses a command's flags, and returns the names of the ones that were set and
+// the arguments after them.
+func (c *cli) parseArgs(flags *flag.FlagSet, args []string) (map[string]bool, []string, error) {
+	flags.SetOutput(c.stderr)
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, errFlags
+	}
+
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set, flags.Args(), nil
+}
+
+// cli.backend returns the store when one is configured, and the api otherwise.
+func (c *cli) backend() (backend, error) {
+	switch c.store {
+	case "":
+		if c.api == "" {
+			return nil, errors.New("set -api or PETROL_API_URL, or -store to use the tables directly")
+		}
+		options := []petrolapi.Option{petrolapi.WithAPIKey(c.key)}
+		if c.adminSecret != "" {
+			options = append(options, petrolapi.WithAdminSecret(c.adminSecret))
+		}
+		return apiBackend{client: petrolapi.NewClient(c.api, options...)}, nil
+
+	case storeDynamoDB:
+		return newStoreBackend(c.region, c.dbEndpoint, c.lambdaEndpoint, c.function)
+	}
+	return nil, fmt.Errorf("unknown store %q, expected dynamodb", c.store)
+}
+
+// cli.cheapest prints the cheapest stations for a fuel type in a postcode or near a location.
+func (c *cli) cheapest(ctx context.Context, args []string) error {
+	var query CheapestQuery
+	flags := flag.NewFlagSet("cheapest", flag.ContinueOnError)
+	flags.IntVar(&query.FuelId, "fuel", 0, "the fuel type id")
+	flags.StringVar(&query.Postcode, "postcode", "", "the postcode to search")
+	flags.Float64Var(&query.Lat, "lat", 0, "the latitude to search around")
+	flags.Float64Var(&query.Lng, "lng", 0, "the longitude to search around")
+	flags.Float64Var(&query.RadiusKm, "radius", defaultRadiusKm, "the distance to search around the location, in km")
+	flags.IntVar(&query.Limit, "limit", defaultCheapestLimit, "how many stations to list")
+	set, err := c.parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	query.Near = set["lat"] || set["lng"]
+	switch {
+	case query.FuelId <= 0:
+		return errors.New("cheapest needs a -fuel id")
+	case query.Near && query.Postcode != "":
+		return errors.New("search either a -postcode or a -lat and -lng, not both")
+	case query.Near && !(set["lat"] && set["lng"]):
+		return errors.New("a location needs both -lat and -lng")
+	case !query.Near && query.Postcode == "":
+		return errors.New("cheapest needs a -postcode, or a -lat and -lng")
+	case query.Limit < 1:
+		return errors.New("-limit must be a positive integer")
+	case query.RadiusKm <= 0:
+		return errors.New("-radius must be positive")
+	}
+
+	b, err := c.backend()
+	if err != nil {
+		return err
+	}
+	stations, err := b.Cheapest(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"Rank", "SiteId", "Name", "Brand", "Postcode", "CentsPerLitre", "TransactionDateUtc"}
+	if query.Near {
+		header = append(header, "DistanceKm")
+	}
+	rows := [][]string{}
+	for _, station := range stations {
+		row := []string{
+			strconv.Itoa(station.Rank),
+			strconv.Itoa(station.SiteId),
+			station.Name,
+			station.Brand,
+			station.Postcode,
+			formatCentsPerLitre(station.Price),
+			station.TransactionDateUTC,
+		}
+		if station.DistanceKm != nil {
+			row = append(row, strconv.FormatFloat(*station.DistanceKm, 'f', 2, 64))
+		}
+		rows = append(rows, row)
+	}
+	return printResult(c.stdout, c.output, stations, header, rows)
+}
+
+// cli.sites prints every site.
+func (c *cli) sites(ctx context.Context, args []string) error {
+	_, err := c.parse(flag.NewFlagSet("sites", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.backend()
+	if err != nil {
+		return err
+	}
+	sites, err := b.Sites(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, site := range sites {
+		rows = append(rows, []string{
+			strconv.Itoa(site.SiteId),
+			site.Name,
+			strconv.FormatFloat(site.Lat, 'f', -1, 64),
+			strconv.FormatFloat(site.Lng, 'f', -1, 64),
+			site.GooglePlaceID,
+		})
+	}
+	return printResult(c.stdout, c.output, sites, []string{"SiteId", "Name", "Latitude", "Longitude", "GooglePlaceId"}, rows)
+}
+
+// cli.history prints the daily prices of a fuel type in a region.
+func (c *cli) history(ctx context.Context, args []string) error {
+	var options petrolapi.HistoryOptions
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.IntVar(&options.FuelId, "fuel", 0, "the fuel type id")
+	flags.IntVar(&options.RegionId, "region", 0, "the region id")
+	flags.IntVar(&options.Days, "days", 0, "how many days back to go, the api defaults to 7 and allows up to 56")
+	_, err := c.parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if options.FuelId <= 0 || options.RegionId <= 0 {
+		return errors.New("history needs a -fuel and -region id")
+	}
+
+	b, err := c.backend()
+	if err != nil {
+		return err
+	}
+	history, err := b.History(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	// the median and mean are in tenths of a cent too, so have an extra decimal place.
+	rows := [][]string{}
+	for _, day := range history {
+		rows = append(rows, []string{
+			day.Date,
+			formatCentsPerLitre(day.Min),
+			strconv.FormatFloat(day.Median/10, 'f', 2, 64),
+			strconv.FormatFloat(day.Mean/10, 'f', 2, 64),
+			strconv.Itoa(day.Count),
+		})
+	}
+	return printResult(c.stdout, c.output, history, []string{"Date", "Min", "Median", "Mean", "Count"}, rows)
+}
+
+// runHeader is the header of the update run rows.
+var runHeader = []string{"RunId", "Trigger", "Status", "StartedAt", "EndedAt", "PricesWritten", "SitesWritten", "Errors"}
+
+// runRow returns the columns of an update run.
+func runRow(run petrolapi.UpdateRun) []string {
+	return []string{
+		run.RunId,
+		run.Trigger,
+		run.Status,
+		run.StartedAt.Format(time.RFC3339),
+		run.EndedAt.Format(time.RFC3339),
+		strconv.Itoa(run.PricesWritten),
+		strconv.Itoa(run.SitesWritten),
+		strings.Join(run.Errors, "; "),
+	}
+}
+
+// cli.update runs an update, or prints the update status or recent runs.
+func (c *cli) update(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	var limit int
+	var target string
+	flags := flag.NewFlagSet("update "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "runs":
+		flags.IntVar(&limit, "limit", 10, "how many runs to list, up to 50 through the api")
+	case "run":
+		flags.StringVar(&target, "target", "", "what to refresh when invoking the update lambda, one of prices, sites or both")
+	case "status":
+	default:
+		fmt.Fprintf(c.stderr, "petrol: unknown update command %q\n", args[0])
+		return errUsage
+	}
+	_, err := c.parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if args[0] == "runs" && limit < 1 {
+		return errors.New("-limit must be a positive integer")
+	}
+
+	b, err := c.backend()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "runs":
+		runs, err := b.UpdateRuns(ctx, limit)
+		if err != nil {
+			return err
+		}
+		rows := [][]string{}
+		for _, run := range runs {
+			rows = append(rows, runRow(run))
+		}
+		return printResult(c.stdout, c.output, runs, runHeader, rows)
+
+	case "run":
+		run, err := b.TriggerUpdate(ctx, target)
+		if err != nil {
+			return err
+		}
+		return printResult(c.stdout, c.output, run, runHeader, [][]string{runRow(*run)})
+	}
+
+	status, err := b.UpdateStatus(ctx)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	if status.LatestRun != nil {
+		rows = append(rows, append([]string{"latest"}, runRow(*status.LatestRun)...))
+	}
+	if status.LastSuccessfulRun != nil {
+		rows = append(rows, append([]string{"last successful"}, runRow(*status.LastSuccessfulRun)...))
+	}
+	return printResult(c.stdout, c.output, status, append([]string{"Run"}, runHeader...), rows)
+}
+
+// cli.tableFlags parses the flags of dump and restore, which only work against the store.
+func (c *cli) tableFlags(name string, args []string) (*storeBackend, string, string, error) {
+	var table, file string
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&table, "table", "", "the name of the table")
+	flags.StringVar(&file, "file", "", "the file of json lines, defaults to stdin or stdout")
+	_, err := c.parse(flags, args)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if table == "" {
+		return nil, "", "", fmt.Errorf("%s needs a -table", name)
+	}
+	store, err := c.storeBackend(name)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return store, table, file, nil
+}
+
+// cli.storeBackend returns the store, for the commands that only work against the tables.
+func (c *cli) storeBackend(name string) (*storeBackend, error) {
+	if c.store == "" {
+		return nil, fmt.Errorf("%s only works against the tables, set -store", name)
+	}
+
+	b, err := c.backend()
+	if err != nil {
+		return nil, err
+	}
+	return b.(*storeBackend), nil
+}
+
+// cli.dump writes every item of a table as lines of json.
+func (c *cli) dump(ctx context.Context, args []string) error {
+	store, table, file, err := c.tableFlags("dump", args)
+	if err != nil {
+		return err
+	}
+
+	out := c.stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	count, err := dumpTable(ctx, store.db, table, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "dumped %d items from %s\n", count, table)
+	return nil
+}
+
+// cli.restore puts the items of a dump back into a table.
+func (c *cli) restore(ctx context.Context, args []string) error {
+	store, table, file, err := c.tableFlags("restore", args)
+	if err != nil {
+		return err
+	}
+
+	in := c.stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	items, err := readItems(in)
+	if err != nil {
+		return err
+	}
+	err = writeBatches(ctx, store.db, table, items)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "restored %d items to %s\n", len(items), table)
+	return nil
+}
+
+// cli.importExports backfills the price history from csv exports of the prices, and reports the
+// rows it rejected.
+func (c *cli) importExports(ctx context.Context, args []string) error {
+	var from, until, statePath, rejectsPath string
+	var dryRun bool
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.StringVar(&from, "from", "", "the first day to write, earlier prices only carry over into it")
+	flags.StringVar(&until, "until", "", "the last day to write, defaults to yesterday so the update lambda's day is left alone")
+	flags.StringVar(&statePath, "state", "", "the file to save the progress in, to resume an interrupted import")
+	flags.StringVar(&rejectsPath, "rejects", "", "the csv file to write the rejected rows to")
+	flags.BoolVar(&dryRun, "dry-run", false, "check the exports and report without writing anything")
+	_, files, err := c.parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("import needs at least one export")
+	}
+
+	if until == "" {
+		until = time.Now().In(historyLocation).AddDate(0, 0, -1).Format(historyDateLayout)
+	}
+	for _, date := range []string{from, until} {
+		if _, err := time.Parse(historyDateLayout, date); date != "" && err != nil {
+			return fmt.Errorf("%q isn't a date like 2024-05-10", date)
+		}
+	}
+
+	store, err := c.storeBackend("import")
+	if err != nil {
+		return err
+	}
+	regions, err := store.siteRegions(ctx)
+	if err != nil {
+		return err
+	}
+	fuels, err := store.referenceNames(ctx, referenceFuels)
+	if err != nil {
+		return err
+	}
+
+	// the exports are read in the order given, so an earlier export wins a conflicting row.
+	parser := newExportParser(fuels, regions)
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = parser.parse(name, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	report := parser.report
+	history := historyFromPrices(parser.prices, regions, from, until)
+	days := map[string]bool{}
+	for _, day := range history {
+		days[day.Date] = true
+	}
+	report.Days = len(days)
+	report.Records = len(history)
+
+	if rejectsPath != "" {
+		f, err := os.Create(rejectsPath)
+		if err != nil {
+			return err
+		}
+		err = writeRejectedRows(f, parser.rejected)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !dryRun {
+		state, err := loadImportState(statePath, parser.fingerprint(from, until))
+		if err != nil {
+			return err
+		}
+		err = store.writeHistory(ctx, history, statePath, state, report)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "petrol: wrote %d of %d records before failing, run it again with the same -state to resume\n", report.Resumed+report.Written, report.Records)
+			return err
+		}
+	}
+
+	rows := [][]string{
+		{"files", strconv.Itoa(report.Files)},
+		{"rows", strconv.Itoa(report.Rows)},
+		{"accepted", strconv.Itoa(report.Accepted)},
+		{"duplicates", strconv.Itoa(report.Duplicates)},
+		{"rejected", strconv.Itoa(report.Rejected)},
+	}
+	reasons := []string{}
+	for reason := range report.RejectedByReason {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		rows = append(rows, []string{"rejected: " + reason, strconv.Itoa(report.RejectedByReason[reason])})
+	}
+	rows = append(rows,
+		[]string{"days", strconv.Itoa(report.Days)},
+		[]string{"records", strconv.Itoa(report.Records)},
+		[]string{"resumed", strconv.Itoa(report.Resumed)},
+		[]string{"written", strconv.Itoa(report.Written)},
+	)
+	return printResult(c.stdout, c.output, report, []string{"Import", "Count"}, rows)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// runAgainst runs the command against the handler as the api, and returns the exit code, stdout
+// and stderr.
+func runAgainst(t *testing.T, handler http.HandlerFunc, args ...string) (int, string, string) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	env := map[string]string{"PETROL_API_URL": server.URL, "PETROL_API_KEY": "key", "PETROL_ADMIN_SECRET": "secret"}
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(""), &stdout, &stderr, func(name string) string { return env[name] })
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCheapestPostcode(t *testing.T) {
+	code, stdout, stderr := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/cheapest" || query.Get("fuelType") != "2" || query.Get("postcode") != "5000" || query.Get("limit") != "10" || r.Header.Get("x-api-key") != "key" {
+			http.Error(w, "unexpected request.", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"FuelId": 2, "Stations": [
+			{"Rank": 1, "SiteId": 1, "Name": "City", "Brand": "Shell", "Postcode": "5000", "Price": 1799, "TransactionDateUTC": "2024-05-09T01:00:00"},
+			{"Rank": 2, "SiteId": 3, "Name": "Rundle", "Brand": "BP", "Postcode": "5000", "Price": 1850, "TransactionDateUTC": "2024-05-09T02:00:00"}
+		]}`))
+	}, "cheapest", "-fuel", "2", "-postcode", "5000")
+	if code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Rank") || strings.Contains(lines[0], "DistanceKm") {
+		t.Fatalf("unexpected table:\n%s", stdout)
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "1" || fields[2] != "City" || fields[5] != "179.9" {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+}
+
+func TestCheapestNear(t *testing.T) {
+	response := `{"data": {"sites": [
+		{"id": 1, "name": "Near", "distanceKm": 0.5, "brand": null, "prices": [{"fuelType": {"id": 2}, "price": 1899, "current": true}]},
+		{"id": 2, "name": "Stale", "distanceKm": 1.0, "brand": null, "prices": [{"fuelType": {"id": 2}, "price": 1500, "current": false}]},
+		{"id": 3, "name": "Far", "distanceKm": 4.2, "brand": {"name": "BP"}, "prices": [{"fuelType": {"id": 2}, "price": 1799, "current": true}]},
+		{"id": 4, "name": "Tied", "distanceKm": 3.0, "brand": null, "prices": [{"fuelType": {"id": 2}, "price": 1899, "current": true}]}
+	]}}`
+	code, stdout, stderr := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/graphql" || body.Variables["radiusKm"] != float64(10) || body.Variables["first"] != float64(maxNearbySites) {
+			http.Error(w, "unexpected request.", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(response))
+	}, "-output", "json", "cheapest", "-fuel", "2", "-lat", "-34.92", "-lng", "138.6", "-radius", "10", "-limit", "2")
+	if code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr)
+	}
+
+	var stations []Station
+	err := json.Unmarshal([]byte(stdout), &stations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 2 || stations[0].SiteId != 3 || stations[1].SiteId != 1 || stations[1].Rank != 2 || *stations[0].DistanceKm != 4.2 {
+		t.Errorf("unexpected stations: %s", stdout)
+	}
+}
+
+func TestSitesCSV(t *testing.T) {
+	code, stdout, stderr := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"SiteId": 1, "Name": "City, North", "Lat": -34.93, "Lng": 138.6, "GPI": "abc"}]`))
+	}, "-output", "csv", "sites")
+	if code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr)
+	}
+
+	expected := "SiteId,Name,Latitude,Longitude,GooglePlaceId\n1,\"City, North\",-34.93,138.6,abc\n"
+	if stdout != expected {
+		t.Errorf("expected %q, got %q", expected, stdout)
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	code, stdout, stderr := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"LatestRun": {"RunId": "b", "Status": "failed", "Errors": ["upstream timed out"]}, "LastSuccessfulRun": {"RunId": "a", "Status": "succeeded", "Errors": []}, "SecondsSinceSuccess": 900}`))
+	}, "update", "status")
+	if code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "latest") || !strings.HasSuffix(lines[1], "upstream timed out") || !strings.HasPrefix(lines[2], "last successful") {
+		t.Errorf("unexpected table:\n%s", stdout)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		expected string
+	}{
+		{"no command", nil, 2, "usage: petrol"},
+		{"unknown command", []string{"prices"}, 2, `unknown command "prices"`},
+		{"unknown output", []string{"-output", "xml", "sites"}, 1, `unknown output "xml"`},
+		{"missing fuel", []string{"cheapest", "-postcode", "5000"}, 1, "needs a -fuel id"},
+		{"postcode and location", []string{"cheapest", "-fuel", "2", "-postcode", "5000", "-lat", "-34.9", "-lng", "138.6"}, 1, "not both"},
+		{"half a location", []string{"cheapest", "-fuel", "2", "-lat", "-34.9"}, 1, "both -lat and -lng"},
+		{"bad flag", []string{"sites", "-fuel", "2"}, 2, "flag provided but not defined: -fuel"},
+		{"update target", []string{"update", "run", "-target", "sites"}, 1, "only be picked with -store"},
+		{"dump without store", []string{"dump", "-table", "update_runs"}, 1, "set -store"},
+		{"import without exports", []string{"import", "-dry-run"}, 1, "needs at least one export"},
+		{"import bad date", []string{"import", "-from", "May", "2024-q2.csv"}, 1, `"May" isn't a date`},
+		{"import without store", []string{"import", "2024-q2.csv"}, 1, "set -store"},
+		{"api error", []string{"history", "-fuel", "2", "-region", "7"}, 1, "petrolapi: 400 boom"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, stderr := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "boom", http.StatusBadRequest)
+			}, test.args...)
+			if code != test.code || !strings.Contains(stderr, test.expected) {
+				t.Errorf("expected %d with %q, got %d with %q", test.code, test.expected, code, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputTable string = "table"
+	outputJSON  string = "json"
+	outputCSV   string = "csv"
+)
+
+// formatCentsPerLitre formats a price, which is stored in tenths of a cent, as cents per litre
+// with one decimal.
+func formatCentsPerLitre(price int) string {
+	return fmt.Sprintf("%d.%d", price/10, price%10)
+}
+
+// checkOutput checks the output format is one the printer knows.
+func checkOutput(output string) error {
+	switch output {
+	case outputTable, outputJSON, outputCSV:
+		return nil
+	}
+	return fmt.Errorf("unknown output %q, expected table, json or csv", output)
+}
+
+// printResult writes the value as indented json, or the header and rows as an aligned table or
+// csv. json keeps the api's field names and raw prices.
+func printResult(out io.Writer, output string, value interface{}, header []string, rows [][]string) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+
+	case outputCSV:
+		writer := csv.NewWriter(out)
+		writer.Write(header)
+		writer.WriteAll(rows)
+		return writer.Error()
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
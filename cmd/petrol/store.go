@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/connorturlan/petrol-price-api/petrolapi"
+)
+
+const (
+	pricesTableName    string = "current_fuel_prices"
+	sitesTableName     string = "safpis_fuel_sites"
+	historyTableName   string = "price_history"
+	runsTableName      string = "update_runs"
+	referenceTableName string = "reference_names"
+
+	referenceFuels  string = "fuels"
+	referenceBrands string = "brands"
+
+	runsJob       string = "update"
+	runSucceeded  string = "succeeded"
+	runTimeLayout string = "2006-01-02T15:04:05.000Z07:00"
+
+	historyDateLayout string = "2006-01-02"
+	defaultDays       int    = 7
+
+	// maxStatusRuns is how far back the status looks for a successful run, the same as the api.
+	maxStatusRuns int = 50
+
+	// placeholderPrice is what stations report for a fuel they have run out of or stopped selling.
+	placeholderPrice int = 9999
+
+	// stalePriceAge is how long a price can go unchanged before it is left out of the cheapest.
+	stalePriceAge time.Duration = 7 * 24 * time.Hour
+
+	earthRadiusKm float64 = 6371
+)
+
+// historyLocation is the timezone the days of the price history are counted in.
+var historyLocation, _ = time.LoadLocation("Australia/Adelaide")
+
+// siteRecord is a site as the sites table holds it.
+type siteRecord struct {
+	SiteId    int
+	Name      string
+	Address   string
+	Postcode  string
+	BrandId   int
+	RegionId  int
+	PlaceId   string
+	Latitude  float64
+	Longitude float64
+}
+
+// priceRecord is the price of a fuel type at a site, as the prices table holds it.
+type priceRecord struct {
+	Price              int
+	TransactionDateUTC string
+}
+
+// storeBackend reads the tables directly, and invokes the update lambda to run updates.
+type storeBackend struct {
+	db       *dynamodb.DynamoDB
+	lambda   *lambda.Lambda
+	function string
+	now      func() time.Time
+}
+
+// newStoreBackend connects to dynamodb and lambda in the region. the endpoints override the aws
+// ones, e.g. for dynamodb-local and sam local start-lambda.
+func newStoreBackend(region string, dbEndpoint string, lambdaEndpoint string, function string) (*storeBackend, error) {
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	dbConfig := aws.NewConfig()
+	if dbEndpoint != "" {
+		dbConfig = dbConfig.WithEndpoint(dbEndpoint)
+	}
+	lambdaConfig := aws.NewConfig()
+	if lambdaEndpoint != "" {
+		lambdaConfig = lambdaConfig.WithEndpoint(lambdaEndpoint)
+	}
+
+	return &storeBackend{
+		db:       dynamodb.New(sess, dbConfig),
+		lambda:   lambda.New(sess, lambdaConfig),
+		function: function,
+		now:      time.Now,
+	}, nil
+}
+
+// scanTable returns every item of the table.
+func scanTable(ctx context.Context, db *dynamodb.DynamoDB, tableName string) ([]map[string]*dynamodb.AttributeValue, error) {
+	records := []map[string]*dynamodb.AttributeValue{}
+	err := db.ScanPagesWithContext(ctx, &dynamodb.ScanInput{TableName: aws.String(tableName)}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		records = append(records, page.Items...)
+		return true
+	})
+	return records, err
+}
+
+// isMissingTable reports whether the error is dynamodb saying the table doesn't exist.
+func isMissingTable(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException
+}
+
+// parseTransactionDate reads a price's transaction date, which upstream sends without a zone.
+func parseTransactionDate(raw string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02T15:04:05", raw)
+	if err != nil {
+		return time.Parse(time.RFC3339, raw)
+	}
+	return date, nil
+}
+
+// isCurrentPrice reports whether the price is a real price that has changed recently enough to
+// count.
+func isCurrentPrice(price priceRecord, now time.Time) bool {
+	if price.Price <= 0 || price.Price >= placeholderPrice {
+		return false
+	}
+
+	date, err := parseTransactionDate(price.TransactionDateUTC)
+	if err != nil {
+		return false
+	}
+	return now.Sub(date) <= stalePriceAge
+}
+
+// distanceKm returns the great circle distance between two locations.
+func distanceKm(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// unmarshalSite reads a record from the sites table.
+func unmarshalSite(record map[string]*dynamodb.AttributeValue) (site siteRecord, err error) {
+	idRecord, ok := record["SiteId"]
+	if !ok {
+		return site, errors.New("site record is missing SiteId")
+	}
+	site.SiteId, err = strconv.Atoi(aws.StringValue(idRecord.N))
+	if err != nil {
+		return site, err
+	}
+
+	stringFields := map[string]*string{"N": &site.Name, "A": &site.Address, "P": &site.Postcode, "G": &site.PlaceId}
+	for name, field := range stringFields {
+		if value, ok := record[name]; ok {
+			*field = aws.StringValue(value.S)
+		}
+	}
+
+	intFields := map[string]*int{"B": &site.BrandId, "R": &site.RegionId}
+	for name, field := range intFields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.Atoi(aws.StringValue(value.N))
+			if err != nil {
+				return site, err
+			}
+		}
+	}
+
+	floatFields := map[string]*float64{"Lt": &site.Latitude, "Lg": &site.Longitude}
+	for name, field := range floatFields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.ParseFloat(aws.StringValue(value.N), 64)
+			if err != nil {
+				return site, err
+			}
+		}
+	}
+
+	return site, nil
+}
+
+// unmarshalPrices reads a record from the prices table, returning the site id and its prices by
+// fuel id.
+func unmarshalPrices(record map[string]*dynamodb.AttributeValue) (int, map[int]priceRecord, error) {
+	idRecord, ok := record["SiteId"]
+	if !ok {
+		return 0, nil, errors.New("price record is missing SiteId")
+	}
+	siteId, err := strconv.Atoi(aws.StringValue(idRecord.N))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	prices := map[int]priceRecord{}
+	if fuelTypes, ok := record["FuelTypes"]; ok {
+		for rawFuelId, fuelRecord := range fuelTypes.M {
+			fuelId, err := strconv.Atoi(rawFuelId)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			var price priceRecord
+			if priceValue, ok := fuelRecord.M["P"]; ok {
+				price.Price, err = strconv.Atoi(aws.StringValue(priceValue.N))
+				if err != nil {
+					return 0, nil, err
+				}
+			}
+			if dateValue, ok := fuelRecord.M["D"]; ok {
+				price.TransactionDateUTC = aws.StringValue(dateValue.S)
+			}
+			prices[fuelId] = price
+		}
+	}
+	return siteId, prices, nil
+}
+
+// unmarshalDailyPrice reads a record from the history table.
+func unmarshalDailyPrice(record map[string]*dynamodb.AttributeValue) (price petrolapi.DailyPrice, err error) {
+	if dateRecord, ok := record["Date"]; ok {
+		price.Date = aws.StringValue(dateRecord.S)
+	}
+
+	intFields := map[string]*int{"FuelId": &price.FuelId, "Region": &price.RegionId, "Min": &price.Min, "Count": &price.Count}
+	for name, field := range intFields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.Atoi(aws.StringValue(value.N))
+			if err != nil {
+				return price, err
+			}
+		}
+	}
+
+	floatFields := map[string]*float64{"Median": &price.Median, "Mean": &price.Mean}
+	for name, field := range floatFields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.ParseFloat(aws.StringValue(value.N), 64)
+			if err != nil {
+				return price, err
+			}
+		}
+	}
+
+	return price, nil
+}
+
+// unmarshalRun reads a record from the runs table.
+func unmarshalRun(record map[string]*dynamodb.AttributeValue) (run petrolapi.UpdateRun, err error) {
+	stringFields := map[string]*string{"RunId": &run.RunId, "Trigger": &run.Trigger, "Status": &run.Status}
+	for name, field := range stringFields {
+		if value, ok := record[name]; ok {
+			*field = aws.StringValue(value.S)
+		}
+	}
+
+	timeFields := map[string]*time.Time{"StartedAt": &run.StartedAt, "EndedAt": &run.EndedAt}
+	for name, field := range timeFields {
+		if value, ok := record[name]; ok {
+			*field, err = time.Parse(runTimeLayout, aws.StringValue(value.S))
+			if err != nil {
+				return run, err
+			}
+		}
+	}
+
+	intFields := map[string]*int{
+		"PricesFetched": &run.PricesFetched,
+		"PricesChanged": &run.PricesChanged,
+		"PricesWritten": &run.PricesWritten,
+		"SitesFetched":  &run.SitesFetched,
+		"SitesChanged":  &run.SitesChanged,
+		"SitesWritten":  &run.SitesWritten,
+		"Retries":       &run.Retries,
+	}
+	for name, field := range intFields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.Atoi(aws.StringValue(value.N))
+			if err != nil {
+				return run, err
+			}
+		}
+	}
+
+	int64Fields := map[string]*int64{"UpstreamPricesMs": &run.UpstreamPricesMs, "UpstreamSitesMs": &run.UpstreamSitesMs}
+	for name, field := range int64Fields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+			if err != nil {
+				return run, err
+			}
+		}
+	}
+
+	listFields := map[string]*[]string{"Regions": &run.Regions, "Errors": &run.Errors}
+	for name, field := range listFields {
+		*field = []string{}
+		if value, ok := record[name]; ok {
+			for _, entry := range value.L {
+				*field = append(*field, aws.StringValue(entry.S))
+			}
+		}
+	}
+
+	return run, nil
+}
+
+// cheapestStations ranks the current prices of the fuel type at the sites in the postcode, or
+// within the radius of the location.
+func cheapestStations(sites []siteRecord, prices map[int]map[int]priceRecord, brands map[int]string, query CheapestQuery, now time.Time) []Station {
+	stations := []Station{}
+	for _, site := range sites {
+		if query.Postcode != "" && site.Postcode != query.Postcode {
+			continue
+		}
+
+		var distance *float64
+		if query.Near {
+			siteDistance := distanceKm(query.Lat, query.Lng, site.Latitude, site.Longitude)
+			if siteDistance > query.RadiusKm {
+				continue
+			}
+			distance = &siteDistance
+		}
+
+		price, ok := prices[site.SiteId][query.FuelId]
+		if !ok || !isCurrentPrice(price, now) {
+			continue
+		}
+
+		stations = append(stations, Station{
+			SiteId:             site.SiteId,
+			Name:               site.Name,
+			Address:            site.Address,
+			Postcode:           site.Postcode,
+			Brand:              brands[site.BrandId],
+			Price:              price.Price,
+			TransactionDateUTC: price.TransactionDateUTC,
+			DistanceKm:         distance,
+		})
+	}
+	return rankStations(stations, query.Limit)
+}
+
+// storeBackend.sites returns every site in the sites table.
+func (store *storeBackend) sites(ctx context.Context) ([]siteRecord, error) {
+	records, err := scanTable(ctx, store.db, sitesTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := []siteRecord{}
+	for _, record := range records {
+		site, err := unmarshalSite(record)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// storeBackend.referenceNames returns the fuel type, brand or region names by id. names that
+// haven't been loaded yet are left out.
+func (store *storeBackend) referenceNames(ctx context.Context, kind string) (map[int]string, error) {
+	names := map[int]string{}
+	res, err := store.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(referenceTableName),
+		Key:       map[string]*dynamodb.AttributeValue{"Kind": {S: aws.String(kind)}},
+	})
+	if isMissingTable(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if namesRecord, ok := res.Item["Names"]; ok {
+		for rawId, name := range namesRecord.M {
+			id, err := strconv.Atoi(rawId)
+			if err != nil {
+				return nil, err
+			}
+			names[id] = aws.StringValue(name.S)
+		}
+	}
+	return names, nil
+}
+
+// storeBackend.Sites returns every site.
+func (store *storeBackend) Sites(ctx context.Context) ([]petrolapi.Site, error) {
+	records, err := store.sites(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := []petrolapi.Site{}
+	for _, site := range records {
+		sites = append(sites, petrolapi.Site{
+			SiteId:        site.SiteId,
+			Name:          site.Name,
+			Lat:           site.Latitude,
+			Lng:           site.Longitude,
+			GooglePlaceID: site.PlaceId,
+		})
+	}
+	return sites, nil
+}
+
+// storeBackend.Cheapest ranks the current prices read from the sites and prices tables.
+func (store *storeBackend) Cheapest(ctx context.Context, query CheapestQuery) ([]Station, error) {
+	sites, err := store.sites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	brands, err := store.referenceNames(ctx, referenceBrands)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := scanTable(ctx, store.db, pricesTableName)
+	if err != nil {
+		return nil, err
+	}
+	prices := map[int]map[int]priceRecord{}
+	for _, record := range records {
+		siteId, sitePrices, err := unmarshalPrices(record)
+		if err != nil {
+			return nil, err
+		}
+		prices[siteId] = sitePrices
+	}
+
+	return cheapestStations(sites, prices, brands, query, store.now()), nil
+}
+
+// storeBackend.History returns the daily prices of a fuel type in a region, oldest first. a
+// missing table is an empty history.
+func (store *storeBackend) History(ctx context.Context, options petrolapi.HistoryOptions) ([]petrolapi.DailyPrice, error) {
+	days := options.Days
+	if days == 0 {
+		days = defaultDays
+	}
+	since := store.now().In(historyLocation).AddDate(0, 0, 1-days).Format(historyDateLayout)
+
+	history := []petrolapi.DailyPrice{}
+	var unmarshalErr error
+	err := store.db.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(historyTableName),
+		KeyConditionExpression:   aws.String("Series = :series AND #date >= :since"),
+		ExpressionAttributeNames: map[string]*string{"#date": aws.String("Date")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":series": {S: aws.String(fmt.Sprintf("%d/%d", options.FuelId, options.RegionId))},
+			":since":  {S: aws.String(since)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, record := range page.Items {
+			day, err := unmarshalDailyPrice(record)
+			if err != nil {
+				unmarshalErr = err
+				return false
+			}
+			history = append(history, day)
+		}
+		return true
+	})
+	if isMissingTable(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return history, unmarshalErr
+}
+
+// storeBackend.UpdateRuns returns up to limit runs, newest first.
+func (store *storeBackend) UpdateRuns(ctx context.Context, limit int) ([]petrolapi.UpdateRun, error) {
+	runs := []petrolapi.UpdateRun{}
+	res, err := store.db.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(runsTableName),
+		KeyConditionExpression:   aws.String("#job = :job"),
+		ExpressionAttributeNames: map[string]*string{"#job": aws.String("Job")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":job": {S: aws.String(runsJob)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if isMissingTable(err) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range res.Items {
+		run, err := unmarshalRun(record)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// summariseRuns finds the latest and last successful run, from runs ordered newest first.
+func summariseRuns(runs []petrolapi.UpdateRun, now time.Time) *petrolapi.UpdateStatus {
+	status := &petrolapi.UpdateStatus{}
+	if len(runs) > 0 {
+		status.LatestRun = &runs[0]
+	}
+
+	for i, run := range runs {
+		if run.Status == runSucceeded {
+			status.LastSuccessfulRun = &runs[i]
+			age := int64(now.Sub(run.EndedAt).Seconds())
+			status.SecondsSinceSuccess = &age
+			break
+		}
+	}
+	return status
+}
+
+// storeBackend.UpdateStatus returns the latest and last successful update runs.
+func (store *storeBackend) UpdateStatus(ctx context.Context) (*petrolapi.UpdateStatus, error) {
+	runs, err := store.UpdateRuns(ctx, maxStatusRuns)
+	if err != nil {
+		return nil, err
+	}
+	return summariseRuns(runs, store.now()), nil
+}
+
+// storeBackend.TriggerUpdate invokes the update lambda with the target, one of prices, sites or
+// both, and returns the run it recorded.
+func (store *storeBackend) TriggerUpdate(ctx context.Context, target string) (*petrolapi.UpdateRun, error) {
+	if store.function == "" {
+		return nil, errors.New("the update function is needed to trigger updates, set -function")
+	}
+	if target == "" {
+		target = "both"
+	}
+
+	payload, err := json.Marshal(map[string]string{"update": target})
+	if err != nil {
+		return nil, err
+	}
+	res, err := store.lambda.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(store.function),
+		Payload:      payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.FunctionError != nil {
+		return nil, fmt.Errorf("update failed: %s", res.Payload)
+	}
+
+	// the lambda answers with the response it would have sent through api gateway.
+	var response struct {
+		StatusCode int    `json:"statusCode"`
+		Body       string `json:"body"`
+	}
+	err = json.Unmarshal(res.Payload, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusAccepted {
+		return nil, &petrolapi.APIError{StatusCode: response.StatusCode, Message: response.Body}
+	}
+
+	runs, err := store.UpdateRuns(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("the update didn't record a run")
+	}
+	return &runs[0], nil
+}
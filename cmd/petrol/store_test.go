@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/connorturlan/petrol-price-api/petrolapi"
+)
+
+func TestUnmarshalRecords(t *testing.T) {
+	site, err := unmarshalSite(map[string]*dynamodb.AttributeValue{
+		"SiteId": {N: aws.String("61577372")},
+		"N":      {S: aws.String("City")},
+		"P":      {S: aws.String("5000")},
+		"B":      {N: aws.String("5")},
+		"R":      {N: aws.String("7")},
+		"Lt":     {N: aws.String("-34.93")},
+		"Lg":     {N: aws.String("138.6")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := siteRecord{SiteId: 61577372, Name: "City", Postcode: "5000", BrandId: 5, RegionId: 7, Latitude: -34.93, Longitude: 138.6}
+	if site != expected {
+		t.Errorf("expected %+v, got %+v", expected, site)
+	}
+
+	siteId, prices, err := unmarshalPrices(map[string]*dynamodb.AttributeValue{
+		"SiteId":  {N: aws.String("61577372")},
+		"FuelIds": {L: []*dynamodb.AttributeValue{{N: aws.String("2")}}},
+		"FuelTypes": {M: map[string]*dynamodb.AttributeValue{
+			"2": {M: map[string]*dynamodb.AttributeValue{
+				"FuelId": {N: aws.String("2")},
+				"M":      {S: aws.String("T")},
+				"D":      {S: aws.String("2024-05-09T01:00:00")},
+				"P":      {N: aws.String("1899")},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if siteId != 61577372 || prices[2] != (priceRecord{Price: 1899, TransactionDateUTC: "2024-05-09T01:00:00"}) {
+		t.Errorf("unexpected prices for %d: %+v", siteId, prices)
+	}
+
+	_, err = unmarshalSite(map[string]*dynamodb.AttributeValue{"N": {S: aws.String("City")}})
+	if err == nil {
+		t.Error("expected a site without an id to fail")
+	}
+}
+
+func TestUnmarshalRun(t *testing.T) {
+	run, err := unmarshalRun(map[string]*dynamodb.AttributeValue{
+		"Job":           {S: aws.String(runsJob)},
+		"StartedAt":     {S: aws.String("2024-05-10T00:15:00.000Z")},
+		"RunId":         {S: aws.String("b")},
+		"Status":        {S: aws.String("failed")},
+		"PricesWritten": {N: aws.String("12")},
+		"Errors":        {L: []*dynamodb.AttributeValue{{S: aws.String("upstream timed out")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RunId != "b" || run.PricesWritten != 12 || len(run.Errors) != 1 || len(run.Regions) != 0 || !run.StartedAt.Equal(time.Date(2024, 5, 10, 0, 15, 0, 0, time.UTC)) {
+		t.Errorf("unexpected run: %+v", run)
+	}
+}
+
+func TestCheapestStations(t *testing.T) {
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	sites := []siteRecord{
+		{SiteId: 1, Name: "City", Postcode: "5000", BrandId: 5, Latitude: -34.928, Longitude: 138.6},
+		{SiteId: 2, Name: "Norwood", Postcode: "5067", Latitude: -34.921, Longitude: 138.631},
+		{SiteId: 3, Name: "Stale", Postcode: "5000", Latitude: -34.928, Longitude: 138.601},
+		{SiteId: 4, Name: "Placeholder", Postcode: "5000", Latitude: -34.928, Longitude: 138.602},
+		{SiteId: 5, Name: "Gawler", Postcode: "5118", Latitude: -34.6, Longitude: 138.74},
+	}
+	prices := map[int]map[int]priceRecord{
+		1: {2: {Price: 1899, TransactionDateUTC: "2024-05-09T01:00:00"}},
+		2: {2: {Price: 1799, TransactionDateUTC: "2024-05-09T02:00:00"}, 5: {Price: 1999, TransactionDateUTC: "2024-05-09T02:00:00"}},
+		3: {2: {Price: 1599, TransactionDateUTC: "2024-04-01T00:00:00"}},
+		4: {2: {Price: placeholderPrice, TransactionDateUTC: "2024-05-09T00:00:00"}},
+		5: {2: {Price: 1699, TransactionDateUTC: "2024-05-09T00:00:00"}},
+	}
+	brands := map[int]string{5: "Shell"}
+
+	stations := cheapestStations(sites, prices, brands, CheapestQuery{FuelId: 2, Postcode: "5000", Limit: 10}, now)
+	if len(stations) != 1 || stations[0].SiteId != 1 || stations[0].Brand != "Shell" || stations[0].DistanceKm != nil {
+		t.Errorf("expected only the current price in the postcode, got %+v", stations)
+	}
+
+	stations = cheapestStations(sites, prices, brands, CheapestQuery{FuelId: 2, Near: true, Lat: -34.928, Lng: 138.6, RadiusKm: 5, Limit: 10}, now)
+	if len(stations) != 2 || stations[0].SiteId != 2 || stations[1].SiteId != 1 || stations[1].Rank != 2 {
+		t.Fatalf("expected the two current prices within 5km, got %+v", stations)
+	}
+	if *stations[0].DistanceKm < 2.5 || *stations[0].DistanceKm > 3.5 || *stations[1].DistanceKm != 0 {
+		t.Errorf("unexpected distances %v and %v", *stations[0].DistanceKm, *stations[1].DistanceKm)
+	}
+}
+
+func TestRankStations(t *testing.T) {
+	near, far := 1.0, 2.0
+	stations := rankStations([]Station{
+		{SiteId: 3, Price: 1899, DistanceKm: &far},
+		{SiteId: 2, Price: 1899, DistanceKm: &near},
+		{SiteId: 1, Price: 1999, DistanceKm: &near},
+		{SiteId: 4, Price: 1799, DistanceKm: &far},
+	}, 3)
+
+	expected := [][2]int{{4, 1}, {2, 2}, {3, 2}}
+	if len(stations) != len(expected) {
+		t.Fatalf("expected %d stations, got %+v", len(expected), stations)
+	}
+	for n, station := range stations {
+		if station.SiteId != expected[n][0] || station.Rank != expected[n][1] {
+			t.Errorf("expected site %d ranked %d, got %+v", expected[n][0], expected[n][1], station)
+		}
+	}
+}
+
+func TestSummariseRuns(t *testing.T) {
+	now := time.Date(2024, 5, 10, 1, 0, 0, 0, time.UTC)
+	runs := []petrolapi.UpdateRun{
+		{RunId: "c", Status: "failed"},
+		{RunId: "b", Status: runSucceeded, EndedAt: now.Add(-15 * time.Minute)},
+		{RunId: "a", Status: runSucceeded},
+	}
+
+	status := summariseRuns(runs, now)
+	if status.LatestRun.RunId != "c" || status.LastSuccessfulRun.RunId != "b" || *status.SecondsSinceSuccess != 900 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	status = summariseRuns([]petrolapi.UpdateRun{}, now)
+	if status.LatestRun != nil || status.LastSuccessfulRun != nil || status.SecondsSinceSuccess != nil {
+		t.Errorf("expected an empty status, got %+v", status)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	writeBatchSize  int           = 25
+	maxBatchRetries int           = 5
+	batchRetryDelay time.Duration = 100 * time.Millisecond
+
+	// maxItemBytes is the largest item dynamodb stores, so no line of a dump is longer.
+	maxItemBytes int = 400 << 10
+)
+
+// encodeAttribute returns the attribute in the typed json the aws cli uses, e.g. {"N": "1899"},
+// leaving out the types that aren't set.
+func encodeAttribute(value *dynamodb.AttributeValue) map[string]interface{} {
+	switch {
+	case value.S != nil:
+		return map[string]interface{}{"S": aws.StringValue(value.S)}
+	case value.N != nil:
+		return map[string]interface{}{"N": aws.StringValue(value.N)}
+	case value.B != nil:
+		return map[string]interface{}{"B": value.B}
+	case value.BOOL != nil:
+		return map[string]interface{}{"BOOL": aws.BoolValue(value.BOOL)}
+	case value.NULL != nil:
+		return map[string]interface{}{"NULL": aws.BoolValue(value.NULL)}
+	case value.SS != nil:
+		return map[string]interface{}{"SS": aws.StringValueSlice(value.SS)}
+	case value.NS != nil:
+		return map[string]interface{}{"NS": aws.StringValueSlice(value.NS)}
+	case value.BS != nil:
+		return map[string]interface{}{"BS": value.BS}
+	case value.M != nil:
+		return map[string]interface{}{"M": encodeItem(value.M)}
+	}
+
+	list := []interface{}{}
+	for _, entry := range value.L {
+		list = append(list, encodeAttribute(entry))
+	}
+	return map[string]interface{}{"L": list}
+}
+
+// encodeItem returns the item in the typed json the aws cli uses.
+func encodeItem(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	encoded := map[string]interface{}{}
+	for name, value := range item {
+		encoded[name] = encodeAttribute(value)
+	}
+	return encoded
+}
+
+// writeItems writes each item as a line of json.
+func writeItems(out io.Writer, items []map[string]*dynamodb.AttributeValue) error {
+	encoder := json.NewEncoder(out)
+	for _, item := range items {
+		err := encoder.Encode(encodeItem(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readItems reads the lines of json writeItems wrote. blank lines are skipped.
+func readItems(in io.Reader) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64<<10), 2*maxItemBytes)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var item map[string]*dynamodb.AttributeValue
+		err := json.Unmarshal(scanner.Bytes(), &item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// dumpTable writes every item of the table to out, returning how many were written.
+func dumpTable(ctx context.Context, db *dynamodb.DynamoDB, tableName string, out io.Writer) (int, error) {
+	items, err := scanTable(ctx, db, tableName)
+	if err != nil {
+		return 0, err
+	}
+	return len(items), writeItems(out, items)
+}
+
+// writeBatches puts the items into the existing table in batches, resending any dynamodb couldn't
+// process with a doubling delay. items with the same key as one in the table replace it.
+func writeBatches(ctx context.Context, db *dynamodb.DynamoDB, tableName string, items []map[string]*dynamodb.AttributeValue) error {
+	for start := 0; start < len(items); start += writeBatchSize {
+		end := min(start+writeBatchSize, len(items))
+
+		requests := []*dynamodb.WriteRequest{}
+		for _, item := range items[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+		}
+
+		requestItems := map[string][]*dynamodb.WriteRequest{tableName: requests}
+		for attempt := 0; len(requestItems[tableName]) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				return fmt.Errorf("%d items still unprocessed after %d retries", len(requestItems[tableName]), maxBatchRetries)
+			}
+			if attempt > 0 {
+				time.Sleep(batchRetryDelay << (attempt - 1))
+			}
+
+			res, err := db.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{RequestItems: requestItems})
+			if err != nil {
+				return err
+			}
+			requestItems = res.UnprocessedItems
+		}
+	}
+	return nil
+}
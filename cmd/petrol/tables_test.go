@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	items := []map[string]*dynamodb.AttributeValue{
+		{
+			"SiteId":  {N: aws.String("61577372")},
+			"FuelIds": {L: []*dynamodb.AttributeValue{{N: aws.String("2")}, {N: aws.String("5")}}},
+			"FuelTypes": {M: map[string]*dynamodb.AttributeValue{
+				"2": {M: map[string]*dynamodb.AttributeValue{"P": {N: aws.String("1899")}, "D": {S: aws.String("2024-05-09T01:00:00")}}},
+			}},
+		},
+		{
+			"Key":       {S: aws.String("hash")},
+			"Endpoints": {SS: aws.StringSlice([]string{"/sites", "/prices"})},
+			"Enabled":   {BOOL: aws.Bool(false)},
+			"Secret":    {B: []byte{0, 1, 2}},
+			"Regions":   {L: []*dynamodb.AttributeValue{}},
+		},
+	}
+
+	var out bytes.Buffer
+	err := writeItems(&out, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"SiteId":{"N":"61577372"}`) || strings.Contains(out.String(), "null") {
+		t.Fatalf("unexpected dump:\n%s", out.String())
+	}
+
+	restored, err := readItems(strings.NewReader(out.String() + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, restored) {
+		t.Errorf("expected the items back, got %v", restored)
+	}
+}
+
+func TestReadItemsError(t *testing.T) {
+	_, err := readItems(strings.NewReader(`{"SiteId": {"N": "1"}}` + "\n" + `{"SiteId": `))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected the bad line in the error, got %v", err)
+	}
+}
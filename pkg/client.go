@@ -0,0 +1,232 @@
+package petrolapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader string = "x-api-key"
+	userAgent    string = "petrolapi-go"
+
+	defaultTimeout     time.Duration = 30 * time.Second
+	defaultMaxAttempts int           = 3
+	defaultBaseDelay   time.Duration = 500 * time.Millisecond
+	// maxRetryAfter caps how long a Retry-After header can make a request wait.
+	maxRetryAfter time.Duration = 30 * time.Second
+)
+
+// Client calls the petrol price api. prices are integers in tenths of a cent per litre, the same
+// units the api returns them in.
+type Client struct {
+	baseURL     string
+	apiKey      string
+	adminSecret string
+	httpClient  *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	sleep       func(context.Context, time.Duration) error
+	now         func() time.Time
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sends the key with every request, it is needed unless the deployment has keys turned off.
+func WithAPIKey(key string) Option {
+	return func(client *Client) {
+		client.apiKey = key
+	}
+}
+
+// WithAdminSecret sets the secret manual update triggers are signed with.
+func WithAdminSecret(secret string) Option {
+	return func(client *Client) {
+		client.adminSecret = secret
+	}
+}
+
+// WithHTTPClient replaces the http client requests are sent with.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a request is attempted, and the delay before the first retry,
+// which doubles with each retry after it.
+func WithRetries(maxAttempts int, baseDelay time.Duration) Option {
+	return func(client *Client) {
+		client.maxAttempts = max(maxAttempts, 1)
+		client.baseDelay = baseDelay
+	}
+}
+
+// NewClient returns a client for the api at baseURL, including the stage,
+// e.g. https://<api>.execute-api.ap-southeast-2.amazonaws.com/Prod.
+func NewClient(baseURL string, options ...Option) *Client {
+	client := &Client{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		httpClient:  &http.Client{Timeout: defaultTimeout},
+		maxAttempts: defaultMaxAttempts,
+		baseDelay:   defaultBaseDelay,
+		sleep:       sleepContext,
+		now:         time.Now,
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// APIError is a response the api turned the request away with.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *APIError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("petrolapi: %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+	}
+	return fmt.Sprintf("petrolapi: %d %s", err.StatusCode, err.Message)
+}
+
+// sleepContext waits for the delay, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isRetryable reports whether a response status is worth trying again.
+func isRetryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryAfter returns how long the response asked to be retried after, if it did.
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, maxRetryAfter)
+}
+
+// request is a call to the api, its body is kept so it can be sent again.
+type request struct {
+	method  string
+	path    string
+	query   url.Values
+	headers map[string]string
+	body    interface{}
+	// retry is false for requests that shouldn't be sent twice.
+	retry bool
+}
+
+// send sends the request once.
+func (client *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	target := client.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if client.apiKey != "" {
+		httpReq.Header.Set(apiKeyHeader, client.apiKey)
+	}
+	for key, value := range req.headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	return client.httpClient.Do(httpReq)
+}
+
+// do sends the request, retrying network errors and retryable statuses, and decodes the json
+// response into out. responses other than a 2xx are returned as an *APIError.
+func (client *Client) do(ctx context.Context, req request, out interface{}) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+	}
+
+	attempts := client.maxAttempts
+	if !req.retry {
+		attempts = 1
+	}
+
+	var wait time.Duration
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			err := client.sleep(ctx, max(client.baseDelay<<(attempt-2), wait))
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := client.send(ctx, req, body)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= attempts {
+				return err
+			}
+			wait = 0
+			continue
+		}
+		if isRetryable(res.StatusCode) && attempt < attempts {
+			wait = retryAfter(res)
+			res.Body.Close()
+			continue
+		}
+
+		return decodeResponse(res, out)
+	}
+}
+
+// decodeResponse reads the response into out, or returns the error it carries.
+func decodeResponse(res *http.Response, out interface{}) error {
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
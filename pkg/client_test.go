@@ -0,0 +1,124 @@
+package petrolapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for a test server mounted under a /Prod stage, which records
+// the delays it would have slept for.
+func newTestClient(t *testing.T, handler http.HandlerFunc, options ...Option) (*Client, *[]time.Duration) {
+	mux := http.NewServeMux()
+	mux.Handle("/Prod/", http.StripPrefix("/Prod", handler))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL+"/Prod/", options...)
+	delays := &[]time.Duration{}
+	client.sleep = func(ctx context.Context, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return ctx.Err()
+	}
+	return client, delays
+}
+
+func TestRetries(t *testing.T) {
+	attempts := 0
+	client, delays := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "3")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`[{"SiteId": 1, "Name": "City"}]`))
+		}
+	}, WithRetries(4, time.Second))
+
+	sites, err := client.Sites(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 1 || sites[0].Name != "City" {
+		t.Errorf("unexpected sites: %+v", sites)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	// the second retry waits as long as the Retry-After header asked, which is longer than 2s.
+	if len(*delays) != 2 || (*delays)[0] != time.Second || (*delays)[1] != 3*time.Second {
+		t.Errorf("unexpected delays: %v", *delays)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	attempts := 0
+	client, delays := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "unavailable", http.StatusBadGateway)
+	}, WithRetries(3, 100*time.Millisecond))
+
+	_, err := client.Sites(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "unavailable" {
+		t.Fatalf("expected a 502 api error, got %v", err)
+	}
+	if attempts != 3 || len(*delays) != 2 || (*delays)[1] != 200*time.Millisecond {
+		t.Errorf("expected 3 attempts with doubling delays, got %d and %v", attempts, *delays)
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	attempts := 0
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get("x-api-key") != "key" {
+			http.Error(w, "missing api key.", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+
+	_, err := client.Sites(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 api error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+	if !strings.Contains(err.Error(), "missing api key.") {
+		t.Errorf("expected the message in the error, got %q", err.Error())
+	}
+
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" || r.Header.Get("User-Agent") != userAgent {
+			http.Error(w, "missing api key.", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}, WithAPIKey("key"))
+	if _, err := client.Sites(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.Sites(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the request to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package petrolapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	nearbyQuery string = `query Nearby($near: Location!, $radiusKm: Float, $fuelId: Int, $fuelIds: [Int!], $first: Int) {
+	sites(near: $near, radiusKm: $radiusKm, fuelId: $fuelId, first: $first) {
+		id name address postcode lat lng distanceKm
+		brand { name }
+		prices(fuelIds: $fuelIds) { fuelType { id name } price collectionMethod transactionDateUtc current }
+	}
+}`
+
+	historyQuery string = `query History($fuelId: Int!, $regionId: Int!, $days: Int) {
+	history(fuelId: $fuelId, regionId: $regionId, days: $days) { date min median mean count }
+}`
+)
+
+// NearbyOptions picks the sites around a location. a zero radius uses the api's default of 5km,
+// a zero fuel id returns every fuel a site sells, and a zero limit uses the default of 20 sites.
+type NearbyOptions struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+	FuelId   int
+	Limit    int
+}
+
+// SitePrice is the price of a fuel type at a site. Current is false for stale and placeholder
+// prices.
+type SitePrice struct {
+	FuelId             int
+	FuelType           string
+	Price              int
+	CollectionMethod   string
+	TransactionDateUTC string
+	Current            bool
+}
+
+// NearbySite is a site near a location, with its prices.
+type NearbySite struct {
+	SiteId     int
+	Name       string
+	Address    string
+	Postcode   string
+	Brand      string
+	Lat        float64
+	Lng        float64
+	DistanceKm float64
+	Prices     []SitePrice
+}
+
+// HistoryOptions picks the price history of a fuel type in a region. a zero days uses the api's
+// default of 7, and it can be up to 56.
+type HistoryOptions struct {
+	FuelId   int
+	RegionId int
+	Days     int
+}
+
+// DailyPrice summarises the prices of a fuel type across the sites of a region on one day.
+type DailyPrice struct {
+	FuelId   int
+	RegionId int
+	Date     string
+	Min      int
+	Median   float64
+	Mean     float64
+	Count    int
+}
+
+// graphqlResponse is the body of a /graphql response.
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// graphqlErrors joins the messages of the response's errors.
+func (res graphqlResponse) graphqlErrors() string {
+	messages := []string{}
+	for _, err := range res.Errors {
+		messages = append(messages, err.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Client.graphql runs the query and decodes its data into out. errors in the response are
+// returned as an *APIError, even when the rest of the data resolved.
+func (client *Client) graphql(ctx context.Context, query string, variables map[string]interface{}, out interface{}) error {
+	var res graphqlResponse
+	err := client.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/graphql",
+		body:   map[string]interface{}{"query": query, "variables": variables},
+		retry:  true,
+	}, &res)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && json.Unmarshal([]byte(apiErr.Message), &res) == nil && len(res.Errors) > 0 {
+		apiErr.Message = res.graphqlErrors()
+	}
+	if err != nil {
+		return err
+	}
+	if len(res.Errors) > 0 {
+		return &APIError{StatusCode: http.StatusOK, Message: res.graphqlErrors()}
+	}
+
+	return json.Unmarshal(res.Data, out)
+}
+
+// Client.NearbyPrices returns the sites around a location with their prices, nearest first.
+// with a fuel id, only the sites selling it are returned.
+func (client *Client) NearbyPrices(ctx context.Context, options NearbyOptions) ([]NearbySite, error) {
+	// unset variables fall back to the defaults of the query's arguments.
+	variables := map[string]interface{}{
+		"near": map[string]float64{"lat": options.Lat, "lng": options.Lng},
+	}
+	if options.RadiusKm != 0 {
+		variables["radiusKm"] = options.RadiusKm
+	}
+	if options.FuelId != 0 {
+		variables["fuelId"] = options.FuelId
+		variables["fuelIds"] = []int{options.FuelId}
+	}
+	if options.Limit != 0 {
+		variables["first"] = options.Limit
+	}
+
+	var data struct {
+		Sites []struct {
+			Id         int     `json:"id"`
+			Name       string  `json:"name"`
+			Address    string  `json:"address"`
+			Postcode   string  `json:"postcode"`
+			Lat        float64 `json:"lat"`
+			Lng        float64 `json:"lng"`
+			DistanceKm float64 `json:"distanceKm"`
+			Brand      *struct {
+				Name string `json:"name"`
+			} `json:"brand"`
+			Prices []struct {
+				FuelType struct {
+					Id   int    `json:"id"`
+					Name string `json:"name"`
+				} `json:"fuelType"`
+				Price              int    `json:"price"`
+				CollectionMethod   string `json:"collectionMethod"`
+				TransactionDateUtc string `json:"transactionDateUtc"`
+				Current            bool   `json:"current"`
+			} `json:"prices"`
+		} `json:"sites"`
+	}
+	err := client.graphql(ctx, nearbyQuery, variables, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := []NearbySite{}
+	for _, site := range data.Sites {
+		nearby := NearbySite{
+			SiteId:     site.Id,
+			Name:       site.Name,
+			Address:    site.Address,
+			Postcode:   site.Postcode,
+			Lat:        site.Lat,
+			Lng:        site.Lng,
+			DistanceKm: site.DistanceKm,
+			Prices:     []SitePrice{},
+		}
+		if site.Brand != nil {
+			nearby.Brand = site.Brand.Name
+		}
+		for _, price := range site.Prices {
+			nearby.Prices = append(nearby.Prices, SitePrice{
+				FuelId:             price.FuelType.Id,
+				FuelType:           price.FuelType.Name,
+				Price:              price.Price,
+				CollectionMethod:   price.CollectionMethod,
+				TransactionDateUTC: price.TransactionDateUtc,
+				Current:            price.Current,
+			})
+		}
+		sites = append(sites, nearby)
+	}
+	return sites, nil
+}
+
+// Client.History returns the daily prices of a fuel type in a region, oldest first. days without
+// any prices are left out.
+func (client *Client) History(ctx context.Context, options HistoryOptions) ([]DailyPrice, error) {
+	variables := map[string]interface{}{"fuelId": options.FuelId, "regionId": options.RegionId}
+	if options.Days != 0 {
+		variables["days"] = options.Days
+	}
+
+	var data struct {
+		History []struct {
+			Date   string  `json:"date"`
+			Min    int     `json:"min"`
+			Median float64 `json:"median"`
+			Mean   float64 `json:"mean"`
+			Count  int     `json:"count"`
+		} `json:"history"`
+	}
+	err := client.graphql(ctx, historyQuery, variables, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []DailyPrice{}
+	for _, day := range data.History {
+		history = append(history, DailyPrice{
+			FuelId:   options.FuelId,
+			RegionId: options.RegionId,
+			Date:     day.Date,
+			Min:      day.Min,
+			Median:   day.Median,
+			Mean:     day.Mean,
+			Count:    day.Count,
+		})
+	}
+	return history, nil
+}
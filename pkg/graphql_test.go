@@ -0,0 +1,100 @@
+package petrolapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// graphqlHandler answers /graphql requests with the response, after checking the request's
+// variables with check.
+func graphqlHandler(t *testing.T, status int, response string, check func(variables map[string]interface{})) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || r.Method != http.MethodPost || r.URL.Path != "/graphql" || body.Query == "" {
+			t.Errorf("unexpected graphql request: %s %s", r.Method, r.URL.Path)
+		}
+		if check != nil {
+			check(body.Variables)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}
+}
+
+func TestNearbyPrices(t *testing.T) {
+	response := `{"data": {"sites": [
+		{"id": 2, "name": "Norwood", "address": "1 The Parade", "postcode": "5067", "lat": -34.921, "lng": 138.631, "distanceKm": 0.1,
+			"brand": {"name": "BP"}, "prices": [{"fuelType": {"id": 2, "name": "Unleaded"}, "price": 1899, "collectionMethod": "T", "transactionDateUtc": "2024-05-09T01:00:00", "current": true}]},
+		{"id": 1, "name": "City", "address": "", "postcode": "5000", "lat": -34.928, "lng": 138.6, "distanceKm": 2.9, "brand": null, "prices": []}
+	]}}`
+	client, _ := newTestClient(t, graphqlHandler(t, http.StatusOK, response, func(variables map[string]interface{}) {
+		near, _ := variables["near"].(map[string]interface{})
+		if near["lat"] != -34.92 || variables["fuelId"] != float64(2) || variables["first"] != float64(5) {
+			t.Errorf("unexpected variables: %v", variables)
+		}
+		if _, ok := variables["radiusKm"]; ok {
+			t.Error("expected the radius to be left to its default")
+		}
+	}))
+
+	sites, err := client.NearbyPrices(context.Background(), NearbyOptions{Lat: -34.92, Lng: 138.63, FuelId: 2, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 2 || sites[0].SiteId != 2 || sites[0].Brand != "BP" || sites[1].Brand != "" {
+		t.Fatalf("unexpected sites: %+v", sites)
+	}
+	expected := SitePrice{FuelId: 2, FuelType: "Unleaded", Price: 1899, CollectionMethod: "T", TransactionDateUTC: "2024-05-09T01:00:00", Current: true}
+	if len(sites[0].Prices) != 1 || sites[0].Prices[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, sites[0].Prices)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	response := `{"data": {"history": [{"date": "2024-05-08", "min": 1799, "median": 1899, "mean": 1890.5, "count": 40}]}}`
+	client, _ := newTestClient(t, graphqlHandler(t, http.StatusOK, response, func(variables map[string]interface{}) {
+		if variables["fuelId"] != float64(2) || variables["regionId"] != float64(7) || variables["days"] != float64(28) {
+			t.Errorf("unexpected variables: %v", variables)
+		}
+	}))
+
+	history, err := client.History(context.Background(), HistoryOptions{FuelId: 2, RegionId: 7, Days: 28})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DailyPrice{FuelId: 2, RegionId: 7, Date: "2024-05-08", Min: 1799, Median: 1899, Mean: 1890.5, Count: 40}
+	if len(history) != 1 || history[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, history)
+	}
+}
+
+func TestGraphqlErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		expected string
+	}{
+		{"too expensive", http.StatusBadRequest, `{"errors": [{"message": "query costs 4000, more than the limit of 2000."}]}`, "query costs 4000, more than the limit of 2000."},
+		{"resolver", http.StatusOK, `{"data": null, "errors": [{"message": "days must be from 1 to 56."}]}`, "days must be from 1 to 56."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := newTestClient(t, graphqlHandler(t, test.status, test.response, nil))
+
+			_, err := client.History(context.Background(), HistoryOptions{FuelId: 2, RegionId: 7, Days: 100})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != test.status || apiErr.Message != test.expected {
+				t.Errorf("expected %d %q, got %v", test.status, test.expected, err)
+			}
+		})
+	}
+}
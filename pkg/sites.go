@@ -0,0 +1,103 @@
+package petrolapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Site is a petrol station as /sites returns it.
+type Site struct {
+	SiteId        int     `json:"SiteId"`
+	Name          string  `json:"Name"`
+	Lat           float64 `json:"Lat"`
+	Lng           float64 `json:"Lng"`
+	GooglePlaceID string  `json:"GPI"`
+}
+
+// RankedStation is a station selling a fuel type, with its place in the cheapest stations.
+type RankedStation struct {
+	Rank               int     `json:"Rank"`
+	SiteId             int     `json:"SiteId"`
+	Name               string  `json:"Name"`
+	Address            string  `json:"Address"`
+	Postcode           string  `json:"Postcode"`
+	BrandId            int     `json:"BrandId"`
+	Brand              string  `json:"Brand"`
+	RegionId           int     `json:"RegionId"`
+	GooglePlaceID      string  `json:"GPI"`
+	Latitude           float64 `json:"Lat"`
+	Longitude          float64 `json:"Lng"`
+	Price              int     `json:"Price"`
+	CollectionMethod   string  `json:"CollectionMethod"`
+	TransactionDateUTC string  `json:"TransactionDateUTC"`
+	PriceAgeSeconds    int64   `json:"PriceAgeSeconds"`
+}
+
+// CheapestList is the response of /cheapest.
+type CheapestList struct {
+	FuelId   int             `json:"FuelId"`
+	Stations []RankedStation `json:"Stations"`
+}
+
+// CheapestOptions picks the stations /cheapest ranks. the postcode and brand are optional, and
+// a zero limit uses the api's default of 10.
+type CheapestOptions struct {
+	FuelId   int
+	Postcode string
+	BrandId  int
+	Limit    int
+}
+
+// Client.Sites returns every site.
+func (client *Client) Sites(ctx context.Context) ([]Site, error) {
+	sites := []Site{}
+	err := client.do(ctx, request{method: http.MethodGet, path: "/sites", retry: true}, &sites)
+	if err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+// Client.Prices returns the price of the fuel type at each of the sites that sell it, keyed by
+// site id. sites that don't sell it are left out.
+func (client *Client) Prices(ctx context.Context, fuelId int, siteIds []int) (map[int]float64, error) {
+	if siteIds == nil {
+		siteIds = []int{}
+	}
+
+	prices := map[int]float64{}
+	err := client.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/prices",
+		query:  url.Values{"fuelType": {strconv.Itoa(fuelId)}},
+		body:   siteIds,
+		retry:  true,
+	}, &prices)
+	if err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// Client.Cheapest returns the cheapest stations for a fuel type, cheapest first.
+func (client *Client) Cheapest(ctx context.Context, options CheapestOptions) (*CheapestList, error) {
+	query := url.Values{"fuelType": {strconv.Itoa(options.FuelId)}}
+	if options.Postcode != "" {
+		query.Set("postcode", options.Postcode)
+	}
+	if options.BrandId != 0 {
+		query.Set("brand", strconv.Itoa(options.BrandId))
+	}
+	if options.Limit != 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+
+	var list CheapestList
+	err := client.do(ctx, request{method: http.MethodGet, path: "/cheapest", query: query, retry: true}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
@@ -0,0 +1,51 @@
+package petrolapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPrices(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var siteIds []int
+		err := json.NewDecoder(r.Body).Decode(&siteIds)
+		if err != nil || r.Method != http.MethodPost || r.URL.Path != "/prices" || r.URL.Query().Get("fuelType") != "2" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request.", http.StatusBadRequest)
+			return
+		}
+		if len(siteIds) != 2 || siteIds[0] != 61577372 {
+			http.Error(w, "unexpected site ids.", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"61577372": 1899}`))
+	})
+
+	prices, err := client.Prices(context.Background(), 2, []int{61577372, 61577373})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 1 || prices[61577372] != 1899 {
+		t.Errorf("unexpected prices: %v", prices)
+	}
+}
+
+func TestCheapest(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/cheapest" || query.Get("fuelType") != "2" || query.Get("postcode") != "5000" || query.Get("limit") != "3" || query.Has("brand") {
+			http.Error(w, "unexpected request.", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"FuelId": 2, "Stations": [{"Rank": 1, "SiteId": 1, "Name": "City", "Brand": "Shell", "Price": 1799, "Lat": -34.93, "Lng": 138.6}]}`))
+	})
+
+	list, err := client.Cheapest(context.Background(), CheapestOptions{FuelId: 2, Postcode: "5000", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.FuelId != 2 || len(list.Stations) != 1 || list.Stations[0].Price != 1799 || list.Stations[0].Latitude != -34.93 {
+		t.Errorf("unexpected list: %+v", list)
+	}
+}
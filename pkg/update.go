@@ -0,0 +1,125 @@
+package petrolapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	updatePath       string = "/update"
+	updateStatusPath string = "/update/status"
+	updateRunsPath   string = "/update/runs"
+	timestampHeader  string = "x-update-timestamp"
+	signatureHeader  string = "x-update-signature"
+)
+
+// UpdateRun is the summary of one run of the update lambda.
+type UpdateRun struct {
+	RunId            string    `json:"RunId"`
+	Trigger          string    `json:"Trigger"`
+	Status           string    `json:"Status"`
+	StartedAt        time.Time `json:"StartedAt"`
+	EndedAt          time.Time `json:"EndedAt"`
+	Regions          []string  `json:"Regions"`
+	UpstreamPricesMs int64     `json:"UpstreamPricesMs"`
+	UpstreamSitesMs  int64     `json:"UpstreamSitesMs"`
+	PricesFetched    int       `json:"PricesFetched"`
+	PricesChanged    int       `json:"PricesChanged"`
+	PricesWritten    int       `json:"PricesWritten"`
+	SitesFetched     int       `json:"SitesFetched"`
+	SitesChanged     int       `json:"SitesChanged"`
+	SitesWritten     int       `json:"SitesWritten"`
+	Retries          int       `json:"Retries"`
+	Errors           []string  `json:"Errors"`
+}
+
+// UpdateStatus is the latest update run, and the last one that succeeded.
+type UpdateStatus struct {
+	LatestRun           *UpdateRun `json:"LatestRun"`
+	LastSuccessfulRun   *UpdateRun `json:"LastSuccessfulRun"`
+	SecondsSinceSuccess *int64     `json:"SecondsSinceSuccess"`
+}
+
+// signUpdateRequest returns the hex encoded hmac-sha256 of the timestamp, method and path, the
+// same way the update lambda checks it.
+func signUpdateRequest(secret string, timestamp string, method string, path string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s\n%s\n%s", timestamp, method, path)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Client.signedHeaders returns the headers that sign a get of the path with the admin secret,
+// which every update route needs.
+func (client *Client) signedHeaders(path string) (map[string]string, error) {
+	if client.adminSecret == "" {
+		return nil, errors.New("petrolapi: an admin secret is needed for the update routes")
+	}
+
+	timestamp := strconv.FormatInt(client.now().Unix(), 10)
+	return map[string]string{
+		timestampHeader: timestamp,
+		signatureHeader: signUpdateRequest(client.adminSecret, timestamp, http.MethodGet, path),
+	}, nil
+}
+
+// Client.UpdateStatus returns the latest and last successful update runs. it needs the admin
+// secret.
+func (client *Client) UpdateStatus(ctx context.Context) (*UpdateStatus, error) {
+	headers, err := client.signedHeaders(updateStatusPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var status UpdateStatus
+	err = client.do(ctx, request{method: http.MethodGet, path: updateStatusPath, headers: headers, retry: true}, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Client.UpdateRuns returns the most recent update runs, newest first. a zero limit uses the
+// api's default of 10, and limits above 50 are capped. it needs the admin secret.
+func (client *Client) UpdateRuns(ctx context.Context, limit int) ([]UpdateRun, error) {
+	headers, err := client.signedHeaders(updateRunsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	runs := []UpdateRun{}
+	err = client.do(ctx, request{method: http.MethodGet, path: updateRunsPath, query: query, headers: headers, retry: true}, &runs)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Client.TriggerUpdate runs an update now and returns its summary. it needs the admin secret,
+// and isn't retried so a slow run isn't started twice. a run already in progress is an
+// *APIError with a 409 status.
+func (client *Client) TriggerUpdate(ctx context.Context) (*UpdateRun, error) {
+	headers, err := client.signedHeaders(updatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var run UpdateRun
+	err = client.do(ctx, request{method: http.MethodGet, path: updatePath, headers: headers}, &run)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
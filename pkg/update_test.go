@@ -0,0 +1,105 @@
+package petrolapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTriggerUpdate(t *testing.T) {
+	now := time.Unix(1715300000, 0)
+	attempts := 0
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		timestamp := r.Header.Get(timestampHeader)
+		if r.URL.Path != updatePath || timestamp != "1715300000" || r.Header.Get(signatureHeader) != signUpdateRequest("secret", timestamp, http.MethodGet, updatePath) {
+			http.Error(w, "invalid request signature.", http.StatusUnauthorized)
+			return
+		}
+		if attempts > 1 {
+			http.Error(w, "update already running", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"RunId": "a", "Trigger": "manual", "Status": "succeeded", "PricesWritten": 12, "Errors": []}`))
+	}, WithAdminSecret("secret"))
+	client.now = func() time.Time { return now }
+
+	run, err := client.TriggerUpdate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RunId != "a" || run.PricesWritten != 12 {
+		t.Errorf("unexpected run: %+v", run)
+	}
+
+	_, err = client.TriggerUpdate(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected a 409 api error, got %v", err)
+	}
+
+	_, err = NewClient("http://localhost").TriggerUpdate(context.Background())
+	if err == nil {
+		t.Error("expected triggering without an admin secret to fail")
+	}
+}
+
+func TestTriggerUpdateIsNotRetried(t *testing.T) {
+	attempts := 0
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "timed out", http.StatusGatewayTimeout)
+	}, WithAdminSecret("secret"))
+
+	_, err := client.TriggerUpdate(context.Background())
+	if err == nil || attempts != 1 {
+		t.Errorf("expected a single failed attempt, got %d and %v", attempts, err)
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		timestamp := r.Header.Get(timestampHeader)
+		if r.Header.Get(signatureHeader) != signUpdateRequest("secret", timestamp, http.MethodGet, r.URL.Path) {
+			http.Error(w, "invalid request signature.", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/update/status":
+			w.Write([]byte(`{"LatestRun": {"RunId": "b", "Status": "failed", "Errors": ["upstream timed out"]}, "LastSuccessfulRun": null, "SecondsSinceSuccess": null}`))
+		case "/update/runs":
+			if r.URL.Query().Get("limit") != "2" {
+				http.Error(w, "unexpected limit.", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`[{"RunId": "b", "StartedAt": "2024-05-10T00:15:00Z"}, {"RunId": "a", "StartedAt": "2024-05-10T00:00:00Z"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}, WithAdminSecret("secret"))
+
+	status, err := client.UpdateStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LatestRun == nil || status.LatestRun.Errors[0] != "upstream timed out" || status.LastSuccessfulRun != nil || status.SecondsSinceSuccess != nil {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	runs, err := client.UpdateRuns(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].RunId != "b" || !runs[1].StartedAt.Equal(time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected runs: %+v", runs)
+	}
+
+	_, err = NewClient("http://localhost").UpdateStatus(context.Background())
+	if err == nil {
+		t.Error("expected the status without an admin secret to fail")
+	}
+}
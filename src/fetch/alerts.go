@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	alertsTableName    string = "alert_subscriptions"
+	triggeredTableName string = "triggered_alerts"
+	alertsPath         string = "/alerts"
+	alertsPathPrefix   string = "/alerts/"
+	triggeredPath      string = "/alerts/triggered"
+
+	// anonymousOwner owns the alerts made without an api key, when keys aren't required.
+	anonymousOwner string = "anonymous"
+
+	maxAlertsPerOwner      int     = 20
+	maxAlertSites          int     = 50
+	defaultAlertRadiusKm   float64 = 5
+	maxAlertRadiusKm       float64 = 50
+	defaultCooldownMinutes int     = 60
+	// minCooldownMinutes matches how often the prices are updated.
+	minCooldownMinutes int = 15
+	maxCooldownMinutes int = 7 * 24 * 60
+
+	defaultTriggeredLimit int = 20
+	maxTriggeredLimit     int = 100
+)
+
+// AlertSubscription asks to be alerted when a station sells a fuel type below the threshold. the
+// stations are either the listed sites, or every site within the radius of a location. prices are
+// in the same units as the json endpoints.
+type AlertSubscription struct {
+	Owner           string     `json:"-"`
+	AlertId         string     `json:"AlertId"`
+	FuelId          int        `json:"FuelId"`
+	Threshold       int        `json:"Threshold"`
+	SiteIds         []int      `json:"SiteIds"`
+	Latitude        *float64   `json:"Lat"`
+	Longitude       *float64   `json:"Lng"`
+	RadiusKm        float64    `json:"RadiusKm"`
+	CooldownMinutes int        `json:"CooldownMinutes"`
+	CreatedAt       time.Time  `json:"CreatedAt"`
+	LastTriggeredAt *time.Time `json:"LastTriggeredAt"`
+}
+
+// AlertMatch is a station that was below the threshold when an alert triggered.
+type AlertMatch struct {
+	SiteId             int    `json:"SiteId"`
+	Price              int    `json:"Price"`
+	TransactionDateUTC string `json:"TransactionDateUTC"`
+}
+
+// TriggeredAlert is the record of a subscription triggering, written by the update lambda.
+type TriggeredAlert struct {
+	AlertId     string       `json:"AlertId"`
+	FuelId      int          `json:"FuelId"`
+	Threshold   int          `json:"Threshold"`
+	TriggeredAt time.Time    `json:"TriggeredAt"`
+	Matches     []AlertMatch `json:"Matches"`
+}
+
+// alertOwner returns who the request's alerts belong to, the hash of its api key.
+func alertOwner(request events.APIGatewayProxyRequest) string {
+	rawKey := getHeader(request, apiKeyHeader)
+	if rawKey == "" {
+		return anonymousOwner
+	}
+	return hashAPIKey(rawKey)
+}
+
+// newAlertId returns a random id for a subscription.
+func newAlertId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// AlertSubscription.Validate checks the subscription names a fuel type, a threshold and either
+// sites or a location, filling in the default radius and cooldown.
+func (sub *AlertSubscription) Validate() error {
+	if sub.Threshold <= 0 {
+		return errors.New("Threshold must be a positive price.")
+	}
+
+	hasLocation := sub.Latitude != nil || sub.Longitude != nil
+	switch {
+	case len(sub.SiteIds) == 0 && !hasLocation:
+		return errors.New("either SiteIds or Lat and Lng are required.")
+	case len(sub.SiteIds) > 0 && hasLocation:
+		return errors.New("only one of SiteIds or Lat and Lng can be set.")
+	case len(sub.SiteIds) > maxAlertSites:
+		return fmt.Errorf("at most %d SiteIds can be watched.", maxAlertSites)
+	}
+
+	if hasLocation {
+		sub.SiteIds = []int{}
+		if sub.Latitude == nil || sub.Longitude == nil || *sub.Latitude < -90 || *sub.Latitude > 90 || *sub.Longitude < -180 || *sub.Longitude > 180 {
+			return errors.New("Lat and Lng must both be set to a valid location.")
+		}
+		if sub.RadiusKm == 0 {
+			sub.RadiusKm = defaultAlertRadiusKm
+		}
+		if sub.RadiusKm < 0 || sub.RadiusKm > maxAlertRadiusKm {
+			return fmt.Errorf("RadiusKm must be from 0 to %g.", maxAlertRadiusKm)
+		}
+	} else {
+		sub.RadiusKm = 0
+	}
+
+	if sub.CooldownMinutes == 0 {
+		sub.CooldownMinutes = defaultCooldownMinutes
+	}
+	if sub.CooldownMinutes < minCooldownMinutes || sub.CooldownMinutes > maxCooldownMinutes {
+		return fmt.Errorf("CooldownMinutes must be from %d to %d.", minCooldownMinutes, maxCooldownMinutes)
+	}
+
+	return nil
+}
+
+// AlertSubscription.Marshal returns a dynamodb representation of the AlertSubscription struct.
+func (sub AlertSubscription) Marshal() map[string]*dynamodb.AttributeValue {
+	siteIds := []*dynamodb.AttributeValue{}
+	for _, siteId := range sub.SiteIds {
+		siteIds = append(siteIds, &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(siteId))})
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		"Owner":           {S: aws.String(sub.Owner)},
+		"AlertId":         {S: aws.String(sub.AlertId)},
+		"FuelId":          {N: aws.String(strconv.Itoa(sub.FuelId))},
+		"Threshold":       {N: aws.String(strconv.Itoa(sub.Threshold))},
+		"SiteIds":         {L: siteIds},
+		"CooldownMinutes": {N: aws.String(strconv.Itoa(sub.CooldownMinutes))},
+		"CreatedAt":       {S: aws.String(sub.CreatedAt.UTC().Format(time.RFC3339))},
+	}
+	if sub.Latitude != nil && sub.Longitude != nil {
+		item["Lat"] = &dynamodb.AttributeValue{N: aws.String(decimal.NewFromFloat(*sub.Latitude).String())}
+		item["Lng"] = &dynamodb.AttributeValue{N: aws.String(decimal.NewFromFloat(*sub.Longitude).String())}
+		item["RadiusKm"] = &dynamodb.AttributeValue{N: aws.String(decimal.NewFromFloat(sub.RadiusKm).String())}
+	}
+	if sub.LastTriggeredAt != nil {
+		item["LastTriggeredAt"] = &dynamodb.AttributeValue{S: aws.String(sub.LastTriggeredAt.UTC().Format(time.RFC3339))}
+	}
+	return item
+}
+
+func (sub *AlertSubscription) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	alertIdRecord, ok := record["AlertId"]
+	if !ok {
+		return errors.New("alert record is missing AlertId")
+	}
+	sub.AlertId = aws.StringValue(alertIdRecord.S)
+	sub.Owner = aws.StringValue(record["Owner"].S)
+
+	ints := map[string]*int{"FuelId": &sub.FuelId, "Threshold": &sub.Threshold, "CooldownMinutes": &sub.CooldownMinutes}
+	for name, value := range ints {
+		if numRecord, ok := record[name]; ok {
+			*value, err = strconv.Atoi(aws.StringValue(numRecord.N))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sub.SiteIds = []int{}
+	if siteIdsRecord, ok := record["SiteIds"]; ok {
+		for _, siteIdRecord := range siteIdsRecord.L {
+			siteId, err := strconv.Atoi(aws.StringValue(siteIdRecord.N))
+			if err != nil {
+				return err
+			}
+			sub.SiteIds = append(sub.SiteIds, siteId)
+		}
+	}
+
+	floats := map[string]**float64{"Lat": &sub.Latitude, "Lng": &sub.Longitude}
+	for name, value := range floats {
+		if numRecord, ok := record[name]; ok {
+			parsed, err := strconv.ParseFloat(aws.StringValue(numRecord.N), 64)
+			if err != nil {
+				return err
+			}
+			*value = &parsed
+		}
+	}
+	if radiusRecord, ok := record["RadiusKm"]; ok {
+		sub.RadiusKm, err = strconv.ParseFloat(aws.StringValue(radiusRecord.N), 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	if createdRecord, ok := record["CreatedAt"]; ok {
+		sub.CreatedAt, err = time.Parse(time.RFC3339, aws.StringValue(createdRecord.S))
+		if err != nil {
+			return err
+		}
+	}
+	if triggeredRecord, ok := record["LastTriggeredAt"]; ok {
+		triggeredAt, err := time.Parse(time.RFC3339, aws.StringValue(triggeredRecord.S))
+		if err != nil {
+			return err
+		}
+		sub.LastTriggeredAt = &triggeredAt
+	}
+
+	return nil
+}
+
+func (alert *TriggeredAlert) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	alertIdRecord, ok := record["AlertId"]
+	if !ok {
+		return errors.New("triggered alert record is missing AlertId")
+	}
+	alert.AlertId = aws.StringValue(alertIdRecord.S)
+
+	if fuelRecord, ok := record["FuelId"]; ok {
+		alert.FuelId, err = strconv.Atoi(aws.StringValue(fuelRecord.N))
+		if err != nil {
+			return err
+		}
+	}
+	if thresholdRecord, ok := record["Threshold"]; ok {
+		alert.Threshold, err = strconv.Atoi(aws.StringValue(thresholdRecord.N))
+		if err != nil {
+			return err
+		}
+	}
+	if triggeredRecord, ok := record["TriggeredAt"]; ok {
+		alert.TriggeredAt, err = time.Parse(time.RFC3339, aws.StringValue(triggeredRecord.S))
+		if err != nil {
+			return err
+		}
+	}
+
+	alert.Matches = []AlertMatch{}
+	if matchesRecord, ok := record["Matches"]; ok {
+		for _, matchRecord := range matchesRecord.L {
+			match := AlertMatch{TransactionDateUTC: aws.StringValue(matchRecord.M["D"].S)}
+			match.SiteId, err = strconv.Atoi(aws.StringValue(matchRecord.M["SiteId"].N))
+			if err != nil {
+				return err
+			}
+			match.Price, err = strconv.Atoi(aws.StringValue(matchRecord.M["P"].N))
+			if err != nil {
+				return err
+			}
+			alert.Matches = append(alert.Matches, match)
+		}
+	}
+
+	return nil
+}
+
+// queryOwnerItems returns the owner's items from a table keyed by Owner, newest sort key first
+// when descending.
+func queryOwnerItems(ctx context.Context, client *dynamodb.DynamoDB, table string, owner string, limit int, descending bool) ([]map[string]*dynamodb.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+		ScanIndexForward: aws.Bool(!descending),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
+	}
+
+	res, err := client.QueryWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return res.Items, nil
+}
+
+func respondWithJSON(status int, body interface{}) (events.APIGatewayProxyResponse, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling response.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
+		Body:       string(encoded),
+	}, nil
+}
+
+// createAlert subscribes the caller to the alert in the request body.
+func createAlert(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var sub AlertSubscription
+	err := json.Unmarshal([]byte(request.Body), &sub)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: "invalid alert json."}, nil
+	}
+	err = sub.Validate()
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+
+	client := getClient()
+	sub.Owner = alertOwner(request)
+	existing, err := queryOwnerItems(ctx, client, alertsTableName, sub.Owner, 0, false)
+	if err != nil {
+		return respondWithStdErr(err, "error while reading alerts.")
+	}
+	if len(existing) >= maxAlertsPerOwner {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusConflict,
+			Body:       fmt.Sprintf("at most %d alerts can be subscribed to.", maxAlertsPerOwner),
+		}, nil
+	}
+
+	sub.AlertId, err = newAlertId()
+	if err != nil {
+		return respondWithStdErr(err, "error while creating alert id.")
+	}
+	sub.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	sub.LastTriggeredAt = nil
+
+	_, err = client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(alertsTableName),
+		Item:      sub.Marshal(),
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while saving alert.")
+	}
+
+	logger.Info("alert subscribed", "alert_id", sub.AlertId, "fuel_id", sub.FuelId)
+	return respondWithJSON(http.StatusCreated, sub)
+}
+
+// listAlerts returns the caller's subscriptions.
+func listAlerts(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	records, err := queryOwnerItems(ctx, getClient(), alertsTableName, alertOwner(request), 0, false)
+	if err != nil {
+		return respondWithStdErr(err, "error while reading alerts.")
+	}
+
+	subs := []AlertSubscription{}
+	for _, record := range records {
+		var sub AlertSubscription
+		err = sub.Unmarshal(record)
+		if err != nil {
+			return respondWithStdErr(err, "error while unmarshalling alerts.")
+		}
+		subs = append(subs, sub)
+	}
+	return respondWithJSON(http.StatusOK, subs)
+}
+
+// listTriggeredAlerts returns the caller's most recently triggered alerts, newest first.
+func listTriggeredAlerts(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	limit := defaultTriggeredLimit
+	if rawLimit := request.QueryStringParameters["limit"]; rawLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxTriggeredLimit {
+			return events.APIGatewayProxyResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       fmt.Sprintf("limit must be an integer from 1 to %d.", maxTriggeredLimit),
+			}, nil
+		}
+	}
+
+	records, err := queryOwnerItems(ctx, getClient(), triggeredTableName, alertOwner(request), limit, true)
+	if err != nil {
+		return respondWithStdErr(err, "error while reading triggered alerts.")
+	}
+
+	alerts := []TriggeredAlert{}
+	for _, record := range records {
+		var alert TriggeredAlert
+		err = alert.Unmarshal(record)
+		if err != nil {
+			return respondWithStdErr(err, "error while unmarshalling triggered alerts.")
+		}
+		alerts = append(alerts, alert)
+	}
+	return respondWithJSON(http.StatusOK, alerts)
+}
+
+// deleteAlert unsubscribes the caller from the alert in the path.
+func deleteAlert(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	alertId := strings.TrimPrefix(request.Path, alertsPathPrefix)
+	if alertId == "" || strings.Contains(alertId, "/") {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound, Body: "alert not found."}, nil
+	}
+
+	res, err := getClient().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(alertsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":   {S: aws.String(alertOwner(request))},
+			"AlertId": {S: aws.String(alertId)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while deleting alert.")
+	}
+	if len(res.Attributes) == 0 {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound, Body: "alert not found."}, nil
+	}
+
+	logger.Info("alert unsubscribed", "alert_id", alertId)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusNoContent}, nil
+}
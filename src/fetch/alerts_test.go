@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAlertSubscriptionValidate(t *testing.T) {
+	lat, lng, badLat := -34.9, 138.6, 95.0
+
+	sub := AlertSubscription{FuelId: 2, Threshold: 1900, Latitude: &lat, Longitude: &lng}
+	if err := sub.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if sub.RadiusKm != defaultAlertRadiusKm || sub.CooldownMinutes != defaultCooldownMinutes {
+		t.Errorf("expected the defaults to be filled in, got %+v", sub)
+	}
+
+	tests := []struct {
+		name string
+		sub  AlertSubscription
+	}{
+		{"no threshold", AlertSubscription{FuelId: 2, SiteIds: []int{1}}},
+		{"no sites or location", AlertSubscription{FuelId: 2, Threshold: 1900}},
+		{"sites and location", AlertSubscription{FuelId: 2, Threshold: 1900, SiteIds: []int{1}, Latitude: &lat, Longitude: &lng}},
+		{"half a location", AlertSubscription{FuelId: 2, Threshold: 1900, Latitude: &lat}},
+		{"invalid location", AlertSubscription{FuelId: 2, Threshold: 1900, Latitude: &badLat, Longitude: &lng}},
+		{"too far", AlertSubscription{FuelId: 2, Threshold: 1900, Latitude: &lat, Longitude: &lng, RadiusKm: 100}},
+		{"short cooldown", AlertSubscription{FuelId: 2, Threshold: 1900, SiteIds: []int{1}, CooldownMinutes: 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.sub.Validate(); err == nil {
+				t.Error("expected the subscription to be rejected")
+			}
+		})
+	}
+}
+
+func TestAlertSubscriptionMarshalling(t *testing.T) {
+	lat, lng := -34.9, 138.6
+	triggeredAt := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, sub := range []AlertSubscription{
+		{Owner: "owner", AlertId: "a", FuelId: 2, Threshold: 1900, SiteIds: []int{1, 2}, CooldownMinutes: 60, CreatedAt: triggeredAt},
+		{Owner: "owner", AlertId: "b", FuelId: 2, Threshold: 1900, SiteIds: []int{}, Latitude: &lat, Longitude: &lng, RadiusKm: 2.5, CooldownMinutes: 60, CreatedAt: triggeredAt, LastTriggeredAt: &triggeredAt},
+	} {
+		var decoded AlertSubscription
+		err := decoded.Unmarshal(sub.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Owner != sub.Owner || decoded.AlertId != sub.AlertId || decoded.Threshold != sub.Threshold || len(decoded.SiteIds) != len(sub.SiteIds) {
+			t.Errorf("expected %+v, got %+v", sub, decoded)
+		}
+		if (decoded.Latitude == nil) != (sub.Latitude == nil) || decoded.RadiusKm != sub.RadiusKm || !decoded.CreatedAt.Equal(sub.CreatedAt) {
+			t.Errorf("expected %+v, got %+v", sub, decoded)
+		}
+		if (decoded.LastTriggeredAt == nil) != (sub.LastTriggeredAt == nil) {
+			t.Errorf("expected LastTriggeredAt %v, got %v", sub.LastTriggeredAt, decoded.LastTriggeredAt)
+		}
+	}
+}
+
+func TestAlertOwner(t *testing.T) {
+	if owner := alertOwner(events.APIGatewayProxyRequest{}); owner != anonymousOwner {
+		t.Errorf("expected a request without a key to be anonymous, got %s", owner)
+	}
+
+	owner := alertOwner(events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": "secret"}})
+	if owner != hashAPIKey("secret") {
+		t.Errorf("expected the alerts to belong to the key hash, got %s", owner)
+	}
+}
+
+func TestDeleteAlertPath(t *testing.T) {
+	for _, path := range []string{"/alerts/", "/alerts/a/b"} {
+		res, err := deleteAlert(context.Background(), events.APIGatewayProxyRequest{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("expected %s to be not found, got %d", path, res.StatusCode)
+		}
+	}
+}
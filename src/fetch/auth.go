@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	apiKeysTableName     string = "api_keys"
+	apiKeyUsageTableName string = "api_key_usage"
+	apiKeyHeader         string = "x-api-key"
+
+	// maxUsageAttempts is how many times counting a request is tried while it conflicts with other
+	// requests counting against the same key.
+	maxUsageAttempts int = 3
+)
+
+// usageRetryDelay is the wait before counting a request again after a conflict, doubling after
+// each attempt. it is shortened by tests.
+var usageRetryDelay time.Duration = 20 * time.Millisecond
+
+// APIKey is a partner key as stored in the keys table, only the sha256 of the key is kept.
+type APIKey struct {
+	KeyHash      string
+	Owner        string
+	Endpoints    []string
+	DailyQuota   int
+	MonthlyQuota int
+	Disabled     bool
+}
+
+// hashAPIKey returns the hex encoded sha256 of the raw key, which is what the keys table is indexed by.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// getHeader returns a header value regardless of the casing api gateway passed it through with.
+func getHeader(request events.APIGatewayProxyRequest, name string) string {
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// APIKey.Allows reports whether the key is scoped to the given path, "*" allows every endpoint
+// and an endpoint ending in "/*" allows every path under it, e.g. "/tiles/*".
+func (key APIKey) Allows(path string) bool {
+	for _, endpoint := range key.Endpoints {
+		if endpoint == "*" || endpoint == path {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(endpoint, "*"); ok && strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey.Unmarshal reads a key record from the keys table.
+func (key *APIKey) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	hashRecord, ok := record["KeyHash"]
+	if !ok {
+		return errors.New("key record is missing KeyHash")
+	}
+	key.KeyHash = *hashRecord.S
+
+	if ownerRecord, ok := record["Owner"]; ok {
+		key.Owner = *ownerRecord.S
+	}
+
+	key.Endpoints = []string{}
+	if endpointsRecord, ok := record["Endpoints"]; ok {
+		key.Endpoints = aws.StringValueSlice(endpointsRecord.SS)
+	}
+
+	if dailyRecord, ok := record["DailyQuota"]; ok {
+		key.DailyQuota, err = strconv.Atoi(*dailyRecord.N)
+		if err != nil {
+			return err
+		}
+	}
+
+	if monthlyRecord, ok := record["MonthlyQuota"]; ok {
+		key.MonthlyQuota, err = strconv.Atoi(*monthlyRecord.N)
+		if err != nil {
+			return err
+		}
+	}
+
+	if disabledRecord, ok := record["Disabled"]; ok {
+		key.Disabled = aws.BoolValue(disabledRecord.BOOL)
+	}
+
+	return nil
+}
+
+func getAPIKey(ctx context.Context, client *dynamodb.DynamoDB, keyHash string) (*APIKey, error) {
+	res, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(apiKeysTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"KeyHash": {S: aws.String(keyHash)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, nil
+	}
+
+	var key APIKey
+	err = key.Unmarshal(res.Item)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// usageIds returns the usage table keys for the day and month that now falls in.
+func usageIds(keyHash string, now time.Time) (daily string, monthly string) {
+	now = now.UTC()
+	return fmt.Sprintf("%s#%s", keyHash, now.Format("2006-01-02")),
+		fmt.Sprintf("%s#%s", keyHash, now.Format("2006-01"))
+}
+
+// usageUpdate builds the counter increment for a single period, a quota of 0 is unlimited.
+func usageUpdate(usageId string, quota int) *dynamodb.TransactWriteItem {
+	update := &dynamodb.Update{
+		TableName: aws.String(apiKeyUsageTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"UsageId": {S: aws.String(usageId)},
+		},
+		UpdateExpression:         aws.String("ADD #count :one"),
+		ExpressionAttributeNames: map[string]*string{"#count": aws.String("Count")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+	}
+
+	if quota > 0 {
+		update.ConditionExpression = aws.String("attribute_not_exists(#count) OR #count < :quota")
+		update.ExpressionAttributeValues[":quota"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(quota))}
+	}
+
+	return &dynamodb.TransactWriteItem{Update: update}
+}
+
+// quotaExceeded reports whether a transaction was cancelled by one of the quota conditions, rather
+// than by a conflict with another transaction on the same counters.
+func quotaExceeded(err error) bool {
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return false
+	}
+	for _, reason := range canceled.CancellationReasons {
+		if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+// recordUsage counts a request against the daily and monthly quotas, both counters only move if
+// both are under quota. returns false if the key has run out of requests. concurrent requests on
+// the key can cancel each other's counts, those are tried again.
+func recordUsage(ctx context.Context, client *dynamodb.DynamoDB, key APIKey, now time.Time) (bool, error) {
+	dailyId, monthlyId := usageIds(key.KeyHash, now)
+
+	for attempt := 1; ; attempt++ {
+		_, err := client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				usageUpdate(dailyId, key.DailyQuota),
+				usageUpdate(monthlyId, key.MonthlyQuota),
+			},
+		})
+		if err == nil {
+			return true, nil
+		}
+		if quotaExceeded(err) {
+			return false, nil
+		}
+
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeTransactionCanceledException || attempt == maxUsageAttempts {
+			return false, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(usageRetryDelay << (attempt - 1)):
+		}
+	}
+}
+
+// authorizeRequest checks the api key on the request and counts it against the key's quotas.
+// the returned response is only meaningful when ok is false.
+func authorizeRequest(ctx context.Context, request events.APIGatewayProxyRequest) (res events.APIGatewayProxyResponse, ok bool) {
+	rawKey := getHeader(request, apiKeyHeader)
+	if rawKey == "" {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusUnauthorized,
+			Body:       "missing api key.",
+		}, false
+	}
+
+	client := getClient()
+	key, err := getAPIKey(ctx, client, hashAPIKey(rawKey))
+	if err != nil {
+		res, _ = respondWithStdErr(err, "error while looking up api key.")
+		return res, false
+	}
+	if key == nil || key.Disabled {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusUnauthorized,
+			Body:       "invalid api key.",
+		}, false
+	}
+
+	if !key.Allows(request.Path) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusForbidden,
+			Body:       "api key is not allowed to access this endpoint.",
+		}, false
+	}
+
+	allowed, err := recordUsage(ctx, client, *key, time.Now())
+	if err != nil {
+		res, _ = respondWithStdErr(err, "error while recording api key usage.")
+		return res, false
+	}
+	if !allowed {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Body:       "api key quota exceeded.",
+		}, false
+	}
+
+	logger.Info("authorized request", "owner", key.Owner)
+	return events.APIGatewayProxyResponse{}, true
+}
+
+// withAPIKey only passes the request through to next once it has been authorized.
+func withAPIKey(ctx context.Context, request events.APIGatewayProxyRequest, next func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)) (events.APIGatewayProxyResponse, error) {
+	if !isAuthRequired {
+		return next(ctx, request)
+	}
+
+	res, ok := authorizeRequest(ctx, request)
+	if !ok {
+		return res, nil
+	}
+	return next(ctx, request)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	hash := hashAPIKey("abc")
+	if hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash for key: %s", hash)
+	}
+
+	if hashAPIKey("abc") == hashAPIKey("abd") {
+		t.Error("expected different keys to hash differently")
+	}
+}
+
+func TestGetHeader(t *testing.T) {
+	request := events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"X-Api-Key": "1234",
+		},
+	}
+
+	if v := getHeader(request, apiKeyHeader); v != "1234" {
+		t.Errorf("expected header value 1234, got %s", v)
+	}
+	if v := getHeader(request, "Authorization"); v != "" {
+		t.Errorf("expected missing header to be empty, got %s", v)
+	}
+}
+
+func TestAPIKeyAllows(t *testing.T) {
+	key := APIKey{Endpoints: []string{"/sites"}}
+	if !key.Allows("/sites") {
+		t.Error("expected key to allow /sites")
+	}
+	if key.Allows("/prices") {
+		t.Error("expected key to not allow /prices")
+	}
+
+	key = APIKey{Endpoints: []string{"*"}}
+	if !key.Allows("/prices") {
+		t.Error("expected wildcard key to allow /prices")
+	}
+
+	key = APIKey{Endpoints: []string{"/tiles/*"}}
+	if !key.Allows("/tiles/12/3/4.mvt") {
+		t.Error("expected prefix key to allow a tile")
+	}
+	if key.Allows("/tilesets") || key.Allows("/sites") {
+		t.Error("expected prefix key to only allow paths under /tiles/")
+	}
+
+	key = APIKey{}
+	if key.Allows("/sites") {
+		t.Error("expected unscoped key to allow nothing")
+	}
+}
+
+func TestAPIKeyUnmarshalling(t *testing.T) {
+	record := map[string]*dynamodb.AttributeValue{
+		"KeyHash":      {S: aws.String("abc")},
+		"Owner":        {S: aws.String("partner")},
+		"Endpoints":    {SS: aws.StringSlice([]string{"/sites", "/prices"})},
+		"DailyQuota":   {N: aws.String("100")},
+		"MonthlyQuota": {N: aws.String("2000")},
+		"Disabled":     {BOOL: aws.Bool(true)},
+	}
+
+	var key APIKey
+	err := key.Unmarshal(record)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if key.KeyHash != "abc" {
+		t.Errorf("expected KeyHash == 'abc', got %s", key.KeyHash)
+	}
+	if key.Owner != "partner" {
+		t.Errorf("expected Owner == 'partner', got %s", key.Owner)
+	}
+	if len(key.Endpoints) != 2 {
+		t.Errorf("expected 2 endpoints, got %d", len(key.Endpoints))
+	}
+	if key.DailyQuota != 100 {
+		t.Errorf("expected DailyQuota == 100, got %d", key.DailyQuota)
+	}
+	if key.MonthlyQuota != 2000 {
+		t.Errorf("expected MonthlyQuota == 2000, got %d", key.MonthlyQuota)
+	}
+	if !key.Disabled {
+		t.Error("expected key to be disabled")
+	}
+
+	err = key.Unmarshal(map[string]*dynamodb.AttributeValue{})
+	if err == nil {
+		t.Error("expected an error for a record without a KeyHash")
+	}
+}
+
+func TestUsageIds(t *testing.T) {
+	now := time.Date(2024, 3, 31, 23, 30, 0, 0, time.FixedZone("ACDT", 10*60*60+30*60))
+
+	daily, monthly := usageIds("abc", now)
+	if daily != "abc#2024-03-31" {
+		t.Errorf("unexpected daily usage id: %s", daily)
+	}
+	if monthly != "abc#2024-03" {
+		t.Errorf("unexpected monthly usage id: %s", monthly)
+	}
+}
+
+func TestUsageUpdate(t *testing.T) {
+	update := usageUpdate("abc#2024-03", 0).Update
+	if update.ConditionExpression != nil {
+		t.Error("expected no condition for an unlimited quota")
+	}
+
+	update = usageUpdate("abc#2024-03", 10).Update
+	if update.ConditionExpression == nil {
+		t.Error("expected a condition for a limited quota")
+	}
+	if *update.ExpressionAttributeValues[":quota"].N != "10" {
+		t.Errorf("unexpected quota value: %s", *update.ExpressionAttributeValues[":quota"].N)
+	}
+}
+
+func TestMissingAPIKey(t *testing.T) {
+	res, ok := authorizeRequest(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodGet,
+		Path:       "/sites",
+	})
+	if ok {
+		t.Error("expected request without a key to be rejected")
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status code 401, got %d", res.StatusCode)
+	}
+}
+
+// newTestUsageDynamo returns a client for a local dynamodb endpoint that cancels the usage
+// transactions, one for each list of cancellation reasons, and accepts them once they run out.
+func newTestUsageDynamo(t *testing.T, cancellations [][]string, calls *int) *dynamodb.DynamoDB {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if *calls > len(cancellations) {
+			w.Write([]byte("{}"))
+			return
+		}
+
+		reasons := []map[string]string{}
+		for _, code := range cancellations[*calls-1] {
+			reasons = append(reasons, map[string]string{"Code": code})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"__type":              "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+			"message":             "Transaction cancelled",
+			"CancellationReasons": reasons,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	session, err := session.NewSession(aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamodb.New(session)
+}
+
+func TestRecordUsage(t *testing.T) {
+	previous := usageRetryDelay
+	usageRetryDelay = time.Millisecond
+	t.Cleanup(func() { usageRetryDelay = previous })
+
+	key := APIKey{KeyHash: "abc", DailyQuota: 100, MonthlyQuota: 2000}
+	now := time.Now()
+
+	calls := 0
+	allowed, err := recordUsage(context.Background(), newTestUsageDynamo(t, nil, &calls), key, now)
+	if err != nil || !allowed || calls != 1 {
+		t.Errorf("expected the request to be counted, got %t, %v after %d calls", allowed, err, calls)
+	}
+
+	// a quota condition failing is the key running out of requests.
+	calls = 0
+	allowed, err = recordUsage(context.Background(), newTestUsageDynamo(t, [][]string{{"ConditionalCheckFailed", "None"}}, &calls), key, now)
+	if err != nil || allowed || calls != 1 {
+		t.Errorf("expected the quota to be exceeded, got %t, %v after %d calls", allowed, err, calls)
+	}
+
+	// conflicting with another request on the key isn't, it's counted again.
+	calls = 0
+	conflicts := [][]string{{"TransactionConflict", "None"}, {"None", "TransactionConflict"}}
+	allowed, err = recordUsage(context.Background(), newTestUsageDynamo(t, conflicts, &calls), key, now)
+	if err != nil || !allowed || calls != 3 {
+		t.Errorf("expected the request to be counted after the conflicts, got %t, %v after %d calls", allowed, err, calls)
+	}
+
+	// and fails the request, rather than rejecting it for quota, if it keeps conflicting.
+	calls = 0
+	conflicts = [][]string{{"TransactionConflict", "None"}, {"TransactionConflict", "None"}, {"TransactionConflict", "None"}}
+	allowed, err = recordUsage(context.Background(), newTestUsageDynamo(t, conflicts, &calls), key, now)
+	if err == nil || allowed || calls != maxUsageAttempts {
+		t.Errorf("expected an error after %d conflicts, got %t, %v after %d calls", maxUsageAttempts, allowed, err, calls)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	versionsTableName string = "data_versions"
+
+	datasetPrices string = "prices"
+	datasetSites  string = "sites"
+
+	// prices change every update run, so clients always revalidate them. sites change at most
+	// daily, so clients can reuse them for an hour before revalidating.
+	pricesCacheControl string = "private, no-cache"
+	sitesCacheControl  string = "public, max-age=3600"
+)
+
+// DataVersion marks the last update run that changed a dataset.
+type DataVersion struct {
+	Dataset   string
+	Version   string
+	UpdatedAt time.Time
+}
+
+// DataVersion.Unmarshal reads a version record from the versions table.
+func (version *DataVersion) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	datasetRecord, ok := record["Dataset"]
+	if !ok {
+		return errors.New("version record is missing Dataset")
+	}
+	version.Dataset = aws.StringValue(datasetRecord.S)
+
+	versionRecord, ok := record["Version"]
+	if !ok {
+		return errors.New("version record is missing Version")
+	}
+	version.Version = aws.StringValue(versionRecord.S)
+
+	if updatedRecord, ok := record["UpdatedAt"]; ok {
+		version.UpdatedAt, err = time.Parse(time.RFC3339, aws.StringValue(updatedRecord.S))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDataVersion returns the current version of the dataset, or nil if it hasn't been recorded.
+func getDataVersion(ctx context.Context, client *dynamodb.DynamoDB, dataset string) (*DataVersion, error) {
+	res, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(versionsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Dataset": {S: aws.String(dataset)},
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, nil
+	}
+
+	var version DataVersion
+	err = version.Unmarshal(res.Item)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// computeETag returns a strong ETag for the response to the request at the given data version,
+// the query, accept header and body are included as they select what is returned.
+func computeETag(version DataVersion, request events.APIGatewayProxyRequest) string {
+	keys := []string{}
+	for key := range request.QueryStringParameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, part := range []string{version.Dataset, version.Version, request.HTTPMethod, request.Path, getHeader(request, "Accept")} {
+		hash.Write([]byte(part + "\n"))
+	}
+	for _, key := range keys {
+		hash.Write([]byte(key + "=" + request.QueryStringParameters[key] + "\n"))
+	}
+	hash.Write([]byte(request.Body))
+
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// matchesETag reports whether an If-None-Match header matches the etag, using the weak comparison.
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotModified reports whether the client's copy is still current. If-None-Match takes
+// precedence over If-Modified-Since when both are sent.
+func isNotModified(request events.APIGatewayProxyRequest, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := getHeader(request, "If-None-Match"); ifNoneMatch != "" {
+		return matchesETag(ifNoneMatch, etag)
+	}
+
+	if ifModifiedSince := getHeader(request, "If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil || lastModified.IsZero() {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// getDataVersions returns the combined version of the datasets a response is built from, or nil
+// if any of them hasn't been recorded.
+func getDataVersions(ctx context.Context, client *dynamodb.DynamoDB, datasets []string) (*DataVersion, error) {
+	combined := DataVersion{}
+	for i, dataset := range datasets {
+		version, err := getDataVersion(ctx, client, dataset)
+		if err != nil || version == nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			combined.Dataset += ","
+			combined.Version += ","
+		}
+		combined.Dataset += version.Dataset
+		combined.Version += version.Version
+		if version.UpdatedAt.After(combined.UpdatedAt) {
+			combined.UpdatedAt = version.UpdatedAt
+		}
+	}
+	return &combined, nil
+}
+
+// withCaching serves the datasets with ETag, Last-Modified and Cache-Control headers, answering
+// with a 304 instead of calling next when the client's copy is still current. POST /prices is a
+// read, so it is revalidated the same way as the GET endpoints.
+func withCaching(ctx context.Context, request events.APIGatewayProxyRequest, datasets []string, cacheControl string, next func(context.Context) (events.APIGatewayProxyResponse, error)) (events.APIGatewayProxyResponse, error) {
+	version, err := getDataVersions(ctx, getClient(), datasets)
+	if err != nil {
+		logger.Warn("error while getting data version, serving uncached", "datasets", datasets, "error", err)
+	}
+	if version == nil {
+		return next(ctx)
+	}
+
+	headers := map[string]string{
+		"ETag":          computeETag(*version, request),
+		"Cache-Control": cacheControl,
+		"Vary":          "Accept",
+	}
+	if !version.UpdatedAt.IsZero() {
+		headers["Last-Modified"] = version.UpdatedAt.UTC().Format(http.TimeFormat)
+	}
+
+	if isNotModified(request, headers["ETag"], version.UpdatedAt) {
+		logger.Info("not modified", "datasets", datasets, "version", version.Version)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusNotModified,
+			Headers:    headers,
+		}, nil
+	}
+
+	res, err := next(ctx)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+	for key, value := range headers {
+		res.Headers[key] = value
+	}
+	return res, nil
+}
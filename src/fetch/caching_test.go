@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDataVersionUnmarshalling(t *testing.T) {
+	var version DataVersion
+	err := version.Unmarshal(map[string]*dynamodb.AttributeValue{
+		"Dataset":   {S: aws.String(datasetPrices)},
+		"Version":   {S: aws.String("abc")},
+		"UpdatedAt": {S: aws.String("2024-03-31T13:00:00Z")},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if version.Dataset != datasetPrices {
+		t.Errorf("unexpected dataset: %s", version.Dataset)
+	}
+	if version.Version != "abc" {
+		t.Errorf("unexpected version: %s", version.Version)
+	}
+	if !version.UpdatedAt.Equal(time.Date(2024, 3, 31, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected UpdatedAt: %s", version.UpdatedAt)
+	}
+
+	err = version.Unmarshal(map[string]*dynamodb.AttributeValue{"Dataset": {S: aws.String(datasetPrices)}})
+	if err == nil {
+		t.Error("expected an error for a record without a Version")
+	}
+}
+
+func TestComputeETag(t *testing.T) {
+	version := DataVersion{Dataset: datasetPrices, Version: "abc"}
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:            http.MethodPost,
+		Path:                  "/prices",
+		QueryStringParameters: map[string]string{"fuelType": "2"},
+		Body:                  "[1,2,3]",
+	}
+
+	etag := computeETag(version, request)
+	if len(etag) != 34 || etag[0] != '"' || etag[33] != '"' {
+		t.Errorf("expected a quoted etag, got %s", etag)
+	}
+	if computeETag(version, request) != etag {
+		t.Error("expected the etag to be stable")
+	}
+
+	other := request
+	other.Body = "[1,2]"
+	if computeETag(version, other) == etag {
+		t.Error("expected a different body to change the etag")
+	}
+
+	other = request
+	other.QueryStringParameters = map[string]string{"fuelType": "3"}
+	if computeETag(version, other) == etag {
+		t.Error("expected a different query to change the etag")
+	}
+
+	if computeETag(DataVersion{Dataset: datasetPrices, Version: "abd"}, request) == etag {
+		t.Error("expected a new version to change the etag")
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	etag := `"abc"`
+	lastModified := time.Date(2024, 3, 31, 13, 0, 0, 500, time.UTC)
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{"no conditional headers", map[string]string{}, false},
+		{"matching etag", map[string]string{"If-None-Match": `"abc"`}, true},
+		{"weak matching etag", map[string]string{"if-none-match": `W/"abc"`}, true},
+		{"one of several etags", map[string]string{"If-None-Match": `"xyz", "abc"`}, true},
+		{"wildcard etag", map[string]string{"If-None-Match": "*"}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"xyz"`}, false},
+		{"modified since", map[string]string{"If-Modified-Since": "Sun, 31 Mar 2024 12:59:59 GMT"}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": "Sun, 31 Mar 2024 13:00:00 GMT"}, true},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{"etag takes precedence", map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": "Sun, 31 Mar 2024 13:00:00 GMT"}, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{Headers: testCase.headers}
+			if isNotModified(request, etag, lastModified) != testCase.expected {
+				t.Errorf("expected not modified == %t", testCase.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultCheapestLimit int = 10
+	maxCheapestLimit     int = 100
+)
+
+// RankedStation is a site in the cheapest list, with its price for the fuel type. stations with
+// the same price share a rank.
+type RankedStation struct {
+	Rank               int     `json:"Rank"`
+	SiteId             int     `json:"SiteId"`
+	Name               string  `json:"Name"`
+	Address            string  `json:"Address"`
+	Postcode           string  `json:"Postcode"`
+	BrandId            int     `json:"BrandId"`
+	Brand              string  `json:"Brand"`
+	RegionId           int     `json:"RegionId"`
+	GooglePlaceID      string  `json:"GPI"`
+	Latitude           float64 `json:"Lat"`
+	Longitude          float64 `json:"Lng"`
+	Price              int     `json:"Price"`
+	CollectionMethod   string  `json:"CollectionMethod"`
+	TransactionDateUTC string  `json:"TransactionDateUTC"`
+	PriceAgeSeconds    int64   `json:"PriceAgeSeconds"`
+}
+
+// CheapestList is the response of /cheapest.
+type CheapestList struct {
+	FuelId   int             `json:"FuelId"`
+	Stations []RankedStation `json:"Stations"`
+}
+
+// CheapestFilter narrows the cheapest list to a postcode or brand, when they are set.
+type CheapestFilter struct {
+	Postcode string
+	BrandId  int
+}
+
+// CheapestFilter.Matches reports whether the site passes the filter.
+func (filter CheapestFilter) Matches(site SA_PetrolStationSite) bool {
+	if filter.Postcode != "" && site.Postcode != filter.Postcode {
+		return false
+	}
+	return filter.BrandId == 0 || site.BrandID == filter.BrandId
+}
+
+// cheapestStations returns up to limit stations with the lowest current price for the fuel type,
+// cheapest first. placeholder and stale prices are left out. ties are ordered by the most recent
+// price and then the site id.
+func cheapestStations(sites []SA_PetrolStationSite, prices FuelPriceList, fuelId int, filter CheapestFilter, limit int, brands map[int]string, now time.Time) []RankedStation {
+	stations := []RankedStation{}
+	dates := map[int]time.Time{}
+	for _, site := range sites {
+		if !filter.Matches(site) {
+			continue
+		}
+
+		price, ok := prices.Sites[site.SiteID].FuelTypes[fuelId]
+		if !ok || !isCurrentPrice(price, now) {
+			continue
+		}
+
+		date, _ := parseTransactionDate(price.TransactionDateUTC)
+		dates[site.SiteID] = date
+		stations = append(stations, RankedStation{
+			SiteId:             site.SiteID,
+			Name:               site.Name,
+			Address:            site.Address,
+			Postcode:           site.Postcode,
+			BrandId:            site.BrandID,
+			Brand:              brands[site.BrandID],
+			RegionId:           site.RegionID,
+			GooglePlaceID:      site.GooglePlaceID,
+			Latitude:           site.Latitude,
+			Longitude:          site.Longitude,
+			Price:              price.Price,
+			CollectionMethod:   price.CollectionMethod,
+			TransactionDateUTC: price.TransactionDateUTC,
+			PriceAgeSeconds:    int64(now.Sub(date).Seconds()),
+		})
+	}
+
+	sort.Slice(stations, func(i, j int) bool {
+		a, b := stations[i], stations[j]
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+		if !dates[a.SiteId].Equal(dates[b.SiteId]) {
+			return dates[a.SiteId].After(dates[b.SiteId])
+		}
+		return a.SiteId < b.SiteId
+	})
+
+	if len(stations) > limit {
+		stations = stations[:limit]
+	}
+	for n := range stations {
+		stations[n].Rank = n + 1
+		if n > 0 && stations[n].Price == stations[n-1].Price {
+			stations[n].Rank = stations[n-1].Rank
+		}
+	}
+	return stations
+}
+
+// getCheapest returns the cheapest stations for a fuel type, optionally in a postcode or of a
+// brand.
+func getCheapest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	respondBadRequest := func(message string) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: message}, nil
+	}
+
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return respondBadRequest("fuelType must be an integer.")
+	}
+
+	limit := defaultCheapestLimit
+	if rawLimit := request.QueryStringParameters["limit"]; rawLimit != "" {
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxCheapestLimit {
+			return respondBadRequest("limit must be an integer from 1 to 100.")
+		}
+	}
+
+	filter := CheapestFilter{Postcode: request.QueryStringParameters["postcode"]}
+	if rawBrand := request.QueryStringParameters["brand"]; rawBrand != "" {
+		filter.BrandId, err = strconv.Atoi(rawBrand)
+		if err != nil {
+			return respondBadRequest("brand must be an integer brand id.")
+		}
+	}
+
+	client := getClient()
+	siteRecords, err := scanTable(ctx, client, sitesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, siteRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	priceRecords, err := scanTable(ctx, client, pricesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	prices, err := unmarshalPrices(ctx, priceRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling fuel prices.")
+	}
+
+	brands, err := getReferenceNames(ctx, client, referenceBrands)
+	if err != nil {
+		logger.Warn("error while getting brand names", "error", err)
+		brands = map[int]string{}
+	}
+
+	_, span := startSpan(ctx, "rank stations", attribute.Int("limit", limit))
+	stations := cheapestStations(sites, prices, fuelId, filter, limit, brands, time.Now().UTC())
+	span.SetAttributes(attribute.Int("stations", len(stations)))
+	span.End()
+
+	body, err := json.Marshal(CheapestList{FuelId: fuelId, Stations: stations})
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling stations.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
+		Body:       string(body),
+	}, nil
+}
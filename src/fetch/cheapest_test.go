@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheapestStations(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	sites := []SA_PetrolStationSite{
+		{SiteID: 1, Name: "One", Postcode: "5000", BrandID: 5},
+		{SiteID: 2, Name: "Two", Postcode: "5000", BrandID: 9},
+		{SiteID: 3, Name: "Three", Postcode: "5700", BrandID: 9},
+		{SiteID: 4, Name: "Four", Postcode: "5000", BrandID: 5},
+		{SiteID: 5, Name: "Five", Postcode: "5000", BrandID: 5},
+		{SiteID: 6, Name: "Six", Postcode: "5000", BrandID: 5},
+	}
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1900, TransactionDateUTC: "2024-05-10T11:00:00"}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 1800, TransactionDateUTC: "2024-05-10T10:00:00"}}},
+		3: {SiteID: 3, FuelTypes: map[int]FuelPrice{2: {Price: 1800, TransactionDateUTC: "2024-05-10T11:00:00"}}},
+		4: {SiteID: 4, FuelTypes: map[int]FuelPrice{2: {Price: 9999, TransactionDateUTC: "2024-05-10T11:00:00"}}},
+		5: {SiteID: 5, FuelTypes: map[int]FuelPrice{2: {Price: 1500, TransactionDateUTC: "2024-03-01T11:00:00"}}},
+		6: {SiteID: 6, FuelTypes: map[int]FuelPrice{3: {Price: 1500, TransactionDateUTC: "2024-05-10T11:00:00"}}},
+	}}
+	brands := map[int]string{5: "Shell", 9: "BP"}
+
+	stations := cheapestStations(sites, prices, 2, CheapestFilter{}, 10, brands, now)
+	if len(stations) != 3 {
+		t.Fatalf("expected the placeholder, stale and other fuel prices to be left out, got %+v", stations)
+	}
+
+	// the tie is broken by the most recent price, and shares a rank.
+	if stations[0].SiteId != 3 || stations[1].SiteId != 2 || stations[2].SiteId != 1 {
+		t.Errorf("unexpected order: %+v", stations)
+	}
+	if stations[0].Rank != 1 || stations[1].Rank != 1 || stations[2].Rank != 3 {
+		t.Errorf("unexpected ranks: %d, %d, %d", stations[0].Rank, stations[1].Rank, stations[2].Rank)
+	}
+	if stations[0].PriceAgeSeconds != 3600 || stations[0].Brand != "BP" || stations[0].Postcode != "5700" {
+		t.Errorf("unexpected station details: %+v", stations[0])
+	}
+
+	stations = cheapestStations(sites, prices, 2, CheapestFilter{}, 1, brands, now)
+	if len(stations) != 1 || stations[0].SiteId != 3 {
+		t.Errorf("expected the limit to keep the cheapest station, got %+v", stations)
+	}
+
+	stations = cheapestStations(sites, prices, 2, CheapestFilter{Postcode: "5000"}, 10, brands, now)
+	if len(stations) != 2 || stations[0].SiteId != 2 {
+		t.Errorf("expected only the stations in 5000, got %+v", stations)
+	}
+
+	stations = cheapestStations(sites, prices, 2, CheapestFilter{Postcode: "5000", BrandId: 5}, 10, brands, now)
+	if len(stations) != 1 || stations[0].SiteId != 1 {
+		t.Errorf("expected only the brand's stations in 5000, got %+v", stations)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// clusterPixels is the width of a cluster's grid cell on screen, assuming 256 pixel tiles.
+	clusterPixels float64 = 60
+	tilePixels    float64 = 256
+	maxClusters   int     = 5000
+)
+
+// BoundingBox is a lat/long box, as sent in the bbox query param.
+type BoundingBox struct {
+	MinLng float64
+	MinLat float64
+	MaxLng float64
+	MaxLat float64
+}
+
+// BoundingBox.Contains reports whether the location falls inside the box.
+func (bbox BoundingBox) Contains(lat float64, lng float64) bool {
+	return lat >= bbox.MinLat && lat <= bbox.MaxLat && lng >= bbox.MinLng && lng <= bbox.MaxLng
+}
+
+// parseBoundingBox reads a minLng,minLat,maxLng,maxLat bbox query param.
+func parseBoundingBox(raw string) (BoundingBox, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, errors.New("bbox must be minLng,minLat,maxLng,maxLat.")
+	}
+
+	values := [4]float64{}
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BoundingBox{}, errors.New("bbox must be minLng,minLat,maxLng,maxLat.")
+		}
+		values[i] = value
+	}
+
+	bbox := BoundingBox{MinLng: values[0], MinLat: values[1], MaxLng: values[2], MaxLat: values[3]}
+	if bbox.MinLng > bbox.MaxLng || bbox.MinLat > bbox.MaxLat || bbox.MinLat < -90 || bbox.MaxLat > 90 || bbox.MinLng < -180 || bbox.MaxLng > 180 {
+		return BoundingBox{}, errors.New("bbox is out of range.")
+	}
+	return bbox, nil
+}
+
+// Cluster is a group of nearby sites. prices are in the same units as the json endpoints, and
+// are null when none of the sites sell the fuel type.
+type Cluster struct {
+	Count       int      `json:"Count"`
+	Lat         float64  `json:"Lat"`
+	Lng         float64  `json:"Lng"`
+	PricedCount int      `json:"PricedCount"`
+	MinPrice    *int     `json:"MinPrice"`
+	AvgPrice    *float64 `json:"AvgPrice"`
+	SiteIds     []int    `json:"SiteIds"`
+}
+
+// ClusterList is the response of /clusters.
+type ClusterList struct {
+	Zoom     int       `json:"Zoom"`
+	FuelId   int       `json:"FuelId"`
+	Clusters []Cluster `json:"Clusters"`
+}
+
+// clusterSites groups the sites in the box into clusters, by the grid cell they fall in at the
+// zoom level. the centroid is the mean location of the cluster's sites, and its prices are over
+// the sites with a current price for the fuel type. clusters are ordered by their lowest site id.
+func clusterSites(sites []SA_PetrolStationSite, prices FuelPriceList, fuelId int, bbox BoundingBox, zoom int, now time.Time) []Cluster {
+	sites = append([]SA_PetrolStationSite{}, sites...)
+	sort.Slice(sites, func(i, j int) bool { return sites[i].SiteID < sites[j].SiteID })
+
+	// the size of a cell, in tiles.
+	cellSize := clusterPixels / tilePixels
+
+	clusters := []*Cluster{}
+	cells := map[[2]int]*Cluster{}
+	priceTotals := map[*Cluster]int{}
+	for _, site := range sites {
+		if !bbox.Contains(site.Latitude, site.Longitude) {
+			continue
+		}
+
+		worldX, worldY := worldPosition(site.Latitude, site.Longitude, zoom)
+		cell := [2]int{int(math.Floor(worldX / cellSize)), int(math.Floor(worldY / cellSize))}
+		cluster, ok := cells[cell]
+		if !ok {
+			cluster = &Cluster{SiteIds: []int{}}
+			cells[cell] = cluster
+			clusters = append(clusters, cluster)
+		}
+
+		cluster.Count++
+		cluster.Lat += site.Latitude
+		cluster.Lng += site.Longitude
+		cluster.SiteIds = append(cluster.SiteIds, site.SiteID)
+
+		if price, ok := prices.Sites[site.SiteID].FuelTypes[fuelId]; ok && isCurrentPrice(price, now) {
+			cluster.PricedCount++
+			priceTotals[cluster] += price.Price
+			if cluster.MinPrice == nil || price.Price < *cluster.MinPrice {
+				minPrice := price.Price
+				cluster.MinPrice = &minPrice
+			}
+		}
+	}
+
+	result := []Cluster{}
+	for _, cluster := range clusters {
+		cluster.Lat /= float64(cluster.Count)
+		cluster.Lng /= float64(cluster.Count)
+		if cluster.PricedCount > 0 {
+			avgPrice := float64(priceTotals[cluster]) / float64(cluster.PricedCount)
+			cluster.AvgPrice = &avgPrice
+		}
+		result = append(result, *cluster)
+	}
+	return result
+}
+
+// getClusters returns the clusters of sites in the request's bbox, at its zoom level.
+func getClusters(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	respondBadRequest := func(message string) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: message}, nil
+	}
+
+	bbox, err := parseBoundingBox(request.QueryStringParameters["bbox"])
+	if err != nil {
+		return respondBadRequest(err.Error())
+	}
+	zoom, err := strconv.Atoi(request.QueryStringParameters["zoom"])
+	if err != nil || zoom < 0 || zoom > maxTileZoom {
+		return respondBadRequest("zoom must be an integer from 0 to 22.")
+	}
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return respondBadRequest("fuelType must be an integer.")
+	}
+
+	client := getClient()
+	siteRecords, err := scanTable(ctx, client, sitesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, siteRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	priceRecords, err := scanTable(ctx, client, pricesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	prices, err := unmarshalPrices(ctx, priceRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling fuel prices.")
+	}
+
+	_, span := startSpan(ctx, "cluster sites", attribute.Int("zoom", zoom))
+	clusters := clusterSites(sites, prices, fuelId, bbox, zoom, time.Now().UTC())
+	span.SetAttributes(attribute.Int("clusters", len(clusters)))
+	span.End()
+	if len(clusters) > maxClusters {
+		return respondBadRequest("too many clusters, use a smaller bbox or a lower zoom.")
+	}
+
+	body, err := json.Marshal(ClusterList{Zoom: zoom, FuelId: fuelId, Clusters: clusters})
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling clusters.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
+		Body:       string(body),
+	}, nil
+}
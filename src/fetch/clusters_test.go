@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBoundingBox(t *testing.T) {
+	bbox, err := parseBoundingBox("138.4, -35.1,138.8,-34.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bbox != (BoundingBox{MinLng: 138.4, MinLat: -35.1, MaxLng: 138.8, MaxLat: -34.7}) {
+		t.Errorf("unexpected bbox: %+v", bbox)
+	}
+
+	for _, raw := range []string{"", "138.4,-35.1,138.8", "a,b,c,d", "138.8,-35.1,138.4,-34.7", "138.4,-95,138.8,-34.7"} {
+		if _, err := parseBoundingBox(raw); err == nil {
+			t.Errorf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestClusterSites(t *testing.T) {
+	sites := []SA_PetrolStationSite{
+		{SiteID: 3, Latitude: -34.9302, Longitude: 138.6002},
+		{SiteID: 1, Latitude: -34.9300, Longitude: 138.6000},
+		{SiteID: 2, Latitude: -34.9301, Longitude: 138.6001},
+		{SiteID: 4, Latitude: -34.7, Longitude: 138.65},
+		{SiteID: 5, Latitude: -31.95, Longitude: 115.86},
+	}
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 2800, TransactionDateUTC: "2024-05-10T03:12:00"}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 2600, TransactionDateUTC: "2024-05-09T03:12:00"}}},
+		3: {SiteID: 3, FuelTypes: map[int]FuelPrice{3: {Price: 1999, TransactionDateUTC: "2024-05-10T03:12:00"}}},
+	}}
+	adelaide := BoundingBox{MinLng: 138.4, MinLat: -35.1, MaxLng: 138.8, MaxLat: -34.6}
+
+	clusters := clusterSites(sites, prices, 2, adelaide, 10, now)
+	if len(clusters) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(clusters))
+	}
+
+	city := clusters[0]
+	if city.Count != 3 || len(city.SiteIds) != 3 || city.SiteIds[0] != 1 {
+		t.Errorf("unexpected city cluster: %+v", city)
+	}
+	if city.PricedCount != 2 || city.MinPrice == nil || *city.MinPrice != 2600 || city.AvgPrice == nil || *city.AvgPrice != 2700 {
+		t.Errorf("unexpected city cluster prices: %+v", city)
+	}
+	if city.Lat > -34.9300 || city.Lat < -34.9302 || city.Lng < 138.6000 || city.Lng > 138.6002 {
+		t.Errorf("expected the centroid to be between the sites, got %f, %f", city.Lat, city.Lng)
+	}
+
+	if clusters[1].Count != 1 || clusters[1].SiteIds[0] != 4 || clusters[1].MinPrice != nil || clusters[1].AvgPrice != nil {
+		t.Errorf("expected an unpriced cluster for site 4: %+v", clusters[1])
+	}
+
+	// zoomed out everything in the box is one cluster.
+	if clusters := clusterSites(sites, prices, 2, adelaide, 4, now); len(clusters) != 1 || clusters[0].Count != 4 {
+		t.Errorf("expected a single cluster of 4 sites, got %+v", clusters)
+	}
+
+	// zoomed in every site stands alone.
+	if clusters := clusterSites(sites, prices, 2, adelaide, 20, now); len(clusters) != 4 {
+		t.Errorf("expected 4 clusters, got %d", len(clusters))
+	}
+
+	// placeholder and stale prices don't count towards the cluster's prices.
+	prices.Sites[3] = FuelStation{SiteID: 3, FuelTypes: map[int]FuelPrice{2: {Price: 9999, TransactionDateUTC: "2024-05-10T03:12:00"}}}
+	prices.Sites[4] = FuelStation{SiteID: 4, FuelTypes: map[int]FuelPrice{2: {Price: 1899, TransactionDateUTC: "2024-04-01T03:12:00"}}}
+	clusters = clusterSites(sites, prices, 2, adelaide, 10, now)
+	if city := clusters[0]; city.PricedCount != 2 || *city.MinPrice != 2600 || *city.AvgPrice != 2700 {
+		t.Errorf("expected the placeholder price to be left out: %+v", city)
+	}
+	if clusters[1].PricedCount != 0 || clusters[1].MinPrice != nil {
+		t.Errorf("expected the stale price to be left out: %+v", clusters[1])
+	}
+}
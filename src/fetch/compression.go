@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	encodingBrotli   string = "br"
+	encodingGzip     string = "gzip"
+	encodingIdentity string = "identity"
+
+	// minCompressSize is the smallest body worth compressing, below it the encoding overhead and
+	// base64 padding outweigh the saving.
+	minCompressSize int = 1024
+)
+
+// supportedEncodings are the encodings we can send, in order of preference.
+var supportedEncodings = []string{encodingBrotli, encodingGzip}
+
+// parseAcceptEncoding reads the quality of each encoding in an Accept-Encoding header.
+func parseAcceptEncoding(header string) map[string]float64 {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if encoding == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					quality = parsed
+				}
+			}
+		}
+		qualities[encoding] = quality
+	}
+	return qualities
+}
+
+// negotiateEncoding picks the supported encoding the client prefers, or identity if it accepts
+// none of them.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := parseAcceptEncoding(acceptEncoding)
+
+	best, bestQuality := encodingIdentity, 0.0
+	for _, encoding := range supportedEncodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compress encodes the body with the given encoding.
+func compress(body []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	switch encoding {
+	case encodingBrotli:
+		w = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return body, nil
+	}
+
+	_, err := w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// weakETag returns the etag as a weak one. compressed bodies get weak etags, since each encoding
+// of a response is the same data but not the same bytes.
+func weakETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// compressResponse encodes large bodies with the encoding negotiated from the request's
+// Accept-Encoding header. the compressed body is base64 encoded for the proxy integration, which
+// decodes it again before it is sent to the client. a 304 is sent the weak etag the compressed
+// response it stands for would have had.
+func compressResponse(request events.APIGatewayProxyRequest, res events.APIGatewayProxyResponse) events.APIGatewayProxyResponse {
+	if res.StatusCode == http.StatusNotModified && res.Headers["ETag"] != "" {
+		if negotiateEncoding(getHeader(request, "Accept-Encoding")) != encodingIdentity {
+			res.Headers["ETag"] = weakETag(res.Headers["ETag"])
+		}
+		return res
+	}
+	if res.IsBase64Encoded || len(res.Body) < minCompressSize {
+		return res
+	}
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+	if _, ok := res.Headers["Content-Encoding"]; ok {
+		return res
+	}
+	addVary(res.Headers, "Accept-Encoding")
+
+	encoding := negotiateEncoding(getHeader(request, "Accept-Encoding"))
+	if encoding == encodingIdentity {
+		return res
+	}
+
+	body, err := compress([]byte(res.Body), encoding)
+	if err != nil {
+		logger.Error("error while compressing response, sending it uncompressed", "encoding", encoding, "error", err)
+		return res
+	}
+
+	logger.Debug("compressed response", "encoding", encoding, "bytes", len(res.Body), "compressed_bytes", len(body))
+	res.Headers["Content-Encoding"] = encoding
+	if etag, ok := res.Headers["ETag"]; ok {
+		res.Headers["ETag"] = weakETag(etag)
+	}
+	res.Body = base64.StdEncoding.EncodeToString(body)
+	res.IsBase64Encoded = true
+	return res
+}
+
+// decodeRequest undoes the base64 encoding api gateway applies to request bodies once binary media
+// types are enabled for compressed responses.
+func decodeRequest(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRequest, error) {
+	if !request.IsBase64Encoded {
+		return request, nil
+	}
+
+	body, err := base64.StdEncoding.DecodeString(request.Body)
+	if err != nil {
+		return request, err
+	}
+	request.Body = string(body)
+	request.IsBase64Encoded = false
+	return request, nil
+}
+
+// addVary adds the header to the response's Vary header.
+func addVary(headers map[string]string, header string) {
+	if vary, ok := headers["Vary"]; ok && vary != "" {
+		headers["Vary"] = vary + ", " + header
+		return
+	}
+	headers["Vary"] = header
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", encodingIdentity},
+		{"gzip", encodingGzip},
+		{"gzip, deflate, br", encodingBrotli},
+		{"br;q=0.5, gzip", encodingGzip},
+		{"br;q=0, gzip;q=0", encodingIdentity},
+		{"*", encodingBrotli},
+		{"*;q=0.5, gzip;q=0.8", encodingGzip},
+		{"deflate", encodingIdentity},
+		{"GZIP", encodingGzip},
+	}
+
+	for _, testCase := range testCases {
+		encoding := negotiateEncoding(testCase.acceptEncoding)
+		if encoding != testCase.expected {
+			t.Errorf("expected %q to negotiate %s, got %s", testCase.acceptEncoding, testCase.expected, encoding)
+		}
+	}
+}
+
+func decompress(t *testing.T, res events.APIGatewayProxyResponse) string {
+	body, err := base64.StdEncoding.DecodeString(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r io.Reader
+	switch res.Headers["Content-Encoding"] {
+	case encodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	case encodingGzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unexpected encoding: %s", res.Headers["Content-Encoding"])
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
+
+func TestCompressResponse(t *testing.T) {
+	large := strings.Repeat(`{"SiteId":61577372,"Name":"Station"},`, 100)
+
+	for _, encoding := range supportedEncodings {
+		request := events.APIGatewayProxyRequest{Headers: map[string]string{"accept-encoding": encoding}}
+		res := compressResponse(request, events.APIGatewayProxyResponse{StatusCode: 200, Body: large})
+
+		if !res.IsBase64Encoded {
+			t.Fatalf("expected a %s response to be base64 encoded", encoding)
+		}
+		if res.Headers["Content-Encoding"] != encoding {
+			t.Errorf("expected Content-Encoding %s, got %s", encoding, res.Headers["Content-Encoding"])
+		}
+		if res.Headers["Vary"] != "Accept-Encoding" {
+			t.Error("expected the response to vary on Accept-Encoding")
+		}
+		if decompress(t, res) != large {
+			t.Errorf("expected the %s body to decompress to the original", encoding)
+		}
+	}
+}
+
+func TestCompressResponseSkipped(t *testing.T) {
+	small := events.APIGatewayProxyResponse{StatusCode: 200, Body: "{}"}
+	request := events.APIGatewayProxyRequest{Headers: map[string]string{"Accept-Encoding": "gzip"}}
+
+	res := compressResponse(request, small)
+	if res.IsBase64Encoded || res.Body != "{}" {
+		t.Error("expected a small response to stay uncompressed")
+	}
+
+	large := events.APIGatewayProxyResponse{StatusCode: 200, Body: strings.Repeat("a", minCompressSize)}
+	res = compressResponse(events.APIGatewayProxyRequest{}, large)
+	if res.IsBase64Encoded || res.Body != large.Body {
+		t.Error("expected a response to stay uncompressed without Accept-Encoding")
+	}
+	if res.Headers["Vary"] != "Accept-Encoding" {
+		t.Error("expected the response to vary on Accept-Encoding")
+	}
+}
+
+func TestCompressedETags(t *testing.T) {
+	large := strings.Repeat(`{"SiteId":61577372,"Name":"Station"},`, 100)
+	respond := func(status int, body string) events.APIGatewayProxyResponse {
+		return events.APIGatewayProxyResponse{StatusCode: status, Headers: map[string]string{"ETag": `"abc"`}, Body: body}
+	}
+	gzipped := events.APIGatewayProxyRequest{Headers: map[string]string{"Accept-Encoding": "gzip"}}
+
+	if etag := compressResponse(gzipped, respond(http.StatusOK, large)).Headers["ETag"]; etag != `W/"abc"` {
+		t.Errorf("expected a compressed response to have a weak etag, got %s", etag)
+	}
+	if etag := compressResponse(events.APIGatewayProxyRequest{}, respond(http.StatusOK, large)).Headers["ETag"]; etag != `"abc"` {
+		t.Errorf("expected an uncompressed response to keep its strong etag, got %s", etag)
+	}
+	if etag := compressResponse(gzipped, respond(http.StatusNotModified, "")).Headers["ETag"]; etag != `W/"abc"` {
+		t.Errorf("expected a 304 to have the compressed response's etag, got %s", etag)
+	}
+
+	// the weak etag sent back still matches.
+	if !matchesETag(`W/"abc"`, `"abc"`) {
+		t.Error("expected the weak etag to match")
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	request, err := decodeRequest(events.APIGatewayProxyRequest{
+		Body:            base64.StdEncoding.EncodeToString([]byte("[1,2,3]")),
+		IsBase64Encoded: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Body != "[1,2,3]" || request.IsBase64Encoded {
+		t.Errorf("expected the body to be decoded, got %s", request.Body)
+	}
+
+	request, err = decodeRequest(events.APIGatewayProxyRequest{Body: "[1,2,3]"})
+	if err != nil || request.Body != "[1,2,3]" {
+		t.Error("expected a plain body to be left alone")
+	}
+
+	_, err = decodeRequest(events.APIGatewayProxyRequest{Body: "not base64!", IsBase64Encoded: true})
+	if err == nil {
+		t.Error("expected an error for an invalid body")
+	}
+}
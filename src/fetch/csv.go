@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	formatCSV      string = "csv"
+	contentTypeCSV string = "text/csv"
+
+	// maxCSVBytes keeps an uncompressed export inside the lambda response limit, anything past it
+	// is left for the next chunk.
+	maxCSVBytes int = 4 << 20
+)
+
+var (
+	sitesCSVHeader  = []string{"SiteId", "Name", "Address", "Postcode", "BrandId", "Brand", "Latitude", "Longitude", "GooglePlaceId"}
+	pricesCSVHeader = []string{"SiteId", "SiteName", "BrandId", "Brand", "FuelId", "FuelType", "CentsPerLitre", "CollectionMethod", "TransactionDateUtc"}
+)
+
+// formatCentsPerLitre formats a price, which is stored in tenths of a cent, as cents per litre
+// with one decimal.
+func formatCentsPerLitre(price int) string {
+	return fmt.Sprintf("%d.%d", price/10, price%10)
+}
+
+// csvText makes a text cell safe to open in a spreadsheet. cells starting with a character that
+// spreadsheets read as the start of a formula are prefixed with a quote, so a site name like
+// "=HYPERLINK(...)" is shown as text instead of being run. numbers are written as they are, since
+// they come from us and negative coordinates have to stay numbers.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// sitesCSVRows returns a row per site, ordered by site id.
+func sitesCSVRows(sites []SA_PetrolStationSite, brands map[int]string) [][]string {
+	sites = append([]SA_PetrolStationSite{}, sites...)
+	sort.Slice(sites, func(i, j int) bool { return sites[i].SiteID < sites[j].SiteID })
+
+	rows := [][]string{}
+	for _, site := range sites {
+		rows = append(rows, []string{
+			strconv.Itoa(site.SiteID),
+			csvText(site.Name),
+			csvText(site.Address),
+			csvText(site.Postcode),
+			strconv.Itoa(site.BrandID),
+			csvText(brands[site.BrandID]),
+			strconv.FormatFloat(site.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(site.Longitude, 'f', -1, 64),
+			csvText(site.GooglePlaceID),
+		})
+	}
+	return rows
+}
+
+// pricesCSVRows returns a row per site selling the fuel type, ordered by site id. like the json
+// response, other fuel types are left out.
+func pricesCSVRows(stations FuelPriceList, fuelId int, sites map[int]SA_PetrolStationSite, fuels map[int]string, brands map[int]string) [][]string {
+	siteIds := []int{}
+	for siteId := range stations.Sites {
+		siteIds = append(siteIds, siteId)
+	}
+	sort.Ints(siteIds)
+
+	rows := [][]string{}
+	for _, siteId := range siteIds {
+		price, ok := stations.Sites[siteId].FuelTypes[fuelId]
+		if !ok {
+			continue
+		}
+
+		site := sites[siteId]
+		rows = append(rows, []string{
+			strconv.Itoa(siteId),
+			csvText(site.Name),
+			strconv.Itoa(site.BrandID),
+			csvText(brands[site.BrandID]),
+			strconv.Itoa(fuelId),
+			csvText(fuels[fuelId]),
+			formatCentsPerLitre(price.Price),
+			csvText(price.CollectionMethod),
+			csvText(price.TransactionDateUTC),
+		})
+	}
+	return rows
+}
+
+// parseCSVOffset reads the offset query param, the row the chunk starts at.
+func parseCSVOffset(request events.APIGatewayProxyRequest) (int, error) {
+	rawOffset, ok := request.QueryStringParameters["offset"]
+	if !ok || rawOffset == "" {
+		return 0, nil
+	}
+
+	offset, err := strconv.Atoi(rawOffset)
+	if err != nil || offset < 0 {
+		return 0, errors.New("offset must be a non negative integer.")
+	}
+	return offset, nil
+}
+
+// writeCSVChunk writes the header and the rows from offset onwards until the chunk reaches
+// maxBytes. returns the offset of the next chunk, or -1 if every row was written.
+func writeCSVChunk(header []string, rows [][]string, offset int, maxBytes int) (string, int, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	err := w.Write(header)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for n := offset; n < len(rows); n++ {
+		w.Flush()
+		if buf.Len() >= maxBytes && n > offset {
+			return buf.String(), n, w.Error()
+		}
+
+		err = w.Write(rows[n])
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	w.Flush()
+	return buf.String(), -1, w.Error()
+}
+
+// nextChunkLink returns the url of the chunk starting at offset, with the rest of the query kept.
+func nextChunkLink(request events.APIGatewayProxyRequest, offset int) string {
+	query := url.Values{}
+	for key, value := range request.QueryStringParameters {
+		query.Set(key, value)
+	}
+	query.Set("offset", strconv.Itoa(offset))
+	return fmt.Sprintf("<%s?%s>; rel=\"next\"", request.Path, query.Encode())
+}
+
+// respondWithCSV returns the chunk of rows the request's offset asks for. when rows are left over
+// the response links to the next chunk.
+func respondWithCSV(ctx context.Context, request events.APIGatewayProxyRequest, filename string, header []string, rows [][]string) (events.APIGatewayProxyResponse, error) {
+	offset, err := parseCSVOffset(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	_, span := startSpan(ctx, "marshal csv", attribute.Int("rows", len(rows)), attribute.Int("offset", offset))
+	body, next, err := writeCSVChunk(header, rows, offset, maxCSVBytes)
+	endSpan(span, err)
+	if err != nil {
+		return respondWithStdErr(err, "error while writing csv.")
+	}
+
+	res := events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type":        contentTypeCSV + "; charset=utf-8",
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", filename),
+		},
+		Body: body,
+	}
+	if next >= 0 {
+		logger.Info("csv export chunked", "offset", offset, "next", next, "rows", len(rows))
+		res.Headers["Link"] = nextChunkLink(request, next)
+	}
+	return res, nil
+}
+
+// getNames returns the fuel type and brand names, leaving them blank if they can't be read.
+func getNames(ctx context.Context, client *dynamodb.DynamoDB) (fuels map[int]string, brands map[int]string) {
+	fuels, err := getReferenceNames(ctx, client, referenceFuels)
+	if err != nil {
+		logger.Warn("error while getting fuel type names", "error", err)
+		fuels = map[int]string{}
+	}
+
+	brands, err = getReferenceNames(ctx, client, referenceBrands)
+	if err != nil {
+		logger.Warn("error while getting brand names", "error", err)
+		brands = map[int]string{}
+	}
+	return fuels, brands
+}
+
+// getPricesCSV looks up the sites of the stations, and returns their prices as csv.
+func getPricesCSV(ctx context.Context, client *dynamodb.DynamoDB, request events.APIGatewayProxyRequest, stations FuelPriceList, fuelId int) (events.APIGatewayProxyResponse, error) {
+	siteIds := []int{}
+	for siteId := range stations.Sites {
+		siteIds = append(siteIds, siteId)
+	}
+
+	records, err := batchGetSiteRecords(ctx, client, sitesTableName, siteIds)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, records)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	sitesById := map[int]SA_PetrolStationSite{}
+	for _, site := range sites {
+		sitesById[site.SiteID] = site
+	}
+
+	fuels, brands := getNames(ctx, client)
+	return respondWithCSV(ctx, request, "prices.csv", pricesCSVHeader, pricesCSVRows(stations, fuelId, sitesById, fuels, brands))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestFormatCentsPerLitre(t *testing.T) {
+	testCases := map[int]string{
+		2799: "279.9",
+		1850: "185.0",
+		5:    "0.5",
+	}
+	for price, expected := range testCases {
+		if formatted := formatCentsPerLitre(price); formatted != expected {
+			t.Errorf("expected %d to format as %s, got %s", price, expected, formatted)
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	testCases := map[string]string{
+		"Shell Norwood":            "Shell Norwood",
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+61 8 8000 0000":          "'+61 8 8000 0000",
+		"-1+1":                     "'-1+1",
+		"@SUM(A1)":                 "'@SUM(A1)",
+		"\t=1":                     "'\t=1",
+		"":                         "",
+	}
+	for value, expected := range testCases {
+		if escaped := csvText(value); escaped != expected {
+			t.Errorf("expected %q to be written as %q, got %q", value, expected, escaped)
+		}
+	}
+
+	rows := sitesCSVRows([]SA_PetrolStationSite{{SiteID: 1, Name: "=1+1", Latitude: -34.9}}, map[int]string{})
+	if rows[0][1] != "'=1+1" || rows[0][6] != "-34.9" {
+		t.Errorf("expected only the text cells to be escaped, got %v", rows[0])
+	}
+}
+
+func TestSitesCSVRows(t *testing.T) {
+	sites := []SA_PetrolStationSite{
+		{SiteID: 2, Name: "Second", BrandID: 9},
+		{SiteID: 1, Name: "First, the one", BrandID: 5, Latitude: -34.9, Longitude: 138.6},
+	}
+
+	rows := sitesCSVRows(sites, map[int]string{5: "Shell"})
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if len(rows[0]) != len(sitesCSVHeader) {
+		t.Errorf("expected %d columns, got %d", len(sitesCSVHeader), len(rows[0]))
+	}
+	if rows[0][0] != "1" || rows[1][0] != "2" {
+		t.Error("expected rows ordered by site id")
+	}
+	if rows[0][5] != "Shell" || rows[1][5] != "" {
+		t.Errorf("unexpected brand names: %s, %s", rows[0][5], rows[1][5])
+	}
+	if rows[0][6] != "-34.9" || rows[0][7] != "138.6" {
+		t.Errorf("unexpected location: %s, %s", rows[0][6], rows[0][7])
+	}
+}
+
+func TestPricesCSVRows(t *testing.T) {
+	stations := FuelPriceList{Sites: map[int]FuelStation{
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{3: {FuelID: 3, Price: 2999}, 2: {FuelID: 2, Price: 2799}}},
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 1850}}},
+	}}
+	sites := map[int]SA_PetrolStationSite{2: {SiteID: 2, Name: "Second", BrandID: 5}}
+
+	rows := pricesCSVRows(stations, 2, sites, map[int]string{2: "Unleaded", 3: "Diesel"}, map[int]string{5: "Shell"})
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	// only the requested fuel type is exported, as in the json response.
+	order := []string{}
+	for _, row := range rows {
+		order = append(order, row[0]+"/"+row[4])
+	}
+	if strings.Join(order, ",") != "1/2,2/2" {
+		t.Errorf("expected a row per site for fuel 2, got %v", order)
+	}
+	if rows[1][1] != "Second" || rows[1][3] != "Shell" || rows[1][5] != "Unleaded" || rows[1][6] != "279.9" {
+		t.Errorf("unexpected row: %v", rows[1])
+	}
+}
+
+func TestWriteCSVChunk(t *testing.T) {
+	rows := [][]string{}
+	for i := 0; i < 10; i++ {
+		rows = append(rows, []string{"0123456789"})
+	}
+
+	body, next, err := writeCSVChunk([]string{"Value"}, rows, 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != -1 {
+		t.Errorf("expected every row to fit, got next offset %d", next)
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 11 || records[0][0] != "Value" {
+		t.Errorf("expected a header and 10 rows, got %d records", len(records))
+	}
+
+	// each row is 11 bytes, after the 6 byte header.
+	body, next, err = writeCSVChunk([]string{"Value"}, rows, 0, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 4 {
+		t.Errorf("expected the chunk to stop at row 4, got %d", next)
+	}
+
+	body, next, err = writeCSVChunk([]string{"Value"}, rows, 8, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != -1 || strings.Count(body, "\n") != 3 {
+		t.Errorf("expected the last chunk to hold the header and 2 rows, got %q", body)
+	}
+}
+
+func TestNextChunkLink(t *testing.T) {
+	link := nextChunkLink(events.APIGatewayProxyRequest{
+		Path:                  "/sites",
+		QueryStringParameters: map[string]string{"format": "csv", "offset": "10"},
+	}, 20)
+	if link != `</sites?format=csv&offset=20>; rel="next"` {
+		t.Errorf("unexpected link: %s", link)
+	}
+}
+
+func TestUnmarshalReferenceNames(t *testing.T) {
+	names, err := unmarshalReferenceNames(map[string]*dynamodb.AttributeValue{
+		"Kind": {S: aws.String(referenceFuels)},
+		"Names": {M: map[string]*dynamodb.AttributeValue{
+			"2": {S: aws.String("Unleaded")},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names[2] != "Unleaded" {
+		t.Errorf("unexpected names: %v", names)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	phaseTrough  string = "trough"
+	phaseRising  string = "rising"
+	phasePeak    string = "peak"
+	phaseFalling string = "falling"
+	phaseUnknown string = "unknown"
+
+	recommendBuy  string = "buy-now"
+	recommendWait string = "wait"
+
+	// forecastHistoryDays is how far back the history is read, enough for several weekly cycles.
+	forecastHistoryDays int = 56
+	// minForecastDays is the least history a phase is detected from.
+	minForecastDays int = 14
+
+	// hikeThreshold is the day on day rise of the median price, in tenths of a cent, that starts a
+	// new cycle.
+	hikeThreshold float64 = 50
+	// risingThreshold is the day on day rise that means a hike is still going through.
+	risingThreshold float64 = 10
+	// fullAmplitude is the swing between the cycle's low and high that counts as a clear cycle.
+	fullAmplitude float64 = 100
+
+	// backtestHorizon is how many days ahead a recommendation is judged over.
+	backtestHorizon int = 3
+	// backtestTolerance is how much cheaper a later day has to be for waiting to have paid off.
+	backtestTolerance float64 = 10
+)
+
+// CycleAnalysis is the detected phase of the price cycle of a series, as of its last day.
+type CycleAnalysis struct {
+	Phase          string  `json:"Phase"`
+	Recommendation string  `json:"Recommendation"`
+	Confidence     float64 `json:"Confidence"`
+	Current        float64 `json:"Current"`
+	CycleLow       float64 `json:"CycleLow"`
+	CycleHigh      float64 `json:"CycleHigh"`
+	CycleDays      float64 `json:"CycleDays"`
+	DaysSinceHike  int     `json:"DaysSinceHike"`
+	LastHike       string  `json:"LastHike"`
+	Days           int     `json:"Days"`
+	AsOf           string  `json:"AsOf"`
+}
+
+// Forecast is the cycle analysis of a fuel type in one region.
+type Forecast struct {
+	RegionId int    `json:"RegionId"`
+	Region   string `json:"Region"`
+	CycleAnalysis
+}
+
+// ForecastList is the response of /forecast.
+type ForecastList struct {
+	FuelId    int        `json:"FuelId"`
+	Forecasts []Forecast `json:"Forecasts"`
+}
+
+// BacktestResult is how often the recommendations made from the history up to each day turned
+// out right over the following days.
+type BacktestResult struct {
+	Days     int     `json:"Days"`
+	Correct  int     `json:"Correct"`
+	Accuracy float64 `json:"Accuracy"`
+}
+
+// dayNumber returns the number of days since the epoch of a history date, or -1 if it is invalid.
+func dayNumber(date string) int {
+	t, err := time.Parse(historyDateLayout, date)
+	if err != nil {
+		return -1
+	}
+	return int(t.Unix() / (24 * 60 * 60))
+}
+
+// hikeStarts returns the indexes of the days the median price jumped, the start of each cycle. a
+// hike spread over consecutive days counts from its first day.
+func hikeStarts(days []DailyPrice) []int {
+	isHike := func(i int) bool {
+		return i > 0 && days[i].Median-days[i-1].Median >= hikeThreshold
+	}
+
+	starts := []int{}
+	for i := 1; i < len(days); i++ {
+		if isHike(i) && !isHike(i-1) {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// median returns the middle of the values, which are sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// recommend returns whether to buy now or wait in a phase. prices keep climbing through a hike
+// and then sit high, so the time to buy is at the trough or while a hike is still going through.
+func recommend(phase string) string {
+	switch phase {
+	case phasePeak, phaseFalling:
+		return recommendWait
+	}
+	return recommendBuy
+}
+
+// analyseCycle detects the phase of the price cycle on the last day of the history, which is
+// ordered oldest first. the confidence combines how regular the past cycles were, how big the
+// swing is and how many cycles were seen.
+func analyseCycle(days []DailyPrice) CycleAnalysis {
+	analysis := CycleAnalysis{Phase: phaseUnknown, Recommendation: recommendBuy, Days: len(days)}
+	if len(days) == 0 {
+		return analysis
+	}
+
+	last := days[len(days)-1]
+	analysis.Current = last.Median
+	analysis.AsOf = last.Date
+	if len(days) < minForecastDays {
+		return analysis
+	}
+
+	// the cycle lengths are measured in calendar days, so gaps in the history don't shorten them.
+	starts := hikeStarts(days)
+	lengths := []float64{}
+	for n := 1; n < len(starts); n++ {
+		length := dayNumber(days[starts[n]].Date) - dayNumber(days[starts[n-1]].Date)
+		if length > 0 {
+			lengths = append(lengths, float64(length))
+		}
+	}
+
+	regularity := 0.0
+	if len(lengths) > 0 {
+		analysis.CycleDays = median(append([]float64{}, lengths...))
+		regular := 0
+		for _, length := range lengths {
+			if math.Abs(length-analysis.CycleDays) <= 1 {
+				regular++
+			}
+		}
+		regularity = float64(regular) / float64(len(lengths))
+	}
+
+	// the low and high are taken over the last full cycle, or a week when no cycle was seen.
+	window := int(math.Max(analysis.CycleDays, 7)) + 1
+	if window > len(days) {
+		window = len(days)
+	}
+	analysis.CycleLow, analysis.CycleHigh = math.Inf(1), math.Inf(-1)
+	for _, day := range days[len(days)-window:] {
+		analysis.CycleLow = math.Min(analysis.CycleLow, day.Median)
+		analysis.CycleHigh = math.Max(analysis.CycleHigh, day.Median)
+	}
+	amplitude := analysis.CycleHigh - analysis.CycleLow
+
+	analysis.DaysSinceHike = -1
+	if len(starts) > 0 {
+		hike := days[starts[len(starts)-1]]
+		analysis.LastHike = hike.Date
+		analysis.DaysSinceHike = dayNumber(last.Date) - dayNumber(hike.Date)
+	}
+
+	position := 0.0
+	if amplitude > 0 {
+		position = (last.Median - analysis.CycleLow) / amplitude
+	}
+	change := last.Median - days[len(days)-2].Median
+	dueForHike := analysis.CycleDays > 0 && analysis.DaysSinceHike >= int(analysis.CycleDays)-1
+
+	// a hike that went through in a day is already at the peak, rising is a hike still going
+	// through.
+	switch {
+	case position >= 0.75:
+		analysis.Phase = phasePeak
+	case change >= risingThreshold:
+		analysis.Phase = phaseRising
+	case position <= 0.1 || dueForHike:
+		analysis.Phase = phaseTrough
+	default:
+		analysis.Phase = phaseFalling
+	}
+	analysis.Recommendation = recommend(analysis.Phase)
+
+	swing := math.Min(amplitude/fullAmplitude, 1)
+	coverage := math.Min(float64(len(lengths))/4, 1)
+	analysis.Confidence = math.Round((0.5*regularity+0.25*swing+0.25*coverage)*100) / 100
+	return analysis
+}
+
+// backtestForecast replays the history, analysing each day from the days before it, and checks
+// the recommendation against the following days. buying was right if no day in the horizon was
+// cheaper by more than the tolerance, and waiting was right if one was.
+func backtestForecast(days []DailyPrice, horizon int) BacktestResult {
+	result := BacktestResult{}
+	for i := minForecastDays - 1; i+horizon < len(days); i++ {
+		analysis := analyseCycle(days[:i+1])
+
+		cheapest := math.Inf(1)
+		for _, day := range days[i+1 : i+1+horizon] {
+			cheapest = math.Min(cheapest, day.Median)
+		}
+		cheaperLater := cheapest < days[i].Median-backtestTolerance
+
+		result.Days++
+		if (analysis.Recommendation == recommendWait) == cheaperLater {
+			result.Correct++
+		}
+	}
+
+	if result.Days > 0 {
+		result.Accuracy = float64(result.Correct) / float64(result.Days)
+	}
+	return result
+}
+
+// getForecast returns the cycle phase and a recommendation for a fuel type, in one region or in
+// every region with a name.
+func getForecast(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	respondBadRequest := func(message string) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: message}, nil
+	}
+
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return respondBadRequest("fuelType must be an integer.")
+	}
+
+	client := getClient()
+	regions, err := getReferenceNames(ctx, client, referenceRegions)
+	if err != nil {
+		logger.Warn("error while getting region names", "error", err)
+		regions = map[int]string{}
+	}
+
+	regionIds := []int{}
+	if rawRegion := request.QueryStringParameters["region"]; rawRegion != "" {
+		regionId, err := strconv.Atoi(rawRegion)
+		if err != nil {
+			return respondBadRequest("region must be an integer region id.")
+		}
+		regionIds = append(regionIds, regionId)
+	} else {
+		for regionId := range regions {
+			regionIds = append(regionIds, regionId)
+		}
+		sort.Ints(regionIds)
+	}
+
+	since := time.Now().AddDate(0, 0, -forecastHistoryDays)
+	list := ForecastList{FuelId: fuelId, Forecasts: []Forecast{}}
+	for _, regionId := range regionIds {
+		days, err := getPriceHistory(ctx, client, fuelId, regionId, since)
+		if err != nil {
+			return respondWithStdErr(err, "error while reading price history.")
+		}
+
+		_, span := startSpan(ctx, "analyse cycle", attribute.Int("region", regionId), attribute.Int("days", len(days)))
+		analysis := analyseCycle(days)
+		span.SetAttributes(attribute.String("phase", analysis.Phase))
+		span.End()
+
+		list.Forecasts = append(list.Forecasts, Forecast{RegionId: regionId, Region: regions[regionId], CycleAnalysis: analysis})
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling forecast.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
+		Body:       string(body),
+	}, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// cycleHistory builds a daily history shaped like the adelaide unleaded cycle: a 25c hike every
+// cycleDays days, spread over hikeDays, then an even fall back to the trough, with a little noise.
+// each cycle starts on its trough day.
+func cycleHistory(days int, cycleDays int, hikeDays int) []DailyPrice {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	trough, hike := 1750.0, 250.0
+
+	history := []DailyPrice{}
+	for n := 0; n < days; n++ {
+		day := n % cycleDays
+		price := trough
+		switch {
+		case day == 0:
+		case day <= hikeDays:
+			price = trough + hike*float64(day)/float64(hikeDays)
+		default:
+			price = trough + hike*float64(cycleDays-day)/float64(cycleDays-hikeDays)
+		}
+		price += float64((n*37)%9 - 4)
+
+		history = append(history, DailyPrice{
+			FuelId:   2,
+			RegionId: 12,
+			Date:     start.AddDate(0, 0, n).Format(historyDateLayout),
+			Median:   price,
+		})
+	}
+	return history
+}
+
+func TestHikeStarts(t *testing.T) {
+	starts := hikeStarts(cycleHistory(21, 7, 2))
+	if len(starts) != 3 || starts[0] != 1 || starts[1] != 8 || starts[2] != 15 {
+		t.Errorf("expected a hike starting every 7 days, got %v", starts)
+	}
+}
+
+func TestAnalyseCycle(t *testing.T) {
+	// the hike takes two days, so on the first day of it prices are still rising.
+	history := cycleHistory(8*7, 7, 2)
+
+	tests := []struct {
+		name  string
+		days  int
+		phase string
+	}{
+		{"first day of the hike", 6*7 + 2, phaseRising},
+		{"end of the hike", 6*7 + 3, phasePeak},
+		{"mid cycle", 6*7 + 5, phaseFalling},
+		{"before the next hike", 7*7 + 1, phaseTrough},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analysis := analyseCycle(history[:test.days])
+			if analysis.Phase != test.phase {
+				t.Errorf("expected %s, got %+v", test.phase, analysis)
+			}
+			if analysis.CycleDays != 7 {
+				t.Errorf("expected a 7 day cycle, got %f", analysis.CycleDays)
+			}
+			if analysis.Confidence < 0.9 {
+				t.Errorf("expected a regular cycle to be confident, got %f", analysis.Confidence)
+			}
+		})
+	}
+
+	analysis := analyseCycle(history[:minForecastDays-1])
+	if analysis.Phase != phaseUnknown || analysis.Confidence != 0 {
+		t.Errorf("expected too short a history to be unknown, got %+v", analysis)
+	}
+
+	flat := cycleHistory(28, 7, 1)
+	for n := range flat {
+		flat[n].Median = 1800
+	}
+	analysis = analyseCycle(flat)
+	if analysis.Confidence > 0.1 {
+		t.Errorf("expected a flat history to have no confidence, got %+v", analysis)
+	}
+}
+
+func TestBacktestForecast(t *testing.T) {
+	tests := []struct {
+		name      string
+		cycleDays int
+		hikeDays  int
+	}{
+		{"weekly cycle", 7, 1},
+		{"weekly cycle with a two day hike", 7, 2},
+		{"longer cycle", 9, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := backtestForecast(cycleHistory(12*7, test.cycleDays, test.hikeDays), backtestHorizon)
+			if result.Days == 0 {
+				t.Fatal("expected days to be backtested")
+			}
+			if result.Accuracy < 0.8 {
+				t.Errorf("expected at least 80%% of recommendations to be right, got %+v", result)
+			}
+		})
+	}
+}
+
+// readHistoryFixture reads a daily history in the csv format the petrol cli prints it in, with the
+// prices in cents per litre.
+func readHistoryFixture(t *testing.T, path string) []DailyPrice {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []DailyPrice{}
+	for _, record := range records[1:] {
+		median, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, DailyPrice{FuelId: 2, RegionId: 7, Date: record[0], Median: median * 10})
+	}
+	return history
+}
+
+// TestBacktestHistory checks the forecast against a longer, irregular history than cycleHistory
+// builds: recommendations over the following days, the phase on the day of each hike, and the
+// phase the day before one.
+func TestBacktestHistory(t *testing.T) {
+	history := readHistoryFixture(t, "testdata/adelaide_ulp_daily.csv")
+
+	result := backtestForecast(history, backtestHorizon)
+	if result.Days < 150 || result.Accuracy < 0.8 {
+		t.Errorf("expected at least 80%% of recommendations to be right, got %+v", result)
+	}
+
+	hikes, hikesSeen, troughs, troughsSeen := 0, 0, 0, 0
+	for _, start := range hikeStarts(history) {
+		if start < minForecastDays {
+			continue
+		}
+		hikes++
+		if phase := analyseCycle(history[:start+1]).Phase; phase == phaseRising || phase == phasePeak {
+			hikesSeen++
+		}
+		troughs++
+		if analyseCycle(history[:start]).Phase == phaseTrough {
+			troughsSeen++
+		}
+	}
+	if hikes < 20 || float64(hikesSeen)/float64(hikes) < 0.9 {
+		t.Errorf("expected at least 90%% of hikes to be seen as rising or the peak, got %d of %d", hikesSeen, hikes)
+	}
+	if float64(troughsSeen)/float64(troughs) < 0.8 {
+		t.Errorf("expected at least 80%% of the days before a hike to be seen as the trough, got %d of %d", troughsSeen, troughs)
+	}
+}
+
+func TestDailyPriceUnmarshal(t *testing.T) {
+	var day DailyPrice
+	err := day.Unmarshal(map[string]*dynamodb.AttributeValue{
+		"Series": {S: aws.String("2/12")},
+		"Date":   {S: aws.String("2024-05-10")},
+		"FuelId": {N: aws.String("2")},
+		"Region": {N: aws.String("12")},
+		"Min":    {N: aws.String("1799")},
+		"Median": {N: aws.String("1850.5")},
+		"Mean":   {N: aws.String("1861.25")},
+		"Count":  {N: aws.String("40")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if day != (DailyPrice{FuelId: 2, RegionId: 12, Date: "2024-05-10", Min: 1799, Median: 1850.5, Mean: 1861.25, Count: 40}) {
+		t.Errorf("unexpected day: %+v", day)
+	}
+
+	err = day.Unmarshal(map[string]*dynamodb.AttributeValue{})
+	if err == nil {
+		t.Error("expected an error for a record without a Date")
+	}
+}
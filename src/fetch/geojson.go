@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	formatJSON    string = "json"
+	formatGeoJSON string = "geojson"
+
+	contentTypeJSON    string = "application/json"
+	contentTypeGeoJSON string = "application/geo+json"
+)
+
+// formatContentTypes maps each output format to the media type it is negotiated by.
+var formatContentTypes = map[string]string{
+	formatJSON:    contentTypeJSON,
+	formatGeoJSON: contentTypeGeoJSON,
+	formatCSV:     contentTypeCSV,
+}
+
+// responseFormat picks the output format from the format query param, falling back to the Accept
+// header and then json.
+func responseFormat(request events.APIGatewayProxyRequest) (string, error) {
+	if format, ok := request.QueryStringParameters["format"]; ok && format != "" {
+		format = strings.ToLower(format)
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", fmt.Errorf("unsupported format %q.", format)
+		}
+		return format, nil
+	}
+
+	accept := strings.ToLower(getHeader(request, "Accept"))
+	for _, mediaType := range strings.Split(accept, ",") {
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		mediaType = strings.TrimSpace(mediaType)
+		for format, contentType := range formatContentTypes {
+			if mediaType == contentType {
+				return format, nil
+			}
+		}
+	}
+
+	return formatJSON, nil
+}
+
+// respondUnsupportedFormat rejects a format query param we can't produce.
+func respondUnsupportedFormat(err error) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       err.Error(),
+	}, nil
+}
+
+// FeatureCollection is a GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature with a point geometry.
+type Feature struct {
+	Type       string            `json:"type"`
+	Id         int               `json:"id"`
+	Geometry   Point             `json:"geometry"`
+	Properties SiteFeatureFields `json:"properties"`
+}
+
+// Point is a GeoJSON point, its coordinates are longitude then latitude.
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// SiteFeatureFields are the properties of a site feature. prices are keyed by fuel id, in the
+// same units as the json endpoints.
+type SiteFeatureFields struct {
+	SiteId        int                  `json:"SiteId"`
+	Name          string               `json:"Name"`
+	Address       string               `json:"Address"`
+	Postcode      string               `json:"Postcode"`
+	BrandId       int                  `json:"BrandId"`
+	GooglePlaceID string               `json:"GPI"`
+	Prices        map[string]FuelPrice `json:"Prices"`
+}
+
+// siteFeature returns the site as a GeoJSON feature, with the station's prices.
+func siteFeature(site SA_PetrolStationSite, station FuelStation) Feature {
+	prices := map[string]FuelPrice{}
+	for fuelId, price := range station.FuelTypes {
+		prices[strconv.Itoa(fuelId)] = price
+	}
+
+	return Feature{
+		Type: "Feature",
+		Id:   site.SiteID,
+		Geometry: Point{
+			Type:        "Point",
+			Coordinates: [2]float64{site.Longitude, site.Latitude},
+		},
+		Properties: SiteFeatureFields{
+			SiteId:        site.SiteID,
+			Name:          site.Name,
+			Address:       site.Address,
+			Postcode:      site.Postcode,
+			BrandId:       site.BrandID,
+			GooglePlaceID: site.GooglePlaceID,
+			Prices:        prices,
+		},
+	}
+}
+
+// sitesFeatureCollection returns a feature for each site, ordered by site id. sites without
+// prices have an empty Prices property.
+func sitesFeatureCollection(sites []SA_PetrolStationSite, prices FuelPriceList) FeatureCollection {
+	collection := FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []Feature{},
+	}
+	for _, site := range sites {
+		collection.Features = append(collection.Features, siteFeature(site, prices.Sites[site.SiteID]))
+	}
+
+	sort.Slice(collection.Features, func(i, j int) bool {
+		return collection.Features[i].Id < collection.Features[j].Id
+	})
+	return collection
+}
+
+func respondWithGeoJSON(ctx context.Context, collection FeatureCollection) (events.APIGatewayProxyResponse, error) {
+	_, span := startSpan(ctx, "marshal geojson", attribute.Int("features", len(collection.Features)))
+	body, err := json.Marshal(collection)
+	endSpan(span, err)
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling geojson.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": contentTypeGeoJSON},
+		Body:       string(body),
+	}, nil
+}
+
+// getPricesGeoJSON looks up the sites of the stations, and returns them as features with their
+// prices.
+func getPricesGeoJSON(ctx context.Context, client *dynamodb.DynamoDB, stations FuelPriceList) (events.APIGatewayProxyResponse, error) {
+	siteIds := []int{}
+	for siteId := range stations.Sites {
+		siteIds = append(siteIds, siteId)
+	}
+
+	records, err := batchGetSiteRecords(ctx, client, sitesTableName, siteIds)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, records)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	return respondWithGeoJSON(ctx, sitesFeatureCollection(sites, stations))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestResponseFormat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    map[string]string
+		headers  map[string]string
+		expected string
+	}{
+		{"default", nil, nil, formatJSON},
+		{"format param", map[string]string{"format": "geojson"}, nil, formatGeoJSON},
+		{"accept header", nil, map[string]string{"accept": "application/geo+json"}, formatGeoJSON},
+		{"accept header with params", nil, map[string]string{"Accept": "text/html, application/geo+json;q=0.9"}, formatGeoJSON},
+		{"param wins over header", map[string]string{"format": "json"}, map[string]string{"Accept": "application/geo+json"}, formatJSON},
+		{"unknown accept header", nil, map[string]string{"Accept": "*/*"}, formatJSON},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			format, err := responseFormat(events.APIGatewayProxyRequest{
+				QueryStringParameters: testCase.query,
+				Headers:               testCase.headers,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != testCase.expected {
+				t.Errorf("expected format %s, got %s", testCase.expected, format)
+			}
+		})
+	}
+
+	_, err := responseFormat(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"format": "xml"}})
+	if err == nil {
+		t.Error("expected an unsupported format to be rejected")
+	}
+}
+
+func TestSitesFeatureCollection(t *testing.T) {
+	sites := []SA_PetrolStationSite{
+		{SiteID: 2, Name: "Second", Latitude: -34.9, Longitude: 138.6},
+		{SiteID: 1, Name: "First", Address: "1 Main St", Postcode: "5000", BrandID: 5, Latitude: -35.1, Longitude: 138.5},
+	}
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 2799}, 3: {FuelID: 3, Price: 2999}}},
+	}}
+
+	collection := sitesFeatureCollection(sites, prices)
+	if collection.Type != "FeatureCollection" {
+		t.Errorf("unexpected type: %s", collection.Type)
+	}
+	if len(collection.Features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(collection.Features))
+	}
+
+	first := collection.Features[0]
+	if first.Id != 1 {
+		t.Errorf("expected features ordered by site id, got %d first", first.Id)
+	}
+	if first.Geometry.Type != "Point" || first.Geometry.Coordinates != [2]float64{138.5, -35.1} {
+		t.Errorf("expected a longitude, latitude point, got %v", first.Geometry.Coordinates)
+	}
+	if first.Properties.Address != "1 Main St" || first.Properties.BrandId != 5 {
+		t.Errorf("unexpected properties: %+v", first.Properties)
+	}
+	if len(first.Properties.Prices) != 2 || first.Properties.Prices["2"].Price != 2799 {
+		t.Errorf("unexpected prices: %+v", first.Properties.Prices)
+	}
+	if len(collection.Features[1].Properties.Prices) != 0 {
+		t.Error("expected a site without prices to have no prices")
+	}
+
+	body, err := json.Marshal(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(body, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feature := decoded["features"].([]interface{})[0].(map[string]interface{})
+	if feature["type"] != "Feature" {
+		t.Errorf("unexpected feature type: %v", feature["type"])
+	}
+	if _, ok := feature["properties"].(map[string]interface{})["Prices"]; !ok {
+		t.Error("expected the prices in the feature properties")
+	}
+}
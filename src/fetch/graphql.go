@@ -0,0 +1,646 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	graphqlPath string = "/graphql"
+
+	// maxQueryCost is the most a query can cost, see queryCost for how it is counted.
+	maxQueryCost int = 2000
+	// maxGraphqlParallelism limits how many fields, and so history queries, resolve at once.
+	maxGraphqlParallelism int = 10
+
+	defaultSitesFirst   int     = 20
+	maxSitesFirst       int     = 100
+	defaultNearRadiusKm float64 = 5
+	maxNearRadiusKm     float64 = 50
+	defaultHistoryDays  int     = 7
+	maxHistoryDays      int     = 56
+
+	earthRadiusKm float64 = 6371
+)
+
+const graphqlSchemaString string = `
+schema {
+	query: Query
+}
+
+type Query {
+	site(id: Int!): Site
+	sites(near: Location, radiusKm: Float, bbox: String, postcode: String, brandId: Int, regionId: Int, fuelId: Int, first: Int = 20): [Site!]!
+	fuelTypes: [FuelType!]!
+	brands: [Brand!]!
+	regions: [Region!]!
+	history(fuelId: Int!, regionId: Int!, days: Int = 7): [DailyPrice!]!
+}
+
+input Location {
+	lat: Float!
+	lng: Float!
+}
+
+type Site {
+	id: Int!
+	name: String!
+	address: String!
+	postcode: String!
+	lat: Float!
+	lng: Float!
+	distanceKm: Float
+	brand: Brand
+	region: Region
+	prices(fuelIds: [Int!]): [Price!]!
+	history(fuelId: Int!, days: Int = 7): [DailyPrice!]!
+}
+
+type Price {
+	fuelType: FuelType!
+	price: Int!
+	collectionMethod: String!
+	transactionDateUtc: String!
+	current: Boolean!
+}
+
+type FuelType {
+	id: Int!
+	name: String!
+}
+
+type Brand {
+	id: Int!
+	name: String!
+}
+
+type Region {
+	id: Int!
+	name: String!
+}
+
+type DailyPrice {
+	date: String!
+	min: Int!
+	median: Float!
+	mean: Float!
+	count: Int!
+}
+`
+
+// graphqlSchema is parsed once per container, the data a request reads is loaded by the
+// graphqlLoader in its context.
+var graphqlSchema = graphql.MustParseSchema(graphqlSchemaString, &graphqlResolver{}, graphql.MaxParallelism(maxGraphqlParallelism))
+
+// GraphqlRequest is the body of a /graphql request.
+type GraphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlLoader loads the tables a query reads at most once, however many fields read them.
+// anything already set is used as is.
+type graphqlLoader struct {
+	client  *dynamodb.DynamoDB
+	now     time.Time
+	mu      sync.Mutex
+	sites   []SA_PetrolStationSite
+	prices  *FuelPriceList
+	names   map[string]map[int]string
+	history map[string][]DailyPrice
+}
+
+type loaderKey struct{}
+
+func newGraphqlLoader(client *dynamodb.DynamoDB, now time.Time) *graphqlLoader {
+	return &graphqlLoader{
+		client:  client,
+		now:     now,
+		names:   map[string]map[int]string{},
+		history: map[string][]DailyPrice{},
+	}
+}
+
+func loaderFromContext(ctx context.Context) *graphqlLoader {
+	return ctx.Value(loaderKey{}).(*graphqlLoader)
+}
+
+func (l *graphqlLoader) Sites(ctx context.Context) ([]SA_PetrolStationSite, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sites == nil {
+		records, err := scanTable(ctx, l.client, sitesTableName)
+		if err != nil {
+			return nil, err
+		}
+		l.sites, err = unmarshalSites(ctx, records)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l.sites, nil
+}
+
+func (l *graphqlLoader) Prices(ctx context.Context) (FuelPriceList, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.prices == nil {
+		records, err := scanTable(ctx, l.client, pricesTableName)
+		if err != nil {
+			return FuelPriceList{}, err
+		}
+		prices, err := unmarshalPrices(ctx, records)
+		if err != nil {
+			return FuelPriceList{}, err
+		}
+		l.prices = &prices
+	}
+	return *l.prices, nil
+}
+
+// graphqlLoader.Names returns the reference names of the kind, names that can't be read are
+// logged and left empty like they are for the other endpoints.
+func (l *graphqlLoader) Names(ctx context.Context, kind string) map[int]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names, ok := l.names[kind]
+	if !ok {
+		var err error
+		names, err = getReferenceNames(ctx, l.client, kind)
+		if err != nil {
+			logger.Warn("error while getting reference names", "kind", kind, "error", err)
+			names = map[int]string{}
+		}
+		l.names[kind] = names
+	}
+	return names
+}
+
+// graphqlLoader.History returns the last days of the series, including today.
+func (l *graphqlLoader) History(ctx context.Context, fuelId int, regionId int, days int) ([]DailyPrice, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := fmt.Sprintf("%s/%d", historySeries(fuelId, regionId), days)
+	history, ok := l.history[key]
+	if !ok {
+		var err error
+		history, err = getPriceHistory(ctx, l.client, fuelId, regionId, l.now.AddDate(0, 0, 1-days))
+		if err != nil {
+			return nil, err
+		}
+		l.history[key] = history
+	}
+	return history, nil
+}
+
+// distanceKm returns the great circle distance between two locations.
+func distanceKm(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// checkHistoryDays checks a days argument is within the history that is kept for forecasting.
+func checkHistoryDays(days int32) error {
+	if days < 1 || int(days) > maxHistoryDays {
+		return fmt.Errorf("days must be from 1 to %d.", maxHistoryDays)
+	}
+	return nil
+}
+
+type graphqlResolver struct{}
+
+type siteResolver struct {
+	site     SA_PetrolStationSite
+	distance *float64
+}
+
+type nameResolver struct {
+	id   int
+	kind string
+}
+
+type priceResolver struct {
+	fuelId int
+	price  FuelPrice
+}
+
+type dailyPriceResolver struct {
+	day DailyPrice
+}
+
+type locationInput struct {
+	Lat float64
+	Lng float64
+}
+
+type sitesArgs struct {
+	Near     *locationInput
+	RadiusKm *float64
+	Bbox     *string
+	Postcode *string
+	BrandId  *int32
+	RegionId *int32
+	FuelId   *int32
+	First    int32
+}
+
+func (r *graphqlResolver) Site(ctx context.Context, args struct{ Id int32 }) (*siteResolver, error) {
+	sites, err := loaderFromContext(ctx).Sites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, site := range sites {
+		if site.SiteID == int(args.Id) {
+			return &siteResolver{site: site}, nil
+		}
+	}
+	return nil, nil
+}
+
+// graphqlResolver.Sites returns the sites matching every filter that is set. sites near a
+// location are ordered nearest first, and the rest by id.
+func (r *graphqlResolver) Sites(ctx context.Context, args sitesArgs) ([]*siteResolver, error) {
+	if args.First < 1 || int(args.First) > maxSitesFirst {
+		return nil, fmt.Errorf("first must be from 1 to %d.", maxSitesFirst)
+	}
+
+	radiusKm := defaultNearRadiusKm
+	if args.RadiusKm != nil {
+		radiusKm = *args.RadiusKm
+	}
+	if args.Near != nil && (args.Near.Lat < -90 || args.Near.Lat > 90 || args.Near.Lng < -180 || args.Near.Lng > 180) {
+		return nil, errors.New("near must be a valid location.")
+	}
+	if radiusKm <= 0 || radiusKm > maxNearRadiusKm {
+		return nil, fmt.Errorf("radiusKm must be from 0 to %g.", maxNearRadiusKm)
+	}
+
+	var bbox *BoundingBox
+	if args.Bbox != nil {
+		parsed, err := parseBoundingBox(*args.Bbox)
+		if err != nil {
+			return nil, err
+		}
+		bbox = &parsed
+	}
+
+	loader := loaderFromContext(ctx)
+	sites, err := loader.Sites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var prices FuelPriceList
+	if args.FuelId != nil {
+		prices, err = loader.Prices(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := []*siteResolver{}
+	for _, site := range sites {
+		switch {
+		case bbox != nil && !bbox.Contains(site.Latitude, site.Longitude):
+			continue
+		case args.Postcode != nil && site.Postcode != *args.Postcode:
+			continue
+		case args.BrandId != nil && site.BrandID != int(*args.BrandId):
+			continue
+		case args.RegionId != nil && site.RegionID != int(*args.RegionId):
+			continue
+		}
+		if args.FuelId != nil {
+			if _, ok := prices.Sites[site.SiteID].FuelTypes[int(*args.FuelId)]; !ok {
+				continue
+			}
+		}
+
+		result := &siteResolver{site: site}
+		if args.Near != nil {
+			distance := distanceKm(args.Near.Lat, args.Near.Lng, site.Latitude, site.Longitude)
+			if distance > radiusKm {
+				continue
+			}
+			result.distance = &distance
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].distance != nil && *results[i].distance != *results[j].distance {
+			return *results[i].distance < *results[j].distance
+		}
+		return results[i].site.SiteID < results[j].site.SiteID
+	})
+	if len(results) > int(args.First) {
+		results = results[:args.First]
+	}
+	return results, nil
+}
+
+// namesList returns the reference names of the kind, ordered by id.
+func namesList(ctx context.Context, kind string) []*nameResolver {
+	names := loaderFromContext(ctx).Names(ctx, kind)
+	results := []*nameResolver{}
+	for id := range names {
+		results = append(results, &nameResolver{id: id, kind: kind})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].id < results[j].id })
+	return results
+}
+
+func (r *graphqlResolver) FuelTypes(ctx context.Context) []*nameResolver {
+	return namesList(ctx, referenceFuels)
+}
+
+func (r *graphqlResolver) Brands(ctx context.Context) []*nameResolver {
+	return namesList(ctx, referenceBrands)
+}
+
+func (r *graphqlResolver) Regions(ctx context.Context) []*nameResolver {
+	return namesList(ctx, referenceRegions)
+}
+
+func (r *graphqlResolver) History(ctx context.Context, args struct {
+	FuelId   int32
+	RegionId int32
+	Days     int32
+}) ([]*dailyPriceResolver, error) {
+	return historyResolvers(ctx, int(args.FuelId), int(args.RegionId), args.Days)
+}
+
+func historyResolvers(ctx context.Context, fuelId int, regionId int, days int32) ([]*dailyPriceResolver, error) {
+	err := checkHistoryDays(days)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := loaderFromContext(ctx).History(ctx, fuelId, regionId, int(days))
+	if err != nil {
+		return nil, err
+	}
+	results := []*dailyPriceResolver{}
+	for _, day := range history {
+		results = append(results, &dailyPriceResolver{day: day})
+	}
+	return results, nil
+}
+
+func (r *siteResolver) Id() int32            { return int32(r.site.SiteID) }
+func (r *siteResolver) Name() string         { return r.site.Name }
+func (r *siteResolver) Address() string      { return r.site.Address }
+func (r *siteResolver) Postcode() string     { return r.site.Postcode }
+func (r *siteResolver) Lat() float64         { return r.site.Latitude }
+func (r *siteResolver) Lng() float64         { return r.site.Longitude }
+func (r *siteResolver) DistanceKm() *float64 { return r.distance }
+
+func (r *siteResolver) Brand() *nameResolver {
+	if r.site.BrandID == 0 {
+		return nil
+	}
+	return &nameResolver{id: r.site.BrandID, kind: referenceBrands}
+}
+
+func (r *siteResolver) Region() *nameResolver {
+	if r.site.RegionID == 0 {
+		return nil
+	}
+	return &nameResolver{id: r.site.RegionID, kind: referenceRegions}
+}
+
+// siteResolver.Prices returns the site's prices ordered by fuel type, only the fuel types asked
+// for when fuelIds is set.
+func (r *siteResolver) Prices(ctx context.Context, args struct{ FuelIds *[]int32 }) ([]*priceResolver, error) {
+	prices, err := loaderFromContext(ctx).Prices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*priceResolver{}
+	for fuelId, price := range prices.Sites[r.site.SiteID].FuelTypes {
+		if args.FuelIds != nil && !containsId(*args.FuelIds, fuelId) {
+			continue
+		}
+		results = append(results, &priceResolver{fuelId: fuelId, price: price})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].fuelId < results[j].fuelId })
+	return results, nil
+}
+
+// siteResolver.History returns the history of the fuel type in the site's region, the history
+// isn't kept per site.
+func (r *siteResolver) History(ctx context.Context, args struct {
+	FuelId int32
+	Days   int32
+}) ([]*dailyPriceResolver, error) {
+	if r.site.RegionID == 0 {
+		return []*dailyPriceResolver{}, checkHistoryDays(args.Days)
+	}
+	return historyResolvers(ctx, int(args.FuelId), r.site.RegionID, args.Days)
+}
+
+func containsId(ids []int32, id int) bool {
+	for _, candidate := range ids {
+		if int(candidate) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *nameResolver) Id() int32 { return int32(r.id) }
+
+func (r *nameResolver) Name(ctx context.Context) string {
+	return loaderFromContext(ctx).Names(ctx, r.kind)[r.id]
+}
+
+func (r *priceResolver) FuelType() *nameResolver {
+	return &nameResolver{id: r.fuelId, kind: referenceFuels}
+}
+
+func (r *priceResolver) Price() int32               { return int32(r.price.Price) }
+func (r *priceResolver) CollectionMethod() string   { return r.price.CollectionMethod }
+func (r *priceResolver) TransactionDateUtc() string { return r.price.TransactionDateUTC }
+
+func (r *priceResolver) Current(ctx context.Context) bool {
+	return isCurrentPrice(r.price, loaderFromContext(ctx).now)
+}
+
+func (r *dailyPriceResolver) Date() string    { return r.day.Date }
+func (r *dailyPriceResolver) Min() int32      { return int32(r.day.Min) }
+func (r *dailyPriceResolver) Median() float64 { return r.day.Median }
+func (r *dailyPriceResolver) Mean() float64   { return r.day.Mean }
+func (r *dailyPriceResolver) Count() int32    { return int32(r.day.Count) }
+
+// listSize returns how many items a list field can return, which every field selected under it
+// is counted for. fields that aren't lists return one.
+func listSize(field *ast.Field, variables map[string]interface{}) int {
+	intArg := func(name string, fallback int) int {
+		arg := field.Arguments.ForName(name)
+		if arg == nil {
+			return fallback
+		}
+		value, err := arg.Value.Value(variables)
+		if err != nil {
+			return fallback
+		}
+		switch value := value.(type) {
+		case int64:
+			return int(value)
+		case float64:
+			return int(value)
+		case []interface{}:
+			return len(value)
+		}
+		return fallback
+	}
+
+	switch field.Name {
+	case "sites":
+		return intArg("first", defaultSitesFirst)
+	case "history":
+		return intArg("days", defaultHistoryDays)
+	case "prices":
+		return intArg("fuelIds", 10)
+	case "fuelTypes", "brands", "regions":
+		return 50
+	}
+	return 1
+}
+
+// fieldCost is the cost of resolving a field once, history is read from dynamodb for each
+// site it is asked for.
+func fieldCost(field *ast.Field) int {
+	if field.Name == "history" {
+		return 10
+	}
+	return 1
+}
+
+// selectionCost counts the cost of a selection set resolved count times.
+func selectionCost(selections ast.SelectionSet, doc *ast.QueryDocument, variables map[string]interface{}, count int, depth int) (int, error) {
+	if depth > 20 {
+		return 0, errors.New("query is nested too deeply.")
+	}
+
+	cost := 0
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			// introspection is answered from the schema, it doesn't read any tables.
+			if strings.HasPrefix(selection.Name, "__") {
+				cost += count
+				continue
+			}
+
+			size := listSize(selection, variables)
+			childCost, err := selectionCost(selection.SelectionSet, doc, variables, count*max(size, 1), depth+1)
+			if err != nil {
+				return 0, err
+			}
+			cost += count*fieldCost(selection) + childCost
+
+		case *ast.FragmentSpread:
+			fragment := doc.Fragments.ForName(selection.Name)
+			if fragment == nil {
+				return 0, fmt.Errorf("unknown fragment %q.", selection.Name)
+			}
+			childCost, err := selectionCost(fragment.SelectionSet, doc, variables, count, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			cost += childCost
+
+		case *ast.InlineFragment:
+			childCost, err := selectionCost(selection.SelectionSet, doc, variables, count, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			cost += childCost
+		}
+	}
+	return cost, nil
+}
+
+// queryCost estimates how expensive a query is to resolve. every field costs one for each time
+// it can be resolved, so fields under a list cost as much as the list can be long, and history
+// costs ten for the query it makes.
+func queryCost(query string, operationName string, variables map[string]interface{}) (int, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return 0, err
+	}
+
+	var operation *ast.OperationDefinition
+	if operationName != "" {
+		operation = doc.Operations.ForName(operationName)
+	} else if len(doc.Operations) == 1 {
+		operation = doc.Operations[0]
+	}
+	if operation == nil {
+		return 0, errors.New("the operation to run must be named.")
+	}
+
+	return selectionCost(operation.SelectionSet, doc, variables, 1, 0)
+}
+
+// respondGraphqlError responds with a single error in the graphql response format.
+func respondGraphqlError(status int, message string) (events.APIGatewayProxyResponse, error) {
+	return respondWithJSON(status, map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
+
+// executeGraphql runs the query against the schema, rejecting it first if it costs too much.
+func executeGraphql(ctx context.Context, loader *graphqlLoader, request GraphqlRequest) (events.APIGatewayProxyResponse, error) {
+	if strings.TrimSpace(request.Query) == "" {
+		return respondGraphqlError(http.StatusBadRequest, "query is required.")
+	}
+
+	cost, err := queryCost(request.Query, request.OperationName, request.Variables)
+	if err != nil {
+		return respondGraphqlError(http.StatusBadRequest, err.Error())
+	}
+	if cost > maxQueryCost {
+		return respondGraphqlError(http.StatusBadRequest, fmt.Sprintf("query costs %d, more than the limit of %d.", cost, maxQueryCost))
+	}
+
+	ctx, span := startSpan(ctx, "execute graphql", attribute.Int("cost", cost))
+	result := graphqlSchema.Exec(context.WithValue(ctx, loaderKey{}, loader), request.Query, request.OperationName, request.Variables)
+	span.SetAttributes(attribute.Int("errors", len(result.Errors)))
+	span.End()
+
+	logger.Info("executed graphql query", "operation", request.OperationName, "cost", cost, "errors", len(result.Errors))
+	return respondWithJSON(http.StatusOK, result)
+}
+
+// handleGraphql answers a graphql query posted as json.
+func handleGraphql(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var body GraphqlRequest
+	err := json.Unmarshal([]byte(request.Body), &body)
+	if err != nil {
+		return respondGraphqlError(http.StatusBadRequest, "invalid graphql request json.")
+	}
+
+	return executeGraphql(ctx, newGraphqlLoader(getClient(), time.Now().UTC()), body)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLoader returns a loader with every table already loaded, so queries don't need dynamodb.
+func newTestLoader(now time.Time) *graphqlLoader {
+	loader := newGraphqlLoader(nil, now)
+	loader.sites = []SA_PetrolStationSite{
+		{SiteID: 1, Name: "City", Postcode: "5000", BrandID: 5, RegionID: 7, Latitude: -34.928, Longitude: 138.600},
+		{SiteID: 2, Name: "Norwood", Postcode: "5067", BrandID: 6, RegionID: 7, Latitude: -34.921, Longitude: 138.631},
+		{SiteID: 3, Name: "Perth", Postcode: "6000", BrandID: 5, Latitude: -31.95, Longitude: 115.86},
+	}
+	loader.prices = &FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{
+			2: {FuelID: 2, Price: 1899, TransactionDateUTC: now.Add(-time.Hour).Format("2006-01-02T15:04:05")},
+			3: {FuelID: 3, Price: 9999, TransactionDateUTC: now.Add(-time.Hour).Format("2006-01-02T15:04:05")},
+		}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{
+			3: {FuelID: 3, Price: 2099, TransactionDateUTC: now.Add(-time.Hour).Format("2006-01-02T15:04:05")},
+		}},
+	}}
+	loader.names = map[string]map[int]string{
+		referenceFuels:   {2: "Unleaded", 3: "Diesel"},
+		referenceBrands:  {5: "Shell", 6: "BP"},
+		referenceRegions: {7: "Adelaide"},
+	}
+	loader.history = map[string][]DailyPrice{
+		"2/7/7": {{FuelId: 2, RegionId: 7, Date: "2024-05-09", Min: 1799, Median: 1899, Mean: 1890.5, Count: 40}},
+	}
+	return loader
+}
+
+func TestExecuteGraphql(t *testing.T) {
+	loader := newTestLoader(time.Now().UTC())
+	res, err := executeGraphql(context.Background(), loader, GraphqlRequest{
+		Query: `query Station($id: Int!) {
+			site(id: $id) {
+				name
+				brand { name }
+				region { name }
+				prices { fuelType { name } price current }
+				history(fuelId: 2) { date min mean }
+			}
+		}`,
+		Variables: map[string]interface{}{"id": float64(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.StatusCode, res.Body)
+	}
+
+	expected := `{"data":{"site":{"name":"City","brand":{"name":"Shell"},"region":{"name":"Adelaide"},` +
+		`"prices":[{"fuelType":{"name":"Unleaded"},"price":1899,"current":true},{"fuelType":{"name":"Diesel"},"price":9999,"current":false}],` +
+		`"history":[{"date":"2024-05-09","min":1799,"mean":1890.5}]}}}`
+	if res.Body != expected {
+		t.Errorf("expected %s, got %s", expected, res.Body)
+	}
+}
+
+func TestGraphqlSites(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected []int
+	}{
+		{"all", `{ sites { id } }`, []int{1, 2, 3}},
+		{"first", `{ sites(first: 1) { id } }`, []int{1}},
+		{"near", `{ sites(near: {lat: -34.92, lng: 138.63}, radiusKm: 10) { id } }`, []int{2, 1}},
+		{"bbox", `{ sites(bbox: "115,-32,116,-31") { id } }`, []int{3}},
+		{"brand and fuel", `{ sites(brandId: 5, fuelId: 2) { id } }`, []int{1}},
+		{"postcode", `{ sites(postcode: "5067") { id } }`, []int{2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := executeGraphql(context.Background(), newTestLoader(time.Now().UTC()), GraphqlRequest{Query: test.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var body struct {
+				Data struct {
+					Sites []struct {
+						Id int `json:"id"`
+					} `json:"sites"`
+				} `json:"data"`
+			}
+			err = json.Unmarshal([]byte(res.Body), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, site := range body.Data.Sites {
+				ids = append(ids, site.Id)
+			}
+			if len(ids) != len(test.expected) {
+				t.Fatalf("expected sites %v, got %s", test.expected, res.Body)
+			}
+			for i := range ids {
+				if ids[i] != test.expected[i] {
+					t.Errorf("expected sites %v, got %v", test.expected, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryCost(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		expected  int
+	}{
+		{"single field", `{ site(id: 1) { name } }`, nil, 2},
+		// 1 for sites, then 20 sites of name, brand and its name.
+		{"default first", `{ sites { name brand { name } } }`, nil, 61},
+		{"variables", `query Q($first: Int) { sites(first: $first) { name } }`, map[string]interface{}{"first": float64(5)}, 6},
+		// 1 for sites, then 10 sites of history, which costs 10 plus 7 days of min.
+		{"history", `{ sites(first: 10) { history(fuelId: 2) { min } } }`, nil, 171},
+		{"fragments", `{ sites(first: 2) { ...names } } fragment names on Site { name ... on Site { postcode } }`, nil, 5},
+		{"introspection", `{ __schema { types { name fields { name } } } }`, nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cost, err := queryCost(test.query, "", test.variables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cost != test.expected {
+				t.Errorf("expected cost %d, got %d", test.expected, cost)
+			}
+		})
+	}
+
+	res, err := executeGraphql(context.Background(), newTestLoader(time.Now().UTC()), GraphqlRequest{
+		Query: `{ sites(first: 100) { prices { fuelType { name } } history(fuelId: 2, days: 56) { min } } }`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusBadRequest || !strings.Contains(res.Body, "more than the limit") {
+		t.Errorf("expected the query to be too expensive, got %d: %s", res.StatusCode, res.Body)
+	}
+}
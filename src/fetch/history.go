@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	historyTableName  string = "price_history"
+	historyDateLayout string = "2006-01-02"
+)
+
+// historyLocation is the timezone the days of the price history are counted in.
+var historyLocation, _ = time.LoadLocation("Australia/Adelaide")
+
+// DailyPrice summarises the prices of a fuel type across the sites of a region on one day, as
+// recorded by the update lambda.
+type DailyPrice struct {
+	FuelId   int
+	RegionId int
+	Date     string
+	Min      int
+	Median   float64
+	Mean     float64
+	Count    int
+}
+
+// historySeries is the partition key of the history of a fuel type in a region.
+func historySeries(fuelId int, regionId int) string {
+	return fmt.Sprintf("%d/%d", fuelId, regionId)
+}
+
+func (price *DailyPrice) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	dateRecord, ok := record["Date"]
+	if !ok {
+		return errors.New("history record is missing Date")
+	}
+	price.Date = aws.StringValue(dateRecord.S)
+
+	ints := map[string]*int{"FuelId": &price.FuelId, "Region": &price.RegionId, "Min": &price.Min, "Count": &price.Count}
+	for name, value := range ints {
+		if numRecord, ok := record[name]; ok {
+			*value, err = strconv.Atoi(aws.StringValue(numRecord.N))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	floats := map[string]*float64{"Median": &price.Median, "Mean": &price.Mean}
+	for name, value := range floats {
+		if numRecord, ok := record[name]; ok {
+			*value, err = strconv.ParseFloat(aws.StringValue(numRecord.N), 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getPriceHistory returns the days of a series from since onwards, oldest first. a missing table
+// is an empty history.
+func getPriceHistory(ctx context.Context, client *dynamodb.DynamoDB, fuelId int, regionId int, since time.Time) ([]DailyPrice, error) {
+	days := []DailyPrice{}
+	var unmarshalErr error
+	err := client.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(historyTableName),
+		KeyConditionExpression: aws.String("Series = :series AND #date >= :since"),
+		ExpressionAttributeNames: map[string]*string{
+			"#date": aws.String("Date"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":series": {S: aws.String(historySeries(fuelId, regionId))},
+			":since":  {S: aws.String(since.In(historyLocation).Format(historyDateLayout))},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, record := range page.Items {
+			var day DailyPrice
+			unmarshalErr = day.Unmarshal(record)
+			if unmarshalErr != nil {
+				return false
+			}
+			days = append(days, day)
+		}
+		return true
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return days, nil
+		}
+		return nil, err
+	}
+	return days, unmarshalErr
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	testCases := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+		"":      slog.LevelInfo,
+		"loud":  slog.LevelInfo,
+	}
+
+	for level, expected := range testCases {
+		if parsed := parseLogLevel(level); parsed != expected {
+			t.Errorf("expected level %q to parse as %s, got %s", level, expected, parsed)
+		}
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf, "debug", "hunter2")
+
+	log.Info("sending request with hunter2",
+		"apikey", "abc",
+		"url", "https://example.com/?key=hunter2",
+		"error", errors.New("bad key hunter2"),
+		headersAttr(map[string]string{"X-Api-Key": "def", "Accept": "application/json"}),
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "abc", "def"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be redacted, got %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "application/json") {
+		t.Errorf("expected non sensitive headers to be logged, got %s", out)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf, "warn")
+
+	log.Info("quiet")
+	if buf.Len() != 0 {
+		t.Errorf("expected info to be dropped at warn level, got %s", buf.String())
+	}
+
+	log.Warn("loud")
+	if !strings.Contains(buf.String(), `"msg":"loud"`) {
+		t.Errorf("expected warning to be logged, got %s", buf.String())
+	}
+}
+
+func TestRequestRoute(t *testing.T) {
+	request := events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/tiles/{proxy+}", Path: "/tiles/12/3614/2458"}
+	if route := requestRoute(request); route != "GET /tiles/{proxy+}" {
+		t.Errorf("expected the resource as the route, got %q", route)
+	}
+
+	request.Resource = ""
+	if route := requestRoute(request); route != "GET "+unmatchedResource {
+		t.Errorf("expected requests without a resource to share a route, got %q", route)
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -14,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -22,12 +27,18 @@ const (
 	sitesTableName  string = "safpis_fuel_sites"
 	writeBatchSize  int    = 25
 	readBatchSize   int    = 100
+	maxBatchRetries int    = 5
 	fuelURL         string = "https://fppdirectapi-prod.safuelpricinginformation.com.au"
 )
 
 var (
-	isLocal bool   = os.Getenv("local") == "true"
-	apikey  string = os.Getenv("api_key")
+	isLocal        bool   = os.Getenv("local") == "true"
+	isAuthRequired bool   = os.Getenv("require_api_key") != "false"
+	apikey         string = os.Getenv("api_key")
+
+	// batchRetryDelay is the wait before the first retry of unprocessed keys, doubling after each
+	// retry. it is shortened by tests.
+	batchRetryDelay time.Duration = 100 * time.Millisecond
 )
 
 type PetrolStationSite struct {
@@ -41,7 +52,7 @@ type PetrolStationSite struct {
 func getClient() *dynamodb.DynamoDB {
 	config := aws.NewConfig().WithRegion(region)
 	if isLocal {
-		fmt.Println("Using local endpoint.")
+		logger.Debug("using local endpoint")
 		config = config.WithEndpoint("http://dynamodb-local:8000")
 	}
 
@@ -50,11 +61,13 @@ func getClient() *dynamodb.DynamoDB {
 		return nil
 	}
 
-	return dynamodb.New(session, config)
+	return instrumentClient(dynamodb.New(session, config))
 }
 
-func checkTableExists(client *dynamodb.DynamoDB, tableName string) bool {
-	awsTables, err := client.ListTables(&dynamodb.ListTablesInput{})
+func checkTableExists(ctx context.Context, client *dynamodb.DynamoDB, tableName string) bool {
+	ctx, span := startSpan(ctx, "checkTableExists", attribute.String("table", tableName))
+	awsTables, err := client.ListTablesWithContext(ctx, &dynamodb.ListTablesInput{})
+	endSpan(span, err)
 	if err != nil {
 		return false
 	}
@@ -67,68 +80,160 @@ func checkTableExists(client *dynamodb.DynamoDB, tableName string) bool {
 	return slices.Contains(tables, tableName)
 }
 
-func getAllSites() (events.APIGatewayProxyResponse, error) {
+// scanTable reads every record in the table.
+func scanTable(ctx context.Context, client *dynamodb.DynamoDB, tableName string) ([]map[string]*dynamodb.AttributeValue, error) {
+	records := []map[string]*dynamodb.AttributeValue{}
+	err := client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		records = append(records, page.Items...)
+		return true
+	})
+	return records, err
+}
+
+// sleepContext waits for the delay, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// batchGetSiteRecords reads the records for the site ids from the table, in batches, retrying any
+// unprocessed keys with backoff.
+func batchGetSiteRecords(ctx context.Context, client *dynamodb.DynamoDB, tableName string, siteIds []int) ([]map[string]*dynamodb.AttributeValue, error) {
+	records := []map[string]*dynamodb.AttributeValue{}
+	for n := 0; n < len(siteIds); n += readBatchSize {
+		keys := []map[string]*dynamodb.AttributeValue{}
+
+		end := min(n+readBatchSize, len(siteIds))
+		for _, siteId := range siteIds[n:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"SiteId": {N: aws.String(fmt.Sprintf("%d", siteId))},
+			})
+		}
+
+		// - send the batch, and resend any keys dynamo couldn't process.
+		requestItems := map[string]*dynamodb.KeysAndAttributes{tableName: {Keys: keys}}
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				return nil, fmt.Errorf("%d keys still unprocessed after %d retries", len(requestItems[tableName].Keys), maxBatchRetries)
+			}
+			if attempt > 0 {
+				err := sleepContext(ctx, batchRetryDelay<<(attempt-1))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			batchRes, err := client.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				logger.Error("error while sending batch get item", "table", tableName, "error", err)
+				return nil, err
+			}
+			records = append(records, batchRes.Responses[tableName]...)
+			requestItems = batchRes.UnprocessedKeys
+		}
+
+		logger.Debug("read batch", "table", tableName, "read", end, "found", len(records))
+	}
+	return records, nil
+}
+
+// unmarshalSites reads the records from the sites table.
+func unmarshalSites(ctx context.Context, records []map[string]*dynamodb.AttributeValue) ([]SA_PetrolStationSite, error) {
+	_, span := startSpan(ctx, "unmarshal sites", attribute.Int("items", len(records)))
+	defer span.End()
+
+	sites := []SA_PetrolStationSite{}
+	for _, record := range records {
+		var site SA_PetrolStationSite
+		err := site.Unmarshal(record)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// unmarshalPrices reads the records from the prices table.
+func unmarshalPrices(ctx context.Context, records []map[string]*dynamodb.AttributeValue) (FuelPriceList, error) {
+	_, span := startSpan(ctx, "unmarshal prices", attribute.Int("items", len(records)))
+	var prices FuelPriceList
+	err := prices.Unmarshal(records)
+	endSpan(span, err)
+	return prices, err
+}
+
+func getAllSites(ctx context.Context, request events.APIGatewayProxyRequest, format string) (events.APIGatewayProxyResponse, error) {
 	// get dbclient
 	client := getClient()
 
-	if !checkTableExists(client, sitesTableName) {
+	if !checkTableExists(ctx, client, sitesTableName) {
 		return respondWithStdErr(nil, "table doesn't exist.")
 	}
 
 	// get all sites
 	// - send req
-	fmt.Println("Getting all sites.")
-	allSitesRaw, err := client.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(sitesTableName),
-	})
+	started := time.Now()
+	records, err := scanTable(ctx, client, sitesTableName)
 	if err != nil {
 		return respondWithStdErr(err, "")
 	}
+	logger.Info("scanned sites table", "items", len(records), "duration_ms", time.Since(started).Milliseconds())
 
-	// - trim
-	fmt.Printf("Trimming all sites. %d items\n", len(allSitesRaw.Items))
-	allSites := []PetrolStationSite{}
-	for _, rawsite := range allSitesRaw.Items {
-		name := *rawsite["N"].S
-
-		SiteId, err := strconv.Atoi(*rawsite["SiteId"].N)
-		if err != nil {
-			return respondWithStdErr(err, "error while converting siteid into int.")
-		}
+	sites, err := unmarshalSites(ctx, records)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
 
-		lat, err := strconv.ParseFloat(*rawsite["Lt"].N, 64)
+	if format == formatGeoJSON {
+		// the features carry every site's current prices.
+		priceRecords, err := scanTable(ctx, client, pricesTableName)
 		if err != nil {
-			return respondWithStdErr(err, "error while converting lat into float.")
+			return respondWithStdErr(err, "")
 		}
-
-		lng, err := strconv.ParseFloat(*rawsite["Lg"].N, 64)
+		prices, err := unmarshalPrices(ctx, priceRecords)
 		if err != nil {
-			return respondWithStdErr(err, "error while converting long into float.")
+			return respondWithStdErr(err, "error while unmarshalling fuel prices.")
 		}
+		return respondWithGeoJSON(ctx, sitesFeatureCollection(sites, prices))
+	}
 
-		googlePlaceID := *rawsite["G"].S
-
-		site := PetrolStationSite{
-			SiteId:        SiteId,
-			Name:          name,
-			Lat:           float64(lat),
-			Lng:           float64(lng),
-			GooglePlaceID: googlePlaceID,
-		}
+	if format == formatCSV {
+		_, brands := getNames(ctx, client)
+		return respondWithCSV(ctx, request, "sites.csv", sitesCSVHeader, sitesCSVRows(sites, brands))
+	}
 
-		allSites = append(allSites, site)
+	// - trim
+	allSites := []PetrolStationSite{}
+	for _, site := range sites {
+		allSites = append(allSites, PetrolStationSite{
+			SiteId:        site.SiteID,
+			Name:          site.Name,
+			Lat:           site.Latitude,
+			Lng:           site.Longitude,
+			GooglePlaceID: site.GooglePlaceID,
+		})
 	}
 
 	// - marshall
-	fmt.Println("Marshalling all sites.")
 	bytes, err := json.Marshal(allSites)
 	if err != nil {
 		return respondWithStdErr(err, "")
 	}
 
-	fmt.Println("Done!")
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
 		Body:       string(bytes),
 	}, nil
 }
@@ -147,137 +252,273 @@ func respondWithStdErr(err error, errstring string) (events.APIGatewayProxyRespo
 	}, err
 }
 
+func handleDelete(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if strings.HasPrefix(request.Path, alertsPathPrefix) {
+		return deleteAlert(ctx, request)
+	}
+	if strings.HasPrefix(request.Path, webhooksPathPrefix) {
+		return deleteWebhook(ctx, request)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       "invalid path.",
+	}, nil
+}
+
 func handleCors(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Headers: map[string]string{
 			"Access-Control-Allow-Headers": "*",
 			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Methods": "OPTIONS,GET,POST",
+			"Access-Control-Allow-Methods": "OPTIONS,GET,POST,DELETE",
 		},
 	}, nil
 }
 
-func handleGet(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handleGet(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// check the path and route based on that.
 	switch request.Path {
 	case "/prices":
-		latitude := request.QueryStringParameters["lat"]
-		longitude := request.QueryStringParameters["long"]
-		fuelType := request.QueryStringParameters["fuelType"]
+		format, err := responseFormat(request)
+		if err != nil {
+			return respondUnsupportedFormat(err)
+		}
 
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Body:       fmt.Sprintf("Prices Look Good! pos@%s.%s for %s\n", latitude, longitude, fuelType),
-		}, nil
+		// which sites are nearby depends on the sites as well as the prices.
+		return withCaching(ctx, request, []string{datasetSites, datasetPrices}, pricesCacheControl, func(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+			return getNearbyPrices(ctx, request, format)
+		})
 
 	case "/sites":
-		return getAllSites()
+		format, err := responseFormat(request)
+		if err != nil {
+			return respondUnsupportedFormat(err)
+		}
+
+		// geojson features carry the current prices, so they go stale as often as the prices do.
+		datasets, cacheControl := []string{datasetSites}, sitesCacheControl
+		if format == formatGeoJSON {
+			datasets, cacheControl = []string{datasetSites, datasetPrices}, pricesCacheControl
+		}
+
+		return withCaching(ctx, request, datasets, cacheControl, func(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+			return getAllSites(ctx, request, format)
+		})
+
+	case "/clusters":
+		return withCaching(ctx, request, []string{datasetSites, datasetPrices}, pricesCacheControl, func(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+			return getClusters(ctx, request)
+		})
+
+	case "/stats":
+		return getStats(ctx, request)
+
+	case "/cheapest":
+		return getCheapest(ctx, request)
+
+	case "/forecast":
+		return getForecast(ctx, request)
+
+	case alertsPath:
+		return listAlerts(ctx, request)
+
+	case triggeredPath:
+		return listTriggeredAlerts(ctx, request)
+
+	case webhooksPath:
+		return listWebhooks(ctx, request)
+	}
+
+	if webhookId, deliveries, ok := parseWebhookPath(request.Path); ok && deliveries {
+		return listWebhookDeliveries(ctx, request, webhookId)
+	}
+
+	if strings.HasPrefix(request.Path, tilesPathPrefix) {
+		return withCaching(ctx, request, []string{datasetSites, datasetPrices}, pricesCacheControl, func(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+			return getTile(ctx, request)
+		})
 	}
 
 	return respondWithStdErr(nil, "")
 }
 
-func handlePost(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handlePost(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// check the path and route based on that.
 	switch request.Path {
 	case "/prices":
-		// get params
-		fmt.Println("getting fuel type from params.")
-		fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+		format, err := responseFormat(request)
 		if err != nil {
-			return respondWithStdErr(err, "Error converting fuelId into integer.")
+			return respondUnsupportedFormat(err)
 		}
 
-		fmt.Println("getting sites from body.")
-		var siteIds []int
-		err = json.Unmarshal([]byte(request.Body), &siteIds)
-		if err != nil {
-			return respondWithStdErr(err, "")
+		datasets := []string{datasetPrices}
+		if format != formatJSON {
+			datasets = append(datasets, datasetSites)
 		}
 
-		fmt.Printf("getting prices for sites %d\n", siteIds)
-		fmt.Printf("getting prices for fuel type %d\n", fuelId)
+		return withCaching(ctx, request, datasets, pricesCacheControl, func(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+			return getPrices(ctx, request, format)
+		})
 
-		// get prices from DB.
-		dbclient := getClient()
-		if !checkTableExists(dbclient, pricesTableName) {
-			return respondWithStdErr(nil, "prices table doesn't exist.")
-		}
+	case alertsPath:
+		return createAlert(ctx, request)
 
-		// update the database.
-		var item map[string]*dynamodb.AttributeValue
+	case webhooksPath:
+		return registerWebhook(ctx, request)
 
-		allPrices := map[int]float64{}
-		fmt.Printf("fetching %d prices from database.\n", len(siteIds))
-		for n := 0; n < len(siteIds); {
-			attrs := []map[string]*dynamodb.AttributeValue{}
+	case graphqlPath:
+		return handleGraphql(ctx, request)
+	}
 
-			end := min(n+readBatchSize, len(siteIds))
-			for _, siteId := range siteIds[n:end] {
-				// - marshall the struct
-				item = map[string]*dynamodb.AttributeValue{
-					"SiteId": {N: aws.String(fmt.Sprintf("%d", siteId))},
-				}
+	return respondWithStdErr(nil, "invalid path.")
+}
 
-				// - append the write req
-				attrs = append(attrs, item)
-			}
+// getPrices returns the price of the fuel type at each of the sites in the body.
+func getPrices(ctx context.Context, request events.APIGatewayProxyRequest, format string) (events.APIGatewayProxyResponse, error) {
+	// get params
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return respondWithStdErr(err, "Error converting fuelId into integer.")
+	}
 
-			// - send the batch
-			batchReq := dynamodb.BatchGetItemInput{
-				RequestItems: map[string]*dynamodb.KeysAndAttributes{
-					pricesTableName: {
-						Keys: attrs,
-					},
-				},
-			}
-			batchRes, err := dbclient.BatchGetItem(&batchReq)
-			if err != nil {
-				fmt.Println("Error while sending batch get item.")
-				return respondWithStdErr(err, "")
-			}
+	var siteIds []int
+	err = json.Unmarshal([]byte(request.Body), &siteIds)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
 
-			var allSites FuelPriceList
-			err = allSites.Unmarshal(batchRes.Responses[pricesTableName])
-			if err != nil {
-				fmt.Println("Error while unmarshalling fuel prices.")
-				return respondWithStdErr(err, "Error while unmarshalling fuel prices.")
-			}
-			fmt.Println(allSites)
+	return getSitePrices(ctx, request, format, fuelId, siteIds)
+}
 
-			// filter the sites.
-			for siteId, site := range allSites.Sites {
-				if price, ok := site.FuelTypes[fuelId]; ok {
-					allPrices[siteId] = float64(price.Price)
-				}
-			}
+// nearbySiteIds returns the ids of the sites within the radius of the location, nearest first.
+func nearbySiteIds(sites []SA_PetrolStationSite, lat float64, lng float64, radiusKm float64) []int {
+	distances := map[int]float64{}
+	siteIds := []int{}
+	for _, site := range sites {
+		distance := distanceKm(lat, lng, site.Latitude, site.Longitude)
+		if distance <= radiusKm {
+			distances[site.SiteID] = distance
+			siteIds = append(siteIds, site.SiteID)
+		}
+	}
 
-			n += readBatchSize
-			fmt.Printf("found ~%d/%d records in database.\n", end, len(allPrices))
+	sort.Slice(siteIds, func(i, j int) bool {
+		if distances[siteIds[i]] != distances[siteIds[j]] {
+			return distances[siteIds[i]] < distances[siteIds[j]]
 		}
-		fmt.Printf("done!.\n")
+		return siteIds[i] < siteIds[j]
+	})
+	return siteIds
+}
 
-		// marshall the prices.
-		body, err := json.Marshal(allPrices)
-		if err != nil {
-			return respondWithStdErr(err, "error while marshalling prices.")
+// getNearbyPrices returns the price of the fuel type at each of the sites within the radius of
+// the location in the query.
+func getNearbyPrices(ctx context.Context, request events.APIGatewayProxyRequest, format string) (events.APIGatewayProxyResponse, error) {
+	respondBadRequest := func(message string) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: message}, nil
+	}
+
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return respondBadRequest("fuelType must be an integer.")
+	}
+	lat, err := strconv.ParseFloat(request.QueryStringParameters["lat"], 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return respondBadRequest("lat must be a latitude.")
+	}
+	lng, err := strconv.ParseFloat(request.QueryStringParameters["long"], 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return respondBadRequest("long must be a longitude.")
+	}
+	radiusKm := defaultNearRadiusKm
+	if rawRadius := request.QueryStringParameters["radiusKm"]; rawRadius != "" {
+		radiusKm, err = strconv.ParseFloat(rawRadius, 64)
+		if err != nil || radiusKm <= 0 || radiusKm > maxNearRadiusKm {
+			return respondBadRequest(fmt.Sprintf("radiusKm must be from 0 to %g.", maxNearRadiusKm))
 		}
+	}
 
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Body:       string(body),
-		}, nil
+	siteRecords, err := scanTable(ctx, getClient(), sitesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, siteRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	return getSitePrices(ctx, request, format, fuelId, nearbySiteIds(sites, lat, lng, radiusKm))
+}
 
+// getSitePrices returns the price of the fuel type at each of the sites, in the format.
+func getSitePrices(ctx context.Context, request events.APIGatewayProxyRequest, format string, fuelId int, siteIds []int) (events.APIGatewayProxyResponse, error) {
+	logger.Debug("getting prices", "fuel_id", fuelId, "site_ids", siteIds)
+
+	// get prices from DB.
+	dbclient := getClient()
+	if !checkTableExists(ctx, dbclient, pricesTableName) {
+		return respondWithStdErr(nil, "prices table doesn't exist.")
 	}
 
-	return respondWithStdErr(nil, "invalid path.")
+	started := time.Now()
+	records, err := batchGetSiteRecords(ctx, dbclient, pricesTableName, siteIds)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+
+	allSites, err := unmarshalPrices(ctx, records)
+	if err != nil {
+		logger.Error("error while unmarshalling fuel prices", "error", err)
+		return respondWithStdErr(err, "Error while unmarshalling fuel prices.")
+	}
+
+	// filter the sites.
+	allPrices := map[int]float64{}
+	stations := map[int]FuelStation{}
+	for siteId, site := range allSites.Sites {
+		if price, ok := site.FuelTypes[fuelId]; ok {
+			allPrices[siteId] = float64(price.Price)
+			stations[siteId] = site
+		}
+	}
+	logger.Info("read prices from database", "sites", len(siteIds), "found", len(allPrices), "duration_ms", time.Since(started).Milliseconds())
+
+	switch format {
+	case formatGeoJSON:
+		return getPricesGeoJSON(ctx, dbclient, FuelPriceList{Sites: stations})
+	case formatCSV:
+		return getPricesCSV(ctx, dbclient, request, FuelPriceList{Sites: stations}, fuelId)
+	}
+
+	// marshall the prices.
+	_, span := startSpan(ctx, "marshal prices", attribute.Int("prices", len(allPrices)))
+	body, err := json.Marshal(allPrices)
+	endSpan(span, err)
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling prices.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
+		Body:       string(body),
+	}, nil
 }
 
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var res events.APIGatewayProxyResponse
 	var err error
 
+	request, err = decodeRequest(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "invalid base64 body.",
+		}, nil
+	}
+
 	switch request.HTTPMethod {
 	default:
 		return events.APIGatewayProxyResponse{
@@ -288,22 +529,96 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return handleCors(request)
 
 	case http.MethodGet:
-		res, err = handleGet(request)
+		if request.Path == openapiPath {
+			res, err = getOpenAPISpec()
+			break
+		}
+		res, err = withAPIKey(ctx, request, validated(handleGet))
 	case http.MethodPost:
-		res, err = handlePost(request)
+		res, err = withAPIKey(ctx, request, validated(handlePost))
+	case http.MethodDelete:
+		res, err = withAPIKey(ctx, request, validated(handleDelete))
 	}
 
-	res.Headers =
-		map[string]string{
-			"Access-Control-Allow-Headers": "*",
-			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Methods": "OPTIONS,GET,POST",
-		}
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+	// the responses that don't say otherwise are plain text messages.
+	if _, ok := res.Headers["Content-Type"]; !ok && res.Body != "" {
+		res.Headers["Content-Type"] = contentTypePlain
+	}
+	res.Headers["Access-Control-Allow-Headers"] = "*"
+	res.Headers["Access-Control-Allow-Origin"] = "*"
+	res.Headers["Access-Control-Allow-Methods"] = "OPTIONS,GET,POST,DELETE"
+	res.Headers["Access-Control-Expose-Headers"] = "ETag,Last-Modified"
+	return compressResponse(request, res), err
+}
+
+// invoke is the lambda entrypoint, it scopes the logger and trace to the request before handling it.
+func invoke(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	finish := beginRequest(ctx, request)
+	spanCtx, span := startRequestSpan(ctx, request)
+	res, err := handler(spanCtx, request)
+	endRequestSpan(span, res, err)
+	finish(res, err)
+	flushTraces(ctx)
+	return res, err
+}
+
+// invokeWebsocket is invoke for the websocket api's events.
+func invokeWebsocket(ctx context.Context, wsRequest events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	request := websocketRequest(wsRequest)
+	finish := beginRequest(ctx, request)
+	spanCtx, span := startRequestSpan(ctx, request)
+	res, err := handleWebsocket(spanCtx, wsRequest.RequestContext.ConnectionID, request)
+	endRequestSpan(span, res, err)
+	finish(res, err)
+	flushTraces(ctx)
 	return res, err
 }
 
+// route tells the websocket api's events, which carry a connection id, apart from rest requests.
+func route(ctx context.Context, payload json.RawMessage) (events.APIGatewayProxyResponse, error) {
+	var event struct {
+		RequestContext struct {
+			ConnectionID string `json:"connectionId"`
+		} `json:"requestContext"`
+	}
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	if event.RequestContext.ConnectionID != "" {
+		var wsRequest events.APIGatewayWebsocketProxyRequest
+		err = json.Unmarshal(payload, &wsRequest)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		return invokeWebsocket(ctx, wsRequest)
+	}
+
+	var request events.APIGatewayProxyRequest
+	err = json.Unmarshal(payload, &request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return invoke(ctx, request)
+}
+
 func main() {
-	lambda.Start(handler)
+	err := initTracing(context.Background())
+	if err != nil {
+		logger.Error("error while starting tracing", "error", err)
+	}
+
+	if serverAddr != "" {
+		err = serve(serverAddr)
+		logger.Error("error while serving", "error", err)
+		os.Exit(1)
+	}
+
+	lambda.Start(route)
 }
 
 //{"CollectionMethod":{"S":"T"},"FuelId":{"N":"2"},"Price":{"N":"2799"},"SiteId":{"N":"61577372"},"TransactionDateUtc":{"S":"2023-10-27T05:11:11.663"}}
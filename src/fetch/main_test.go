@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
 func TestHandler(t *testing.T) {
@@ -43,7 +52,7 @@ func TestHandler(t *testing.T) {
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			response, err := handler(testCase.request)
+			response, err := handler(context.Background(), testCase.request)
 			if err != testCase.expectedError {
 				t.Errorf("Expected error %v, but got %v", testCase.expectedError, err)
 			}
@@ -58,3 +67,75 @@ func TestHandler(t *testing.T) {
 		})
 	}
 }
+
+// newTestDynamo returns a client for a local dynamodb endpoint that answers every batch get with
+// the first processed keys and the rest unprocessed.
+func newTestDynamo(t *testing.T, processed int, calls *int) *dynamodb.DynamoDB {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		var input struct {
+			RequestItems map[string]struct {
+				Keys []map[string]interface{}
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&input)
+
+		keys := input.RequestItems[sitesTableName].Keys
+		done := min(processed, len(keys))
+		output := map[string]interface{}{"Responses": map[string]interface{}{sitesTableName: keys[:done]}}
+		if done < len(keys) {
+			output["UnprocessedKeys"] = map[string]interface{}{sitesTableName: map[string]interface{}{"Keys": keys[done:]}}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(server.Close)
+
+	session, err := session.NewSession(aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamodb.New(session)
+}
+
+func TestBatchGetSiteRecordsRetries(t *testing.T) {
+	previous := batchRetryDelay
+	batchRetryDelay = time.Millisecond
+	t.Cleanup(func() { batchRetryDelay = previous })
+
+	calls := 0
+	records, err := batchGetSiteRecords(context.Background(), newTestDynamo(t, 1, &calls), sitesTableName, []int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || calls != 3 {
+		t.Errorf("expected the unprocessed keys to be retried, got %d records from %d calls", len(records), calls)
+	}
+
+	// keys that are never processed are given up on.
+	calls = 0
+	_, err = batchGetSiteRecords(context.Background(), newTestDynamo(t, 0, &calls), sitesTableName, []int{1, 2})
+	if err == nil || calls != maxBatchRetries+1 {
+		t.Errorf("expected to give up after %d retries, got %d calls and %v", maxBatchRetries, calls, err)
+	}
+}
+
+func TestNearbySiteIds(t *testing.T) {
+	sites := []SA_PetrolStationSite{
+		{SiteID: 1, Latitude: -34.95, Longitude: 138.62},
+		{SiteID: 2, Latitude: -34.9286, Longitude: 138.6008},
+		{SiteID: 3, Latitude: -34.80, Longitude: 138.60},
+		{SiteID: 4, Latitude: -31.95, Longitude: 115.86},
+	}
+
+	siteIds := nearbySiteIds(sites, -34.9285, 138.6007, 5)
+	if len(siteIds) != 2 || siteIds[0] != 2 || siteIds[1] != 1 {
+		t.Errorf("expected the sites within 5km nearest first, got %v", siteIds)
+	}
+	if siteIds := nearbySiteIds(sites, -34.9285, 138.6007, 20); len(siteIds) != 3 || siteIds[2] != 3 {
+		t.Errorf("expected a wider radius to reach site 3, got %v", siteIds)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestMetricsDocuments(t *testing.T) {
+	m := newMetrics(map[string]string{"Route": "GET /sites"})
+	m.Put("Latency", 12, unitMilliseconds)
+	m.PutWith("DynamoDBLatency", 3, unitMilliseconds, map[string]string{"Operation": "Scan"})
+	m.PutWith("DynamoDBLatency", 4, unitMilliseconds, map[string]string{"Operation": "Scan"})
+	m.PutWith("DynamoDBLatency", 5, unitMilliseconds, map[string]string{"Operation": "ListTables"})
+
+	documents := m.Documents(time.UnixMilli(1700000000000))
+	if len(documents) != 3 {
+		t.Fatalf("expected a document per set of dimensions, got %d", len(documents))
+	}
+
+	if documents[0]["Route"] != "GET /sites" {
+		t.Errorf("expected the Route dimension, got %v", documents[0]["Route"])
+	}
+	if documents[0]["Latency"] != 12.0 {
+		t.Errorf("expected a single value, got %v", documents[0]["Latency"])
+	}
+
+	scan := documents[1]
+	if scan["Operation"] != "Scan" || scan["Route"] != "GET /sites" {
+		t.Errorf("expected the extra dimension on top of the route, got %v", scan)
+	}
+	values, ok := scan["DynamoDBLatency"].([]float64)
+	if !ok || len(values) != 2 {
+		t.Errorf("expected repeated values to be sent as an array, got %v", scan["DynamoDBLatency"])
+	}
+
+	directive := scan["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]map[string]interface{})[0]
+	dimensions := directive["Dimensions"].([][]string)[0]
+	if strings.Join(dimensions, ",") != "Operation,Route" {
+		t.Errorf("unexpected dimensions: %v", dimensions)
+	}
+	definitions := directive["Metrics"].([]map[string]string)
+	if len(definitions) != 1 || definitions[0]["Name"] != "DynamoDBLatency" || definitions[0]["Unit"] != unitMilliseconds {
+		t.Errorf("unexpected metric definitions: %v", definitions)
+	}
+}
+
+func TestMetricsFlush(t *testing.T) {
+	m := newMetrics(map[string]string{"Route": "POST /prices"})
+	m.Put("Requests", 1, unitCount)
+
+	var buf bytes.Buffer
+	err := m.Flush(&buf, time.UnixMilli(1700000000000))
+	if err != nil {
+		t.Error(err)
+	}
+
+	var document map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &document)
+	if err != nil {
+		t.Errorf("expected a json document, got %s", buf.String())
+	}
+	if document["_aws"].(map[string]interface{})["Timestamp"] != 1700000000000.0 {
+		t.Errorf("unexpected timestamp: %v", document["_aws"])
+	}
+
+	buf.Reset()
+	m.Flush(&buf, time.Now())
+	if buf.Len() != 0 {
+		t.Errorf("expected flushed metrics to be cleared, got %s", buf.String())
+	}
+}
+
+func TestRecordRequestMetrics(t *testing.T) {
+	metrics = newMetrics(map[string]string{"Route": "GET /sites"})
+	recordRequestMetrics(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: "[]"}, nil, time.Second)
+	recordRequestMetrics(events.APIGatewayProxyResponse{StatusCode: http.StatusTooManyRequests}, nil, time.Second)
+	recordRequestMetrics(events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, errors.New("boom"), time.Second)
+
+	document := metrics.Documents(time.Now())[0]
+	if errs := document["Errors"].([]float64); errs[0] != 0 || errs[1] != 0 || errs[2] != 1 {
+		t.Errorf("unexpected Errors: %v", errs)
+	}
+	if errs := document["ClientErrors"].([]float64); errs[0] != 0 || errs[1] != 1 || errs[2] != 0 {
+		t.Errorf("unexpected ClientErrors: %v", errs)
+	}
+	if sizes := document["ResponseBytes"].([]float64); sizes[0] != 2 {
+		t.Errorf("unexpected ResponseBytes: %v", sizes)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+const (
+	openapiPath      string = "/openapi.json"
+	contentTypePlain string = "text/plain; charset=utf-8"
+)
+
+// openapiSpec is the contract of every route, including the update lambda's, as served at /openapi.json.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// openapiRouter finds the operation a request is for. openapiErr is why the spec couldn't be
+// loaded, in which case every validated request is refused rather than let through unchecked.
+var (
+	openapiRouter routers.Router
+	openapiErr    error
+)
+
+func init() {
+	// error messages name the parameter and the rule it broke, without dumping the schema.
+	openapi3.SchemaErrorDetailsDisabled = true
+	openapi3filter.RegisterBodyDecoder(contentTypeGeoJSON, openapi3filter.JSONBodyDecoder)
+	openapi3filter.RegisterBodyDecoder(contentTypeTile, openapi3filter.FileBodyDecoder)
+
+	openapiRouter, openapiErr = newOpenAPIRouter(openapiSpec)
+	if openapiErr != nil {
+		logger.Error("error while loading the openapi spec, requests will be refused", "error", openapiErr)
+	}
+}
+
+// newOpenAPIRouter loads and checks the spec. routes are matched on the path alone, since api
+// gateway passes requests through without the stage the server urls include.
+func newOpenAPIRouter(spec []byte) (routers.Router, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	err = doc.Validate(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Servers = nil
+	return gorillamux.NewRouter(doc)
+}
+
+// httpRequest returns the proxy request as a http request, for the spec to be checked against.
+// the handlers read every body as json whatever it was sent as, so it is validated as json too.
+func httpRequest(ctx context.Context, request events.APIGatewayProxyRequest) (*http.Request, error) {
+	query := url.Values{}
+	for key, value := range request.QueryStringParameters {
+		query.Set(key, value)
+	}
+	for key, values := range request.MultiValueQueryStringParameters {
+		query[key] = values
+	}
+
+	target := url.URL{Path: request.Path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, request.HTTPMethod, target.String(), strings.NewReader(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range request.Headers {
+		req.Header.Set(key, value)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); request.Body != "" && mediaType != contentTypeJSON {
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
+	return req, nil
+}
+
+// validateRequest checks the request against the spec. requests for paths the spec doesn't have
+// are left for the handlers to turn away.
+func validateRequest(ctx context.Context, request events.APIGatewayProxyRequest) error {
+	req, err := httpRequest(ctx, request)
+	if err != nil {
+		return err
+	}
+	route, pathParams, err := openapiRouter.FindRoute(req)
+	if errors.Is(err, routers.ErrPathNotFound) || errors.Is(err, routers.ErrMethodNotAllowed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return openapi3filter.ValidateRequest(ctx, &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			// api keys are checked by withAPIKey, which knows whether they are required.
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+			SkipSettingDefaults: true,
+		},
+	})
+}
+
+// withValidation turns away requests that don't match the spec before they reach next.
+func withValidation(ctx context.Context, request events.APIGatewayProxyRequest, next func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)) (events.APIGatewayProxyResponse, error) {
+	if openapiErr != nil {
+		logger.Error("refused request without an openapi spec to validate it", "error", openapiErr)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "internal server error.",
+		}, nil
+	}
+
+	err := validateRequest(ctx, request)
+	if err != nil {
+		logger.Info("rejected invalid request", "error", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       fmt.Sprintf("invalid request: %s", err.Error()),
+		}, nil
+	}
+	return next(ctx, request)
+}
+
+// validated wraps the handler with withValidation, so it can be passed to withAPIKey.
+func validated(next func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return withValidation(ctx, request, next)
+	}
+}
+
+// getOpenAPISpec returns the spec, which is public so clients can be generated without a key.
+func getOpenAPISpec() (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type":  contentTypeJSON,
+			"Cache-Control": sitesCacheControl,
+		},
+		Body: string(openapiSpec),
+	}, nil
+}
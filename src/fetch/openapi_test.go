@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// checkResponse fails the test if the response to the request doesn't match the spec.
+func checkResponse(t *testing.T, request events.APIGatewayProxyRequest, res events.APIGatewayProxyResponse) {
+	t.Helper()
+
+	req, err := httpRequest(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, pathParams, err := openapiRouter.FindRoute(req)
+	if err != nil {
+		t.Fatalf("%s %s isn't in the spec: %v", request.HTTPMethod, request.Path, err)
+	}
+
+	body := []byte(res.Body)
+	if res.IsBase64Encoded {
+		body, err = base64.StdEncoding.DecodeString(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	header := http.Header{}
+	for key, value := range res.Headers {
+		header.Set(key, value)
+	}
+
+	err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req, PathParams: pathParams, Route: route},
+		Status:                 res.StatusCode,
+		Header:                 header,
+		Body:                   io.NopCloser(bytes.NewReader(body)),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		t.Errorf("%s %s responded with %d against the spec: %v", request.HTTPMethod, request.Path, res.StatusCode, err)
+	}
+}
+
+// withoutAuth turns api keys off for the test, so the handler doesn't look them up.
+func withoutAuth(t *testing.T) {
+	previous := isAuthRequired
+	isAuthRequired = false
+	t.Cleanup(func() {
+		isAuthRequired = previous
+	})
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	if openapiErr != nil {
+		t.Fatalf("expected the spec to load: %v", openapiErr)
+	}
+
+	for _, route := range [][2]string{
+		{http.MethodGet, "/prices"},
+		{http.MethodPost, "/prices"},
+		{http.MethodGet, "/sites"},
+		{http.MethodGet, "/tiles/12/3705/2468.mvt"},
+		{http.MethodGet, "/clusters"},
+		{http.MethodGet, "/stats"},
+		{http.MethodGet, "/cheapest"},
+		{http.MethodGet, "/forecast"},
+		{http.MethodGet, alertsPath},
+		{http.MethodPost, alertsPath},
+		{http.MethodGet, triggeredPath},
+		{http.MethodDelete, "/alerts/0f3a"},
+		{http.MethodGet, webhooksPath},
+		{http.MethodPost, webhooksPath},
+		{http.MethodDelete, "/webhooks/0f3a"},
+		{http.MethodGet, "/webhooks/0f3a/deliveries"},
+		{http.MethodPost, graphqlPath},
+		{http.MethodGet, openapiPath},
+		{http.MethodGet, "/update"},
+		{http.MethodGet, "/update/status"},
+		{http.MethodGet, "/update/runs"},
+	} {
+		req, err := http.NewRequest(route[0], route[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := openapiRouter.FindRoute(req); err != nil {
+			t.Errorf("expected %s %s to be in the spec: %v", route[0], route[1], err)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		valid   bool
+	}{
+		{"valid query", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/cheapest", QueryStringParameters: map[string]string{"fuelType": "2", "limit": "5"}}, true},
+		{"missing fuel type", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/cheapest"}, false},
+		{"limit too high", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/cheapest", QueryStringParameters: map[string]string{"fuelType": "2", "limit": "500"}}, false},
+		{"unknown group", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/stats", QueryStringParameters: map[string]string{"fuelType": "2", "groupBy": "city"}}, false},
+		{"unknown format", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/sites", QueryStringParameters: map[string]string{"format": "xml"}}, false},
+		{"tile", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/tiles/12/3705/2468.mvt", QueryStringParameters: map[string]string{"fuelType": "2"}}, true},
+		{"tile zoom", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/tiles/30/0/0.mvt", QueryStringParameters: map[string]string{"fuelType": "2"}}, false},
+		{"location", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/prices", QueryStringParameters: map[string]string{"lat": "-34.93", "long": "138.6", "fuelType": "2", "radiusKm": "2.5"}}, true},
+		{"missing location", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/prices", QueryStringParameters: map[string]string{"fuelType": "2"}}, false},
+		{"radius too far", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/prices", QueryStringParameters: map[string]string{"lat": "-34.93", "long": "138.6", "fuelType": "2", "radiusKm": "80"}}, false},
+		{"site ids", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/prices", QueryStringParameters: map[string]string{"fuelType": "2"}, Body: "[61577372, 61577373]"}, true},
+		{"form encoded site ids", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/prices", QueryStringParameters: map[string]string{"fuelType": "2"}, Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, Body: "[61577372]"}, true},
+		{"invalid site ids", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/prices", QueryStringParameters: map[string]string{"fuelType": "2"}, Body: `["61577372"]`}, false},
+		{"missing body", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/prices", QueryStringParameters: map[string]string{"fuelType": "2"}}, false},
+		{"alert", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: alertsPath, Body: `{"FuelId": 2, "Threshold": 1799, "Lat": -34.93, "Lng": 138.6}`}, true},
+		{"alert radius", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: alertsPath, Body: `{"FuelId": 2, "Threshold": 1799, "Lat": -34.93, "Lng": 138.6, "RadiusKm": 80}`}, false},
+		{"webhook event", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: webhooksPath, Body: `{"Url": "https://example.com/hook", "Events": ["price.dropped"]}`}, false},
+		{"graphql", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: graphqlPath, Body: `{"query": "{ fuelTypes { name } }"}`}, true},
+		{"unknown path", events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/unknown"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := withValidation(context.Background(), test.request, func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid := res.StatusCode == http.StatusOK; valid != test.valid {
+				t.Errorf("expected valid to be %t, got %d: %s", test.valid, res.StatusCode, res.Body)
+			}
+		})
+	}
+}
+
+func TestValidationFailsClosed(t *testing.T) {
+	_, err := newOpenAPIRouter([]byte(`{"openapi": "3.0.3", "paths": {}}`))
+	if err == nil {
+		t.Fatal("expected a spec without info to be invalid")
+	}
+
+	previous := openapiErr
+	openapiErr = err
+	t.Cleanup(func() { openapiErr = previous })
+
+	called := false
+	res, _ := withValidation(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/sites"}, func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		called = true
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	})
+	if called || res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected requests to be refused without a spec, got %d", res.StatusCode)
+	}
+}
+
+func TestHandlerContract(t *testing.T) {
+	withoutAuth(t)
+
+	for _, request := range []events.APIGatewayProxyRequest{
+		{HTTPMethod: http.MethodGet, Path: "/prices", QueryStringParameters: map[string]string{"lat": "-134.9", "long": "138.6", "fuelType": "2"}},
+		{HTTPMethod: http.MethodGet, Path: openapiPath},
+		{HTTPMethod: http.MethodGet, Path: "/stats", QueryStringParameters: map[string]string{"fuelType": "diesel"}},
+		{HTTPMethod: http.MethodGet, Path: "/clusters", QueryStringParameters: map[string]string{"bbox": "138,-35,139,-34", "zoom": "23", "fuelType": "2"}},
+		{HTTPMethod: http.MethodPost, Path: graphqlPath, Body: `{"query": ""}`},
+		{HTTPMethod: http.MethodPost, Path: graphqlPath, Body: `{"query": "{ sites(first: 100) { history(fuelId: 2, days: 56) { min } } }"}`},
+	} {
+		res, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponse(t, request, res)
+	}
+}
+
+func TestResponseContract(t *testing.T) {
+	now := time.Now().UTC()
+	loader := newTestLoader(now)
+	sites, prices := loader.sites, *loader.prices
+	adelaide := BoundingBox{MinLng: 138.4, MinLat: -35.1, MaxLng: 138.8, MaxLat: -34.7}
+	lat, lng := -34.93, 138.6
+
+	respond := func(status int, body interface{}) events.APIGatewayProxyResponse {
+		res, err := respondWithJSON(status, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	get := func(path string, params map[string]string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: path, QueryStringParameters: params}
+	}
+
+	geojson, err := respondWithGeoJSON(context.Background(), sitesFeatureCollection(sites, prices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sitesRequest := get("/sites", map[string]string{"format": formatCSV})
+	csv, err := respondWithCSV(context.Background(), sitesRequest, "sites.csv", sitesCSVHeader, sitesCSVRows(sites, loader.names[referenceBrands]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile := TileCoord{Z: 12, X: 3705, Y: 2468}
+	graphql, err := executeGraphql(context.Background(), loader, GraphqlRequest{Query: `{ site(id: 1) { name prices { price } } }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := AlertSubscription{FuelId: 2, Threshold: 1799, Latitude: &lat, Longitude: &lng}
+	err = alert.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert.AlertId, alert.CreatedAt = "0f3a", now.Truncate(time.Second)
+
+	var triggered TriggeredAlert
+	err = triggered.Unmarshal(map[string]*dynamodb.AttributeValue{
+		"AlertId":     {S: aws.String("0f3a")},
+		"TriggeredAt": {S: aws.String(now.Format(time.RFC3339))},
+		"FuelId":      {N: aws.String("2")},
+		"Threshold":   {N: aws.String("1799")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Webhook{WebhookId: "0f3a", Url: "https://example.com/hook", Events: []string{"*"}, Secret: "secret", CreatedAt: now}
+	delivery := WebhookDelivery{DeliveryId: "1", EventId: "2", EventType: "price.changed", Status: "failed", Attempts: 5, ResponseStatus: 503, Error: "unavailable", DeliveredAt: now}
+
+	tests := []struct {
+		name     string
+		request  events.APIGatewayProxyRequest
+		response events.APIGatewayProxyResponse
+	}{
+		{"prices", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/prices"}, respond(http.StatusOK, map[int]float64{1: 1899})},
+		{"prices geojson", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/prices"}, geojson},
+		{"nearby prices", get("/prices", nil), respond(http.StatusOK, map[int]float64{1: 1899})},
+		{"nearby prices geojson", get("/prices", nil), geojson},
+		{"sites", get("/sites", nil), respond(http.StatusOK, []PetrolStationSite{{SiteId: 1, Name: "City", Lat: lat, Lng: lng}})},
+		{"sites geojson", get("/sites", nil), geojson},
+		{"sites csv", sitesRequest, csv},
+		{"tile", get("/tiles/12/3705/2468.mvt", nil), events.APIGatewayProxyResponse{
+			StatusCode:      http.StatusOK,
+			Headers:         map[string]string{"Content-Type": contentTypeTile},
+			Body:            base64.StdEncoding.EncodeToString(encodeTile(tileMarkers(tile, sites, prices, 2, now))),
+			IsBase64Encoded: true,
+		}},
+		{"clusters", get("/clusters", nil), respond(http.StatusOK, ClusterList{Zoom: 10, FuelId: 5, Clusters: clusterSites(sites, prices, 5, adelaide, 10, now)})},
+		{"stats", get("/stats", nil), respond(http.StatusOK, groupPriceStats(sites, prices, 2, groupByRegion, loader.names[referenceRegions], now))},
+		{"cheapest", get("/cheapest", nil), respond(http.StatusOK, CheapestList{FuelId: 2, Stations: cheapestStations(sites, prices, 2, CheapestFilter{}, 10, loader.names[referenceBrands], now)})},
+		{"forecast", get("/forecast", nil), respond(http.StatusOK, ForecastList{FuelId: 2, Forecasts: []Forecast{{RegionId: 7, Region: "Adelaide", CycleAnalysis: analyseCycle(loader.history["2/7/7"])}}})},
+		{"alert", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: alertsPath}, respond(http.StatusCreated, alert)},
+		{"alerts", get(alertsPath, nil), respond(http.StatusOK, []AlertSubscription{alert})},
+		{"triggered alerts", get(triggeredPath, nil), respond(http.StatusOK, []TriggeredAlert{triggered})},
+		{"webhook", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: webhooksPath}, respond(http.StatusCreated, hook)},
+		{"webhook deliveries", get("/webhooks/0f3a/deliveries", nil), respond(http.StatusOK, []WebhookDelivery{delivery})},
+		{"graphql", events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: graphqlPath}, graphql},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkResponse(t, test.request, test.response)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	prometheusPrefix      string = "petrol_"
+	prometheusContentType string = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// registry totals the metrics of every request for the standalone server's /metrics endpoint. it
+// is only set when running as the standalone server, a lambda has nothing to scrape.
+var registry *Registry
+
+// Registry keeps running totals of the metrics that were flushed, in the prometheus text format.
+// counts become counters, and latencies and sizes become summaries of their sum and count.
+type Registry struct {
+	mu     sync.Mutex
+	series map[string]*prometheusSeries
+}
+
+// prometheusSeries is the total of one metric for one set of labels.
+type prometheusSeries struct {
+	name   string
+	kind   string
+	labels map[string]string
+	sum    float64
+	count  int
+}
+
+func newRegistry() *Registry {
+	return &Registry{series: map[string]*prometheusSeries{}}
+}
+
+// prometheusName turns a metric name like "DynamoDBLatency" into "dynamo_db_latency".
+func prometheusName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previousLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// prometheusMetric returns the name, type and value a metric entry is exposed with. milliseconds
+// are exposed as seconds, which is the unit prometheus expects durations in.
+func prometheusMetric(entry metricEntry) (string, string, float64) {
+	name := prometheusPrefix + prometheusName(entry.name)
+	switch entry.unit {
+	case unitMilliseconds:
+		return name + "_seconds", "summary", entry.value / 1000
+	case unitBytes:
+		if !strings.HasSuffix(name, "_bytes") {
+			name += "_bytes"
+		}
+		return name, "summary", entry.value
+	}
+	return name + "_total", "counter", entry.value
+}
+
+// Registry.Observe adds the entries to the totals.
+func (r *Registry) Observe(entries []metricEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range entries {
+		name, kind, value := prometheusMetric(entry)
+		labels := map[string]string{}
+		for key, dimension := range entry.dimensions {
+			labels[prometheusName(key)] = dimension
+		}
+
+		key := name + "{" + dimensionsKey(labels) + "}"
+		series, ok := r.series[key]
+		if !ok {
+			series = &prometheusSeries{name: name, kind: kind, labels: maps.Clone(labels)}
+			r.series[key] = series
+		}
+		series.sum += value
+		series.count++
+	}
+}
+
+// prometheusLabels formats the labels sorted by name, escaping their values.
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := []string{}
+	for _, key := range sortedKeys(labels) {
+		parts = append(parts, key+`="`+escaper.Replace(labels[key])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatPrometheusValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Registry.Write writes the totals in the prometheus text format, grouped by metric and sorted so
+// scrapes are stable.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := []string{}
+	for key := range r.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	written := map[string]bool{}
+	for _, key := range keys {
+		series := r.series[key]
+		if !written[series.name] {
+			_, err := fmt.Fprintf(w, "# TYPE %s %s\n", series.name, series.kind)
+			if err != nil {
+				return err
+			}
+			written[series.name] = true
+		}
+
+		labels := prometheusLabels(series.labels)
+		var err error
+		if series.kind == "counter" {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", series.name, labels, formatPrometheusValue(series.sum))
+		} else {
+			_, err = fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", series.name, labels, formatPrometheusValue(series.sum), series.name, labels, series.count)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrometheusName(t *testing.T) {
+	tests := map[string]string{
+		"Requests":        "requests",
+		"DynamoDBLatency": "dynamo_db_latency",
+		"ResponseBytes":   "response_bytes",
+		"Route":           "route",
+	}
+	for name, expected := range tests {
+		if actual := prometheusName(name); actual != expected {
+			t.Errorf("expected %s to be %s, got %s", name, expected, actual)
+		}
+	}
+}
+
+func TestRegistryWrite(t *testing.T) {
+	r := newRegistry()
+	m := newMetrics(map[string]string{"Route": "GET /sites"})
+	m.Put("Requests", 1, unitCount)
+	m.Put("Latency", 250, unitMilliseconds)
+	m.Put("ResponseBytes", 100, unitBytes)
+	m.PutWith("DynamoDBLatency", 4, unitMilliseconds, map[string]string{"Operation": "Scan"})
+	r.Observe(m.Entries())
+
+	m = newMetrics(map[string]string{"Route": `GET "/prices"`})
+	m.Put("Requests", 1, unitCount)
+	m.Put("Latency", 750, unitMilliseconds)
+	r.Observe(m.Entries())
+	m = newMetrics(map[string]string{"Route": "GET /sites"})
+	m.Put("Requests", 1, unitCount)
+	m.Put("ResponseBytes", 50, unitBytes)
+	r.Observe(m.Entries())
+
+	var b strings.Builder
+	err := r.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE petrol_dynamo_db_latency_seconds summary
+petrol_dynamo_db_latency_seconds_sum{operation="Scan",route="GET /sites"} 0.004
+petrol_dynamo_db_latency_seconds_count{operation="Scan",route="GET /sites"} 1
+# TYPE petrol_latency_seconds summary
+petrol_latency_seconds_sum{route="GET \"/prices\""} 0.75
+petrol_latency_seconds_count{route="GET \"/prices\""} 1
+petrol_latency_seconds_sum{route="GET /sites"} 0.25
+petrol_latency_seconds_count{route="GET /sites"} 1
+# TYPE petrol_requests_total counter
+petrol_requests_total{route="GET \"/prices\""} 1
+petrol_requests_total{route="GET /sites"} 2
+# TYPE petrol_response_bytes summary
+petrol_response_bytes_sum{route="GET /sites"} 150
+petrol_response_bytes_count{route="GET /sites"} 2
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
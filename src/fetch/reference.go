@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	referenceTableName string = "reference_names"
+
+	referenceFuels   string = "fuels"
+	referenceBrands  string = "brands"
+	referenceRegions string = "regions"
+)
+
+// unmarshalReferenceNames reads the names from a reference record, keyed by id.
+func unmarshalReferenceNames(record map[string]*dynamodb.AttributeValue) (map[int]string, error) {
+	names := map[int]string{}
+	namesRecord, ok := record["Names"]
+	if !ok {
+		return names, nil
+	}
+
+	for rawId, name := range namesRecord.M {
+		id, err := strconv.Atoi(rawId)
+		if err != nil {
+			return nil, err
+		}
+		names[id] = aws.StringValue(name.S)
+	}
+	return names, nil
+}
+
+// getReferenceNames returns the fuel type or brand names by id, the update lambda refreshes them
+// along with the sites. names that haven't been loaded yet come back empty.
+func getReferenceNames(ctx context.Context, client *dynamodb.DynamoDB, kind string) (map[int]string, error) {
+	res, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(referenceTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Kind": {S: aws.String(kind)},
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return map[int]string{}, nil
+		}
+		return nil, err
+	}
+	if res.Item == nil {
+		return map[int]string{}, nil
+	}
+
+	return unmarshalReferenceNames(res.Item)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	metricsPath            string = "/metrics"
+	contentTypeEventStream string = "text/event-stream"
+	// connectionsPath is where the update function posts to stream connections. it is the same as
+	// in api gateway's management api, so stream_endpoint can be pointed at the standalone server.
+	connectionsPath string = "/@connections/"
+
+	// streamKeepAlive is how often an idle event stream is sent a comment, so proxies keep it open.
+	streamKeepAlive time.Duration = 30 * time.Second
+	// streamBuffer is how many messages an event stream can fall behind by before it is closed.
+	streamBuffer int = 16
+	// maxStreamMessageSize is the largest message that can be posted to a connection, the same as
+	// api gateway's.
+	maxStreamMessageSize int64 = 128 << 10
+)
+
+// serverAddr is the address the standalone server listens on, e.g. ":8080". the binary runs as a
+// lambda when it isn't set.
+var serverAddr string = os.Getenv("server_addr")
+
+// serverRoutes are the api gateway routes the template sends to this lambda. the standalone server
+// matches requests against the same resources, so they're logged and measured the same way.
+var serverRoutes = []struct {
+	method   string
+	resource string
+}{
+	{http.MethodGet, "/prices"},
+	{http.MethodPost, "/prices"},
+	{http.MethodGet, "/sites"},
+	{http.MethodGet, "/tiles/{proxy+}"},
+	{http.MethodGet, "/clusters"},
+	{http.MethodGet, "/stats"},
+	{http.MethodGet, "/cheapest"},
+	{http.MethodGet, "/forecast"},
+	{http.MethodGet, "/alerts"},
+	{http.MethodPost, "/alerts"},
+	{http.MethodGet, "/alerts/triggered"},
+	{http.MethodDelete, "/alerts/{alertId}"},
+	{http.MethodGet, "/webhooks"},
+	{http.MethodPost, "/webhooks"},
+	{http.MethodDelete, "/webhooks/{webhookId}"},
+	{http.MethodGet, "/webhooks/{webhookId}/deliveries"},
+	{http.MethodPost, "/graphql"},
+	{http.MethodGet, "/openapi.json"},
+}
+
+var resourceParamRegex = regexp.MustCompile(`\{([^}+]+)(\+?)\}`)
+
+// EventStream is an open server-sent event stream, which is sent the messages posted to its
+// connection.
+type EventStream struct {
+	messages chan []byte
+	close    context.CancelFunc
+}
+
+// eventStreams are the standalone server's open event streams, by connection id.
+var eventStreams = struct {
+	sync.Mutex
+	byId map[string]*EventStream
+}{byId: map[string]*EventStream{}}
+
+var (
+	// invocations makes the standalone server handle one request at a time, the logger and
+	// metrics are swapped for each request the same way they are between lambda invocations.
+	invocations sync.Mutex
+	requestSeq  int
+)
+
+// resourcePattern returns the resource as a http.ServeMux pattern, and the names of its params.
+// a greedy {proxy+} param matches the rest of the path, the same as in api gateway.
+func resourcePattern(method string, resource string) (string, []string) {
+	names := []string{}
+	pattern := resourceParamRegex.ReplaceAllStringFunc(resource, func(param string) string {
+		match := resourceParamRegex.FindStringSubmatch(param)
+		names = append(names, match[1])
+		if match[2] == "+" {
+			return "{" + match[1] + "...}"
+		}
+		return param
+	})
+	return method + " " + pattern, names
+}
+
+// proxyRequest returns the http request as the event api gateway would send the lambda for it.
+func proxyRequest(r *http.Request, resource string, params []string) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	request := events.APIGatewayProxyRequest{
+		Resource:                        resource,
+		Path:                            r.URL.Path,
+		HTTPMethod:                      r.Method,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+		PathParameters:                  map[string]string{},
+		Body:                            string(body),
+	}
+	// like api gateway, the single value maps hold the last value that was sent.
+	for name, values := range r.Header {
+		request.Headers[name] = values[len(values)-1]
+		request.MultiValueHeaders[name] = values
+	}
+	for name, values := range r.URL.Query() {
+		request.QueryStringParameters[name] = values[len(values)-1]
+		request.MultiValueQueryStringParameters[name] = values
+	}
+	for _, name := range params {
+		request.PathParameters[name] = r.PathValue(name)
+	}
+	if !utf8.Valid(body) {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+	return request, nil
+}
+
+// writeProxyResponse writes the lambda's response, decoding its body if it was base64 encoded.
+func writeProxyResponse(w http.ResponseWriter, res events.APIGatewayProxyResponse) {
+	body := []byte(res.Body)
+	if res.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(res.Body)
+		if err != nil {
+			logger.Error("error while decoding response body", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		body = decoded
+	}
+
+	for name, value := range res.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range res.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// serveResource passes requests for the resource on to the lambda's handler.
+func serveResource(resource string, params []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := proxyRequest(r, resource, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		invocations.Lock()
+		defer invocations.Unlock()
+		requestSeq++
+		request.RequestContext.RequestID = "standalone-" + strconv.Itoa(requestSeq)
+
+		res, err := invoke(r.Context(), request)
+		if err != nil {
+			writeInvokeError(w)
+			return
+		}
+		writeProxyResponse(w, res)
+	}
+}
+
+// writeInvokeError answers a failed invocation the same way api gateway does.
+func writeInvokeError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadGateway)
+	_, _ = w.Write([]byte(`{"message": "Internal server error"}`))
+}
+
+// newConnectionId returns a random id for an event stream. only the update function, which reads
+// it from the connections table, can post to the stream with it.
+func newConnectionId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// invokeStream sends an event stream's connect or disconnect through the websocket entrypoint, so
+// it is authorised, stored and logged the same way as a websocket's.
+func invokeStream(ctx context.Context, r *http.Request, routeKey string, connectionId string) (events.APIGatewayProxyResponse, error) {
+	request, err := proxyRequest(r, streamPath, nil)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	invocations.Lock()
+	defer invocations.Unlock()
+	requestSeq++
+
+	return invokeWebsocket(ctx, events.APIGatewayWebsocketProxyRequest{
+		Headers:               request.Headers,
+		QueryStringParameters: request.QueryStringParameters,
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			RouteKey:     routeKey,
+			ConnectionID: connectionId,
+			RequestID:    "standalone-" + strconv.Itoa(requestSeq),
+		},
+	})
+}
+
+// postToStream hands the message to the connection's event stream. it reports false when the
+// stream isn't open, or has fallen so far behind that it was closed.
+func postToStream(connectionId string, message []byte) bool {
+	eventStreams.Lock()
+	defer eventStreams.Unlock()
+
+	stream, ok := eventStreams.byId[connectionId]
+	if !ok {
+		return false
+	}
+	select {
+	case stream.messages <- message:
+		return true
+	default:
+		delete(eventStreams.byId, connectionId)
+		stream.close()
+		return false
+	}
+}
+
+// writeEvents writes each message as a server-sent event until ctx is done or the stream can't be
+// written to.
+func writeEvents(ctx context.Context, w http.ResponseWriter, messages <-chan []byte) {
+	flusher, _ := w.(http.Flusher)
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case message := <-messages:
+			// every line of a message is sent as a data field.
+			event := "data: " + strings.ReplaceAll(string(message), "\n", "\ndata: ") + "\n\n"
+			_, err = io.WriteString(w, event)
+		}
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// serveStream sends the price changes the update function posts to the connection as server-sent
+// events. it connects with the same api key and query params as the websocket api, and is
+// forgotten again once the client goes away.
+func serveStream(w http.ResponseWriter, r *http.Request) {
+	connectionId, err := newConnectionId()
+	if err != nil {
+		baseLogger.Error("error while creating connection id", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	res, err := invokeStream(r.Context(), r, routeConnect, connectionId)
+	if err != nil {
+		writeInvokeError(w)
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		writeProxyResponse(w, res)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream := &EventStream{messages: make(chan []byte, streamBuffer), close: cancel}
+	eventStreams.Lock()
+	eventStreams.byId[connectionId] = stream
+	eventStreams.Unlock()
+
+	defer func() {
+		eventStreams.Lock()
+		delete(eventStreams.byId, connectionId)
+		eventStreams.Unlock()
+
+		// the request's context is done by now, the connection still has to be removed.
+		_, err := invokeStream(context.WithoutCancel(r.Context()), r, routeDisconnect, connectionId)
+		if err != nil {
+			baseLogger.Error("error while disconnecting stream", "connection_id", connectionId, "error", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	writeEvents(ctx, w, stream.messages)
+}
+
+// servePostToConnection is api gateway's PostToConnection for event streams. a connection that
+// isn't open is answered with a GoneException, the same as api gateway, so the update function
+// removes it from the connections table.
+func servePostToConnection(w http.ResponseWriter, r *http.Request) {
+	message, err := io.ReadAll(io.LimitReader(r.Body, maxStreamMessageSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !postToStream(r.PathValue("connectionId"), message) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amzn-Errortype", "GoneException")
+		w.WriteHeader(http.StatusGone)
+		_, _ = w.Write([]byte(`{"message": "connection is gone."}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveMetrics writes the totals of the metrics every request has flushed so far.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	err := registry.Write(w)
+	if err != nil {
+		baseLogger.Error("error while writing metrics", "error", err)
+	}
+}
+
+// newServerMux routes the template's resources to the handler, and /metrics to the registry. the
+// websocket api is served as server-sent events at /stream.
+func newServerMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range serverRoutes {
+		pattern, params := resourcePattern(route.method, route.resource)
+		mux.HandleFunc(pattern, serveResource(route.resource, params))
+	}
+	mux.HandleFunc(http.MethodGet+" "+metricsPath, serveMetrics)
+	mux.HandleFunc(http.MethodGet+" "+streamPath, serveStream)
+	mux.HandleFunc(http.MethodPost+" "+connectionsPath+"{connectionId}", servePostToConnection)
+	return mux
+}
+
+// serve runs the api as a standalone http server rather than a lambda, with a prometheus endpoint
+// for its metrics.
+func serve(addr string) error {
+	registry = newRegistry()
+	baseLogger.Info("serving", "addr", addr)
+	return http.ListenAndServe(addr, newServerMux())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+)
+
+func TestResourcePattern(t *testing.T) {
+	tests := []struct {
+		method   string
+		resource string
+		pattern  string
+		params   []string
+	}{
+		{http.MethodGet, "/prices", "GET /prices", []string{}},
+		{http.MethodGet, "/tiles/{proxy+}", "GET /tiles/{proxy...}", []string{"proxy"}},
+		{http.MethodGet, "/webhooks/{webhookId}/deliveries", "GET /webhooks/{webhookId}/deliveries", []string{"webhookId"}},
+	}
+	for _, test := range tests {
+		pattern, params := resourcePattern(test.method, test.resource)
+		if pattern != test.pattern || strings.Join(params, ",") != strings.Join(test.params, ",") {
+			t.Errorf("expected %s %v for %s, got %s %v", test.pattern, test.params, test.resource, pattern, params)
+		}
+	}
+}
+
+func TestProxyRequest(t *testing.T) {
+	var request events.APIGatewayProxyRequest
+	mux := http.NewServeMux()
+	pattern, params := resourcePattern(http.MethodGet, "/tiles/{proxy+}")
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		request, err = proxyRequest(r, "/tiles/{proxy+}", params)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/tiles/12/3/4.mvt?fuelType=2&fuelType=3", nil)
+	r.Header.Set("x-api-key", "abc")
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+
+	if request.Resource != "/tiles/{proxy+}" || request.Path != "/tiles/12/3/4.mvt" || request.HTTPMethod != http.MethodGet {
+		t.Errorf("unexpected request: %+v", request)
+	}
+	if request.PathParameters["proxy"] != "12/3/4.mvt" {
+		t.Errorf("expected the greedy param to be the rest of the path, got %v", request.PathParameters)
+	}
+	if request.QueryStringParameters["fuelType"] != "3" || len(request.MultiValueQueryStringParameters["fuelType"]) != 2 {
+		t.Errorf("unexpected query params: %v %v", request.QueryStringParameters, request.MultiValueQueryStringParameters)
+	}
+	if getHeader(request, "X-Api-Key") != "abc" {
+		t.Errorf("unexpected headers: %v", request.Headers)
+	}
+}
+
+func TestServer(t *testing.T) {
+	registry = newRegistry()
+	defer func() { registry = nil }()
+	server := httptest.NewServer(newServerMux())
+	defer server.Close()
+
+	// the spec is large enough to be compressed, which the lambda base64 encodes.
+	res, err := http.Get(server.URL + openapiPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "{") {
+		t.Fatalf("expected the spec, got %d %.50s", res.StatusCode, body)
+	}
+
+	res, err = http.Get(server.URL + "/nowhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected routes outside the template to be not found, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(server.URL + metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), `petrol_requests_total{route="GET /openapi.json"} 1`+"\n") {
+		t.Errorf("expected the request to be counted, got:\n%s", body)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	previous := isAuthRequired
+	isAuthRequired = false
+	t.Cleanup(func() { isAuthRequired = previous })
+	server := httptest.NewServer(newServerMux())
+	defer server.Close()
+
+	// the filter is checked the same way as the websocket api's, before anything is stored.
+	res, err := http.Get(server.URL + streamPath + "?fuelType=diesel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an invalid filter to be rejected, got %d", res.StatusCode)
+	}
+
+	stream := &EventStream{messages: make(chan []byte, 1), close: func() {}}
+	eventStreams.Lock()
+	eventStreams.byId["abc"] = stream
+	eventStreams.Unlock()
+	t.Cleanup(func() {
+		eventStreams.Lock()
+		delete(eventStreams.byId, "abc")
+		eventStreams.Unlock()
+	})
+
+	// the update function posts with the management api client, pointed at the server.
+	awsSession, err := session.NewSession(aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := apigatewaymanagementapi.New(awsSession)
+
+	_, err = client.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{ConnectionId: aws.String("abc"), Data: []byte(`{"Type":"price.changed"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := <-stream.messages; string(message) != `{"Type":"price.changed"}` {
+		t.Errorf("unexpected message: %s", message)
+	}
+
+	_, err = client.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{ConnectionId: aws.String("gone"), Data: []byte("{}")})
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != apigatewaymanagementapi.ErrCodeGoneException {
+		t.Errorf("expected a connection that isn't open to be gone, got %v", err)
+	}
+
+	// a stream that falls behind is closed, and is gone from then on.
+	closed := false
+	stream.messages <- []byte("{}")
+	stream.close = func() { closed = true }
+	if postToStream("abc", []byte("{}")) || !closed || postToStream("abc", []byte("{}")) {
+		t.Error("expected a stream that fell behind to be closed")
+	}
+}
+
+func TestWriteEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := make(chan []byte)
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		writeEvents(ctx, recorder, messages)
+		close(done)
+	}()
+
+	messages <- []byte(`{"Type":"price.changed"}`)
+	messages <- []byte("two\nlines")
+	cancel()
+	<-done
+
+	expected := "data: {\"Type\":\"price.changed\"}\n\ndata: two\ndata: lines\n\n"
+	if recorder.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, recorder.Body.String())
+	}
+	if !recorder.Flushed {
+		t.Error("expected every event to be flushed")
+	}
+}
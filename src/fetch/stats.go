@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	groupByPostcode string = "postcode"
+	groupByRegion   string = "region"
+	groupByBrand    string = "brand"
+
+	// placeholderPrice is what stations report for a fuel they have run out of or stopped selling.
+	placeholderPrice int = 9999
+
+	// stalePriceAge is how long a price can go unchanged before it is left out of the stats.
+	stalePriceAge time.Duration = 7 * 24 * time.Hour
+)
+
+// statsPercentiles are the percentiles reported for each group, besides the median.
+var statsPercentiles = []int{10, 25, 75, 90}
+
+// PriceStats summarises the prices of a fuel type in one group. prices are in the same units as
+// the json endpoints.
+type PriceStats struct {
+	Key         string             `json:"Key"`
+	Name        string             `json:"Name"`
+	Count       int                `json:"Count"`
+	Min         int                `json:"Min"`
+	Max         int                `json:"Max"`
+	Mean        float64            `json:"Mean"`
+	Median      float64            `json:"Median"`
+	Percentiles map[string]float64 `json:"Percentiles"`
+}
+
+// PriceStatsList is the response of /stats.
+type PriceStatsList struct {
+	FuelId   int          `json:"FuelId"`
+	GroupBy  string       `json:"GroupBy"`
+	Excluded int          `json:"Excluded"`
+	Groups   []PriceStats `json:"Groups"`
+}
+
+// parseTransactionDate reads the upstream transaction date, which is utc without a zone.
+func parseTransactionDate(raw string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02T15:04:05", raw)
+	if err != nil {
+		return time.Parse(time.RFC3339, raw)
+	}
+	return date, nil
+}
+
+// isCurrentPrice reports whether the price is a real price that has changed recently enough to
+// count.
+func isCurrentPrice(price FuelPrice, now time.Time) bool {
+	if price.Price <= 0 || price.Price >= placeholderPrice {
+		return false
+	}
+
+	date, err := parseTransactionDate(price.TransactionDateUTC)
+	if err != nil {
+		return false
+	}
+	return now.Sub(date) <= stalePriceAge
+}
+
+// percentile returns the p'th percentile of the sorted prices, interpolating between the closest
+// ranks.
+func percentile(sorted []int, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return float64(sorted[lower])*(1-weight) + float64(sorted[upper])*weight
+}
+
+// priceStats summarises a non empty group of prices.
+func priceStats(key string, name string, prices []int) PriceStats {
+	sorted := append([]int{}, prices...)
+	sort.Ints(sorted)
+
+	total := 0
+	for _, price := range sorted {
+		total += price
+	}
+
+	stats := PriceStats{
+		Key:         key,
+		Name:        name,
+		Count:       len(sorted),
+		Min:         sorted[0],
+		Max:         sorted[len(sorted)-1],
+		Mean:        float64(total) / float64(len(sorted)),
+		Median:      percentile(sorted, 50),
+		Percentiles: map[string]float64{},
+	}
+	for _, p := range statsPercentiles {
+		stats.Percentiles[strconv.Itoa(p)] = percentile(sorted, float64(p))
+	}
+	return stats
+}
+
+// siteGroup returns the key and name of the group the site falls in.
+func siteGroup(site SA_PetrolStationSite, groupBy string, names map[int]string) (string, string) {
+	switch groupBy {
+	case groupByRegion:
+		return strconv.Itoa(site.RegionID), names[site.RegionID]
+	case groupByBrand:
+		return strconv.Itoa(site.BrandID), names[site.BrandID]
+	default:
+		return site.Postcode, site.Postcode
+	}
+}
+
+// groupPriceStats groups the current prices of the fuel type by the sites' postcode, region or
+// brand. placeholder and stale prices, and prices of unknown sites, are counted as excluded.
+// groups are ordered by key.
+func groupPriceStats(sites []SA_PetrolStationSite, prices FuelPriceList, fuelId int, groupBy string, names map[int]string, now time.Time) PriceStatsList {
+	sitesById := map[int]SA_PetrolStationSite{}
+	for _, site := range sites {
+		sitesById[site.SiteID] = site
+	}
+
+	list := PriceStatsList{FuelId: fuelId, GroupBy: groupBy, Groups: []PriceStats{}}
+	groups := map[string][]int{}
+	groupNames := map[string]string{}
+	for siteId, station := range prices.Sites {
+		price, ok := station.FuelTypes[fuelId]
+		if !ok {
+			continue
+		}
+
+		site, ok := sitesById[siteId]
+		if !ok || !isCurrentPrice(price, now) {
+			list.Excluded++
+			continue
+		}
+
+		key, name := siteGroup(site, groupBy, names)
+		groups[key] = append(groups[key], price.Price)
+		groupNames[key] = name
+	}
+
+	for key, groupPrices := range groups {
+		list.Groups = append(list.Groups, priceStats(key, groupNames[key], groupPrices))
+	}
+
+	// keys are ids or postcodes, so shorter keys sort first to keep them in numeric order.
+	sort.Slice(list.Groups, func(i, j int) bool {
+		a, b := list.Groups[i].Key, list.Groups[j].Key
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return list
+}
+
+// parseGroupBy reads the groupBy query param, which defaults to postcode.
+func parseGroupBy(request events.APIGatewayProxyRequest) (string, error) {
+	groupBy, ok := request.QueryStringParameters["groupBy"]
+	if !ok || groupBy == "" {
+		return groupByPostcode, nil
+	}
+
+	switch groupBy {
+	case groupByPostcode, groupByRegion, groupByBrand:
+		return groupBy, nil
+	}
+	return "", errors.New("groupBy must be postcode, region or brand.")
+}
+
+// getStats returns the price statistics of a fuel type, grouped as the request asks.
+func getStats(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	respondBadRequest := func(message string) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: message}, nil
+	}
+
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return respondBadRequest("fuelType must be an integer.")
+	}
+	groupBy, err := parseGroupBy(request)
+	if err != nil {
+		return respondBadRequest(err.Error())
+	}
+
+	client := getClient()
+	siteRecords, err := scanTable(ctx, client, sitesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, siteRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	priceRecords, err := scanTable(ctx, client, pricesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	prices, err := unmarshalPrices(ctx, priceRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling fuel prices.")
+	}
+
+	names := map[int]string{}
+	if groupBy != groupByPostcode {
+		kind := referenceRegions
+		if groupBy == groupByBrand {
+			kind = referenceBrands
+		}
+
+		names, err = getReferenceNames(ctx, client, kind)
+		if err != nil {
+			logger.Warn("error while getting group names", "kind", kind, "error", err)
+			names = map[int]string{}
+		}
+	}
+
+	_, span := startSpan(ctx, "price stats", attribute.String("group_by", groupBy))
+	stats := groupPriceStats(sites, prices, fuelId, groupBy, names, time.Now().UTC())
+	span.SetAttributes(attribute.Int("groups", len(stats.Groups)), attribute.Int("excluded", stats.Excluded))
+	span.End()
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return respondWithStdErr(err, "error while marshalling stats.")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": contentTypeJSON},
+		Body:       string(body),
+	}, nil
+}
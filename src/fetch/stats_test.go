@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []int{10, 20, 30, 40}
+	if percentile(sorted, 0) != 10 || percentile(sorted, 100) != 40 {
+		t.Error("expected the 0th and 100th percentiles to be the min and max")
+	}
+	if percentile(sorted, 50) != 25 {
+		t.Errorf("expected the median to be 25, got %f", percentile(sorted, 50))
+	}
+	if percentile([]int{7}, 90) != 7 {
+		t.Error("expected a single price to be every percentile")
+	}
+}
+
+func TestIsCurrentPrice(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		price FuelPrice
+		want  bool
+	}{
+		{"recent", FuelPrice{Price: 1899, TransactionDateUTC: "2024-05-10T03:12:00"}, true},
+		{"fractional seconds", FuelPrice{Price: 1899, TransactionDateUTC: "2024-05-09T03:12:00.123"}, true},
+		{"placeholder", FuelPrice{Price: 9999, TransactionDateUTC: "2024-05-10T03:12:00"}, false},
+		{"zero", FuelPrice{Price: 0, TransactionDateUTC: "2024-05-10T03:12:00"}, false},
+		{"stale", FuelPrice{Price: 1899, TransactionDateUTC: "2024-04-01T03:12:00"}, false},
+		{"unknown date", FuelPrice{Price: 1899, TransactionDateUTC: ""}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isCurrentPrice(test.price, now); got != test.want {
+				t.Errorf("expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGroupPriceStats(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	date := "2024-05-10T03:12:00"
+
+	sites := []SA_PetrolStationSite{
+		{SiteID: 1, Postcode: "5000", BrandID: 5, RegionID: 12},
+		{SiteID: 2, Postcode: "5000", BrandID: 5, RegionID: 12},
+		{SiteID: 3, Postcode: "5000", BrandID: 9, RegionID: 12},
+		{SiteID: 4, Postcode: "5700", BrandID: 9, RegionID: 13},
+		{SiteID: 5, Postcode: "5700", BrandID: 9, RegionID: 13},
+	}
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1800, TransactionDateUTC: date}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 2000, TransactionDateUTC: date}}},
+		3: {SiteID: 3, FuelTypes: map[int]FuelPrice{2: {Price: 1900, TransactionDateUTC: date}}},
+		4: {SiteID: 4, FuelTypes: map[int]FuelPrice{2: {Price: 9999, TransactionDateUTC: date}}},
+		5: {SiteID: 5, FuelTypes: map[int]FuelPrice{2: {Price: 2100, TransactionDateUTC: date}, 3: {Price: 2200, TransactionDateUTC: date}}},
+		6: {SiteID: 6, FuelTypes: map[int]FuelPrice{2: {Price: 1700, TransactionDateUTC: date}}},
+	}}
+
+	stats := groupPriceStats(sites, prices, 2, groupByPostcode, map[int]string{}, now)
+	if stats.Excluded != 2 {
+		t.Errorf("expected the placeholder price and the unknown site to be excluded, got %d", stats.Excluded)
+	}
+	if len(stats.Groups) != 2 || stats.Groups[0].Key != "5000" || stats.Groups[1].Key != "5700" {
+		t.Fatalf("unexpected groups: %+v", stats.Groups)
+	}
+
+	city := stats.Groups[0]
+	if city.Count != 3 || city.Min != 1800 || city.Max != 2000 || city.Mean != 1900 || city.Median != 1900 {
+		t.Errorf("unexpected stats: %+v", city)
+	}
+	if city.Percentiles["10"] != 1820 || city.Percentiles["90"] != 1980 {
+		t.Errorf("unexpected percentiles: %v", city.Percentiles)
+	}
+
+	stats = groupPriceStats(sites, prices, 2, groupByBrand, map[int]string{5: "Shell", 9: "BP"}, now)
+	if len(stats.Groups) != 2 || stats.Groups[0].Key != "5" || stats.Groups[0].Name != "Shell" || stats.Groups[1].Count != 2 {
+		t.Errorf("unexpected brand groups: %+v", stats.Groups)
+	}
+
+	stats = groupPriceStats(sites, prices, 2, groupByRegion, map[int]string{12: "Metro"}, now)
+	if len(stats.Groups) != 2 || stats.Groups[0].Name != "Metro" || stats.Groups[0].Count != 3 {
+		t.Errorf("unexpected region groups: %+v", stats.Groups)
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	groupBy, err := parseGroupBy(events.APIGatewayProxyRequest{})
+	if err != nil || groupBy != groupByPostcode {
+		t.Errorf("expected postcode by default, got %q, %v", groupBy, err)
+	}
+
+	_, err = parseGroupBy(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"groupBy": "suburb"}})
+	if err == nil {
+		t.Error("expected an unknown groupBy to be rejected")
+	}
+}
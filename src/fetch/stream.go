@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	connectionsTableName string = "stream_connections"
+	// streamPath is the path websocket requests are authorised and logged as.
+	streamPath string = "/stream"
+
+	routeConnect    string = "$connect"
+	routeDisconnect string = "$disconnect"
+	routeSubscribe  string = "subscribe"
+
+	maxStreamSites int = 100
+	maxStreamFuels int = 20
+	// connectionLifetime is how long api gateway keeps a websocket open, connections that didn't
+	// disconnect cleanly expire from the table after it.
+	connectionLifetime time.Duration = 2 * time.Hour
+)
+
+// StreamFilter picks the price changes a stream connection is sent. empty filters match
+// everything, and a price has to match all of the filters that are set.
+type StreamFilter struct {
+	FuelIds []int
+	SiteIds []int
+	BBox    *BoundingBox
+}
+
+// StreamSubscription is a subscribe message, the bbox is sent the same way as the query param.
+type StreamSubscription struct {
+	Action  string `json:"action"`
+	FuelIds []int  `json:"FuelIds"`
+	SiteIds []int  `json:"SiteIds"`
+	BBox    string `json:"BBox"`
+}
+
+// parseIdList reads a comma separated list of ids.
+func parseIdList(raw string, name string) ([]int, error) {
+	ids := []int{}
+	if raw == "" {
+		return ids, nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a comma separated list of ids.", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// newStreamFilter checks the filter's lists aren't too long and reads its bbox.
+func newStreamFilter(fuelIds []int, siteIds []int, rawBBox string) (StreamFilter, error) {
+	if len(fuelIds) > maxStreamFuels {
+		return StreamFilter{}, fmt.Errorf("at most %d fuel types can be streamed.", maxStreamFuels)
+	}
+	if len(siteIds) > maxStreamSites {
+		return StreamFilter{}, fmt.Errorf("at most %d sites can be streamed.", maxStreamSites)
+	}
+
+	filter := StreamFilter{FuelIds: fuelIds, SiteIds: siteIds}
+	if filter.FuelIds == nil {
+		filter.FuelIds = []int{}
+	}
+	if filter.SiteIds == nil {
+		filter.SiteIds = []int{}
+	}
+	if rawBBox != "" {
+		bbox, err := parseBoundingBox(rawBBox)
+		if err != nil {
+			return StreamFilter{}, err
+		}
+		filter.BBox = &bbox
+	}
+	return filter, nil
+}
+
+// parseStreamQuery reads the filter a websocket connected with, from the fuelType, siteIds and
+// bbox query params.
+func parseStreamQuery(params map[string]string) (StreamFilter, error) {
+	fuelIds, err := parseIdList(params["fuelType"], "fuelType")
+	if err != nil {
+		return StreamFilter{}, err
+	}
+	siteIds, err := parseIdList(params["siteIds"], "siteIds")
+	if err != nil {
+		return StreamFilter{}, err
+	}
+	return newStreamFilter(fuelIds, siteIds, params["bbox"])
+}
+
+// StreamFilter.Attributes returns the dynamodb representation of the filter.
+func (filter StreamFilter) Attributes() map[string]*dynamodb.AttributeValue {
+	idList := func(ids []int) *dynamodb.AttributeValue {
+		list := []*dynamodb.AttributeValue{}
+		for _, id := range ids {
+			list = append(list, &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(id))})
+		}
+		return &dynamodb.AttributeValue{L: list}
+	}
+
+	attributes := map[string]*dynamodb.AttributeValue{
+		"FuelIds": idList(filter.FuelIds),
+		"SiteIds": idList(filter.SiteIds),
+	}
+	if filter.BBox != nil {
+		bbox := *filter.BBox
+		attributes["BBox"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("%s,%s,%s,%s",
+			strconv.FormatFloat(bbox.MinLng, 'f', -1, 64),
+			strconv.FormatFloat(bbox.MinLat, 'f', -1, 64),
+			strconv.FormatFloat(bbox.MaxLng, 'f', -1, 64),
+			strconv.FormatFloat(bbox.MaxLat, 'f', -1, 64),
+		))}
+	}
+	return attributes
+}
+
+// websocketRequest returns the websocket event as a rest request, so it can be authorised and
+// logged the same way.
+func websocketRequest(request events.APIGatewayWebsocketProxyRequest) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod:            request.RequestContext.RouteKey,
+		Resource:              streamPath,
+		Path:                  streamPath,
+		Headers:               request.Headers,
+		QueryStringParameters: request.QueryStringParameters,
+		Body:                  request.Body,
+		RequestContext:        events.APIGatewayProxyRequestContext{RequestID: request.RequestContext.RequestID},
+	}
+}
+
+// connectStream stores the connection with the filter it connected with, for the update lambda
+// to push price changes to.
+func connectStream(ctx context.Context, connectionId string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	filter, err := parseStreamQuery(request.QueryStringParameters)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+
+	now := time.Now().UTC()
+	item := filter.Attributes()
+	item["ConnectionId"] = &dynamodb.AttributeValue{S: aws.String(connectionId)}
+	item["Owner"] = &dynamodb.AttributeValue{S: aws.String(alertOwner(request))}
+	item["ConnectedAt"] = &dynamodb.AttributeValue{S: aws.String(now.Format(time.RFC3339))}
+	item["ExpiresAt"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Add(connectionLifetime).Unix(), 10))}
+
+	_, err = getClient().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(connectionsTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while saving connection.")
+	}
+
+	logger.Info("stream connected", "connection_id", connectionId, "fuels", len(filter.FuelIds), "sites", len(filter.SiteIds), "bbox", filter.BBox != nil)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+// disconnectStream forgets the connection.
+func disconnectStream(ctx context.Context, connectionId string) (events.APIGatewayProxyResponse, error) {
+	_, err := getClient().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(connectionsTableName),
+		Key:       map[string]*dynamodb.AttributeValue{"ConnectionId": {S: aws.String(connectionId)}},
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while removing connection.")
+	}
+
+	logger.Info("stream disconnected", "connection_id", connectionId)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+// subscribeStream replaces the connection's filter with the one in the subscribe message.
+func subscribeStream(ctx context.Context, connectionId string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var sub StreamSubscription
+	err := json.Unmarshal([]byte(request.Body), &sub)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: "invalid subscribe json."}, nil
+	}
+	filter, err := newStreamFilter(sub.FuelIds, sub.SiteIds, sub.BBox)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+
+	attributes := filter.Attributes()
+	update := "SET FuelIds = :fuelIds, SiteIds = :siteIds"
+	values := map[string]*dynamodb.AttributeValue{
+		":fuelIds": attributes["FuelIds"],
+		":siteIds": attributes["SiteIds"],
+	}
+	if bbox, ok := attributes["BBox"]; ok {
+		update += ", BBox = :bbox"
+		values[":bbox"] = bbox
+	} else {
+		update += " REMOVE BBox"
+	}
+
+	_, err = getClient().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(connectionsTableName),
+		Key:                       map[string]*dynamodb.AttributeValue{"ConnectionId": {S: aws.String(connectionId)}},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(ConnectionId)"),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusGone, Body: "connection not found."}, nil
+		}
+		return respondWithStdErr(err, "error while saving subscription.")
+	}
+
+	logger.Info("stream subscribed", "connection_id", connectionId, "fuels", len(filter.FuelIds), "sites", len(filter.SiteIds), "bbox", filter.BBox != nil)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+// handleWebsocket routes the websocket api's events. only connecting is authorised, api gateway
+// only sends the rest of a connection's events once it has been accepted.
+func handleWebsocket(ctx context.Context, connectionId string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch request.HTTPMethod {
+	case routeConnect:
+		return withAPIKey(ctx, request, func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return connectStream(ctx, connectionId, request)
+		})
+	case routeDisconnect:
+		return disconnectStream(ctx, connectionId)
+	case routeSubscribe:
+		return subscribeStream(ctx, connectionId, request)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: "unknown action."}, nil
+}
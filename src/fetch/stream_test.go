@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParseStreamQuery(t *testing.T) {
+	filter, err := parseStreamQuery(map[string]string{"fuelType": "2, 3", "siteIds": "61577372", "bbox": "138.4,-35.1,138.8,-34.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter.FuelIds) != 2 || filter.FuelIds[1] != 3 || len(filter.SiteIds) != 1 || filter.BBox == nil || filter.BBox.MinLat != -35.1 {
+		t.Errorf("unexpected filter: %+v", filter)
+	}
+
+	attributes := filter.Attributes()
+	if *attributes["BBox"].S != "138.4,-35.1,138.8,-34.7" || len(attributes["FuelIds"].L) != 2 {
+		t.Errorf("unexpected attributes: %v", attributes)
+	}
+
+	filter, err = parseStreamQuery(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter.FuelIds) != 0 || len(filter.SiteIds) != 0 || filter.BBox != nil {
+		t.Errorf("expected an empty filter, got %+v", filter)
+	}
+	if _, ok := filter.Attributes()["BBox"]; ok {
+		t.Error("expected no bbox to be stored")
+	}
+
+	for _, params := range []map[string]string{
+		{"fuelType": "diesel"},
+		{"siteIds": "1,,2"},
+		{"bbox": "138.8,-35.1,138.4,-34.7"},
+	} {
+		if _, err := parseStreamQuery(params); err == nil {
+			t.Errorf("expected %v to be rejected", params)
+		}
+	}
+}
+
+func TestRouteWebsocket(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  events.APIGatewayWebsocketProxyRequest
+		status int
+	}{
+		{
+			"unknown action",
+			events.APIGatewayWebsocketProxyRequest{RequestContext: events.APIGatewayWebsocketProxyRequestContext{ConnectionID: "abc=", RouteKey: "$default"}},
+			http.StatusBadRequest,
+		},
+		{
+			"invalid subscription",
+			events.APIGatewayWebsocketProxyRequest{RequestContext: events.APIGatewayWebsocketProxyRequestContext{ConnectionID: "abc=", RouteKey: routeSubscribe}, Body: `{"action": "subscribe", "BBox": "1,2"}`},
+			http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := json.Marshal(test.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := route(context.Background(), payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, res.StatusCode, res.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	tilesPathPrefix  string = "/tiles/"
+	contentTypeTile  string = "application/vnd.mapbox-vector-tile"
+	tileLayerName    string = "stations"
+	tileExtent       int    = 4096
+	tileBuffer       int    = 64
+	maxTileZoom      int    = 22
+	simplifyMaxZoom  int    = 14
+	coarseGridSize   int    = 64
+	fineGridSize     int    = 16
+	fineGridMinZoom  int    = 12
+	mvtVersion       uint64 = 2
+	mvtPointType     uint64 = 1
+	mvtMoveToCommand uint32 = 1
+)
+
+// TileCoord is the z/x/y address of a tile.
+type TileCoord struct {
+	Z int
+	X int
+	Y int
+}
+
+// parseTilePath reads the tile address from a /tiles/{z}/{x}/{y}.mvt path.
+func parseTilePath(path string) (TileCoord, error) {
+	parts := strings.Split(strings.TrimPrefix(path, tilesPathPrefix), "/")
+	if !strings.HasPrefix(path, tilesPathPrefix) || len(parts) != 3 || !strings.HasSuffix(parts[2], ".mvt") {
+		return TileCoord{}, errors.New("tile path must be /tiles/{z}/{x}/{y}.mvt.")
+	}
+	parts[2] = strings.TrimSuffix(parts[2], ".mvt")
+
+	values := [3]int{}
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return TileCoord{}, errors.New("tile coordinates must be non negative integers.")
+		}
+		values[i] = value
+	}
+
+	tile := TileCoord{Z: values[0], X: values[1], Y: values[2]}
+	if tile.Z > maxTileZoom || tile.X >= 1<<tile.Z || tile.Y >= 1<<tile.Z {
+		return TileCoord{}, errors.New("tile is out of range.")
+	}
+	return tile, nil
+}
+
+// worldPosition returns the web mercator position of the location at the zoom level, in tiles
+// from the top left of the world.
+func worldPosition(lat float64, lng float64, z int) (float64, float64) {
+	scale := math.Exp2(float64(z))
+	sinLat := math.Sin(lat * math.Pi / 180)
+	return (lng + 180) / 360 * scale,
+		(0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * scale
+}
+
+// TileCoord.Project returns where the location falls in the tile, in tile units from its top left.
+func (tile TileCoord) Project(lat float64, lng float64) (int, int) {
+	worldX, worldY := worldPosition(lat, lng, tile.Z)
+
+	return int(math.Floor((worldX - float64(tile.X)) * float64(tileExtent))),
+		int(math.Floor((worldY - float64(tile.Y)) * float64(tileExtent)))
+}
+
+// tileGridSize is how close, in tile units, sites have to be to be merged into one marker. sites
+// are never merged from simplifyMaxZoom in.
+func tileGridSize(z int) int {
+	switch {
+	case z >= simplifyMaxZoom:
+		return 0
+	case z >= fineGridMinZoom:
+		return fineGridSize
+	}
+	return coarseGridSize
+}
+
+// TileMarker is a point in a tile, standing for one or more sites. Price is the cheapest current
+// price of the selected fuel type across the sites, or -1 if none of them have one.
+type TileMarker struct {
+	X      int
+	Y      int
+	SiteId int
+	Name   string
+	Count  int
+	Price  int
+}
+
+// tileMarkers returns a marker for each site in the tile, merging sites that fall in the same
+// grid cell at low zoom levels. markers are ordered by site id so tiles encode the same way.
+func tileMarkers(tile TileCoord, sites []SA_PetrolStationSite, prices FuelPriceList, fuelId int, now time.Time) []TileMarker {
+	sites = append([]SA_PetrolStationSite{}, sites...)
+	sort.Slice(sites, func(i, j int) bool { return sites[i].SiteID < sites[j].SiteID })
+
+	gridSize := tileGridSize(tile.Z)
+	markers := []*TileMarker{}
+	cells := map[[2]int]*TileMarker{}
+
+	for _, site := range sites {
+		x, y := tile.Project(site.Latitude, site.Longitude)
+		if x < -tileBuffer || y < -tileBuffer || x >= tileExtent+tileBuffer || y >= tileExtent+tileBuffer {
+			continue
+		}
+
+		price := -1
+		if fuelPrice, ok := prices.Sites[site.SiteID].FuelTypes[fuelId]; ok && isCurrentPrice(fuelPrice, now) {
+			price = fuelPrice.Price
+		}
+
+		marker := &TileMarker{X: x, Y: y, SiteId: site.SiteID, Name: site.Name, Count: 1, Price: price}
+		if gridSize == 0 {
+			markers = append(markers, marker)
+			continue
+		}
+
+		cell := [2]int{int(math.Floor(float64(x) / float64(gridSize))), int(math.Floor(float64(y) / float64(gridSize)))}
+		merged, ok := cells[cell]
+		if !ok {
+			// merged markers sit in the middle of their cell.
+			marker.X = cell[0]*gridSize + gridSize/2
+			marker.Y = cell[1]*gridSize + gridSize/2
+			cells[cell] = marker
+			markers = append(markers, marker)
+			continue
+		}
+
+		merged.Count++
+		if price >= 0 && (merged.Price < 0 || price < merged.Price) {
+			merged.SiteId, merged.Name, merged.Price = site.SiteID, site.Name, price
+		}
+	}
+
+	result := []TileMarker{}
+	for _, marker := range markers {
+		result = append(result, *marker)
+	}
+	return result
+}
+
+// appendTileValue appends an mvt Value message.
+func appendTileValue(b []byte, value interface{}) []byte {
+	var encoded []byte
+	switch v := value.(type) {
+	case string:
+		encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, v)
+	case float64:
+		encoded = protowire.AppendTag(encoded, 3, protowire.Fixed64Type)
+		encoded = protowire.AppendFixed64(encoded, math.Float64bits(v))
+	case int:
+		encoded = protowire.AppendTag(encoded, 6, protowire.VarintType)
+		encoded = protowire.AppendVarint(encoded, protowire.EncodeZigZag(int64(v)))
+	}
+
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	return protowire.AppendBytes(b, encoded)
+}
+
+// tileLayer accumulates the keys and values of a layer, which features refer to by index.
+type tileLayer struct {
+	keys       []string
+	keyIndex   map[string]uint32
+	values     []interface{}
+	valueIndex map[interface{}]uint32
+	features   [][]byte
+}
+
+func (layer *tileLayer) tag(key string, value interface{}) []uint32 {
+	keyId, ok := layer.keyIndex[key]
+	if !ok {
+		keyId = uint32(len(layer.keys))
+		layer.keyIndex[key] = keyId
+		layer.keys = append(layer.keys, key)
+	}
+
+	valueId, ok := layer.valueIndex[value]
+	if !ok {
+		valueId = uint32(len(layer.values))
+		layer.valueIndex[value] = valueId
+		layer.values = append(layer.values, value)
+	}
+
+	return []uint32{keyId, valueId}
+}
+
+// tileLayer.addPoint appends a point feature with the given properties.
+func (layer *tileLayer) addPoint(id uint64, x int, y int, properties [][2]interface{}) {
+	tags := []uint32{}
+	for _, property := range properties {
+		tags = append(tags, layer.tag(property[0].(string), property[1])...)
+	}
+
+	var packedTags []byte
+	for _, tag := range tags {
+		packedTags = protowire.AppendVarint(packedTags, uint64(tag))
+	}
+
+	// a single MoveTo, relative to the tile's origin.
+	var geometry []byte
+	geometry = protowire.AppendVarint(geometry, uint64(mvtMoveToCommand&0x7|1<<3))
+	geometry = protowire.AppendVarint(geometry, protowire.EncodeZigZag(int64(x)))
+	geometry = protowire.AppendVarint(geometry, protowire.EncodeZigZag(int64(y)))
+
+	var feature []byte
+	feature = protowire.AppendTag(feature, 1, protowire.VarintType)
+	feature = protowire.AppendVarint(feature, id)
+	feature = protowire.AppendTag(feature, 2, protowire.BytesType)
+	feature = protowire.AppendBytes(feature, packedTags)
+	feature = protowire.AppendTag(feature, 3, protowire.VarintType)
+	feature = protowire.AppendVarint(feature, mvtPointType)
+	feature = protowire.AppendTag(feature, 4, protowire.BytesType)
+	feature = protowire.AppendBytes(feature, geometry)
+	layer.features = append(layer.features, feature)
+}
+
+// tileLayer.Marshal returns the layer wrapped in an mvt Tile message.
+func (layer *tileLayer) Marshal(name string) []byte {
+	var encoded []byte
+	encoded = protowire.AppendTag(encoded, 15, protowire.VarintType)
+	encoded = protowire.AppendVarint(encoded, mvtVersion)
+	encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+	encoded = protowire.AppendString(encoded, name)
+	for _, feature := range layer.features {
+		encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+		encoded = protowire.AppendBytes(encoded, feature)
+	}
+	for _, key := range layer.keys {
+		encoded = protowire.AppendTag(encoded, 3, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, key)
+	}
+	for _, value := range layer.values {
+		encoded = appendTileValue(encoded, value)
+	}
+	encoded = protowire.AppendTag(encoded, 5, protowire.VarintType)
+	encoded = protowire.AppendVarint(encoded, uint64(tileExtent))
+
+	var tile []byte
+	tile = protowire.AppendTag(tile, 3, protowire.BytesType)
+	return protowire.AppendBytes(tile, encoded)
+}
+
+// encodeTile encodes the markers as a mapbox vector tile with a single stations layer. each
+// feature carries the site_id and name of the site, or of the cheapest site it stands for, the
+// count of sites, and the cheapest price in cents per litre when any of them sell the fuel type.
+func encodeTile(markers []TileMarker) []byte {
+	layer := &tileLayer{
+		keyIndex:   map[string]uint32{},
+		valueIndex: map[interface{}]uint32{},
+	}
+
+	for _, marker := range markers {
+		properties := [][2]interface{}{
+			{"site_id", marker.SiteId},
+			{"name", marker.Name},
+			{"count", marker.Count},
+		}
+		if marker.Price >= 0 {
+			properties = append(properties, [2]interface{}{"price", float64(marker.Price) / 10})
+		}
+		layer.addPoint(uint64(marker.SiteId), marker.X, marker.Y, properties)
+	}
+
+	if len(layer.features) == 0 {
+		return []byte{}
+	}
+	return layer.Marshal(tileLayerName)
+}
+
+// getTile returns the vector tile at the request's path, with prices for the fuelType query param.
+func getTile(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	tile, err := parseTilePath(request.Path)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+	fuelId, err := strconv.Atoi(request.QueryStringParameters["fuelType"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: "fuelType must be an integer."}, nil
+	}
+
+	client := getClient()
+	siteRecords, err := scanTable(ctx, client, sitesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	sites, err := unmarshalSites(ctx, siteRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling sites.")
+	}
+
+	priceRecords, err := scanTable(ctx, client, pricesTableName)
+	if err != nil {
+		return respondWithStdErr(err, "")
+	}
+	prices, err := unmarshalPrices(ctx, priceRecords)
+	if err != nil {
+		return respondWithStdErr(err, "error while unmarshalling fuel prices.")
+	}
+
+	_, span := startSpan(ctx, "encode tile", attribute.Int("z", tile.Z), attribute.Int("x", tile.X), attribute.Int("y", tile.Y))
+	markers := tileMarkers(tile, sites, prices, fuelId, time.Now().UTC())
+	body := encodeTile(markers)
+	span.SetAttributes(attribute.Int("features", len(markers)), attribute.Int("bytes", len(body)))
+	span.End()
+
+	return events.APIGatewayProxyResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         map[string]string{"Content-Type": contentTypeTile},
+		Body:            base64.StdEncoding.EncodeToString(body),
+		IsBase64Encoded: true,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseTilePath(t *testing.T) {
+	tile, err := parseTilePath("/tiles/12/3638/2466.mvt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile != (TileCoord{Z: 12, X: 3638, Y: 2466}) {
+		t.Errorf("unexpected tile: %+v", tile)
+	}
+
+	for _, path := range []string{"/tiles/12/3638/2466", "/tiles/12/3638.mvt", "/tiles/a/b/c.mvt", "/tiles/1/2/0.mvt", "/tiles/23/0/0.mvt", "/sites"} {
+		if _, err := parseTilePath(path); err == nil {
+			t.Errorf("expected %s to be rejected", path)
+		}
+	}
+}
+
+func TestTileProject(t *testing.T) {
+	// adelaide falls in the bottom right quarter of the world tile.
+	x, y := TileCoord{}.Project(-34.93, 138.6)
+	if x < tileExtent/2 || x >= tileExtent || y < tileExtent/2 || y >= tileExtent {
+		t.Errorf("unexpected position: %d, %d", x, y)
+	}
+
+	tile := TileCoord{Z: 12, X: 3624, Y: 2472}
+	x, y = tile.Project(-34.93, 138.6)
+	if x < 0 || x >= tileExtent || y < 0 || y >= tileExtent {
+		t.Errorf("expected the location to fall inside %+v, got %d, %d", tile, x, y)
+	}
+}
+
+func TestTileMarkers(t *testing.T) {
+	sites := []SA_PetrolStationSite{
+		{SiteID: 1, Name: "First", Latitude: -34.9300, Longitude: 138.6000},
+		{SiteID: 2, Name: "Second", Latitude: -34.9301, Longitude: 138.6001},
+		{SiteID: 3, Name: "Third", Latitude: -34.9302, Longitude: 138.6002},
+		{SiteID: 4, Name: "Perth", Latitude: -31.95, Longitude: 115.86},
+	}
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 2799, TransactionDateUTC: "2024-05-10T03:12:00"}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 2599, TransactionDateUTC: "2024-05-09T03:12:00"}}},
+		3: {SiteID: 3, FuelTypes: map[int]FuelPrice{3: {Price: 1999, TransactionDateUTC: "2024-05-10T03:12:00"}}},
+	}}
+
+	// at low zoom the nearby sites merge, keeping the cheapest.
+	markers := tileMarkers(TileCoord{Z: 6, X: 56, Y: 38}, sites, prices, 2, now)
+	if len(markers) != 1 {
+		t.Fatalf("expected the adelaide sites to merge into 1 marker, got %d", len(markers))
+	}
+	if markers[0].Count != 3 || markers[0].SiteId != 2 || markers[0].Price != 2599 {
+		t.Errorf("unexpected merged marker: %+v", markers[0])
+	}
+
+	// zoomed in every site is its own marker.
+	markers = tileMarkers(TileCoord{Z: 18, X: 231997, Y: 158247}, sites, prices, 2, now)
+	if len(markers) != 3 {
+		t.Fatalf("expected 3 markers, got %d", len(markers))
+	}
+	if markers[2].SiteId != 3 || markers[2].Price != -1 {
+		t.Errorf("expected a site without the fuel type to have no price: %+v", markers[2])
+	}
+
+	// placeholder and stale prices aren't shown, or picked as the cheapest of a merged marker.
+	prices.Sites[1] = FuelStation{SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 2799, TransactionDateUTC: "2024-04-01T03:12:00"}}}
+	prices.Sites[2] = FuelStation{SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 9999, TransactionDateUTC: "2024-05-10T03:12:00"}}}
+	markers = tileMarkers(TileCoord{Z: 6, X: 56, Y: 38}, sites, prices, 2, now)
+	if markers[0].Count != 3 || markers[0].Price != -1 {
+		t.Errorf("expected the merged marker to have no price: %+v", markers[0])
+	}
+	markers = tileMarkers(TileCoord{Z: 18, X: 231997, Y: 158247}, sites, prices, 2, now)
+	if markers[0].Price != -1 || markers[1].Price != -1 {
+		t.Errorf("expected the stale and placeholder prices to be left out: %+v", markers)
+	}
+}
+
+func TestEncodeTile(t *testing.T) {
+	if len(encodeTile([]TileMarker{})) != 0 {
+		t.Error("expected an empty tile to have no layers")
+	}
+
+	body := encodeTile([]TileMarker{
+		{X: 10, Y: 20, SiteId: 1, Name: "First", Count: 1, Price: 2799},
+		{X: 30, Y: 40, SiteId: 2, Name: "Second", Count: 2, Price: -1},
+	})
+
+	num, typ, n := protowire.ConsumeTag(body)
+	if num != 3 || typ != protowire.BytesType {
+		t.Fatalf("expected a layer, got field %d", num)
+	}
+	layer, _ := protowire.ConsumeBytes(body[n:])
+
+	name, features, keys, extent := "", 0, []string{}, uint64(0)
+	for len(layer) > 0 {
+		num, typ, n := protowire.ConsumeTag(layer)
+		layer = layer[n:]
+		switch {
+		case num == 1:
+			value, n := protowire.ConsumeString(layer)
+			name, layer = value, layer[n:]
+		case num == 2:
+			_, n := protowire.ConsumeBytes(layer)
+			features, layer = features+1, layer[n:]
+		case num == 3:
+			value, n := protowire.ConsumeString(layer)
+			keys, layer = append(keys, value), layer[n:]
+		case num == 5:
+			value, n := protowire.ConsumeVarint(layer)
+			extent, layer = value, layer[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, layer)
+			if n < 0 {
+				t.Fatalf("invalid field %d", num)
+			}
+			layer = layer[n:]
+		}
+	}
+
+	if name != tileLayerName {
+		t.Errorf("unexpected layer name: %s", name)
+	}
+	if features != 2 {
+		t.Errorf("expected 2 features, got %d", features)
+	}
+	if len(keys) != 4 {
+		t.Errorf("expected site_id, name, count and price keys, got %v", keys)
+	}
+	if extent != uint64(tileExtent) {
+		t.Errorf("unexpected extent: %d", extent)
+	}
+}
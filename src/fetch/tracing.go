@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName  string = "github.com/connorturlan/petrol-price-api/fetch"
+	serviceName string = "petrol-price-fetch"
+)
+
+var (
+	isTracingEnabled bool   = os.Getenv("tracing_enabled") == "true"
+	otlpEndpoint     string = os.Getenv("otlp_endpoint")
+)
+
+// tracer is a noop until initTracing is called, so spans cost nothing while tracing is off.
+var (
+	tracer         trace.Tracer = noop.NewTracerProvider().Tracer(tracerName)
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// newTracerProvider returns a provider that batches spans to the otlp/http collector at endpoint,
+// which is the full url of its traces path, e.g. http://localhost:4318/v1/traces.
+func newTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	options := []otlptracehttp.Option{}
+	if endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	), nil
+}
+
+// initTracing starts exporting spans if the tracing_enabled env var is set.
+func initTracing(ctx context.Context) error {
+	if !isTracingEnabled {
+		return nil
+	}
+
+	provider, err := newTracerProvider(ctx, otlpEndpoint)
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = provider
+	tracer = provider.Tracer(tracerName)
+	logger.Info("tracing enabled", "endpoint", otlpEndpoint)
+	return nil
+}
+
+// flushTraces exports the invocation's spans before lambda freezes the process.
+func flushTraces(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+
+	err := tracerProvider.ForceFlush(ctx)
+	if err != nil {
+		logger.Error("error while flushing traces", "error", err)
+	}
+}
+
+// startRequestSpan starts the server span for a request, continuing the caller's trace if it sent
+// a traceparent header.
+func startRequestSpan(ctx context.Context, request events.APIGatewayProxyRequest) (context.Context, trace.Span) {
+	headers := http.Header{}
+	for key, value := range request.Headers {
+		headers.Set(key, value)
+	}
+	ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(headers))
+
+	return tracer.Start(ctx, requestRoute(request),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(request.HTTPMethod),
+			semconv.HTTPRoute(requestResource(request)),
+			semconv.URLPath(request.Path),
+		),
+	)
+}
+
+// endRequestSpan records the outcome of the request on its span and ends it.
+func endRequestSpan(span trace.Span, res events.APIGatewayProxyResponse, err error) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if res.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	span.End()
+}
+
+// startSpan starts an internal span for a step of handling a request.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it as failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startDynamoSpan is attached to the dynamodb client, and starts a client span for every operation
+// under the span in the request's context.
+func startDynamoSpan(r *request.Request) {
+	ctx, _ := tracer.Start(r.Context(), "DynamoDB."+r.Operation.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemDynamoDB,
+			semconv.RPCSystemKey.String("aws-api"),
+			semconv.RPCService("DynamoDB"),
+			semconv.RPCMethod(r.Operation.Name),
+		),
+	)
+	r.SetContext(ctx)
+}
+
+// endDynamoSpan ends the span started by startDynamoSpan once the operation has completed.
+func endDynamoSpan(r *request.Request) {
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.Int("aws.retries", r.RetryCount))
+	if r.HTTPResponse != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(r.HTTPResponse.StatusCode))
+	}
+	if r.RequestID != "" {
+		span.SetAttributes(attribute.String("aws.request_id", r.RequestID))
+	}
+	endSpan(span, r.Error)
+}
+
+// instrumentClient attaches the metrics and tracing handlers to a dynamodb client.
+func instrumentClient(client *dynamodb.DynamoDB) *dynamodb.DynamoDB {
+	client.Handlers.Validate.PushFront(startDynamoSpan)
+	client.Handlers.Complete.PushBack(recordDynamoMetrics)
+	client.Handlers.Complete.PushBack(endDynamoSpan)
+	return client
+}
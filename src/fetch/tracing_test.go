@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testCollector is an in-process otlp/http collector that keeps every span it receives.
+type testCollector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var export collectortrace.ExportTraceServiceRequest
+	err = proto.Unmarshal(body, &export)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resourceSpans := range export.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+
+	res, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(res)
+}
+
+func (c *testCollector) Span(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// withTestCollector points the tracer at an in-process collector for the length of the test.
+func withTestCollector(t *testing.T) *testCollector {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	t.Cleanup(server.Close)
+
+	provider, err := newTracerProvider(context.Background(), server.URL+"/v1/traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousProvider, previousTracer := tracerProvider, tracer
+	tracerProvider, tracer = provider, provider.Tracer(tracerName)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		tracerProvider, tracer = previousProvider, previousTracer
+	})
+
+	return collector
+}
+
+func TestRequestSpan(t *testing.T) {
+	collector := withTestCollector(t)
+
+	res, err := invoke(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodOptions,
+		Resource:   "/tiles/{proxy+}",
+		Path:       "/tiles/12/3614/2458",
+		Headers: map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status code 200, got %d", res.StatusCode)
+	}
+
+	span := collector.Span("OPTIONS /tiles/{proxy+}")
+	if span == nil {
+		t.Fatal("expected a span named for the request's route")
+	}
+	for _, attr := range span.Attributes {
+		if attr.Key == "http.route" && attr.Value.GetStringValue() != "/tiles/{proxy+}" {
+			t.Errorf("expected the resource as the route, got %s", attr.Value.GetStringValue())
+		}
+	}
+	if span.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("expected a server span, got %s", span.Kind)
+	}
+	if traceId := string(span.TraceId); traceId != string([]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}) {
+		t.Errorf("expected the span to continue the caller's trace, got %x", span.TraceId)
+	}
+}
+
+func TestDynamoSpan(t *testing.T) {
+	collector := withTestCollector(t)
+
+	// a dynamodb endpoint that fails every request.
+	dynamo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"__type":"InternalServerError","message":"unavailable"}`, http.StatusInternalServerError)
+	}))
+	defer dynamo.Close()
+
+	session, err := session.NewSession(aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(dynamo.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithMaxRetries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := instrumentClient(dynamodb.New(session))
+
+	ctx, span := startSpan(context.Background(), "test")
+	if checkTableExists(ctx, client, pricesTableName) {
+		t.Error("expected the table check to fail")
+	}
+	span.End()
+	flushTraces(context.Background())
+
+	parent := collector.Span("checkTableExists")
+	if parent == nil {
+		t.Fatal("expected a span for the table check")
+	}
+
+	operation := collector.Span("DynamoDB.ListTables")
+	if operation == nil {
+		t.Fatal("expected a span for the dynamodb operation")
+	}
+	if string(operation.ParentSpanId) != string(parent.SpanId) {
+		t.Error("expected the operation span to be a child of the table check")
+	}
+	if operation.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("expected the operation span to be an error, got %s", operation.Status.Code)
+	}
+}
@@ -43,6 +43,7 @@ type SA_PetrolStationSite struct {
 	BrandID       int     `json:"B"`
 	Postcode      string  `json:"P"`
 	GooglePlaceID string  `json:"GPI"`
+	RegionID      int     `json:"G2"`
 	Latitude      float64 `json:"Lat"`
 	Longitude     float64 `json:"Lng"`
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -183,8 +184,57 @@ func (p *FuelPriceList) Unmarshal(records []map[string]*dynamodb.AttributeValue)
 		}
 
 		p.Sites[site.SiteID] = site
+	}
+
+	return nil
+}
 
-		fmt.Println(site)
+// SA_PetrolStationSite.Unmarshal reads a site record from the sites table.
+func (site *SA_PetrolStationSite) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	siteIdRecord, ok := record["SiteId"]
+	if !ok {
+		return errors.New("site record is missing SiteId")
+	}
+	site.SiteID, err = strconv.Atoi(*siteIdRecord.N)
+	if err != nil {
+		return err
+	}
+
+	if addressRecord, ok := record["A"]; ok {
+		site.Address = *addressRecord.S
+	}
+	if nameRecord, ok := record["N"]; ok {
+		site.Name = *nameRecord.S
+	}
+	if brandRecord, ok := record["B"]; ok {
+		site.BrandID, err = strconv.Atoi(*brandRecord.N)
+		if err != nil {
+			return err
+		}
+	}
+	if postcodeRecord, ok := record["P"]; ok {
+		site.Postcode = *postcodeRecord.S
+	}
+	if googlePlaceRecord, ok := record["G"]; ok {
+		site.GooglePlaceID = *googlePlaceRecord.S
+	}
+	if regionRecord, ok := record["R"]; ok {
+		site.RegionID, err = strconv.Atoi(*regionRecord.N)
+		if err != nil {
+			return err
+		}
+	}
+	if latRecord, ok := record["Lt"]; ok {
+		site.Latitude, err = strconv.ParseFloat(*latRecord.N, 64)
+		if err != nil {
+			return err
+		}
+	}
+	if lngRecord, ok := record["Lg"]; ok {
+		site.Longitude, err = strconv.ParseFloat(*lngRecord.N, 64)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
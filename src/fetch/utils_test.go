@@ -374,3 +374,36 @@ func TestFuelPriceUnmarshalling(t *testing.T) {
 // 		t.Errorf("expected length of FuelTypes to be 1, got %d", len(vM))
 // 	}
 // }
+
+func TestSiteUnmarshalling(t *testing.T) {
+	var site SA_PetrolStationSite
+	err := site.Unmarshal(map[string]*dynamodb.AttributeValue{
+		"SiteId": {N: aws.String("61577372")},
+		"N":      {S: aws.String("Station")},
+		"A":      {S: aws.String("1 Main St")},
+		"B":      {N: aws.String("5")},
+		"P":      {S: aws.String("5000")},
+		"G":      {S: aws.String("abc")},
+		"R":      {N: aws.String("12")},
+		"Lt":     {N: aws.String("-34.9")},
+		"Lg":     {N: aws.String("138.6")},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if site.SiteID != 61577372 || site.Name != "Station" || site.Address != "1 Main St" {
+		t.Errorf("unexpected site: %+v", site)
+	}
+	if site.BrandID != 5 || site.Postcode != "5000" || site.GooglePlaceID != "abc" || site.RegionID != 12 {
+		t.Errorf("unexpected site: %+v", site)
+	}
+	if site.Latitude != -34.9 || site.Longitude != 138.6 {
+		t.Errorf("unexpected location: %f, %f", site.Latitude, site.Longitude)
+	}
+
+	err = site.Unmarshal(map[string]*dynamodb.AttributeValue{})
+	if err == nil {
+		t.Error("expected an error for a record without a SiteId")
+	}
+}
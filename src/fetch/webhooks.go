@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	webhooksTableName    string = "webhooks"
+	deliveriesTableName  string = "webhook_deliveries"
+	webhooksPath         string = "/webhooks"
+	webhooksPathPrefix   string = "/webhooks/"
+	deliveriesPathSuffix string = "/deliveries"
+
+	maxWebhooksPerOwner int = 10
+
+	defaultDeliveriesLimit int = 20
+	maxDeliveriesLimit     int = 100
+)
+
+// webhookEvents are the events a webhook can subscribe to, "*" subscribing it to all of them.
+var webhookEvents = []string{"price.changed", "site.added", "site.removed", "update.completed", "*"}
+
+// blockedPrefixes are the address ranges net.IP doesn't already classify that webhooks mustn't be
+// delivered to: "this network", carrier-grade nat, ietf protocol assignments and benchmarking.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// lookupHost resolves a webhook's host, it is swapped out by tests.
+var lookupHost func(ctx context.Context, host string) ([]net.IPAddr, error) = net.DefaultResolver.LookupIPAddr
+
+// isPublicAddress reports whether the ip is on the public internet, rather than being a private,
+// loopback, link-local or otherwise internal address.
+func isPublicAddress(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookHost resolves the host and checks every address it resolves to is public, so
+// deliveries can't be pointed at the network the lambdas run in.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := lookupHost(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("Url host %q could not be resolved.", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicAddress(addr.IP) {
+			return fmt.Errorf("Url host %q must not resolve to a private address.", host)
+		}
+	}
+	return nil
+}
+
+// Webhook is a consumer's registration for events, delivered by the update lambda. the secret
+// signs the deliveries, and is only returned when the webhook is registered.
+type Webhook struct {
+	Owner     string    `json:"-"`
+	WebhookId string    `json:"WebhookId"`
+	Url       string    `json:"Url"`
+	Events    []string  `json:"Events"`
+	Secret    string    `json:"Secret,omitempty"`
+	CreatedAt time.Time `json:"CreatedAt"`
+}
+
+// WebhookDelivery is an entry of a webhook's delivery log, written by the update lambda.
+type WebhookDelivery struct {
+	DeliveryId     string    `json:"DeliveryId"`
+	EventId        string    `json:"EventId"`
+	EventType      string    `json:"EventType"`
+	Status         string    `json:"Status"`
+	Attempts       int       `json:"Attempts"`
+	ResponseStatus int       `json:"ResponseStatus"`
+	Error          string    `json:"Error,omitempty"`
+	DeliveredAt    time.Time `json:"DeliveredAt"`
+	DurationMs     int64     `json:"DurationMs"`
+}
+
+// Webhook.Validate checks the webhook has a public url deliveries can be posted to and only known
+// events. plain http and private urls are only allowed when running locally, so deliveries can go
+// to a local receiver.
+func (hook *Webhook) Validate(ctx context.Context) error {
+	parsed, err := url.Parse(hook.Url)
+	if err != nil || parsed.Hostname() == "" || (parsed.Scheme != "https" && !(isLocal && parsed.Scheme == "http")) {
+		return errors.New("Url must be an absolute https url.")
+	}
+
+	if len(hook.Events) == 0 {
+		return errors.New("at least one of Events is required.")
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q, Events must be from %s.", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	slices.Sort(hook.Events)
+	hook.Events = slices.Compact(hook.Events)
+
+	if isLocal {
+		return nil
+	}
+	return checkWebhookHost(ctx, parsed.Hostname())
+}
+
+// Webhook.Marshal returns a dynamodb representation of the Webhook struct.
+func (hook Webhook) Marshal() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Owner":     {S: aws.String(hook.Owner)},
+		"WebhookId": {S: aws.String(hook.WebhookId)},
+		"Url":       {S: aws.String(hook.Url)},
+		"Events":    {SS: aws.StringSlice(hook.Events)},
+		"Secret":    {S: aws.String(hook.Secret)},
+		"CreatedAt": {S: aws.String(hook.CreatedAt.UTC().Format(time.RFC3339))},
+	}
+}
+
+// Webhook.Unmarshal reads a webhook from dynamodb, leaving out its secret.
+func (hook *Webhook) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	webhookIdRecord, ok := record["WebhookId"]
+	if !ok {
+		return errors.New("webhook record is missing WebhookId")
+	}
+	hook.WebhookId = aws.StringValue(webhookIdRecord.S)
+	hook.Owner = aws.StringValue(record["Owner"].S)
+
+	if urlRecord, ok := record["Url"]; ok {
+		hook.Url = aws.StringValue(urlRecord.S)
+	}
+	hook.Events = []string{}
+	if eventsRecord, ok := record["Events"]; ok {
+		hook.Events = aws.StringValueSlice(eventsRecord.SS)
+	}
+	if createdRecord, ok := record["CreatedAt"]; ok {
+		hook.CreatedAt, err = time.Parse(time.RFC3339, aws.StringValue(createdRecord.S))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (delivery *WebhookDelivery) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	deliveryIdRecord, ok := record["DeliveryId"]
+	if !ok {
+		return errors.New("delivery record is missing DeliveryId")
+	}
+	delivery.DeliveryId = aws.StringValue(deliveryIdRecord.S)
+
+	strs := map[string]*string{"EventId": &delivery.EventId, "EventType": &delivery.EventType, "Status": &delivery.Status, "Error": &delivery.Error}
+	for name, value := range strs {
+		if strRecord, ok := record[name]; ok {
+			*value = aws.StringValue(strRecord.S)
+		}
+	}
+
+	ints := map[string]*int{"Attempts": &delivery.Attempts, "ResponseStatus": &delivery.ResponseStatus}
+	for name, value := range ints {
+		if numRecord, ok := record[name]; ok {
+			*value, err = strconv.Atoi(aws.StringValue(numRecord.N))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if durationRecord, ok := record["DurationMs"]; ok {
+		delivery.DurationMs, err = strconv.ParseInt(aws.StringValue(durationRecord.N), 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+	if deliveredRecord, ok := record["DeliveredAt"]; ok {
+		delivery.DeliveredAt, err = time.Parse(time.RFC3339, aws.StringValue(deliveredRecord.S))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseWebhookPath returns the webhook id of a /webhooks/{id} path, and whether it is the path of
+// the webhook's deliveries.
+func parseWebhookPath(path string) (string, bool, bool) {
+	rest := strings.TrimPrefix(path, webhooksPathPrefix)
+	if rest == path {
+		return "", false, false
+	}
+
+	webhookId, deliveries := strings.CutSuffix(rest, deliveriesPathSuffix)
+	if webhookId == "" || strings.Contains(webhookId, "/") {
+		return "", false, false
+	}
+	return webhookId, deliveries, true
+}
+
+// registerWebhook registers the webhook in the request body for the caller, returning it with the
+// secret its deliveries will be signed with.
+func registerWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var hook Webhook
+	err := json.Unmarshal([]byte(request.Body), &hook)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: "invalid webhook json."}, nil
+	}
+	err = hook.Validate(ctx)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+
+	client := getClient()
+	hook.Owner = alertOwner(request)
+	existing, err := queryOwnerItems(ctx, client, webhooksTableName, hook.Owner, 0, false)
+	if err != nil {
+		return respondWithStdErr(err, "error while reading webhooks.")
+	}
+	if len(existing) >= maxWebhooksPerOwner {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusConflict,
+			Body:       fmt.Sprintf("at most %d webhooks can be registered.", maxWebhooksPerOwner),
+		}, nil
+	}
+
+	hook.WebhookId, err = newAlertId()
+	if err != nil {
+		return respondWithStdErr(err, "error while creating webhook id.")
+	}
+	hook.Secret, err = newAlertId()
+	if err != nil {
+		return respondWithStdErr(err, "error while creating webhook secret.")
+	}
+	hook.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	_, err = client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(webhooksTableName),
+		Item:      hook.Marshal(),
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while saving webhook.")
+	}
+
+	logger.Info("webhook registered", "webhook_id", hook.WebhookId, "events", strings.Join(hook.Events, ","))
+	return respondWithJSON(http.StatusCreated, hook)
+}
+
+// listWebhooks returns the caller's webhooks, without their secrets.
+func listWebhooks(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	records, err := queryOwnerItems(ctx, getClient(), webhooksTableName, alertOwner(request), 0, false)
+	if err != nil {
+		return respondWithStdErr(err, "error while reading webhooks.")
+	}
+
+	hooks := []Webhook{}
+	for _, record := range records {
+		var hook Webhook
+		err = hook.Unmarshal(record)
+		if err != nil {
+			return respondWithStdErr(err, "error while unmarshalling webhooks.")
+		}
+		hooks = append(hooks, hook)
+	}
+	return respondWithJSON(http.StatusOK, hooks)
+}
+
+// listWebhookDeliveries returns the delivery log of one of the caller's webhooks, newest first.
+func listWebhookDeliveries(ctx context.Context, request events.APIGatewayProxyRequest, webhookId string) (events.APIGatewayProxyResponse, error) {
+	limit := defaultDeliveriesLimit
+	if rawLimit := request.QueryStringParameters["limit"]; rawLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			return events.APIGatewayProxyResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       fmt.Sprintf("limit must be an integer from 1 to %d.", maxDeliveriesLimit),
+			}, nil
+		}
+	}
+
+	// the delivery log is keyed by webhook, so check the webhook is the caller's first.
+	client := getClient()
+	res, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(webhooksTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(alertOwner(request))},
+			"WebhookId": {S: aws.String(webhookId)},
+		},
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while reading webhook.")
+	}
+	if len(res.Item) == 0 {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound, Body: "webhook not found."}, nil
+	}
+
+	deliveriesRes, err := client.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(deliveriesTableName),
+		KeyConditionExpression: aws.String("WebhookId = :webhookId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":webhookId": {S: aws.String(webhookId)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while reading webhook deliveries.")
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, record := range deliveriesRes.Items {
+		var delivery WebhookDelivery
+		err = delivery.Unmarshal(record)
+		if err != nil {
+			return respondWithStdErr(err, "error while unmarshalling webhook deliveries.")
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return respondWithJSON(http.StatusOK, deliveries)
+}
+
+// deleteWebhook removes the caller's webhook in the path. events already being delivered by a
+// running update may still arrive.
+func deleteWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	webhookId, deliveries, ok := parseWebhookPath(request.Path)
+	if !ok || deliveries {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound, Body: "webhook not found."}, nil
+	}
+
+	res, err := getClient().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(webhooksTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(alertOwner(request))},
+			"WebhookId": {S: aws.String(webhookId)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return respondWithStdErr(err, "error while deleting webhook.")
+	}
+	if len(res.Attributes) == 0 {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound, Body: "webhook not found."}, nil
+	}
+
+	logger.Info("webhook deleted", "webhook_id", webhookId)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusNoContent}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// withTestResolver resolves hosts from the map for the test, and ip addresses as themselves.
+func withTestResolver(t *testing.T, hosts map[string][]string) {
+	previous := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IPAddr{{IP: ip}}, nil
+		}
+		addrs := []net.IPAddr{}
+		for _, addr := range hosts[host] {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		if len(addrs) == 0 {
+			return nil, errors.New("no such host")
+		}
+		return addrs, nil
+	}
+	t.Cleanup(func() { lookupHost = previous })
+}
+
+func TestWebhookValidate(t *testing.T) {
+	withTestResolver(t, map[string][]string{
+		"example.com":         {"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"},
+		"internal.example":    {"10.0.12.7"},
+		"rebind.example":      {"93.184.215.14", "127.0.0.1"},
+		"metadata.example":    {"169.254.169.254"},
+		"carrier-nat.example": {"100.64.3.2"},
+	})
+
+	hook := Webhook{Url: "https://example.com/hook", Events: []string{"site.removed", "price.changed", "site.removed"}}
+	if err := hook.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.Events) != 2 || hook.Events[0] != "price.changed" || hook.Events[1] != "site.removed" {
+		t.Errorf("expected the events to be sorted and deduplicated, got %v", hook.Events)
+	}
+
+	tests := []struct {
+		name string
+		hook Webhook
+	}{
+		{"no url", Webhook{Events: []string{"*"}}},
+		{"relative url", Webhook{Url: "/hook", Events: []string{"*"}}},
+		{"plain http", Webhook{Url: "http://example.com/hook", Events: []string{"*"}}},
+		{"no events", Webhook{Url: "https://example.com/hook"}},
+		{"unknown event", Webhook{Url: "https://example.com/hook", Events: []string{"price.dropped"}}},
+		{"unresolvable host", Webhook{Url: "https://missing.example/hook", Events: []string{"*"}}},
+		{"private host", Webhook{Url: "https://internal.example/hook", Events: []string{"*"}}},
+		{"partly private host", Webhook{Url: "https://rebind.example/hook", Events: []string{"*"}}},
+		{"link-local host", Webhook{Url: "https://metadata.example/latest/meta-data", Events: []string{"*"}}},
+		{"carrier-grade nat host", Webhook{Url: "https://carrier-nat.example/hook", Events: []string{"*"}}},
+		{"loopback address", Webhook{Url: "https://127.0.0.1:8443/hook", Events: []string{"*"}}},
+		{"private address", Webhook{Url: "https://192.168.1.20/hook", Events: []string{"*"}}},
+		{"ipv6 loopback", Webhook{Url: "https://[::1]/hook", Events: []string{"*"}}},
+		{"ipv4 mapped address", Webhook{Url: "https://[::ffff:10.0.0.1]/hook", Events: []string{"*"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.hook.Validate(context.Background()); err == nil {
+				t.Error("expected the webhook to be rejected")
+			}
+		})
+	}
+}
+
+func TestWebhookMarshalling(t *testing.T) {
+	hook := Webhook{
+		Owner:     "owner",
+		WebhookId: "hook",
+		Url:       "https://example.com/hook",
+		Events:    []string{"*"},
+		Secret:    "secret",
+		CreatedAt: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC),
+	}
+
+	item := hook.Marshal()
+	if *item["Secret"].S != "secret" {
+		t.Errorf("expected the secret to be stored, got %v", item)
+	}
+
+	var decoded Webhook
+	err := decoded.Unmarshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Owner != hook.Owner || decoded.Url != hook.Url || len(decoded.Events) != 1 || !decoded.CreatedAt.Equal(hook.CreatedAt) {
+		t.Errorf("expected %+v, got %+v", hook, decoded)
+	}
+	if decoded.Secret != "" {
+		t.Error("expected the secret not to be read back")
+	}
+}
+
+func TestParseWebhookPath(t *testing.T) {
+	tests := []struct {
+		path       string
+		webhookId  string
+		deliveries bool
+		ok         bool
+	}{
+		{"/webhooks/abc", "abc", false, true},
+		{"/webhooks/abc/deliveries", "abc", true, true},
+		{"/webhooks/", "", false, false},
+		{"/webhooks/abc/other", "", false, false},
+		{"/webhooks", "", false, false},
+	}
+	for _, test := range tests {
+		webhookId, deliveries, ok := parseWebhookPath(test.path)
+		if webhookId != test.webhookId || deliveries != test.deliveries || ok != test.ok {
+			t.Errorf("%s: expected (%q, %t, %t), got (%q, %t, %t)", test.path, test.webhookId, test.deliveries, test.ok, webhookId, deliveries, ok)
+		}
+	}
+
+	res, err := deleteWebhook(context.Background(), events.APIGatewayProxyRequest{Path: "/webhooks/abc/deliveries"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected deleting the deliveries path to be not found, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const redacted string = "[REDACTED]"
+
+// sensitiveKeys are the attribute keys that are never logged, matched case insensitively anywhere
+// in the key.
+var sensitiveKeys = []string{"apikey", "api_key", "api-key", "authorization", "secret", "signature", "password", "token"}
+
+// baseLogger logs json to stdout at the level set by the log_level env var. logger is a copy of it
+// scoped to the current invocation, lambda only runs one invocation at a time per process so it
+// is safe to swap out at the start of each one.
+var (
+	baseLogger *slog.Logger = newLogger(os.Stdout, os.Getenv("log_level"), apikey)
+	logger     *slog.Logger = baseLogger
+)
+
+// parseLogLevel reads debug, info, warn or error, defaulting to info.
+func parseLogLevel(level string) slog.Level {
+	var parsed slog.Level
+	err := parsed.UnmarshalText([]byte(level))
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitiveKey := range sensitiveKeys {
+		if strings.Contains(key, sensitiveKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets replaces any of the secret values that turn up in the string.
+func redactSecrets(value string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			value = strings.ReplaceAll(value, secret, redacted)
+		}
+	}
+	return value
+}
+
+// newLogger returns a json logger that redacts sensitive attributes, and any of the secrets
+// wherever they appear in a message, value or error.
+func newLogger(w io.Writer, level string, secrets ...string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: parseLogLevel(level),
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if isSensitiveKey(attr.Key) {
+				return slog.String(attr.Key, redacted)
+			}
+
+			switch attr.Value.Kind() {
+			case slog.KindString:
+				attr.Value = slog.StringValue(redactSecrets(attr.Value.String(), secrets))
+			case slog.KindAny:
+				if err, ok := attr.Value.Any().(error); ok {
+					attr.Value = slog.StringValue(redactSecrets(err.Error(), secrets))
+				}
+			}
+			return attr
+		},
+	}))
+}
+
+// headersAttr logs the headers as a group, so sensitive headers are redacted by key.
+func headersAttr(headers map[string]string) slog.Attr {
+	attrs := []any{}
+	for key, value := range headers {
+		attrs = append(attrs, slog.String(key, value))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// beginRequest scopes the logger to the invocation, and returns a func that logs the outcome and
+// how long it took.
+func beginRequest(ctx context.Context, request events.APIGatewayProxyRequest) func(res events.APIGatewayProxyResponse, err error) {
+	requestId := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestId = lc.AwsRequestID
+	}
+
+	logger = baseLogger.With(
+		"request_id", requestId,
+		"api_request_id", request.RequestContext.RequestID,
+		"route", request.HTTPMethod+" "+request.Path,
+	)
+	logger.Debug("request started", headersAttr(request.Headers))
+
+	started := time.Now()
+	return func(res events.APIGatewayProxyResponse, err error) {
+		attrs := []any{
+			"status", res.StatusCode,
+			"duration_ms", time.Since(started).Milliseconds(),
+			"response_bytes", len(res.Body),
+		}
+		if err != nil {
+			logger.Error("request failed", append(attrs, "error", err)...)
+			return
+		}
+		logger.Info("request finished", attrs...)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
 const (
@@ -21,9 +26,25 @@ const (
 var (
 	isLocal         bool   = os.Getenv("local") == "true"
 	isUpdatingSites bool   = os.Getenv("update_sites") == "true"
+	isAuthRequired  bool   = os.Getenv("require_api_key") != "false"
 	apikey          string = os.Getenv("api_key")
 )
 
+func getClient() *dynamodb.DynamoDB {
+	config := aws.NewConfig().WithRegion(region)
+	if isLocal {
+		logger.Debug("using local endpoint")
+		config = config.WithEndpoint("http://dynamodb-local:8000")
+	}
+
+	session, err := session.NewSession()
+	if err != nil {
+		return nil
+	}
+
+	return dynamodb.New(session, config)
+}
+
 func respondWithStdErr(err error, errstring string) (events.APIGatewayProxyResponse, error) {
 	if err == nil {
 		return events.APIGatewayProxyResponse{
@@ -49,7 +70,7 @@ func handleCors(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRe
 	}, nil
 }
 
-func handleGet(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handleGet(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// check the path and route based on that.
 	// switch request.Path {
 	// case "/prices":
@@ -63,11 +84,11 @@ func handleGet(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 	return respondWithStdErr(nil, "")
 }
 
-func handlePost(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handlePost(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	return respondWithStdErr(nil, "invalid path.")
 }
 
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var res events.APIGatewayProxyResponse
 	var err error
 
@@ -81,9 +102,9 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return handleCors(request)
 
 	case http.MethodGet:
-		res, err = handleGet(request)
+		res, err = withAPIKey(ctx, request, handleGet)
 	case http.MethodPost:
-		res, err = handlePost(request)
+		res, err = withAPIKey(ctx, request, handlePost)
 	}
 
 	res.Headers =
@@ -95,8 +116,16 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	return res, err
 }
 
+// invoke is the lambda entrypoint, it scopes the logger to the request before handling it.
+func invoke(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	finish := beginRequest(ctx, request)
+	res, err := handler(ctx, request)
+	finish(res, err)
+	return res, err
+}
+
 func main() {
-	lambda.Start(handler)
+	lambda.Start(invoke)
 }
 
 //{"CollectionMethod":{"S":"T"},"FuelId":{"N":"2"},"Price":{"N":"2799"},"SiteId":{"N":"61577372"},"TransactionDateUtc":{"S":"2023-10-27T05:11:11.663"}}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	timestampHeader string        = "x-update-timestamp"
+	signatureHeader string        = "x-update-signature"
+	maxSignatureAge time.Duration = 5 * time.Minute
+)
+
+// getHeader returns a header value regardless of the casing api gateway passed it through with.
+func getHeader(request events.APIGatewayProxyRequest, name string) string {
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// signUpdateRequest returns the hex encoded hmac-sha256 of the timestamp, method and path,
+// a manual trigger has to send this in the signature header.
+func signUpdateRequest(secret string, timestamp string, method string, path string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s\n%s\n%s", timestamp, method, path)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyUpdateRequest checks a manual trigger was signed with the admin secret in the last few minutes.
+func verifyUpdateRequest(request events.APIGatewayProxyRequest, secret string, now time.Time) error {
+	if secret == "" {
+		return errors.New("manual updates are disabled.")
+	}
+
+	timestamp := getHeader(request, timestampHeader)
+	signature := getHeader(request, signatureHeader)
+	if timestamp == "" || signature == "" {
+		return errors.New("missing request signature.")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid request timestamp.")
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return errors.New("request signature has expired.")
+	}
+
+	expected := signUpdateRequest(secret, timestamp, request.HTTPMethod, request.Path)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return errors.New("invalid request signature.")
+	}
+
+	return nil
+}
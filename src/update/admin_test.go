@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func signedRequest(secret string, path string, now time.Time) events.APIGatewayProxyRequest {
+	timestamp := fmt.Sprintf("%d", now.Unix())
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodGet,
+		Path:       path,
+		Headers: map[string]string{
+			"X-Update-Timestamp": timestamp,
+			"X-Update-Signature": signUpdateRequest(secret, timestamp, http.MethodGet, path),
+		},
+	}
+}
+
+// withAdminSecret sets the admin secret for the test.
+func withAdminSecret(t *testing.T, secret string) {
+	previous := adminSecret
+	adminSecret = secret
+	t.Cleanup(func() { adminSecret = previous })
+}
+
+func TestVerifyUpdateRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	err := verifyUpdateRequest(signedRequest("secret", "/update", now), "secret", now)
+	if err != nil {
+		t.Errorf("expected signed request to verify, got %v", err)
+	}
+
+	err = verifyUpdateRequest(signedRequest("secret", "/update", now), "", now)
+	if err == nil {
+		t.Error("expected manual updates to be rejected without a secret")
+	}
+
+	err = verifyUpdateRequest(signedRequest("other", "/update", now), "secret", now)
+	if err == nil {
+		t.Error("expected request signed with the wrong secret to be rejected")
+	}
+
+	err = verifyUpdateRequest(signedRequest("secret", "/update", now), "secret", now.Add(10*time.Minute))
+	if err == nil {
+		t.Error("expected an old signature to be rejected")
+	}
+
+	request := signedRequest("secret", "/update", now)
+	request.Path = "/prices"
+	err = verifyUpdateRequest(request, "secret", now)
+	if err == nil {
+		t.Error("expected a signature for a different path to be rejected")
+	}
+
+	err = verifyUpdateRequest(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/update"}, "secret", now)
+	if err == nil {
+		t.Error("expected an unsigned request to be rejected")
+	}
+}
+
+func TestUnsignedUpdateIsRejected(t *testing.T) {
+	withAdminSecret(t, "secret")
+
+	for _, path := range []string{"/update", "/update/status", "/update/runs"} {
+		res, err := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: path})
+		if err != nil {
+			t.Error(err)
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status code 401 from %s, got %d", path, res.StatusCode)
+		}
+	}
+
+	// a signature for one route doesn't open the others.
+	request := signedRequest("secret", "/update", time.Now())
+	request.Path = "/update/runs"
+	res, _ := handler(context.Background(), request)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status code 401, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	alertsTableName    string = "alert_subscriptions"
+	triggeredTableName string = "triggered_alerts"
+
+	earthRadiusKm float64 = 6371
+)
+
+// AlertSubscription asks to be alerted when a station sells a fuel type below the threshold, as
+// subscribed to through the fetch lambda. LastFingerprint is the matches of the last time it
+// triggered, so the same prices don't trigger it twice.
+type AlertSubscription struct {
+	Owner           string
+	AlertId         string
+	FuelId          int
+	Threshold       int
+	SiteIds         []int
+	Latitude        *float64
+	Longitude       *float64
+	RadiusKm        float64
+	CooldownMinutes int
+	LastTriggeredAt *time.Time
+	LastFingerprint string
+}
+
+// AlertMatch is a station that was below the threshold when an alert triggered.
+type AlertMatch struct {
+	SiteId             int
+	Price              int
+	TransactionDateUTC string
+}
+
+// TriggeredAlert is the record of a subscription triggering.
+type TriggeredAlert struct {
+	Owner       string
+	AlertId     string
+	FuelId      int
+	Threshold   int
+	TriggeredAt time.Time
+	Matches     []AlertMatch
+}
+
+// AlertStats counts what happened to the subscriptions in an evaluation. Failed are the ones
+// that couldn't be read or recorded.
+type AlertStats struct {
+	Evaluated  int
+	Triggered  int
+	Duplicates int
+	CoolingOff int
+	Failed     int
+}
+
+func (sub *AlertSubscription) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	alertIdRecord, ok := record["AlertId"]
+	if !ok {
+		return errors.New("alert record is missing AlertId")
+	}
+	sub.AlertId = aws.StringValue(alertIdRecord.S)
+	if ownerRecord, ok := record["Owner"]; ok {
+		sub.Owner = aws.StringValue(ownerRecord.S)
+	}
+
+	ints := map[string]*int{"FuelId": &sub.FuelId, "Threshold": &sub.Threshold, "CooldownMinutes": &sub.CooldownMinutes}
+	for name, value := range ints {
+		if numRecord, ok := record[name]; ok {
+			*value, err = strconv.Atoi(aws.StringValue(numRecord.N))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sub.SiteIds = []int{}
+	if siteIdsRecord, ok := record["SiteIds"]; ok {
+		for _, siteIdRecord := range siteIdsRecord.L {
+			siteId, err := strconv.Atoi(aws.StringValue(siteIdRecord.N))
+			if err != nil {
+				return err
+			}
+			sub.SiteIds = append(sub.SiteIds, siteId)
+		}
+	}
+
+	floats := map[string]**float64{"Lat": &sub.Latitude, "Lng": &sub.Longitude}
+	for name, value := range floats {
+		if numRecord, ok := record[name]; ok {
+			parsed, err := strconv.ParseFloat(aws.StringValue(numRecord.N), 64)
+			if err != nil {
+				return err
+			}
+			*value = &parsed
+		}
+	}
+	if radiusRecord, ok := record["RadiusKm"]; ok {
+		sub.RadiusKm, err = strconv.ParseFloat(aws.StringValue(radiusRecord.N), 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	if triggeredRecord, ok := record["LastTriggeredAt"]; ok {
+		triggeredAt, err := time.Parse(time.RFC3339, aws.StringValue(triggeredRecord.S))
+		if err != nil {
+			return err
+		}
+		sub.LastTriggeredAt = &triggeredAt
+	}
+	if fingerprintRecord, ok := record["LastFingerprint"]; ok {
+		sub.LastFingerprint = aws.StringValue(fingerprintRecord.S)
+	}
+
+	return nil
+}
+
+// TriggeredAlert.Marshal returns a dynamodb representation of the TriggeredAlert struct. the
+// TriggerId sort key orders an owner's alerts by when they triggered.
+func (alert TriggeredAlert) Marshal() map[string]*dynamodb.AttributeValue {
+	matches := []*dynamodb.AttributeValue{}
+	for _, match := range alert.Matches {
+		matches = append(matches, &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{
+			"SiteId": {N: aws.String(strconv.Itoa(match.SiteId))},
+			"P":      {N: aws.String(strconv.Itoa(match.Price))},
+			"D":      {S: aws.String(match.TransactionDateUTC)},
+		}})
+	}
+
+	triggeredAt := alert.TriggeredAt.UTC()
+	return map[string]*dynamodb.AttributeValue{
+		"Owner":       {S: aws.String(alert.Owner)},
+		"TriggerId":   {S: aws.String(triggeredAt.Format(runTimeLayout) + "#" + alert.AlertId)},
+		"AlertId":     {S: aws.String(alert.AlertId)},
+		"FuelId":      {N: aws.String(strconv.Itoa(alert.FuelId))},
+		"Threshold":   {N: aws.String(strconv.Itoa(alert.Threshold))},
+		"TriggeredAt": {S: aws.String(triggeredAt.Format(time.RFC3339))},
+		"Matches":     {L: matches},
+	}
+}
+
+// distanceKm returns the great circle distance between two locations.
+func distanceKm(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// AlertSubscription.watchedSites returns the ids of the sites the subscription covers.
+func (sub AlertSubscription) watchedSites(sites PetrolStationList) []int {
+	if sub.Latitude == nil || sub.Longitude == nil {
+		return sub.SiteIds
+	}
+
+	siteIds := []int{}
+	for _, site := range sites.Sites {
+		if distanceKm(*sub.Latitude, *sub.Longitude, site.Latitude, site.Longitude) <= sub.RadiusKm {
+			siteIds = append(siteIds, site.SiteID)
+		}
+	}
+	return siteIds
+}
+
+// alertMatches returns the watched stations selling the fuel type below the threshold, cheapest
+// first. placeholder prices never match.
+func alertMatches(sub AlertSubscription, sites PetrolStationList, prices FuelPriceList) []AlertMatch {
+	matches := []AlertMatch{}
+	for _, siteId := range sub.watchedSites(sites) {
+		price, ok := prices.Sites[siteId].FuelTypes[sub.FuelId]
+		if !ok || price.Price <= 0 || price.Price >= placeholderPrice || price.Price >= sub.Threshold {
+			continue
+		}
+		matches = append(matches, AlertMatch{SiteId: siteId, Price: price.Price, TransactionDateUTC: price.TransactionDateUTC})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Price != matches[j].Price {
+			return matches[i].Price < matches[j].Price
+		}
+		return matches[i].SiteId < matches[j].SiteId
+	})
+	return matches
+}
+
+// alertFingerprint identifies a set of matches, so an alert isn't triggered again by the same
+// prices.
+func alertFingerprint(matches []AlertMatch) string {
+	parts := []string{}
+	for _, match := range matches {
+		parts = append(parts, fmt.Sprintf("%d:%d:%s", match.SiteId, match.Price, match.TransactionDateUTC))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// evaluateAlert decides whether the subscription triggers on the latest prices. it doesn't while
+// it is cooling off from the last time it triggered, or when the matches are the same ones it
+// last triggered on.
+func evaluateAlert(sub AlertSubscription, sites PetrolStationList, prices FuelPriceList, now time.Time, stats *AlertStats) (TriggeredAlert, bool) {
+	stats.Evaluated++
+
+	matches := alertMatches(sub, sites, prices)
+	if len(matches) == 0 {
+		return TriggeredAlert{}, false
+	}
+
+	cooldown := time.Duration(sub.CooldownMinutes) * time.Minute
+	if sub.LastTriggeredAt != nil && now.Sub(*sub.LastTriggeredAt) < cooldown {
+		stats.CoolingOff++
+		return TriggeredAlert{}, false
+	}
+	if alertFingerprint(matches) == sub.LastFingerprint {
+		stats.Duplicates++
+		return TriggeredAlert{}, false
+	}
+
+	stats.Triggered++
+	return TriggeredAlert{
+		Owner:       sub.Owner,
+		AlertId:     sub.AlertId,
+		FuelId:      sub.FuelId,
+		Threshold:   sub.Threshold,
+		TriggeredAt: now,
+		Matches:     matches,
+	}, true
+}
+
+// recordTriggeredAlert writes the triggered alert and marks the subscription as triggered, the
+// subscription is left alone if it was deleted in the meantime.
+func recordTriggeredAlert(ctx context.Context, dbClient *dynamodb.DynamoDB, alert TriggeredAlert) error {
+	_, err := dbClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(triggeredTableName),
+		Item:      alert.Marshal(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = dbClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(alertsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":   {S: aws.String(alert.Owner)},
+			"AlertId": {S: aws.String(alert.AlertId)},
+		},
+		UpdateExpression:    aws.String("SET LastTriggeredAt = :triggered, LastFingerprint = :fingerprint"),
+		ConditionExpression: aws.String("attribute_exists(AlertId)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":triggered":   {S: aws.String(alert.TriggeredAt.UTC().Format(time.RFC3339))},
+			":fingerprint": {S: aws.String(alertFingerprint(alert.Matches))},
+		},
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
+
+// evaluateAlerts checks every subscription against the latest prices and records the ones that
+// trigger. there being no subscriptions table yet is the same as there being no subscriptions. a
+// subscription that can't be read or recorded is logged and skipped, so it can't hold up the rest.
+func evaluateAlerts(ctx context.Context, dbClient *dynamodb.DynamoDB, sites PetrolStationList, prices FuelPriceList, now time.Time) (stats AlertStats, err error) {
+	ctx, span := startSpan(ctx, "evaluateAlerts")
+	defer func() { endSpan(span, err) }()
+
+	records, err := scanTable(ctx, dbClient, alertsTableName)
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return stats, nil
+		}
+		return stats, err
+	}
+
+	for _, record := range records {
+		var sub AlertSubscription
+		err := sub.Unmarshal(record)
+		if err != nil {
+			logger.Error("error while reading alert subscription", "alert_id", sub.AlertId, "error", err)
+			stats.Failed++
+			continue
+		}
+
+		alert, ok := evaluateAlert(sub, sites, prices, now, &stats)
+		if !ok {
+			continue
+		}
+
+		err = recordTriggeredAlert(ctx, dbClient, alert)
+		if err != nil {
+			logger.Error("error while recording triggered alert", "alert_id", alert.AlertId, "error", err)
+			stats.Failed++
+		}
+	}
+
+	logger.Info("evaluated alerts",
+		"evaluated", stats.Evaluated,
+		"triggered", stats.Triggered,
+		"duplicates", stats.Duplicates,
+		"cooling_off", stats.CoolingOff,
+		"failed", stats.Failed,
+	)
+	return stats, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDistanceKm(t *testing.T) {
+	// adelaide to melbourne is about 650km.
+	distance := distanceKm(-34.9285, 138.6007, -37.8136, 144.9631)
+	if distance < 640 || distance > 660 {
+		t.Errorf("unexpected distance: %f", distance)
+	}
+}
+
+func TestEvaluateAlert(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	lat, lng := -34.9285, 138.6007
+
+	sites := PetrolStationList{Sites: []PetrolStationSite{
+		{SiteID: 1, Latitude: -34.93, Longitude: 138.60},
+		{SiteID: 2, Latitude: -34.95, Longitude: 138.62},
+		{SiteID: 3, Latitude: -31.95, Longitude: 115.86},
+	}}
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1850, TransactionDateUTC: "a"}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 1790, TransactionDateUTC: "b"}}},
+		3: {SiteID: 3, FuelTypes: map[int]FuelPrice{2: {Price: 1500, TransactionDateUTC: "c"}}},
+	}}
+	sub := AlertSubscription{Owner: "owner", AlertId: "alert", FuelId: 2, Threshold: 1900, Latitude: &lat, Longitude: &lng, RadiusKm: 5, CooldownMinutes: 60}
+
+	stats := AlertStats{}
+	alert, ok := evaluateAlert(sub, sites, prices, now, &stats)
+	if !ok {
+		t.Fatal("expected the alert to trigger")
+	}
+	if len(alert.Matches) != 2 || alert.Matches[0].SiteId != 2 || alert.Matches[1].SiteId != 1 {
+		t.Errorf("expected the nearby sites cheapest first, got %+v", alert.Matches)
+	}
+
+	// the same prices don't trigger it again once it has cooled off.
+	triggeredAt := now.Add(-2 * time.Hour)
+	sub.LastTriggeredAt = &triggeredAt
+	sub.LastFingerprint = alertFingerprint(alert.Matches)
+	if _, ok := evaluateAlert(sub, sites, prices, now, &stats); ok {
+		t.Error("expected the same matches not to trigger twice")
+	}
+
+	// a new price does, but not while cooling off.
+	prices.Sites[1].FuelTypes[2] = FuelPrice{Price: 1700, TransactionDateUTC: "d"}
+	triggeredAt = now.Add(-30 * time.Minute)
+	if _, ok := evaluateAlert(sub, sites, prices, now, &stats); ok {
+		t.Error("expected the alert not to trigger while cooling off")
+	}
+	triggeredAt = now.Add(-61 * time.Minute)
+	if _, ok := evaluateAlert(sub, sites, prices, now, &stats); !ok {
+		t.Error("expected a new price to trigger the alert after the cooldown")
+	}
+
+	if stats != (AlertStats{Evaluated: 4, Triggered: 2, Duplicates: 1, CoolingOff: 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// site subscriptions only watch their sites, and placeholders never match.
+	prices.Sites[3].FuelTypes[2] = FuelPrice{Price: 9999}
+	sub = AlertSubscription{FuelId: 2, Threshold: 1900, SiteIds: []int{3}}
+	if _, ok := evaluateAlert(sub, sites, prices, now, &stats); ok {
+		t.Error("expected a placeholder price not to trigger the alert")
+	}
+}
+
+func TestEvaluateAlertsSkipsBrokenSubscriptions(t *testing.T) {
+	dynamo := &testDynamo{puts: map[string]int{}, scans: map[string]string{
+		alertsTableName: `[
+			{"Owner": {"S": "owner"}, "AlertId": {"S": "broken"}, "FuelId": {"N": "two"}, "Threshold": {"N": "1900"}},
+			{"Owner": {"S": "owner"}, "AlertId": {"S": "alert"}, "FuelId": {"N": "2"}, "Threshold": {"N": "1900"}, "SiteIds": {"L": [{"N": "1"}]}}
+		]`,
+	}}
+	client := newTestDynamoClient(t, dynamo)
+
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1850, TransactionDateUTC: "a"}}},
+	}}
+	stats, err := evaluateAlerts(context.Background(), client, PetrolStationList{}, prices, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats != (AlertStats{Evaluated: 1, Triggered: 1, Failed: 1}) {
+		t.Errorf("expected the broken subscription to be skipped, got %+v", stats)
+	}
+	if dynamo.puts[triggeredTableName] != 1 || dynamo.puts[alertsTableName] != 1 {
+		t.Errorf("expected the other subscription to be recorded, got %v", dynamo.puts)
+	}
+}
+
+func TestAlertSubscriptionUnmarshal(t *testing.T) {
+	var sub AlertSubscription
+	err := sub.Unmarshal(map[string]*dynamodb.AttributeValue{
+		"Owner":           {S: aws.String("owner")},
+		"AlertId":         {S: aws.String("alert")},
+		"FuelId":          {N: aws.String("2")},
+		"Threshold":       {N: aws.String("1900")},
+		"SiteIds":         {L: []*dynamodb.AttributeValue{}},
+		"Lat":             {N: aws.String("-34.9")},
+		"Lng":             {N: aws.String("138.6")},
+		"RadiusKm":        {N: aws.String("2.5")},
+		"CooldownMinutes": {N: aws.String("60")},
+		"LastTriggeredAt": {S: aws.String("2024-05-10T12:00:00Z")},
+		"LastFingerprint": {S: aws.String("1:1850:a")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Owner != "owner" || sub.FuelId != 2 || sub.Threshold != 1900 || sub.RadiusKm != 2.5 || sub.CooldownMinutes != 60 {
+		t.Errorf("unexpected subscription: %+v", sub)
+	}
+	if sub.Latitude == nil || *sub.Latitude != -34.9 || sub.LastTriggeredAt == nil || sub.LastFingerprint != "1:1850:a" {
+		t.Errorf("unexpected subscription: %+v", sub)
+	}
+
+	item := TriggeredAlert{
+		Owner:       "owner",
+		AlertId:     "alert",
+		TriggeredAt: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC),
+		Matches:     []AlertMatch{{SiteId: 1, Price: 1850, TransactionDateUTC: "a"}},
+	}.Marshal()
+	if *item["TriggerId"].S != "2024-05-10T12:00:00.000Z#alert" || len(item["Matches"].L) != 1 {
+		t.Errorf("unexpected triggered alert: %v", item)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	runsTableName string = "update_runs"
+	runsJob       string = "update"
+
+	triggerScheduled string = "scheduled"
+	triggerManual    string = "manual"
+	triggerInvoke    string = "invoke"
+
+	runSucceeded string = "succeeded"
+	runFailed    string = "failed"
+
+	// runErrorMessage stands in for a failed run's errors when it's served, they're logged with
+	// the run id.
+	runErrorMessage string = "the run failed, see the logs of run %s."
+
+	defaultRunsLimit int = 10
+	maxRunsLimit     int = 50
+
+	// runTimeLayout is fixed width so the StartedAt sort key orders runs by time.
+	runTimeLayout string = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// UpdateRun is the audit record of a single update run.
+type UpdateRun struct {
+	RunId            string    `json:"RunId"`
+	Trigger          string    `json:"Trigger"`
+	Status           string    `json:"Status"`
+	StartedAt        time.Time `json:"StartedAt"`
+	EndedAt          time.Time `json:"EndedAt"`
+	Regions          []string  `json:"Regions"`
+	UpstreamPricesMs int64     `json:"UpstreamPricesMs"`
+	UpstreamSitesMs  int64     `json:"UpstreamSitesMs"`
+	PricesFetched    int       `json:"PricesFetched"`
+	PricesChanged    int       `json:"PricesChanged"`
+	PricesWritten    int       `json:"PricesWritten"`
+	SitesFetched     int       `json:"SitesFetched"`
+	SitesChanged     int       `json:"SitesChanged"`
+	SitesWritten     int       `json:"SitesWritten"`
+	Retries          int       `json:"Retries"`
+	Errors           []string  `json:"Errors"`
+}
+
+// UpdateStatus is the summary returned by /update/status.
+type UpdateStatus struct {
+	LatestRun           *UpdateRun `json:"LatestRun"`
+	LastSuccessfulRun   *UpdateRun `json:"LastSuccessfulRun"`
+	SecondsSinceSuccess *int64     `json:"SecondsSinceSuccess"`
+}
+
+func newUpdateRun(runId string, trigger string, now time.Time) *UpdateRun {
+	return &UpdateRun{
+		RunId:     runId,
+		Trigger:   trigger,
+		StartedAt: now.UTC(),
+		Regions:   []string{fmt.Sprintf("%d/%d/%d", countryId, geoRegionLevel, geoRegionId)},
+		Errors:    []string{},
+	}
+}
+
+// UpdateRun.Finish stamps the end of the run, and whether it succeeded.
+func (run *UpdateRun) Finish(err error, now time.Time) {
+	run.EndedAt = now.UTC()
+	run.Status = runSucceeded
+	if err != nil {
+		run.Status = runFailed
+		run.Errors = append(run.Errors, err.Error())
+	}
+}
+
+// UpdateRun.Served returns the run as it is served by /update/status and /update/runs. its errors
+// can carry upstream responses and table names, so they're swapped for a pointer to the logs.
+func (run UpdateRun) Served() UpdateRun {
+	if len(run.Errors) > 0 {
+		run.Errors = []string{fmt.Sprintf(runErrorMessage, run.RunId)}
+	}
+	return run
+}
+
+// UpdateRun.Marshal returns a dynamodb representation of the UpdateRun struct.
+func (run UpdateRun) Marshal() map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"Job":              {S: aws.String(runsJob)},
+		"StartedAt":        {S: aws.String(run.StartedAt.Format(runTimeLayout))},
+		"EndedAt":          {S: aws.String(run.EndedAt.Format(runTimeLayout))},
+		"RunId":            {S: aws.String(run.RunId)},
+		"Trigger":          {S: aws.String(run.Trigger)},
+		"Status":           {S: aws.String(run.Status)},
+		"Regions":          {L: []*dynamodb.AttributeValue{}},
+		"UpstreamPricesMs": {N: aws.String(strconv.FormatInt(run.UpstreamPricesMs, 10))},
+		"UpstreamSitesMs":  {N: aws.String(strconv.FormatInt(run.UpstreamSitesMs, 10))},
+		"PricesFetched":    {N: aws.String(strconv.Itoa(run.PricesFetched))},
+		"PricesChanged":    {N: aws.String(strconv.Itoa(run.PricesChanged))},
+		"PricesWritten":    {N: aws.String(strconv.Itoa(run.PricesWritten))},
+		"SitesFetched":     {N: aws.String(strconv.Itoa(run.SitesFetched))},
+		"SitesChanged":     {N: aws.String(strconv.Itoa(run.SitesChanged))},
+		"SitesWritten":     {N: aws.String(strconv.Itoa(run.SitesWritten))},
+		"Retries":          {N: aws.String(strconv.Itoa(run.Retries))},
+		"Errors":           {L: []*dynamodb.AttributeValue{}},
+	}
+
+	for _, region := range run.Regions {
+		item["Regions"].L = append(item["Regions"].L, &dynamodb.AttributeValue{S: aws.String(region)})
+	}
+	for _, runErr := range run.Errors {
+		item["Errors"].L = append(item["Errors"].L, &dynamodb.AttributeValue{S: aws.String(runErr)})
+	}
+
+	return item
+}
+
+func (run *UpdateRun) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	stringFields := map[string]*string{
+		"RunId":   &run.RunId,
+		"Trigger": &run.Trigger,
+		"Status":  &run.Status,
+	}
+	for name, field := range stringFields {
+		if value, ok := record[name]; ok {
+			*field = aws.StringValue(value.S)
+		}
+	}
+
+	timeFields := map[string]*time.Time{
+		"StartedAt": &run.StartedAt,
+		"EndedAt":   &run.EndedAt,
+	}
+	for name, field := range timeFields {
+		if value, ok := record[name]; ok {
+			*field, err = time.Parse(runTimeLayout, aws.StringValue(value.S))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	intFields := map[string]*int{
+		"PricesFetched": &run.PricesFetched,
+		"PricesChanged": &run.PricesChanged,
+		"PricesWritten": &run.PricesWritten,
+		"SitesFetched":  &run.SitesFetched,
+		"SitesChanged":  &run.SitesChanged,
+		"SitesWritten":  &run.SitesWritten,
+		"Retries":       &run.Retries,
+	}
+	for name, field := range intFields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.Atoi(aws.StringValue(value.N))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	int64Fields := map[string]*int64{
+		"UpstreamPricesMs": &run.UpstreamPricesMs,
+		"UpstreamSitesMs":  &run.UpstreamSitesMs,
+	}
+	for name, field := range int64Fields {
+		if value, ok := record[name]; ok {
+			*field, err = strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	listFields := map[string]*[]string{
+		"Regions": &run.Regions,
+		"Errors":  &run.Errors,
+	}
+	for name, field := range listFields {
+		*field = []string{}
+		if value, ok := record[name]; ok {
+			for _, entry := range value.L {
+				*field = append(*field, aws.StringValue(entry.S))
+			}
+		}
+	}
+
+	return nil
+}
+
+func createRunsTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new runs table")
+
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(runsTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("Job"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("StartedAt"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("Job"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("StartedAt"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(2),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return err
+		}
+	}
+
+	return client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(runsTableName),
+	})
+}
+
+func recordUpdateRun(ctx context.Context, client *dynamodb.DynamoDB, run *UpdateRun) error {
+	if !checkTableExists(ctx, client, runsTableName) {
+		err := createRunsTable(ctx, client)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(runsTableName),
+		Item:      run.Marshal(),
+	})
+	return err
+}
+
+// getLatestRuns returns up to limit runs, newest first.
+func getLatestRuns(ctx context.Context, client *dynamodb.DynamoDB, limit int) ([]UpdateRun, error) {
+	runs := []UpdateRun{}
+	if !checkTableExists(ctx, client, runsTableName) {
+		return runs, nil
+	}
+
+	res, err := client.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(runsTableName),
+		KeyConditionExpression:   aws.String("#job = :job"),
+		ExpressionAttributeNames: map[string]*string{"#job": aws.String("Job")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":job": {S: aws.String(runsJob)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range res.Items {
+		var run UpdateRun
+		err = run.Unmarshal(record)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// summariseRuns finds the latest and last successful run, from runs ordered newest first.
+func summariseRuns(runs []UpdateRun, now time.Time) UpdateStatus {
+	status := UpdateStatus{}
+	if len(runs) > 0 {
+		status.LatestRun = &runs[0]
+	}
+
+	for i, run := range runs {
+		if run.Status == runSucceeded {
+			status.LastSuccessfulRun = &runs[i]
+			age := int64(now.Sub(run.EndedAt).Seconds())
+			status.SecondsSinceSuccess = &age
+			break
+		}
+	}
+
+	return status
+}
+
+// parseRunsLimit reads the limit query param, defaulting and capping it.
+func parseRunsLimit(request events.APIGatewayProxyRequest) (int, error) {
+	rawLimit, ok := request.QueryStringParameters["limit"]
+	if !ok || rawLimit == "" {
+		return defaultRunsLimit, nil
+	}
+
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive integer.")
+	}
+	return min(limit, maxRunsLimit), nil
+}
+
+func respondWithJson(obj interface{}) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return respondWithStdErr(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Access-Control-Allow-Headers": "*",
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "OPTIONS,GET,POST",
+			"Content-Type":                 "application/json",
+		},
+		Body: string(body),
+	}, nil
+}
+
+// respondWithInternalErr logs the error and tells the caller only that something went wrong.
+func respondWithInternalErr(err error) (events.APIGatewayProxyResponse, error) {
+	logger.Error("error while handling request", "error", err)
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       "internal server error.",
+	}, nil
+}
+
+// servedRuns returns the runs as they are served.
+func servedRuns(runs []UpdateRun) []UpdateRun {
+	served := []UpdateRun{}
+	for _, run := range runs {
+		served = append(served, run.Served())
+	}
+	return served
+}
+
+func handleStatus(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// look far enough back to find a successful run behind a few failures.
+	runs, err := getLatestRuns(ctx, getClient(), maxRunsLimit)
+	if err != nil {
+		return respondWithInternalErr(err)
+	}
+
+	return respondWithJson(summariseRuns(servedRuns(runs), time.Now()))
+}
+
+func handleRuns(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	limit, err := parseRunsLimit(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	runs, err := getLatestRuns(ctx, getClient(), limit)
+	if err != nil {
+		return respondWithInternalErr(err)
+	}
+
+	return respondWithJson(servedRuns(runs))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestUpdateRunMarshalling(t *testing.T) {
+	started := time.Date(2024, 3, 1, 10, 0, 0, 120000000, time.UTC)
+	run := newUpdateRun("abc", triggerScheduled, started)
+	run.UpstreamPricesMs = 250
+	run.PricesFetched = 10
+	run.PricesChanged = 4
+	run.PricesWritten = 4
+	run.Retries = 1
+	run.Finish(errors.New("boom"), started.Add(time.Second))
+
+	var decoded UpdateRun
+	err := decoded.Unmarshal(run.Marshal())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if decoded.RunId != "abc" {
+		t.Errorf("expected RunId == 'abc', got %s", decoded.RunId)
+	}
+	if decoded.Trigger != triggerScheduled {
+		t.Errorf("expected Trigger == 'scheduled', got %s", decoded.Trigger)
+	}
+	if decoded.Status != runFailed {
+		t.Errorf("expected Status == 'failed', got %s", decoded.Status)
+	}
+	if !decoded.StartedAt.Equal(started) {
+		t.Errorf("expected StartedAt == %s, got %s", started, decoded.StartedAt)
+	}
+	if !decoded.EndedAt.Equal(started.Add(time.Second)) {
+		t.Errorf("unexpected EndedAt: %s", decoded.EndedAt)
+	}
+	if decoded.UpstreamPricesMs != 250 {
+		t.Errorf("expected UpstreamPricesMs == 250, got %d", decoded.UpstreamPricesMs)
+	}
+	if decoded.PricesFetched != 10 || decoded.PricesChanged != 4 || decoded.PricesWritten != 4 {
+		t.Errorf("unexpected price counts: %d/%d/%d", decoded.PricesFetched, decoded.PricesChanged, decoded.PricesWritten)
+	}
+	if decoded.Retries != 1 {
+		t.Errorf("expected Retries == 1, got %d", decoded.Retries)
+	}
+	if len(decoded.Regions) != 1 || decoded.Regions[0] != "21/3/4" {
+		t.Errorf("unexpected Regions: %v", decoded.Regions)
+	}
+	if len(decoded.Errors) != 1 || decoded.Errors[0] != "boom" {
+		t.Errorf("unexpected Errors: %v", decoded.Errors)
+	}
+}
+
+func TestRunTimeLayoutOrdering(t *testing.T) {
+	earlier := time.Date(2024, 3, 1, 10, 0, 5, 100000000, time.UTC).Format(runTimeLayout)
+	later := time.Date(2024, 3, 1, 10, 0, 5, 120000000, time.UTC).Format(runTimeLayout)
+	if earlier >= later {
+		t.Errorf("expected %s to sort before %s", earlier, later)
+	}
+}
+
+func TestSummariseRuns(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	runs := []UpdateRun{
+		{RunId: "c", Status: runFailed, EndedAt: now.Add(-time.Minute)},
+		{RunId: "b", Status: runSucceeded, EndedAt: now.Add(-time.Hour)},
+		{RunId: "a", Status: runSucceeded, EndedAt: now.Add(-2 * time.Hour)},
+	}
+
+	status := summariseRuns(runs, now)
+	if status.LatestRun.RunId != "c" {
+		t.Errorf("expected latest run to be 'c', got %s", status.LatestRun.RunId)
+	}
+	if status.LastSuccessfulRun.RunId != "b" {
+		t.Errorf("expected last successful run to be 'b', got %s", status.LastSuccessfulRun.RunId)
+	}
+	if *status.SecondsSinceSuccess != 3600 {
+		t.Errorf("expected 3600 seconds since success, got %d", *status.SecondsSinceSuccess)
+	}
+
+	status = summariseRuns([]UpdateRun{}, now)
+	if status.LatestRun != nil || status.LastSuccessfulRun != nil || status.SecondsSinceSuccess != nil {
+		t.Error("expected an empty status without any runs")
+	}
+}
+
+func TestServedRunHidesErrors(t *testing.T) {
+	run := newUpdateRun("run-7", triggerScheduled, time.Now())
+	run.Finish(errors.New("ResourceNotFoundException: current_fuel_prices not found"), time.Now())
+
+	served := run.Served()
+	if len(served.Errors) != 1 || strings.Contains(served.Errors[0], "current_fuel_prices") || !strings.Contains(served.Errors[0], "run-7") {
+		t.Errorf("expected the errors to be replaced with a pointer to the run, got %v", served.Errors)
+	}
+	if run.Errors[0] == served.Errors[0] {
+		t.Error("expected the stored errors to be kept")
+	}
+	if succeeded := newUpdateRun("b", triggerScheduled, time.Now()).Served(); len(succeeded.Errors) != 0 {
+		t.Errorf("expected no errors for a run without any, got %v", succeeded.Errors)
+	}
+}
+
+func TestParseRunsLimit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		params   map[string]string
+		expected int
+		isError  bool
+	}{
+		{name: "default", params: map[string]string{}, expected: defaultRunsLimit},
+		{name: "set", params: map[string]string{"limit": "5"}, expected: 5},
+		{name: "capped", params: map[string]string{"limit": "500"}, expected: maxRunsLimit},
+		{name: "zero", params: map[string]string{"limit": "0"}, isError: true},
+		{name: "invalid", params: map[string]string{"limit": "ten"}, isError: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			limit, err := parseRunsLimit(events.APIGatewayProxyRequest{QueryStringParameters: testCase.params})
+			if testCase.isError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+			}
+			if limit != testCase.expected {
+				t.Errorf("expected limit %d, got %d", testCase.expected, limit)
+			}
+		})
+	}
+}
+
+func TestRunsInvalidLimit(t *testing.T) {
+	withAdminSecret(t, "secret")
+	request := signedRequest("secret", "/update/runs", time.Now())
+	request.QueryStringParameters = map[string]string{"limit": "-1"}
+
+	res, err := handler(context.Background(), request)
+	if err != nil {
+		t.Error(err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code 400, got %d", res.StatusCode)
+	}
+}
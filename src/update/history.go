@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	historyTableName string = "price_history"
+
+	// historyDateLayout is the sort key of a day in a history series.
+	historyDateLayout string = "2006-01-02"
+
+	// placeholderPrice is what stations report for a fuel they have run out of or stopped selling.
+	placeholderPrice int = 9999
+)
+
+// historyLocation is the timezone the days of the price history are counted in.
+var historyLocation, _ = time.LoadLocation("Australia/Adelaide")
+
+// DailyPrice summarises the prices of a fuel type across the sites of a region on one day. a
+// day's record is overwritten by every update, so it holds the prices as of the day's last run.
+type DailyPrice struct {
+	FuelId   int
+	RegionId int
+	Date     string
+	Min      int
+	Median   float64
+	Mean     float64
+	Count    int
+}
+
+// historySeries is the partition key of the history of a fuel type in a region.
+func historySeries(fuelId int, regionId int) string {
+	return fmt.Sprintf("%d/%d", fuelId, regionId)
+}
+
+// historyDate returns the day of the history the time falls in.
+func historyDate(t time.Time) string {
+	return t.In(historyLocation).Format(historyDateLayout)
+}
+
+// DailyPrice.Marshal returns a dynamodb representation of the DailyPrice struct.
+func (price DailyPrice) Marshal() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Series": {S: aws.String(historySeries(price.FuelId, price.RegionId))},
+		"Date":   {S: aws.String(price.Date)},
+		"FuelId": {N: aws.String(strconv.Itoa(price.FuelId))},
+		"Region": {N: aws.String(strconv.Itoa(price.RegionId))},
+		"Min":    {N: aws.String(strconv.Itoa(price.Min))},
+		"Median": {N: aws.String(decimal.NewFromFloat(price.Median).String())},
+		"Mean":   {N: aws.String(decimal.NewFromFloat(price.Mean).Round(2).String())},
+		"Count":  {N: aws.String(strconv.Itoa(price.Count))},
+	}
+}
+
+// dailyPrices summarises the prices by fuel type and the region of their site. placeholder prices,
+// and prices of sites without a known region, are left out.
+func dailyPrices(prices FuelPriceList, sites PetrolStationList, date string) []DailyPrice {
+	regions := map[int]int{}
+	for _, site := range sites.Sites {
+		regions[site.SiteID] = site.RegionID
+	}
+
+	groups := map[[2]int][]int{}
+	for siteId, station := range prices.Sites {
+		regionId := regions[siteId]
+		if regionId == 0 {
+			continue
+		}
+
+		for fuelId, price := range station.FuelTypes {
+			if price.Price <= 0 || price.Price >= placeholderPrice {
+				continue
+			}
+			key := [2]int{fuelId, regionId}
+			groups[key] = append(groups[key], price.Price)
+		}
+	}
+
+	days := []DailyPrice{}
+	for key, groupPrices := range groups {
+		sort.Ints(groupPrices)
+
+		total := 0
+		for _, price := range groupPrices {
+			total += price
+		}
+
+		middle := len(groupPrices) / 2
+		median := float64(groupPrices[middle])
+		if len(groupPrices)%2 == 0 {
+			median = float64(groupPrices[middle-1]+groupPrices[middle]) / 2
+		}
+
+		days = append(days, DailyPrice{
+			FuelId:   key[0],
+			RegionId: key[1],
+			Date:     date,
+			Min:      groupPrices[0],
+			Median:   median,
+			Mean:     float64(total) / float64(len(groupPrices)),
+			Count:    len(groupPrices),
+		})
+	}
+
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].FuelId != days[j].FuelId {
+			return days[i].FuelId < days[j].FuelId
+		}
+		return days[i].RegionId < days[j].RegionId
+	})
+	return days
+}
+
+func createHistoryTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new history table")
+
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(historyTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("Series"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("Date"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("Series"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("Date"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return err
+		}
+	}
+
+	return client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(historyTableName),
+	})
+}
+
+// recordPriceHistory writes today's summary of the latest prices to the history, grouping the
+// sites by their region.
+func recordPriceHistory(ctx context.Context, dbClient *dynamodb.DynamoDB, sites PetrolStationList, prices FuelPriceList, run *UpdateRun) (err error) {
+	ctx, span := startSpan(ctx, "recordPriceHistory")
+	defer func() { endSpan(span, err) }()
+
+	if !checkTableExists(ctx, dbClient, historyTableName) {
+		err := createHistoryTable(ctx, dbClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, day := range dailyPrices(prices, sites, historyDate(run.StartedAt)) {
+		items = append(items, day.Marshal())
+	}
+
+	retries, err := writeBatches(ctx, dbClient, historyTableName, items)
+	run.Retries += retries
+	if err != nil {
+		return err
+	}
+	logger.Info("recorded price history", "series", len(items))
+	return nil
+}
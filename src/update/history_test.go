@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDailyPrices(t *testing.T) {
+	sites := PetrolStationList{Sites: []PetrolStationSite{
+		{SiteID: 1, RegionID: 12},
+		{SiteID: 2, RegionID: 12},
+		{SiteID: 3, RegionID: 13},
+		{SiteID: 4},
+	}}
+	prices := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1800}, 3: {Price: 2100}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 1900}}},
+		3: {SiteID: 3, FuelTypes: map[int]FuelPrice{2: {Price: 9999}}},
+		4: {SiteID: 4, FuelTypes: map[int]FuelPrice{2: {Price: 1700}}},
+	}}
+
+	days := dailyPrices(prices, sites, "2024-05-10")
+	if len(days) != 2 {
+		t.Fatalf("expected a day for unleaded and diesel in region 12, got %+v", days)
+	}
+
+	unleaded := days[0]
+	if unleaded.FuelId != 2 || unleaded.RegionId != 12 || unleaded.Date != "2024-05-10" {
+		t.Errorf("unexpected series: %+v", unleaded)
+	}
+	if unleaded.Count != 2 || unleaded.Min != 1800 || unleaded.Median != 1850 || unleaded.Mean != 1850 {
+		t.Errorf("unexpected summary: %+v", unleaded)
+	}
+
+	item := unleaded.Marshal()
+	if *item["Series"].S != "2/12" || *item["Date"].S != "2024-05-10" || *item["Median"].N != "1850" {
+		t.Errorf("unexpected item: %v", item)
+	}
+}
+
+func TestHistoryDate(t *testing.T) {
+	// adelaide is ahead of utc, so a utc evening is already the next day.
+	if date := historyDate(time.Date(2024, 5, 9, 20, 0, 0, 0, time.UTC)); date != "2024-05-10" {
+		t.Errorf("unexpected date: %s", date)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	updatePrices string = "prices"
+	updateSites  string = "sites"
+	updateBoth   string = "both"
+)
+
+// invocation holds the fields used to tell the supported event types apart.
+type invocation struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+	HTTPMethod string `json:"httpMethod"`
+	Update     string `json:"update"`
+}
+
+// isScheduled reports whether the event is an eventbridge scheduled event.
+func (event invocation) isScheduled() bool {
+	return event.Source == "aws.events" && event.DetailType == "Scheduled Event"
+}
+
+// targets returns which tables a direct invoke asked to refresh.
+func (event invocation) targets() (prices bool, sites bool, err error) {
+	switch event.Update {
+	case updatePrices:
+		return true, false, nil
+	case updateSites:
+		return false, true, nil
+	case updateBoth:
+		return true, true, nil
+	}
+	return false, false, fmt.Errorf("unknown update target %q.", event.Update)
+}
+
+// invoke is the lambda entrypoint. scheduled events and direct invokes run an update, and api
+// gateway requests are passed on to the handler. anything else is rejected.
+//
+// a direct invoke selects what to refresh, e.g. {"update": "prices"}, {"update": "sites"} or
+// {"update": "both"}. this is also the payload the schedules in the template send.
+func invoke(ctx context.Context, payload json.RawMessage) (res events.APIGatewayProxyResponse, err error) {
+	var event invocation
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	switch {
+	case event.isScheduled():
+		ctx, finish := beginTracedInvocation(ctx, "scheduled", nil)
+		defer func() { finish(res, err) }()
+
+		_, err = runUpdate(ctx, triggerScheduled, true, isUpdatingSites)
+		return respondToUpdate(err)
+
+	case event.Update != "":
+		ctx, finish := beginTracedInvocation(ctx, "invoke "+event.Update, nil)
+		defer func() { finish(res, err) }()
+
+		prices, sites, err := event.targets()
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, err
+		}
+		_, err = runUpdate(ctx, triggerInvoke, prices, sites)
+		return respondToUpdate(err)
+
+	case event.HTTPMethod != "":
+		var request events.APIGatewayProxyRequest
+		err = json.Unmarshal(payload, &request)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		ctx, finish := beginTracedInvocation(ctx, requestRoute(request), request.Headers)
+		defer func() { finish(res, err) }()
+		logger = logger.With("api_request_id", request.RequestContext.RequestID, "path", request.Path)
+		logger.Debug("request headers", headersAttr(request.Headers))
+
+		return handler(ctx, request)
+	}
+
+	logger.Warn("rejected unsupported invocation")
+	return events.APIGatewayProxyResponse{}, errors.New("unsupported invocation.")
+}
+
+// respondToUpdate wraps the result of a non http update.
+func respondToUpdate(err error) (events.APIGatewayProxyResponse, error) {
+	if errors.Is(err, errUpdateRunning) {
+		return respondAlreadyRunning()
+	}
+	if err != nil {
+		return respondWithStdErr(err)
+	}
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusAccepted}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestInvocation(t *testing.T) {
+	var event invocation
+	err := json.Unmarshal([]byte(`{"source":"aws.events","detail-type":"Scheduled Event","detail":{}}`), &event)
+	if err != nil {
+		t.Error(err)
+	}
+	if !event.isScheduled() {
+		t.Error("expected scheduled event to be detected")
+	}
+
+	event = invocation{}
+	err = json.Unmarshal([]byte(`{"source":"aws.s3","detail-type":"Object Created"}`), &event)
+	if err != nil {
+		t.Error(err)
+	}
+	if event.isScheduled() {
+		t.Error("expected other eventbridge events to not be treated as scheduled")
+	}
+
+	_, err = invoke(context.Background(), json.RawMessage(`{"hello":"world"}`))
+	if err == nil {
+		t.Error("expected unknown payloads to be rejected")
+	}
+
+	res, err := invoke(context.Background(), json.RawMessage(`{"httpMethod":"DELETE","path":"/update"}`))
+	if err != nil {
+		t.Error(err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code 400, got %d", res.StatusCode)
+	}
+}
+
+func TestInvocationTargets(t *testing.T) {
+	testCases := []struct {
+		update string
+		prices bool
+		sites  bool
+	}{
+		{update: updatePrices, prices: true, sites: false},
+		{update: updateSites, prices: false, sites: true},
+		{update: updateBoth, prices: true, sites: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.update, func(t *testing.T) {
+			prices, sites, err := invocation{Update: testCase.update}.targets()
+			if err != nil {
+				t.Error(err)
+			}
+			if prices != testCase.prices {
+				t.Errorf("expected prices == %t, got %t", testCase.prices, prices)
+			}
+			if sites != testCase.sites {
+				t.Errorf("expected sites == %t, got %t", testCase.sites, sites)
+			}
+		})
+	}
+
+	_, _, err := invocation{Update: "brands"}.targets()
+	if err == nil {
+		t.Error("expected an unknown target to be rejected")
+	}
+
+	res, err := invoke(context.Background(), json.RawMessage(`{"update":"brands"}`))
+	if err == nil {
+		t.Error("expected an unknown target to fail the invoke")
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code 400, got %d", res.StatusCode)
+	}
+}
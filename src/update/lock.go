@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	locksTableName string = "update_locks"
+	updateLockId   string = "update"
+
+	// lockLeaseDuration is longer than the function timeout, so a run that crashes or times out
+	// always loses the lease before the next run could be blocked by it for good.
+	lockLeaseDuration time.Duration = 15 * time.Minute
+)
+
+// errUpdateRunning is returned when another run holds the update lock.
+var errUpdateRunning = errors.New("update already running.")
+
+// UpdateLock is a lease on the update lock, held by a single run.
+type UpdateLock struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func createLockTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new locks table")
+
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(locksTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("LockId"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("LockId"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	})
+	if err != nil {
+		// another run may have beaten us to creating it.
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return err
+		}
+	}
+
+	return client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(locksTableName),
+	})
+}
+
+// newLockOwner returns a random id for the run taking the lock.
+func newLockOwner() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// acquireLockInput only lets the put through if nobody holds the lock, or their lease has expired.
+func acquireLockInput(lock UpdateLock, now time.Time) *dynamodb.PutItemInput {
+	return &dynamodb.PutItemInput{
+		TableName: aws.String(locksTableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockId":    {S: aws.String(updateLockId)},
+			"Owner":     {S: aws.String(lock.Owner)},
+			"ExpiresAt": {N: aws.String(strconv.FormatInt(lock.ExpiresAt.Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockId) OR ExpiresAt < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	}
+}
+
+// acquireUpdateLock takes the update lock, returning errUpdateRunning if another run holds it.
+func acquireUpdateLock(ctx context.Context, client *dynamodb.DynamoDB, now time.Time) (*UpdateLock, error) {
+	if !checkTableExists(ctx, client, locksTableName) {
+		err := createLockTable(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := UpdateLock{
+		Owner:     owner,
+		ExpiresAt: now.Add(lockLeaseDuration),
+	}
+
+	_, err = client.PutItemWithContext(ctx, acquireLockInput(lock, now))
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, errUpdateRunning
+		}
+		return nil, err
+	}
+
+	logger.Info("acquired update lock", "owner", lock.Owner, "expires_at", lock.ExpiresAt)
+	return &lock, nil
+}
+
+// releaseUpdateLock gives the lock back, unless it has already expired and been taken by another run.
+func releaseUpdateLock(ctx context.Context, client *dynamodb.DynamoDB, lock *UpdateLock) error {
+	_, err := client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(locksTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockId": {S: aws.String(updateLockId)},
+		},
+		ConditionExpression:      aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{"#owner": aws.String("Owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(lock.Owner)},
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			logger.Warn("update lock was already taken by another run", "owner", lock.Owner)
+			return nil
+		}
+		return err
+	}
+
+	logger.Info("released update lock", "owner", lock.Owner)
+	return nil
+}
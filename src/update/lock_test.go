@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewLockOwner(t *testing.T) {
+	a, err := newLockOwner()
+	if err != nil {
+		t.Error(err)
+	}
+	b, err := newLockOwner()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(a) != 32 {
+		t.Errorf("expected a 32 character owner id, got %s", a)
+	}
+	if a == b {
+		t.Error("expected owner ids to be unique")
+	}
+}
+
+func TestAcquireLockInput(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lock := UpdateLock{
+		Owner:     "abc",
+		ExpiresAt: now.Add(lockLeaseDuration),
+	}
+
+	input := acquireLockInput(lock, now)
+	if *input.TableName != locksTableName {
+		t.Errorf("unexpected table name: %s", *input.TableName)
+	}
+	if *input.Item["LockId"].S != updateLockId {
+		t.Errorf("unexpected lock id: %s", *input.Item["LockId"].S)
+	}
+	if *input.Item["Owner"].S != "abc" {
+		t.Errorf("unexpected owner: %s", *input.Item["Owner"].S)
+	}
+	if *input.Item["ExpiresAt"].N != "1700000900" {
+		t.Errorf("unexpected expiry: %s", *input.Item["ExpiresAt"].N)
+	}
+
+	// the lease can only be taken over once it has expired.
+	if *input.ConditionExpression != "attribute_not_exists(LockId) OR ExpiresAt < :now" {
+		t.Errorf("unexpected condition: %s", *input.ConditionExpression)
+	}
+	if *input.ExpressionAttributeValues[":now"].N != "1700000000" {
+		t.Errorf("unexpected condition time: %s", *input.ExpressionAttributeValues[":now"].N)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const (
+	redacted string = "[REDACTED]"
+	// unmatchedResource is the route of requests that didn't come through an api gateway resource.
+	unmatchedResource string = "unmatched"
+)
+
+// sensitiveKeys are the attribute keys that are never logged, matched case insensitively anywhere
+// in the key.
+var sensitiveKeys = []string{"apikey", "api_key", "api-key", "authorization", "secret", "signature", "password", "token"}
+
+// baseLogger logs json to stdout at the level set by the log_level env var. logger is a copy of it
+// scoped to the current invocation, lambda only runs one invocation at a time per process so it
+// is safe to swap out at the start of each one.
+var (
+	baseLogger *slog.Logger = newLogger(os.Stdout, os.Getenv("log_level"), apikey, adminSecret)
+	logger     *slog.Logger = baseLogger
+)
+
+// parseLogLevel reads debug, info, warn or error, defaulting to info.
+func parseLogLevel(level string) slog.Level {
+	var parsed slog.Level
+	err := parsed.UnmarshalText([]byte(level))
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitiveKey := range sensitiveKeys {
+		if strings.Contains(key, sensitiveKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets replaces any of the secret values that turn up in the string.
+func redactSecrets(value string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			value = strings.ReplaceAll(value, secret, redacted)
+		}
+	}
+	return value
+}
+
+// newLogger returns a json logger that redacts sensitive attributes, and any of the secrets
+// wherever they appear in a message, value or error.
+func newLogger(w io.Writer, level string, secrets ...string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: parseLogLevel(level),
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if isSensitiveKey(attr.Key) {
+				return slog.String(attr.Key, redacted)
+			}
+
+			switch attr.Value.Kind() {
+			case slog.KindString:
+				attr.Value = slog.StringValue(redactSecrets(attr.Value.String(), secrets))
+			case slog.KindAny:
+				if err, ok := attr.Value.Any().(error); ok {
+					attr.Value = slog.StringValue(redactSecrets(err.Error(), secrets))
+				}
+			}
+			return attr
+		},
+	}))
+}
+
+// headersAttr logs the headers as a group, so sensitive headers are redacted by key.
+func headersAttr(headers map[string]string) slog.Attr {
+	attrs := []any{}
+	for key, value := range headers {
+		attrs = append(attrs, slog.String(key, value))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// requestRoute names the request by its method and the api gateway resource it matched, so the
+// route stays the same whatever is in the path.
+func requestRoute(request events.APIGatewayProxyRequest) string {
+	resource := request.Resource
+	if resource == "" {
+		resource = unmatchedResource
+	}
+	return request.HTTPMethod + " " + resource
+}
+
+// beginInvocation scopes the logger to the invocation and route, and returns a func that logs
+// the outcome and how long it took.
+func beginInvocation(ctx context.Context, route string) func(res events.APIGatewayProxyResponse, err error) {
+	requestId := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestId = lc.AwsRequestID
+	}
+
+	logger = baseLogger.With("request_id", requestId, "route", route)
+	logger.Debug("invocation started")
+	metrics = newMetrics(map[string]string{"Route": route})
+
+	started := time.Now()
+	return func(res events.APIGatewayProxyResponse, err error) {
+		duration := time.Since(started)
+		attrs := []any{
+			"status", res.StatusCode,
+			"duration_ms", duration.Milliseconds(),
+		}
+
+		recordRequestMetrics(res, err, duration)
+		defer flushMetrics()
+
+		if err != nil {
+			logger.Error("invocation failed", append(attrs, "error", err)...)
+			return
+		}
+		logger.Info("invocation finished", attrs...)
+	}
+}
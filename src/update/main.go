@@ -1,15 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"slices"
-
-	"github.com/shopspring/decimal"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -17,6 +17,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -25,12 +26,19 @@ const (
 	sitesTableName  string = "safpis_fuel_sites"
 	batchSize       int    = 25
 	fuelURL         string = "https://fppdirectapi-prod.safuelpricinginformation.com.au"
+	countryId       int    = 21
+	geoRegionLevel  int    = 3
+	geoRegionId     int    = 4
+
+	maxBatchRetries int           = 5
+	batchRetryDelay time.Duration = 100 * time.Millisecond
 )
 
 var (
 	isLocal         bool   = os.Getenv("local") == "true"
 	isUpdatingSites bool   = os.Getenv("update_sites") == "true"
 	apikey          string = os.Getenv("api_key")
+	adminSecret     string = os.Getenv("admin_secret")
 )
 
 type PetrolStationList struct {
@@ -44,14 +52,25 @@ type PetrolStationSite struct {
 	BrandID       int     `json:"B"`
 	Postcode      string  `json:"P"`
 	GooglePlaceID string  `json:"GPI"`
+	RegionID      int     `json:"G2"`
 	Latitude      float64 `json:"Lat"`
 	Longitude     float64 `json:"Lng"`
 }
 
+// countryQuery returns the upstream query string for the country's reference data.
+func countryQuery() string {
+	return fmt.Sprintf("countryId=%d", countryId)
+}
+
+// regionQuery returns the upstream query string for the region being updated.
+func regionQuery() string {
+	return fmt.Sprintf("countryId=%d&geoRegionLevel=%d&geoRegionId=%d", countryId, geoRegionLevel, geoRegionId)
+}
+
 func getClient() *dynamodb.DynamoDB {
 	config := aws.NewConfig().WithRegion(region)
 	if isLocal {
-		fmt.Println("Using local endpoint.")
+		logger.Debug("using local endpoint")
 		config = config.WithEndpoint("http://dynamodb-local:8000")
 	}
 
@@ -60,13 +79,13 @@ func getClient() *dynamodb.DynamoDB {
 		return nil
 	}
 
-	return dynamodb.New(session, config)
+	return instrumentClient(dynamodb.New(session, config))
 }
 
-func createPriceTable(client *dynamodb.DynamoDB) error {
-	fmt.Println("Creating new prices table!")
+func createPriceTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new prices table")
 
-	_, err := client.CreateTable(&dynamodb.CreateTableInput{
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
 		TableName: aws.String(pricesTableName),
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
 			{
@@ -90,10 +109,10 @@ func createPriceTable(client *dynamodb.DynamoDB) error {
 	return err
 }
 
-func createSiteTable(client *dynamodb.DynamoDB) error {
-	fmt.Println("Creating new sites table!")
+func createSiteTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new sites table")
 
-	_, err := client.CreateTable(&dynamodb.CreateTableInput{
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
 		TableName: aws.String(sitesTableName),
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
 			{
@@ -116,8 +135,10 @@ func createSiteTable(client *dynamodb.DynamoDB) error {
 	return err
 }
 
-func checkTableExists(client *dynamodb.DynamoDB, tableName string) bool {
-	awsTables, err := client.ListTables(&dynamodb.ListTablesInput{})
+func checkTableExists(ctx context.Context, client *dynamodb.DynamoDB, tableName string) bool {
+	ctx, span := startSpan(ctx, "checkTableExists", attribute.String("table", tableName))
+	awsTables, err := client.ListTablesWithContext(ctx, &dynamodb.ListTablesInput{})
+	endSpan(span, err)
 	if err != nil {
 		return false
 	}
@@ -137,34 +158,49 @@ func respondWithStdErr(err error) (events.APIGatewayProxyResponse, error) {
 	}, err
 }
 
-func sendJsonRequest[T interface{}](url string, obj *T) error {
-	httpClient := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// respondAlreadyRunning tells the caller another run is in progress, this isn't an error.
+func respondAlreadyRunning() (events.APIGatewayProxyResponse, error) {
+	logger.Warn("update already running, skipping")
+	return events.APIGatewayProxyResponse{
+		Body:       errUpdateRunning.Error(),
+		StatusCode: http.StatusConflict,
+	}, nil
+}
+
+func sendJsonRequest[T interface{}](ctx context.Context, url string, obj *T) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		fmt.Println("Error while creating http client.")
+		logger.Error("error while creating http request", "error", err)
 		return err
 	}
 	req.Header.Set("Authorization", apikey)
 
 	// - read the body
-	fmt.Printf("Sending request, apikey: %s\n", apikey)
-	res, err := httpClient.Do(req)
+	started := time.Now()
+	res, err := upstreamClient.Do(req)
 	if err != nil {
-		fmt.Println("Error while sending http request.")
+		logger.Error("error while sending http request", "url", url, "error", err)
 		return err
 	}
+	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		log.Fatalln(err)
+		logger.Error("error while reading http response", "url", url, "error", err)
 		return err
 	}
+	logger.Info("upstream request finished",
+		"url", url,
+		"status", res.StatusCode,
+		"response_bytes", len(body),
+		"duration_ms", time.Since(started).Milliseconds(),
+	)
 
 	// - unmarshall the json
 	err = json.Unmarshal(body, &obj)
 	if err != nil {
-		fmt.Println("Error while unmarshalling json body.")
-		fmt.Println(string(body))
+		logger.Error("error while unmarshalling json body", "error", err)
+		logger.Debug("upstream response body", "body", string(body))
 		return err
 	}
 	return nil
@@ -181,165 +217,375 @@ func handleCors(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRe
 	}, nil
 }
 
-func getAllPrices(dbClient *dynamodb.DynamoDB) (events.APIGatewayProxyResponse, error) {
+// scanTable reads every record in the table.
+func scanTable(ctx context.Context, dbClient *dynamodb.DynamoDB, tableName string) ([]map[string]*dynamodb.AttributeValue, error) {
+	records := []map[string]*dynamodb.AttributeValue{}
+	err := dbClient.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		records = append(records, page.Items...)
+		return true
+	})
+	return records, err
+}
+
+// getStoredSites returns the sites as they are in the sites table.
+func getStoredSites(ctx context.Context, dbClient *dynamodb.DynamoDB) (PetrolStationList, error) {
+	var sites PetrolStationList
+	records, err := scanTable(ctx, dbClient, sitesTableName)
+	if err != nil {
+		return sites, err
+	}
+	err = sites.Unmarshal(records)
+	return sites, err
+}
+
+// writeBatches puts the items into the table in batches, retrying any unprocessed items with
+// backoff. returns the number of retries it took.
+func writeBatches(ctx context.Context, dbClient *dynamodb.DynamoDB, tableName string, items []map[string]*dynamodb.AttributeValue) (int, error) {
+	retries := 0
+
+	started := time.Now()
+	for n := 0; n < len(items); {
+		var writeReqs []*dynamodb.WriteRequest
+
+		// - append the write req
+		end := min(n+batchSize, len(items))
+		for _, item := range items[n:end] {
+			writeReqs = append(writeReqs, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+		}
+
+		// - send the batch, and resend anything dynamo couldn't process.
+		requestItems := map[string][]*dynamodb.WriteRequest{tableName: writeReqs}
+		for attempt := 0; len(requestItems[tableName]) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				return retries, fmt.Errorf("%d records still unprocessed after %d retries", len(requestItems[tableName]), maxBatchRetries)
+			}
+			if attempt > 0 {
+				retries++
+				time.Sleep(batchRetryDelay << (attempt - 1))
+			}
+
+			batchRes, err := dbClient.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{RequestItems: requestItems})
+			if err != nil {
+				logger.Error("error while sending batch write item", "table", tableName, "error", err)
+				return retries, err
+			}
+			requestItems = batchRes.UnprocessedItems
+		}
+
+		n += batchSize
+		logger.Debug("wrote batch", "table", tableName, "written", end, "total", len(items))
+	}
+	logger.Info("wrote records to database",
+		"table", tableName,
+		"records", len(items),
+		"retries", retries,
+		"duration_ms", time.Since(started).Milliseconds(),
+	)
+
+	return retries, nil
+}
+
+func getAllPrices(ctx context.Context, dbClient *dynamodb.DynamoDB, run *UpdateRun, hooks *WebhookDispatcher) (err error) {
+	ctx, span := startSpan(ctx, "getAllPrices")
+	defer func() { endSpan(span, err) }()
+
 	// validate the table exists.
-	fmt.Println("checking prices table exists.")
-	if !checkTableExists(dbClient, pricesTableName) {
-		err := createPriceTable(dbClient)
+	if !checkTableExists(ctx, dbClient, pricesTableName) {
+		err := createPriceTable(ctx, dbClient)
 		if err != nil {
-			return respondWithStdErr(err)
+			return err
 		}
 	}
 
 	// get the fuel prices.
 	// - create the request.
 	var saPrices SA_FuelPriceList
-	pricesEndpoint := fuelURL + "/Price/GetSitesPrices?countryId=21&geoRegionLevel=3&geoRegionId=4"
-	err := sendJsonRequest(pricesEndpoint, &saPrices)
+	pricesEndpoint := fuelURL + "/Price/GetSitesPrices?" + regionQuery()
+	started := time.Now()
+	err = sendJsonRequest(ctx, pricesEndpoint, &saPrices)
+	run.UpstreamPricesMs = time.Since(started).Milliseconds()
 	if err != nil {
-		return respondWithStdErr(err)
+		return err
 	}
+	run.PricesFetched = len(saPrices.Prices)
 
 	// convert the SA_FuelPriceList to the local FuelPriceList
 	prices, err := saPrices.ToPriceList()
 	if err != nil {
-		return respondWithStdErr(err)
+		return err
 	}
 
+	// compare against the stored prices to count and announce the changes.
+	records, err := scanTable(ctx, dbClient, pricesTableName)
+	if err != nil {
+		return err
+	}
+	var stored FuelPriceList
+	err = stored.Unmarshal(records)
+	if err != nil {
+		return err
+	}
+	changed := stored.Changed(prices)
+	run.PricesChanged = len(changed.Sites)
+
 	// update the database.
 	allSites, err := prices.Marshal()
 	if err != nil {
-		return respondWithStdErr(err)
+		return err
+	}
+	retries, err := writeBatches(ctx, dbClient, pricesTableName, allSites)
+	run.Retries += retries
+	if err != nil {
+		return err
+	}
+	run.PricesWritten = len(allSites)
+	if len(changed.Sites) > 0 {
+		hooks.Queue(eventPriceChanged, PriceChangedData{Sites: changedStations(changed)})
 	}
-	fmt.Printf("updating %d records in database.\n", len(allSites))
-	for n := 0; n < len(allSites); {
-		var writeReqs []*dynamodb.WriteRequest
 
-		// - append the write req
-		end := min(n+batchSize, len(allSites))
-		for _, site := range allSites[n:end] {
-			writeReqs = append(writeReqs, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: site}})
-		}
+	// the stored sites give the history their regions and the alerts their locations.
+	sites, err := getStoredSites(ctx, dbClient)
+	if err != nil {
+		return err
+	}
 
-		// - send the batch
-		batchReq := dynamodb.BatchWriteItemInput{RequestItems: map[string][]*dynamodb.WriteRequest{pricesTableName: writeReqs}}
-		if _, err = dbClient.BatchWriteItem(&batchReq); err != nil {
-			fmt.Println("Error while sending batch write item.")
-			return respondWithStdErr(err)
-		}
+	// every run refreshes today's history, so a day is recorded even when no prices changed.
+	err = recordPriceHistory(ctx, dbClient, sites, prices, run)
+	if err != nil {
+		return err
+	}
 
-		n += batchSize
-		fmt.Printf("updated ~%d/%d records in database.\n", end, len(allSites))
+	if len(changed.Sites) > 0 {
+		err = putDataVersion(ctx, dbClient, DataVersion{Dataset: datasetPrices, Version: run.RunId, UpdatedAt: time.Now()})
+		if err != nil {
+			return err
+		}
 	}
-	fmt.Printf("done!.\n")
 
-	return events.APIGatewayProxyResponse{}, nil
+	pushPriceChanges(ctx, dbClient, stored, changed, sites, run)
+
+	// the prices are already written, so the alerts failing doesn't fail the run.
+	_, err = evaluateAlerts(ctx, dbClient, sites, prices, time.Now())
+	if err != nil {
+		logger.Error("error while evaluating alerts", "error", err)
+	}
+	return nil
 }
 
-func getAllSites(dbClient *dynamodb.DynamoDB) (events.APIGatewayProxyResponse, error) {
+func getAllSites(ctx context.Context, dbClient *dynamodb.DynamoDB, run *UpdateRun, hooks *WebhookDispatcher) (err error) {
+	ctx, span := startSpan(ctx, "getAllSites")
+	defer func() { endSpan(span, err) }()
+
 	// validate the table exists.
-	fmt.Println("checking sites table exists.")
-	if !checkTableExists(dbClient, sitesTableName) {
-		err := createSiteTable(dbClient)
+	if !checkTableExists(ctx, dbClient, sitesTableName) {
+		err := createSiteTable(ctx, dbClient)
 		if err != nil {
-			return respondWithStdErr(err)
+			return err
 		}
 	}
 
 	// get the sites date.
 	// - create the request.
 	var sites PetrolStationList
-	sitesEndpoint := fuelURL + "/Subscriber/GetFullSiteDetails?countryId=21&geoRegionLevel=3&geoRegionId=4"
-	err := sendJsonRequest(sitesEndpoint, &sites)
+	sitesEndpoint := fuelURL + "/Subscriber/GetFullSiteDetails?" + regionQuery()
+	started := time.Now()
+	err = sendJsonRequest(ctx, sitesEndpoint, &sites)
+	run.UpstreamSitesMs = time.Since(started).Milliseconds()
 	if err != nil {
-		return respondWithStdErr(err)
+		return err
 	}
+	run.SitesFetched = len(sites.Sites)
+
+	// compare against the stored sites to count and announce the changes.
+	stored, err := getStoredSites(ctx, dbClient)
+	if err != nil {
+		return err
+	}
+	changed := stored.Changed(sites)
+	run.SitesChanged = len(changed.Sites)
 
 	// update the database.
-	var item map[string]*dynamodb.AttributeValue
+	allSites := sites.Marshal()
+	retries, err := writeBatches(ctx, dbClient, sitesTableName, allSites)
+	run.Retries += retries
+	if err != nil {
+		return err
+	}
+	run.SitesWritten = len(allSites)
 
-	allSites := sites.Sites
-	fmt.Printf("updating %d records in database.\n", len(allSites))
-	for n := 0; n < len(allSites); {
-		var writeReqs []*dynamodb.WriteRequest
+	if added := stored.Added(sites); len(added) > 0 {
+		hooks.Queue(eventSiteAdded, SitesAddedData{Sites: webhookSites(added)})
+	}
+	if removed := stored.Removed(sites); len(removed) > 0 {
+		hooks.Queue(eventSiteRemoved, SitesRemovedData{SiteIds: removed})
+	}
 
-		end := min(n+batchSize, len(allSites))
-		for _, petrolStation := range allSites[n:end] {
-			// - marshall the struct
-			item = map[string]*dynamodb.AttributeValue{
-				"SiteId": {N: aws.String(fmt.Sprintf("%d", petrolStation.SiteID))},
-				"A":      {S: aws.String(petrolStation.Address)},
-				"N":      {S: aws.String(petrolStation.Name)},
-				"B":      {N: aws.String(fmt.Sprintf("%d", petrolStation.BrandID))},
-				"P":      {S: aws.String(petrolStation.Postcode)},
-				"G":      {S: aws.String(petrolStation.GooglePlaceID)},
-				"Lt":     {N: aws.String(decimal.NewFromFloat(petrolStation.Latitude).String())},
-				"Lg":     {N: aws.String(decimal.NewFromFloat(petrolStation.Longitude).String())},
-			}
+	if len(changed.Sites) > 0 {
+		return putDataVersion(ctx, dbClient, DataVersion{Dataset: datasetSites, Version: run.RunId, UpdatedAt: time.Now()})
+	}
+	return nil
+}
 
-			// - append the write req
-			writeReqs = append(writeReqs, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+// runUpdate refreshes the prices and/or the sites, recording the run in the runs table. only
+// one run can update at a time, any overlapping run returns errUpdateRunning without touching
+// the tables.
+func runUpdate(ctx context.Context, trigger string, prices bool, sites bool) (run *UpdateRun, err error) {
+	// create the dynamo dbClient.
+	dbClient := getClient()
+
+	lock, err := acquireUpdateLock(ctx, dbClient, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		releaseErr := releaseUpdateLock(ctx, dbClient, lock)
+		if err == nil {
+			err = releaseErr
 		}
+	}()
+
+	run = newUpdateRun(lock.Owner, trigger, time.Now())
+	hooks := newWebhookDispatcher(dbClient)
+	defer func() {
+		run.Finish(err, time.Now())
+		logger.Info("update run finished",
+			"run_id", run.RunId,
+			"trigger", run.Trigger,
+			"run_status", run.Status,
+			"prices_changed", run.PricesChanged,
+			"sites_changed", run.SitesChanged,
+			"errors", run.Errors,
+			"duration_ms", run.EndedAt.Sub(run.StartedAt).Milliseconds(),
+		)
+		recordRunMetrics(run)
+		recordErr := recordUpdateRun(ctx, dbClient, run)
+		if recordErr != nil {
+			logger.Error("error while recording update run", "error", recordErr)
+		}
+
+		hooks.QueueRun(run)
+		dispatchWebhooks(ctx, dbClient, hooks)
+	}()
 
-		// - send the batch
-		batchReq := dynamodb.BatchWriteItemInput{RequestItems: map[string][]*dynamodb.WriteRequest{sitesTableName: writeReqs}}
-		if _, err = dbClient.BatchWriteItem(&batchReq); err != nil {
-			fmt.Println("Error while sending batch write item.")
-			return respondWithStdErr(err)
+	if prices {
+		err = getAllPrices(ctx, dbClient, run, hooks)
+		if err != nil {
+			return run, err
 		}
+	}
 
-		n += batchSize
-		fmt.Printf("updated ~%d/%d records in database.\n", end, len(allSites))
+	if sites {
+		err = getAllSites(ctx, dbClient, run, hooks)
+		if err != nil {
+			return run, err
+		}
+
+		err = getReferenceNames(ctx, dbClient)
+		if err != nil {
+			return run, err
+		}
 	}
-	fmt.Printf("done!.\n")
 
-	return events.APIGatewayProxyResponse{}, nil
+	return run, nil
 }
 
-func handleGet(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	var err error
+func handleGet(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// check the path and route based on that.
+	var handle func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+	switch request.Path {
+	case "/update":
+		handle = handleUpdate
+	case "/update/status":
+		handle = handleStatus
+	case "/update/runs":
+		handle = handleRuns
+	default:
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       "invalid path.",
+		}, nil
+	}
 
-	// create the dynamo dbClient.
-	dbClient := getClient()
+	// every route is for admins, only signed requests are allowed through.
+	err := verifyUpdateRequest(request, adminSecret, time.Now())
+	if err != nil {
+		logger.Warn("rejected admin request", "reason", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusUnauthorized,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	return withValidation(ctx, request, handle)
+}
 
-	_, err = getAllPrices(dbClient)
+func handleUpdate(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	run, err := runUpdate(ctx, triggerManual, true, isUpdatingSites)
+	if errors.Is(err, errUpdateRunning) {
+		return respondAlreadyRunning()
+	}
 	if err != nil {
 		return respondWithStdErr(err)
 	}
 
-	if isUpdatingSites {
-		_, err = getAllSites(dbClient)
-		if err != nil {
-			return respondWithStdErr(err)
-		}
+	// return the summary of the run.
+	body, err := json.Marshal(run)
+	if err != nil {
+		return respondWithStdErr(err)
 	}
 
-	// return.
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusAccepted,
 		Headers: map[string]string{
 			"Access-Control-Allow-Headers": "*",
 			"Access-Control-Allow-Origin":  "*",
 			"Access-Control-Allow-Methods": "OPTIONS,GET,POST",
+			"Content-Type":                 "application/json",
 		},
+		Body: string(body),
 	}, nil
 }
 
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var res events.APIGatewayProxyResponse
+	var err error
+
 	switch request.HTTPMethod {
 	case http.MethodOptions:
 		return handleCors(request)
 	case http.MethodGet:
-		return handleGet(request)
+		res, err = handleGet(ctx, request)
 	default:
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusBadRequest,
 		}, nil
 	}
+
+	// the responses that don't say otherwise are plain text messages, as openapi.json documents.
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+	if _, ok := res.Headers["Content-Type"]; !ok && res.Body != "" {
+		res.Headers["Content-Type"] = "text/plain; charset=utf-8"
+	}
+	return res, err
 }
 
 func main() {
-	lambda.Start(handler)
+	err := initTracing(context.Background())
+	if err != nil {
+		logger.Error("error while starting tracing", "error", err)
+	}
+
+	if serverAddr != "" {
+		err = serve(serverAddr)
+		logger.Error("error while serving", "error", err)
+		os.Exit(1)
+	}
+
+	lambda.Start(invoke)
 }
 
 //{"CollectionMethod":{"S":"T"},"FuelId":{"N":"2"},"Price":{"N":"2799"},"SiteId":{"N":"61577372"},"TransactionDateUtc":{"S":"2023-10-27T05:11:11.663"}}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	metricsNamespace string = "PetrolPriceAPI"
+
+	unitCount        string = "Count"
+	unitBytes        string = "Bytes"
+	unitMilliseconds string = "Milliseconds"
+)
+
+// metrics collects the metrics for the current invocation, it is swapped out at the start of each
+// one the same way as the logger.
+var metrics *Metrics = newMetrics(map[string]string{})
+
+// Metrics buffers metric values until they are flushed as cloudwatch embedded metric format. it is
+// safe to put to from several goroutines, the dynamodb client records every operation it completes.
+type Metrics struct {
+	mu         sync.Mutex
+	dimensions map[string]string
+	entries    []metricEntry
+}
+
+type metricEntry struct {
+	name       string
+	unit       string
+	value      float64
+	dimensions map[string]string
+}
+
+// newMetrics returns an empty set of metrics, every metric put will carry the dimensions.
+func newMetrics(dimensions map[string]string) *Metrics {
+	return &Metrics{
+		dimensions: dimensions,
+		entries:    []metricEntry{},
+	}
+}
+
+// Metrics.Put records a value against the invocation's dimensions.
+func (m *Metrics) Put(name string, value float64, unit string) {
+	m.PutWith(name, value, unit, nil)
+}
+
+// Metrics.PutWith records a value with extra dimensions on top of the invocation's ones.
+func (m *Metrics) PutWith(name string, value float64, unit string, dimensions map[string]string) {
+	all := maps.Clone(m.dimensions)
+	for key, dimension := range dimensions {
+		all[key] = dimension
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, metricEntry{
+		name:       name,
+		unit:       unit,
+		value:      value,
+		dimensions: all,
+	})
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// dimensionsKey identifies a set of dimension values, metrics are grouped into a document per set.
+func dimensionsKey(dimensions map[string]string) string {
+	keys := sortedKeys(dimensions)
+	parts := []string{}
+	for _, key := range keys {
+		parts = append(parts, key+"="+dimensions[key])
+	}
+	return strings.Join(parts, "&")
+}
+
+// metricGroup is the metrics sharing one set of dimension values.
+type metricGroup struct {
+	dimensions map[string]string
+	units      map[string]string
+	names      []string
+	values     map[string][]float64
+}
+
+// metricGroup.Document returns the group as an embedded metric format document.
+func (group metricGroup) Document(now time.Time) map[string]interface{} {
+	definitions := []map[string]string{}
+	for _, name := range group.names {
+		definitions = append(definitions, map[string]string{"Name": name, "Unit": group.units[name]})
+	}
+
+	document := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": now.UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace":  metricsNamespace,
+					"Dimensions": [][]string{sortedKeys(group.dimensions)},
+					"Metrics":    definitions,
+				},
+			},
+		},
+	}
+	for name, value := range group.dimensions {
+		document[name] = value
+	}
+	for name, values := range group.values {
+		if len(values) == 1 {
+			document[name] = values[0]
+		} else {
+			document[name] = values
+		}
+	}
+
+	return document
+}
+
+// Metrics.Documents returns an embedded metric format document for each set of dimensions. values
+// put more than once are sent as an array.
+func (m *Metrics) Documents(now time.Time) []map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.documents(now)
+}
+
+func (m *Metrics) documents(now time.Time) []map[string]interface{} {
+	groups := []*metricGroup{}
+	byDimensions := map[string]*metricGroup{}
+
+	for _, entry := range m.entries {
+		key := dimensionsKey(entry.dimensions)
+		group, ok := byDimensions[key]
+		if !ok {
+			group = &metricGroup{
+				dimensions: entry.dimensions,
+				units:      map[string]string{},
+				names:      []string{},
+				values:     map[string][]float64{},
+			}
+			byDimensions[key] = group
+			groups = append(groups, group)
+		}
+
+		if _, ok := group.units[entry.name]; !ok {
+			group.units[entry.name] = entry.unit
+			group.names = append(group.names, entry.name)
+		}
+		group.values[entry.name] = append(group.values[entry.name], entry.value)
+	}
+
+	documents := []map[string]interface{}{}
+	for _, group := range groups {
+		documents = append(documents, group.Document(now))
+	}
+	return documents
+}
+
+// Metrics.Entries returns a copy of the values put since the last flush.
+func (m *Metrics) Entries() []metricEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.entries)
+}
+
+// Metrics.Flush writes the metrics to w as json lines, which cloudwatch picks up from the function
+// logs, and clears them.
+func (m *Metrics) Flush(w io.Writer, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	encoder := json.NewEncoder(w)
+	for _, document := range m.documents(now) {
+		err := encoder.Encode(document)
+		if err != nil {
+			return err
+		}
+	}
+	m.entries = []metricEntry{}
+	return nil
+}
+
+// flushMetrics writes the invocation's metrics to stdout, and adds them to the registry when
+// running as the standalone server.
+func flushMetrics() {
+	if registry != nil {
+		registry.Observe(metrics.Entries())
+	}
+	err := metrics.Flush(os.Stdout, time.Now())
+	if err != nil {
+		logger.Error("error while flushing metrics", "error", err)
+	}
+}
+
+// recordDynamoMetrics is attached to the dynamodb client, and records the latency and any error of
+// every operation it completes.
+func recordDynamoMetrics(r *request.Request) {
+	dimensions := map[string]string{"Operation": r.Operation.Name}
+	metrics.PutWith("DynamoDBLatency", float64(time.Since(r.Time).Milliseconds()), unitMilliseconds, dimensions)
+	if r.Error != nil {
+		metrics.PutWith("DynamoDBErrors", 1, unitCount, dimensions)
+	}
+}
+
+// recordRequestMetrics records the outcome of a request against its route.
+func recordRequestMetrics(res events.APIGatewayProxyResponse, err error, duration time.Duration) {
+	serverErrors, clientErrors := 0.0, 0.0
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		serverErrors = 1
+	} else if res.StatusCode >= http.StatusBadRequest {
+		clientErrors = 1
+	}
+
+	metrics.Put("Requests", 1, unitCount)
+	metrics.Put("Latency", float64(duration.Milliseconds()), unitMilliseconds)
+	metrics.Put("ResponseBytes", float64(len(res.Body)), unitBytes)
+	metrics.Put("Errors", serverErrors, unitCount)
+	metrics.Put("ClientErrors", clientErrors, unitCount)
+}
+
+// recordRunMetrics records what an update run fetched, changed and wrote.
+func recordRunMetrics(run *UpdateRun) {
+	if run.UpstreamPricesMs > 0 {
+		metrics.PutWith("UpstreamLatency", float64(run.UpstreamPricesMs), unitMilliseconds, map[string]string{"Endpoint": updatePrices})
+	}
+	if run.UpstreamSitesMs > 0 {
+		metrics.PutWith("UpstreamLatency", float64(run.UpstreamSitesMs), unitMilliseconds, map[string]string{"Endpoint": updateSites})
+	}
+
+	failed := 0.0
+	if run.Status == runFailed {
+		failed = 1
+	}
+
+	metrics.Put("PricesFetched", float64(run.PricesFetched), unitCount)
+	metrics.Put("PricesChanged", float64(run.PricesChanged), unitCount)
+	metrics.Put("PricesWritten", float64(run.PricesWritten), unitCount)
+	metrics.Put("SitesFetched", float64(run.SitesFetched), unitCount)
+	metrics.Put("SitesChanged", float64(run.SitesChanged), unitCount)
+	metrics.Put("SitesWritten", float64(run.SitesWritten), unitCount)
+	metrics.Put("BatchRetries", float64(run.Retries), unitCount)
+	metrics.Put("FailedRuns", failed, unitCount)
+}
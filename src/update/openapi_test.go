@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+func TestUpdateSpecLoads(t *testing.T) {
+	if openapiErr != nil {
+		t.Fatalf("expected the spec to load: %v", openapiErr)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	withAdminSecret(t, "secret")
+	now := time.Now()
+	limit := func(limit string) events.APIGatewayProxyRequest {
+		request := signedRequest("secret", "/update/runs", now)
+		request.QueryStringParameters = map[string]string{"limit": limit}
+		return request
+	}
+
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		valid   bool
+	}{
+		{"update", signedRequest("secret", "/update", now), true},
+		{"status", signedRequest("secret", "/update/status", now), true},
+		{"runs", limit("20"), true},
+		{"runs over the cap", limit("500"), true},
+		{"negative limit", limit("-1"), false},
+		{"limit that isn't a number", limit("ten"), false},
+		{"unknown path", signedRequest("secret", "/update/everything", now), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := withValidation(context.Background(), test.request, func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid := res.StatusCode == http.StatusOK; valid != test.valid {
+				t.Errorf("expected valid to be %t, got %d: %s", test.valid, res.StatusCode, res.Body)
+			}
+		})
+	}
+}
+
+func TestValidationFailsClosed(t *testing.T) {
+	_, err := newOpenAPIRouter([]byte(`{"openapi": "3.0.3", "paths": {}}`))
+	if err == nil {
+		t.Fatal("expected a spec without info to be invalid")
+	}
+
+	previous := openapiErr
+	openapiErr = err
+	t.Cleanup(func() { openapiErr = previous })
+
+	withAdminSecret(t, "secret")
+	res, _ := handler(context.Background(), signedRequest("secret", "/update/status", time.Now()))
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected requests to be refused without a spec, got %d", res.StatusCode)
+	}
+}
+
+func TestUpdateContract(t *testing.T) {
+	now := time.Now()
+
+	failed := newUpdateRun("b", triggerScheduled, now)
+	failed.Finish(errUpdateRunning, now)
+	succeeded := newUpdateRun("a", triggerManual, now.Add(-time.Hour))
+	succeeded.PricesFetched = 120
+	succeeded.Finish(nil, now.Add(-time.Hour))
+	runs := []UpdateRun{*failed, *succeeded}
+
+	respond := func(status int, obj interface{}) events.APIGatewayProxyResponse {
+		res, err := respondWithJson(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.StatusCode = status
+		return res
+	}
+	handle := func(request events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+		res, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	withAdminSecret(t, "secret")
+	invalidLimit := signedRequest("secret", "/update/runs", now)
+	invalidLimit.QueryStringParameters = map[string]string{"limit": "-1"}
+
+	tests := []struct {
+		name     string
+		path     string
+		response events.APIGatewayProxyResponse
+	}{
+		{"unsigned update", "/update", handle(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/update"})},
+		{"update", "/update", respond(http.StatusAccepted, succeeded)},
+		{"status", "/update/status", respond(http.StatusOK, summariseRuns(servedRuns(runs), now))},
+		{"unsigned status", "/update/status", handle(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/update/status"})},
+		{"status without runs", "/update/status", respond(http.StatusOK, summariseRuns(nil, now))},
+		{"runs", "/update/runs", respond(http.StatusOK, servedRuns(runs))},
+		{"unsigned runs", "/update/runs", handle(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/update/runs"})},
+		{"invalid limit", "/update/runs", handle(invalidLimit)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			route, pathParams, err := openapiRouter.FindRoute(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header := http.Header{}
+			for key, value := range test.response.Headers {
+				header.Set(key, value)
+			}
+			err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req, PathParams: pathParams, Route: route},
+				Status:                 test.response.StatusCode,
+				Header:                 header,
+				Body:                   io.NopCloser(bytes.NewReader([]byte(test.response.Body))),
+				Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+			})
+			if err != nil {
+				t.Errorf("responded with %d against the spec: %v", test.response.StatusCode, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	referenceTableName string = "reference_names"
+
+	referenceFuels   string = "fuels"
+	referenceBrands  string = "brands"
+	referenceRegions string = "regions"
+
+	// siteRegionLevel is the level of the geographic regions sites are grouped by, the level below
+	// the state.
+	siteRegionLevel int = 2
+)
+
+// SA_FuelTypeList is the raw json representation of the fuel types.
+type SA_FuelTypeList struct {
+	Fuels []SA_FuelType `json:"Fuels"`
+}
+
+// SA_FuelType is the raw fuel type data.
+type SA_FuelType struct {
+	FuelId int    `json:"FuelId"`
+	Name   string `json:"Name"`
+}
+
+// SA_BrandList is the raw json representation of the brands.
+type SA_BrandList struct {
+	Brands []SA_Brand `json:"Brands"`
+}
+
+// SA_Brand is the raw brand data.
+type SA_Brand struct {
+	BrandId int    `json:"BrandId"`
+	Name    string `json:"Name"`
+}
+
+// SA_GeographicRegionList is the raw json representation of the geographic regions.
+type SA_GeographicRegionList struct {
+	Regions []SA_GeographicRegion `json:"GeographicRegions"`
+}
+
+// SA_GeographicRegion is the raw geographic region data.
+type SA_GeographicRegion struct {
+	GeoRegionLevel    int    `json:"GeoRegionLevel"`
+	GeoRegionId       int    `json:"GeoRegionId"`
+	Name              string `json:"Name"`
+	Abbrev            string `json:"Abbrev"`
+	GeoRegionParentId int    `json:"GeoRegionParentId"`
+}
+
+// ReferenceNames maps the ids of one kind of reference data, fuel types, brands or regions, to their names.
+type ReferenceNames struct {
+	Kind  string
+	Names map[int]string
+}
+
+// SA_FuelTypeList.ToReferenceNames returns the fuel type names by fuel id.
+func (fuels SA_FuelTypeList) ToReferenceNames() ReferenceNames {
+	names := ReferenceNames{Kind: referenceFuels, Names: map[int]string{}}
+	for _, fuel := range fuels.Fuels {
+		names.Names[fuel.FuelId] = fuel.Name
+	}
+	return names
+}
+
+// SA_BrandList.ToReferenceNames returns the brand names by brand id.
+func (brands SA_BrandList) ToReferenceNames() ReferenceNames {
+	names := ReferenceNames{Kind: referenceBrands, Names: map[int]string{}}
+	for _, brand := range brands.Brands {
+		names.Names[brand.BrandId] = brand.Name
+	}
+	return names
+}
+
+// SA_GeographicRegionList.ToReferenceNames returns the names of the regions sites are grouped by,
+// by region id.
+func (regions SA_GeographicRegionList) ToReferenceNames() ReferenceNames {
+	names := ReferenceNames{Kind: referenceRegions, Names: map[int]string{}}
+	for _, region := range regions.Regions {
+		if region.GeoRegionLevel == siteRegionLevel {
+			names.Names[region.GeoRegionId] = region.Name
+		}
+	}
+	return names
+}
+
+// ReferenceNames.Marshal returns a dynamodb representation of the ReferenceNames struct.
+func (names ReferenceNames) Marshal() map[string]*dynamodb.AttributeValue {
+	namesRecord := map[string]*dynamodb.AttributeValue{}
+	for id, name := range names.Names {
+		namesRecord[strconv.Itoa(id)] = &dynamodb.AttributeValue{S: aws.String(name)}
+	}
+
+	return map[string]*dynamodb.AttributeValue{
+		"Kind":  {S: aws.String(names.Kind)},
+		"Names": {M: namesRecord},
+	}
+}
+
+func createReferenceTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new reference table")
+
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(referenceTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("Kind"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("Kind"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(2),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return err
+		}
+	}
+
+	return client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(referenceTableName),
+	})
+}
+
+// getReferenceNames refreshes the fuel type, brand and region names, they change rarely so they are
+// updated along with the sites.
+func getReferenceNames(ctx context.Context, dbClient *dynamodb.DynamoDB) (err error) {
+	ctx, span := startSpan(ctx, "getReferenceNames")
+	defer func() { endSpan(span, err) }()
+
+	if !checkTableExists(ctx, dbClient, referenceTableName) {
+		err := createReferenceTable(ctx, dbClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	var fuels SA_FuelTypeList
+	err = sendJsonRequest(ctx, fuelURL+"/Subscriber/GetCountryFuelTypes?"+countryQuery(), &fuels)
+	if err != nil {
+		return err
+	}
+
+	var brands SA_BrandList
+	err = sendJsonRequest(ctx, fuelURL+"/Subscriber/GetCountryBrands?"+countryQuery(), &brands)
+	if err != nil {
+		return err
+	}
+
+	var regions SA_GeographicRegionList
+	err = sendJsonRequest(ctx, fuelURL+"/Subscriber/GetCountryGeographicRegions?"+countryQuery(), &regions)
+	if err != nil {
+		return err
+	}
+
+	for _, names := range []ReferenceNames{fuels.ToReferenceNames(), brands.ToReferenceNames(), regions.ToReferenceNames()} {
+		_, err = dbClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(referenceTableName),
+			Item:      names.Marshal(),
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info("updated reference names", "kind", names.Kind, "names", len(names.Names))
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReferenceNames(t *testing.T) {
+	var fuels SA_FuelTypeList
+	err := json.Unmarshal([]byte(`{"Fuels":[{"FuelId":2,"Name":"Unleaded"},{"FuelId":3,"Name":"Diesel"}]}`), &fuels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := fuels.ToReferenceNames()
+	if names.Kind != referenceFuels {
+		t.Errorf("unexpected kind: %s", names.Kind)
+	}
+	if names.Names[2] != "Unleaded" || names.Names[3] != "Diesel" {
+		t.Errorf("unexpected fuel names: %v", names.Names)
+	}
+
+	var brands SA_BrandList
+	err = json.Unmarshal([]byte(`{"Brands":[{"BrandId":5,"Name":"Shell"}]}`), &brands)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := brands.ToReferenceNames().Marshal()
+	if *item["Kind"].S != referenceBrands {
+		t.Errorf("unexpected kind: %s", *item["Kind"].S)
+	}
+	if *item["Names"].M["5"].S != "Shell" {
+		t.Errorf("unexpected brand name: %s", *item["Names"].M["5"].S)
+	}
+}
+
+func TestRegionReferenceNames(t *testing.T) {
+	var regions SA_GeographicRegionList
+	err := json.Unmarshal([]byte(`{"GeographicRegions":[
+		{"GeoRegionLevel":1,"GeoRegionId":100,"Name":"Adelaide","Abbrev":"ADL","GeoRegionParentId":12},
+		{"GeoRegionLevel":2,"GeoRegionId":12,"Name":"Metro","Abbrev":"MET","GeoRegionParentId":4},
+		{"GeoRegionLevel":3,"GeoRegionId":4,"Name":"South Australia","Abbrev":"SA","GeoRegionParentId":21}
+	]}`), &regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := regions.ToReferenceNames()
+	if names.Kind != referenceRegions {
+		t.Errorf("unexpected kind: %s", names.Kind)
+	}
+	if len(names.Names) != 1 || names.Names[12] != "Metro" {
+		t.Errorf("expected only the level %d regions, got %v", siteRegionLevel, names.Names)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const metricsPath string = "/metrics"
+
+// serverAddr is the address the standalone server listens on, e.g. ":8080". the binary runs as a
+// lambda when it isn't set.
+var serverAddr string = os.Getenv("server_addr")
+
+// serverRoutes are the api gateway routes the template sends to this lambda. the standalone server
+// matches requests against the same resources, so they're logged and measured the same way.
+var serverRoutes = []struct {
+	method   string
+	resource string
+}{
+	{http.MethodGet, "/update"},
+	{http.MethodGet, "/update/status"},
+	{http.MethodGet, "/update/runs"},
+}
+
+// serverSchedules are the template's schedules, the standalone server sends itself the same
+// payloads on the same intervals.
+var serverSchedules = []struct {
+	every   time.Duration
+	payload string
+}{
+	{15 * time.Minute, `{"update": "prices"}`},
+	{24 * time.Hour, `{"update": "sites"}`},
+}
+
+var resourceParamRegex = regexp.MustCompile(`\{([^}+]+)(\+?)\}`)
+
+var (
+	// invocations makes the standalone server handle one request or scheduled update at a time,
+	// the logger and metrics are swapped for each one the same way they are between lambda
+	// invocations.
+	invocations sync.Mutex
+	requestSeq  int
+)
+
+// resourcePattern returns the resource as a http.ServeMux pattern, and the names of its params.
+// a greedy {proxy+} param matches the rest of the path, the same as in api gateway.
+func resourcePattern(method string, resource string) (string, []string) {
+	names := []string{}
+	pattern := resourceParamRegex.ReplaceAllStringFunc(resource, func(param string) string {
+		match := resourceParamRegex.FindStringSubmatch(param)
+		names = append(names, match[1])
+		if match[2] == "+" {
+			return "{" + match[1] + "...}"
+		}
+		return param
+	})
+	return method + " " + pattern, names
+}
+
+// proxyRequest returns the http request as the event api gateway would send the lambda for it.
+func proxyRequest(r *http.Request, resource string, params []string) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	request := events.APIGatewayProxyRequest{
+		Resource:                        resource,
+		Path:                            r.URL.Path,
+		HTTPMethod:                      r.Method,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+		PathParameters:                  map[string]string{},
+		Body:                            string(body),
+	}
+	// like api gateway, the single value maps hold the last value that was sent.
+	for name, values := range r.Header {
+		request.Headers[name] = values[len(values)-1]
+		request.MultiValueHeaders[name] = values
+	}
+	for name, values := range r.URL.Query() {
+		request.QueryStringParameters[name] = values[len(values)-1]
+		request.MultiValueQueryStringParameters[name] = values
+	}
+	for _, name := range params {
+		request.PathParameters[name] = r.PathValue(name)
+	}
+	if !utf8.Valid(body) {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+	return request, nil
+}
+
+// writeProxyResponse writes the lambda's response, decoding its body if it was base64 encoded.
+func writeProxyResponse(w http.ResponseWriter, res events.APIGatewayProxyResponse) {
+	body := []byte(res.Body)
+	if res.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(res.Body)
+		if err != nil {
+			logger.Error("error while decoding response body", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		body = decoded
+	}
+
+	for name, value := range res.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range res.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// serveResource passes requests for the resource on to the lambda's handler.
+func serveResource(resource string, params []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := proxyRequest(r, resource, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		invocations.Lock()
+		defer invocations.Unlock()
+		requestSeq++
+		request.RequestContext.RequestID = "standalone-" + strconv.Itoa(requestSeq)
+
+		payload, err := json.Marshal(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res, err := invoke(r.Context(), payload)
+		if err != nil {
+			// api gateway answers a failed invocation the same way.
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`{"message": "Internal server error"}`))
+			return
+		}
+		writeProxyResponse(w, res)
+	}
+}
+
+// runSchedule sends the payload to the lambda's entrypoint every interval, until ctx is done.
+func runSchedule(ctx context.Context, every time.Duration, payload string) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		invocations.Lock()
+		_, err := invoke(ctx, json.RawMessage(payload))
+		invocations.Unlock()
+		if err != nil {
+			baseLogger.Error("scheduled update failed", "payload", payload, "error", err)
+		}
+	}
+}
+
+// serveMetrics writes the totals of the metrics every request has flushed so far.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	err := registry.Write(w)
+	if err != nil {
+		baseLogger.Error("error while writing metrics", "error", err)
+	}
+}
+
+// newServerMux routes the template's resources to the handler, and /metrics to the registry.
+func newServerMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range serverRoutes {
+		pattern, params := resourcePattern(route.method, route.resource)
+		mux.HandleFunc(pattern, serveResource(route.resource, params))
+	}
+	mux.HandleFunc(http.MethodGet+" "+metricsPath, serveMetrics)
+	return mux
+}
+
+// serve runs the update routes as a standalone http server rather than a lambda, with a prometheus
+// endpoint for its metrics. the schedules the template would have set up are run by the server.
+func serve(addr string) error {
+	registry = newRegistry()
+	for _, schedule := range serverSchedules {
+		go runSchedule(context.Background(), schedule.every, schedule.payload)
+	}
+	baseLogger.Info("serving", "addr", addr)
+	return http.ListenAndServe(addr, newServerMux())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	registry = newRegistry()
+	defer func() { registry = nil }()
+	server := httptest.NewServer(newServerMux())
+	defer server.Close()
+
+	// unsigned requests are turned away before anything is run.
+	res, err := http.Get(server.URL + "/update/status?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an unsigned request to be unauthorized, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(server.URL + "/prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected routes outside the template to be not found, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(server.URL + metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	for _, line := range []string{
+		`petrol_requests_total{route="GET /update/status"} 1`,
+		`petrol_client_errors_total{route="GET /update/status"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %s, got:\n%s", line, body)
+		}
+	}
+}
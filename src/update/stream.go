@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const connectionsTableName string = "stream_connections"
+
+// streamEndpoint is the management endpoint of the websocket api, the stream is off without it.
+var streamEndpoint string = os.Getenv("stream_endpoint")
+
+// StreamConnection is a websocket connected to the price stream, with the filters it subscribed
+// with. empty filters match everything.
+type StreamConnection struct {
+	ConnectionId string
+	FuelIds      []int
+	SiteIds      []int
+	BBox         *[4]float64
+}
+
+// StreamPrice is a changed price as it is pushed to the stream.
+type StreamPrice struct {
+	SiteId             int    `json:"SiteId"`
+	FuelId             int    `json:"FuelId"`
+	Price              int    `json:"Price"`
+	TransactionDateUTC string `json:"TransactionDateUTC"`
+}
+
+// StreamEvent is a message pushed to stream connections.
+type StreamEvent struct {
+	Type   string        `json:"Type"`
+	RunId  string        `json:"RunId"`
+	Prices []StreamPrice `json:"Prices"`
+}
+
+func (conn *StreamConnection) Unmarshal(record map[string]*dynamodb.AttributeValue) error {
+	connectionIdRecord, ok := record["ConnectionId"]
+	if !ok {
+		return errors.New("connection record is missing ConnectionId")
+	}
+	conn.ConnectionId = aws.StringValue(connectionIdRecord.S)
+
+	lists := map[string]*[]int{"FuelIds": &conn.FuelIds, "SiteIds": &conn.SiteIds}
+	for name, value := range lists {
+		*value = []int{}
+		if listRecord, ok := record[name]; ok {
+			for _, idRecord := range listRecord.L {
+				id, err := strconv.Atoi(aws.StringValue(idRecord.N))
+				if err != nil {
+					return err
+				}
+				*value = append(*value, id)
+			}
+		}
+	}
+
+	// the box is stored as minLng,minLat,maxLng,maxLat, as it was sent.
+	if bboxRecord, ok := record["BBox"]; ok {
+		parts := strings.Split(aws.StringValue(bboxRecord.S), ",")
+		if len(parts) != 4 {
+			return errors.New("connection record has an invalid BBox")
+		}
+		bbox := [4]float64{}
+		for i, part := range parts {
+			value, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return err
+			}
+			bbox[i] = value
+		}
+		conn.BBox = &bbox
+	}
+
+	return nil
+}
+
+// StreamConnection.Matches reports whether the connection's filters let the price through.
+func (conn StreamConnection) Matches(price StreamPrice, site PetrolStationSite, located bool) bool {
+	if len(conn.FuelIds) > 0 && !slices.Contains(conn.FuelIds, price.FuelId) {
+		return false
+	}
+	if len(conn.SiteIds) > 0 && !slices.Contains(conn.SiteIds, price.SiteId) {
+		return false
+	}
+	if conn.BBox != nil {
+		bbox := *conn.BBox
+		if !located || site.Longitude < bbox[0] || site.Latitude < bbox[1] || site.Longitude > bbox[2] || site.Latitude > bbox[3] {
+			return false
+		}
+	}
+	return true
+}
+
+// changedPrices returns the fuel prices in changed that differ from the stored ones, ordered by
+// site and fuel type. placeholder prices are left out, they aren't prices anyone can buy at.
+func changedPrices(stored FuelPriceList, changed FuelPriceList) []StreamPrice {
+	prices := []StreamPrice{}
+	for siteId, station := range changed.Sites {
+		for fuelId, price := range station.FuelTypes {
+			if storedPrice, ok := stored.Sites[siteId].FuelTypes[fuelId]; ok && storedPrice == price {
+				continue
+			}
+			if price.Price >= placeholderPrice {
+				continue
+			}
+			prices = append(prices, StreamPrice{
+				SiteId:             siteId,
+				FuelId:             fuelId,
+				Price:              price.Price,
+				TransactionDateUTC: price.TransactionDateUTC,
+			})
+		}
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		if prices[i].SiteId != prices[j].SiteId {
+			return prices[i].SiteId < prices[j].SiteId
+		}
+		return prices[i].FuelId < prices[j].FuelId
+	})
+	return prices
+}
+
+// streamPrices returns the prices the connection subscribed to.
+func streamPrices(conn StreamConnection, prices []StreamPrice, sites map[int]PetrolStationSite) []StreamPrice {
+	matched := []StreamPrice{}
+	for _, price := range prices {
+		site, located := sites[price.SiteId]
+		if conn.Matches(price, site, located) {
+			matched = append(matched, price)
+		}
+	}
+	return matched
+}
+
+// getStreamConnections returns the connected websockets, there being no connections table yet is
+// the same as there being no connections.
+func getStreamConnections(ctx context.Context, dbClient *dynamodb.DynamoDB) ([]StreamConnection, error) {
+	connections := []StreamConnection{}
+	records, err := scanTable(ctx, dbClient, connectionsTableName)
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return connections, nil
+		}
+		return nil, err
+	}
+
+	for _, record := range records {
+		var conn StreamConnection
+		err = conn.Unmarshal(record)
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, conn)
+	}
+	return connections, nil
+}
+
+// pushPriceChanges sends the prices that changed in the run to the stream connections that
+// subscribed to them. connections that have gone away are removed. like the webhooks, a failed
+// push is logged rather than failing the run.
+func pushPriceChanges(ctx context.Context, dbClient *dynamodb.DynamoDB, stored FuelPriceList, changed FuelPriceList, sites PetrolStationList, run *UpdateRun) {
+	if streamEndpoint == "" {
+		return
+	}
+
+	prices := changedPrices(stored, changed)
+	if len(prices) == 0 {
+		return
+	}
+
+	ctx, span := startSpan(ctx, "pushPriceChanges", attribute.Int("prices", len(prices)))
+	defer span.End()
+
+	connections, err := getStreamConnections(ctx, dbClient)
+	if err != nil {
+		logger.Error("error while getting stream connections", "error", err)
+		return
+	}
+
+	awsSession, err := session.NewSession()
+	if err != nil {
+		logger.Error("error while creating stream client", "error", err)
+		return
+	}
+	client := apigatewaymanagementapi.New(awsSession, aws.NewConfig().WithRegion(region).WithEndpoint(streamEndpoint))
+
+	locations := map[int]PetrolStationSite{}
+	for _, site := range sites.Sites {
+		locations[site.SiteID] = site
+	}
+
+	pushed, gone := 0, 0
+	for _, conn := range connections {
+		matched := streamPrices(conn, prices, locations)
+		if len(matched) == 0 {
+			continue
+		}
+
+		data, err := json.Marshal(StreamEvent{Type: eventPriceChanged, RunId: run.RunId, Prices: matched})
+		if err != nil {
+			logger.Error("error while marshalling stream event", "error", err)
+			return
+		}
+
+		_, err = client.PostToConnectionWithContext(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+			ConnectionId: aws.String(conn.ConnectionId),
+			Data:         data,
+		})
+		if err != nil {
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) || aerr.Code() != apigatewaymanagementapi.ErrCodeGoneException {
+				logger.Error("error while pushing to stream connection", "connection_id", conn.ConnectionId, "error", err)
+				continue
+			}
+
+			gone++
+			_, err = dbClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+				TableName: aws.String(connectionsTableName),
+				Key:       map[string]*dynamodb.AttributeValue{"ConnectionId": {S: aws.String(conn.ConnectionId)}},
+			})
+			if err != nil {
+				logger.Error("error while removing stream connection", "connection_id", conn.ConnectionId, "error", err)
+			}
+			continue
+		}
+		pushed++
+	}
+
+	logger.Info("pushed price changes", "connections", len(connections), "pushed", pushed, "gone", gone)
+}
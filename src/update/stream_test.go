@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestChangedPrices(t *testing.T) {
+	stored := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1850, TransactionDateUTC: "a"}, 3: {Price: 1950, TransactionDateUTC: "a"}}},
+	}}
+	changed := FuelPriceList{Sites: map[int]FuelStation{
+		1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {Price: 1850, TransactionDateUTC: "a"}, 3: {Price: 1900, TransactionDateUTC: "b"}}},
+		2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {Price: 1799, TransactionDateUTC: "b"}, 5: {Price: 9999, TransactionDateUTC: "b"}}},
+	}}
+
+	prices := changedPrices(stored, changed)
+	expected := []StreamPrice{{SiteId: 1, FuelId: 3, Price: 1900, TransactionDateUTC: "b"}, {SiteId: 2, FuelId: 2, Price: 1799, TransactionDateUTC: "b"}}
+	if len(prices) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, prices)
+	}
+	for i := range expected {
+		if prices[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], prices[i])
+		}
+	}
+}
+
+func TestStreamPrices(t *testing.T) {
+	sites := map[int]PetrolStationSite{
+		1: {SiteID: 1, Latitude: -34.93, Longitude: 138.60},
+		2: {SiteID: 2, Latitude: -31.95, Longitude: 115.86},
+	}
+	prices := []StreamPrice{{SiteId: 1, FuelId: 2}, {SiteId: 1, FuelId: 3}, {SiteId: 2, FuelId: 2}, {SiteId: 3, FuelId: 2}}
+	adelaide := [4]float64{138.4, -35.1, 138.8, -34.7}
+
+	tests := []struct {
+		name     string
+		conn     StreamConnection
+		expected int
+	}{
+		{"no filters", StreamConnection{}, 4},
+		{"fuel types", StreamConnection{FuelIds: []int{2}}, 3},
+		{"sites", StreamConnection{SiteIds: []int{1}}, 2},
+		{"bbox", StreamConnection{BBox: &adelaide}, 2},
+		{"everything", StreamConnection{FuelIds: []int{3}, SiteIds: []int{1, 2}, BBox: &adelaide}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := streamPrices(test.conn, prices, sites); len(matched) != test.expected {
+				t.Errorf("expected %d prices, got %+v", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestStreamConnectionUnmarshal(t *testing.T) {
+	var conn StreamConnection
+	err := conn.Unmarshal(map[string]*dynamodb.AttributeValue{
+		"ConnectionId": {S: aws.String("abc=")},
+		"FuelIds":      {L: []*dynamodb.AttributeValue{{N: aws.String("2")}}},
+		"BBox":         {S: aws.String("138.4,-35.1,138.8,-34.7")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.ConnectionId != "abc=" || len(conn.FuelIds) != 1 || len(conn.SiteIds) != 0 || conn.BBox == nil || conn.BBox[1] != -35.1 {
+		t.Errorf("unexpected connection: %+v", conn)
+	}
+}
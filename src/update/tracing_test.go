@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testCollector is an in-process otlp/http collector that keeps every span it receives.
+type testCollector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var export collectortrace.ExportTraceServiceRequest
+	err = proto.Unmarshal(body, &export)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resourceSpans := range export.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+
+	res, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(res)
+}
+
+func (c *testCollector) Span(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// withTestCollector points the tracer at an in-process collector for the length of the test.
+func withTestCollector(t *testing.T) *testCollector {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	t.Cleanup(server.Close)
+
+	provider, err := newTracerProvider(context.Background(), server.URL+"/v1/traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousProvider, previousTracer := tracerProvider, tracer
+	tracerProvider, tracer = provider, provider.Tracer(tracerName)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		tracerProvider, tracer = previousProvider, previousTracer
+	})
+
+	return collector
+}
+
+func TestInvocationSpan(t *testing.T) {
+	collector := withTestCollector(t)
+
+	_, err := invoke(context.Background(), json.RawMessage(`{"update":"everything"}`))
+	if err == nil {
+		t.Fatal("expected an unknown update target to fail")
+	}
+
+	span := collector.Span("invoke everything")
+	if span == nil {
+		t.Fatal("expected a span for the invocation")
+	}
+	if span.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("expected a server span, got %s", span.Kind)
+	}
+	if span.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("expected the invocation span to be an error, got %s", span.Status.Code)
+	}
+}
+
+func TestUpstreamSpan(t *testing.T) {
+	collector := withTestCollector(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"S":[]}`))
+	}))
+	defer upstream.Close()
+
+	ctx, span := startSpan(context.Background(), "test")
+	var sites PetrolStationList
+	err := sendJsonRequest(ctx, upstream.URL+"/Subscriber/GetFullSiteDetails", &sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+	flushTraces(context.Background())
+
+	parent := collector.Span("test")
+	if parent == nil {
+		t.Fatal("expected the parent span")
+	}
+
+	request := collector.Span("GET " + strings.TrimPrefix(upstream.URL, "http://"))
+	if request == nil {
+		t.Fatal("expected a span for the upstream request")
+	}
+	if request.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Errorf("expected a client span, got %s", request.Kind)
+	}
+	if string(request.ParentSpanId) != string(parent.SpanId) {
+		t.Error("expected the upstream span to be a child of the caller's span")
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
+	"maps"
+	"sort"
 	"strconv"
 
+	"github.com/shopspring/decimal"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
@@ -173,8 +177,183 @@ func (prices FuelPriceList) Marshal() ([]map[string]*dynamodb.AttributeValue, er
 }
 
 func (p *FuelPriceList) Unmarshal(records []map[string]*dynamodb.AttributeValue) error {
-	p = &FuelPriceList{
+	p.Sites = map[int]FuelStation{}
+
+	for _, record := range records {
+		var site FuelStation
+		err := site.Unmarshal(record)
+		if err != nil {
+			return err
+		}
+
+		p.Sites[site.SiteID] = site
+	}
+
+	return nil
+}
+
+// FuelStation.Equal reports whether both stations have the same prices for the same fuel types.
+func (site FuelStation) Equal(other FuelStation) bool {
+	return site.SiteID == other.SiteID && maps.Equal(site.FuelTypes, other.FuelTypes)
+}
+
+// FuelPriceList.Changed returns the stations in latest that are new or have different prices to
+// the ones in this list.
+func (prices FuelPriceList) Changed(latest FuelPriceList) FuelPriceList {
+	changed := FuelPriceList{
 		Sites: map[int]FuelStation{},
 	}
+
+	for siteId, site := range latest.Sites {
+		if stored, ok := prices.Sites[siteId]; ok && stored.Equal(site) {
+			continue
+		}
+		changed.Sites[siteId] = site
+	}
+
+	return changed
+}
+
+// PetrolStationSite.Marshal returns a dynamodb representation of the PetrolStationSite struct.
+func (site PetrolStationSite) Marshal() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"SiteId": {N: aws.String(fmt.Sprintf("%d", site.SiteID))},
+		"A":      {S: aws.String(site.Address)},
+		"N":      {S: aws.String(site.Name)},
+		"B":      {N: aws.String(fmt.Sprintf("%d", site.BrandID))},
+		"P":      {S: aws.String(site.Postcode)},
+		"G":      {S: aws.String(site.GooglePlaceID)},
+		"R":      {N: aws.String(fmt.Sprintf("%d", site.RegionID))},
+		"Lt":     {N: aws.String(decimal.NewFromFloat(site.Latitude).String())},
+		"Lg":     {N: aws.String(decimal.NewFromFloat(site.Longitude).String())},
+	}
+}
+
+func (site *PetrolStationSite) Unmarshal(record map[string]*dynamodb.AttributeValue) (err error) {
+	siteIdRecord, ok := record["SiteId"]
+	if !ok {
+		return nil
+	}
+	site.SiteID, err = strconv.Atoi(*siteIdRecord.N)
+	if err != nil {
+		return err
+	}
+
+	if addressRecord, ok := record["A"]; ok {
+		site.Address = *addressRecord.S
+	}
+	if nameRecord, ok := record["N"]; ok {
+		site.Name = *nameRecord.S
+	}
+	if brandRecord, ok := record["B"]; ok {
+		site.BrandID, err = strconv.Atoi(*brandRecord.N)
+		if err != nil {
+			return err
+		}
+	}
+	if postcodeRecord, ok := record["P"]; ok {
+		site.Postcode = *postcodeRecord.S
+	}
+	if googlePlaceRecord, ok := record["G"]; ok {
+		site.GooglePlaceID = *googlePlaceRecord.S
+	}
+	if regionRecord, ok := record["R"]; ok {
+		site.RegionID, err = strconv.Atoi(*regionRecord.N)
+		if err != nil {
+			return err
+		}
+	}
+	if latRecord, ok := record["Lt"]; ok {
+		site.Latitude, err = strconv.ParseFloat(*latRecord.N, 64)
+		if err != nil {
+			return err
+		}
+	}
+	if lngRecord, ok := record["Lg"]; ok {
+		site.Longitude, err = strconv.ParseFloat(*lngRecord.N, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PetrolStationList.Marshal returns a dynamodb representation of the PetrolStationList struct.
+func (sites PetrolStationList) Marshal() []map[string]*dynamodb.AttributeValue {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, site := range sites.Sites {
+		items = append(items, site.Marshal())
+	}
+	return items
+}
+
+func (sites *PetrolStationList) Unmarshal(records []map[string]*dynamodb.AttributeValue) error {
+	sites.Sites = []PetrolStationSite{}
+
+	for _, record := range records {
+		var site PetrolStationSite
+		err := site.Unmarshal(record)
+		if err != nil {
+			return err
+		}
+		sites.Sites = append(sites.Sites, site)
+	}
+
 	return nil
 }
+
+// PetrolStationList.Changed returns the sites in latest that are new or differ from the ones in
+// this list.
+func (sites PetrolStationList) Changed(latest PetrolStationList) PetrolStationList {
+	stored := map[int]PetrolStationSite{}
+	for _, site := range sites.Sites {
+		stored[site.SiteID] = site
+	}
+
+	changed := PetrolStationList{
+		Sites: []PetrolStationSite{},
+	}
+	for _, site := range latest.Sites {
+		if storedSite, ok := stored[site.SiteID]; ok && storedSite == site {
+			continue
+		}
+		changed.Sites = append(changed.Sites, site)
+	}
+
+	return changed
+}
+
+// PetrolStationList.Added returns the sites in latest that aren't in this list.
+func (sites PetrolStationList) Added(latest PetrolStationList) []PetrolStationSite {
+	stored := map[int]bool{}
+	for _, site := range sites.Sites {
+		stored[site.SiteID] = true
+	}
+
+	added := []PetrolStationSite{}
+	for _, site := range latest.Sites {
+		if !stored[site.SiteID] {
+			added = append(added, site)
+		}
+	}
+	return added
+}
+
+// PetrolStationList.Removed returns the ids of the sites in this list that are missing from
+// latest, in order.
+func (sites PetrolStationList) Removed(latest PetrolStationList) []int {
+	current := map[int]bool{}
+	for _, site := range latest.Sites {
+		current[site.SiteID] = true
+	}
+
+	removed := []int{}
+	for _, site := range sites.Sites {
+		if !current[site.SiteID] {
+			removed = append(removed, site.SiteID)
+		}
+	}
+	sort.Ints(removed)
+	return removed
+}
@@ -374,3 +374,96 @@ func TestFuelPriceUnmarshalling(t *testing.T) {
 // 		t.Errorf("expected length of FuelTypes to be 1, got %d", len(vM))
 // 	}
 // }
+
+func TestFuelPriceListChanged(t *testing.T) {
+	stored := FuelPriceList{
+		Sites: map[int]FuelStation{
+			0: {SiteID: 0, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 1999}}},
+			1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 1899}}},
+		},
+	}
+	latest := FuelPriceList{
+		Sites: map[int]FuelStation{
+			0: {SiteID: 0, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 1999}}},
+			1: {SiteID: 1, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 1949}}},
+			2: {SiteID: 2, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, Price: 2049}}},
+		},
+	}
+
+	changed := stored.Changed(latest)
+	if len(changed.Sites) != 2 {
+		t.Errorf("expected 2 changed sites, got %d", len(changed.Sites))
+	}
+	if _, ok := changed.Sites[0]; ok {
+		t.Error("expected unchanged site 0 to be skipped")
+	}
+	if changed.Sites[1].FuelTypes[2].Price != 1949 {
+		t.Errorf("expected the latest price for site 1, got %d", changed.Sites[1].FuelTypes[2].Price)
+	}
+}
+
+func TestFuelPriceListUnmarshalling(t *testing.T) {
+	site := FuelStation{SiteID: 7, FuelTypes: map[int]FuelPrice{2: {FuelID: 2, CollectionMethod: "T", TransactionDateUTC: "d", Price: 1999}}}
+	record, err := site.Marshal()
+	if err != nil {
+		t.Error(err)
+	}
+
+	var prices FuelPriceList
+	err = prices.Unmarshal([]map[string]*dynamodb.AttributeValue{record})
+	if err != nil {
+		t.Error(err)
+	}
+	if !prices.Sites[7].Equal(site) {
+		t.Errorf("expected the unmarshalled site to equal the original, got %v", prices.Sites[7])
+	}
+}
+
+func TestPetrolStationSiteMarshalling(t *testing.T) {
+	site := PetrolStationSite{
+		SiteID:        1,
+		Address:       "1 Main St",
+		Name:          "Station",
+		BrandID:       5,
+		Postcode:      "5000",
+		GooglePlaceID: "abc",
+		RegionID:      12,
+		Latitude:      -34.928499,
+		Longitude:     138.600746,
+	}
+
+	var decoded PetrolStationSite
+	err := decoded.Unmarshal(site.Marshal())
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != site {
+		t.Errorf("expected %v, got %v", site, decoded)
+	}
+}
+
+func TestPetrolStationListChanged(t *testing.T) {
+	stored := PetrolStationList{
+		Sites: []PetrolStationSite{
+			{SiteID: 1, Name: "One"},
+			{SiteID: 2, Name: "Two"},
+		},
+	}
+	latest := PetrolStationList{
+		Sites: []PetrolStationSite{
+			{SiteID: 1, Name: "One"},
+			{SiteID: 2, Name: "Two (renamed)"},
+			{SiteID: 3, Name: "Three"},
+		},
+	}
+
+	changed := stored.Changed(latest)
+	if len(changed.Sites) != 2 {
+		t.Errorf("expected 2 changed sites, got %d", len(changed.Sites))
+	}
+	for _, site := range changed.Sites {
+		if site.SiteID == 1 {
+			t.Error("expected unchanged site 1 to be skipped")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	versionsTableName string = "data_versions"
+
+	datasetPrices string = "prices"
+	datasetSites  string = "sites"
+)
+
+// DataVersion marks the last run that changed a dataset, the fetch lambda builds its ETag and
+// Last-Modified headers from it.
+type DataVersion struct {
+	Dataset   string
+	Version   string
+	UpdatedAt time.Time
+}
+
+// DataVersion.Marshal returns a dynamodb representation of the DataVersion struct.
+func (version DataVersion) Marshal() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Dataset":   {S: aws.String(version.Dataset)},
+		"Version":   {S: aws.String(version.Version)},
+		"UpdatedAt": {S: aws.String(version.UpdatedAt.UTC().Format(time.RFC3339))},
+	}
+}
+
+func createVersionsTable(ctx context.Context, client *dynamodb.DynamoDB) error {
+	logger.Info("creating new versions table")
+
+	_, err := client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(versionsTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("Dataset"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("Dataset"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return err
+		}
+	}
+
+	return client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(versionsTableName),
+	})
+}
+
+// putDataVersion records that the run changed the dataset.
+func putDataVersion(ctx context.Context, client *dynamodb.DynamoDB, version DataVersion) error {
+	if !checkTableExists(ctx, client, versionsTableName) {
+		err := createVersionsTable(ctx, client)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(versionsTableName),
+		Item:      version.Marshal(),
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("updated data version", "dataset", version.Dataset, "version", version.Version)
+	return nil
+}
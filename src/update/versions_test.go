@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDataVersionMarshalling(t *testing.T) {
+	version := DataVersion{
+		Dataset:   datasetPrices,
+		Version:   "abc",
+		UpdatedAt: time.Date(2024, 3, 31, 23, 30, 0, 0, time.FixedZone("ACDT", 10*60*60+30*60)),
+	}
+
+	item := version.Marshal()
+	if *item["Dataset"].S != datasetPrices {
+		t.Errorf("unexpected dataset: %s", *item["Dataset"].S)
+	}
+	if *item["Version"].S != "abc" {
+		t.Errorf("unexpected version: %s", *item["Version"].S)
+	}
+	if *item["UpdatedAt"].S != "2024-03-31T13:00:00Z" {
+		t.Errorf("expected UpdatedAt in utc, got %s", *item["UpdatedAt"].S)
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	webhooksTableName    string = "webhooks"
+	deliveriesTableName  string = "webhook_deliveries"
+	deadLettersTableName string = "webhook_dead_letters"
+
+	eventPriceChanged    string = "price.changed"
+	eventSiteAdded       string = "site.added"
+	eventSiteRemoved     string = "site.removed"
+	eventUpdateCompleted string = "update.completed"
+	// eventAll subscribes a webhook to every event.
+	eventAll string = "*"
+
+	deliverySucceeded string = "delivered"
+	deliveryFailed    string = "failed"
+
+	webhookSignatureHeader string = "x-webhook-signature"
+	webhookTimestampHeader string = "x-webhook-timestamp"
+	webhookEventHeader     string = "x-webhook-event"
+	webhookDeliveryHeader  string = "x-webhook-delivery"
+
+	maxDeliveryAttempts int           = 5
+	deliveryBaseDelay   time.Duration = time.Second
+	deliveryTimeout     time.Duration = 10 * time.Second
+	// maxLoggedResponse is how much of a failed response body is kept in the delivery log.
+	maxLoggedResponse int = 512
+)
+
+// blockedPrefixes are the address ranges net.IP doesn't already classify that webhooks mustn't be
+// delivered to: "this network", carrier-grade nat, ietf protocol assignments and benchmarking.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// errPrivateAddress is returned for deliveries to a host that resolves to an internal address.
+var errPrivateAddress = errors.New("webhook host resolves to a private address")
+
+// Webhook is a consumer's registration for events, as registered through the fetch lambda.
+type Webhook struct {
+	Owner     string
+	WebhookId string
+	Url       string
+	Secret    string
+	Events    []string
+}
+
+// WebhookEvent is an event as it is posted to webhooks.
+type WebhookEvent struct {
+	Id        string      `json:"Id"`
+	Type      string      `json:"Type"`
+	CreatedAt time.Time   `json:"CreatedAt"`
+	Data      interface{} `json:"Data"`
+}
+
+// PriceChangedData is the data of a price.changed event, the stations whose prices changed.
+type PriceChangedData struct {
+	Sites []FuelStation `json:"Sites"`
+}
+
+// WebhookSite is a site as it is sent in site.added events.
+type WebhookSite struct {
+	SiteId    int     `json:"SiteId"`
+	Name      string  `json:"Name"`
+	Address   string  `json:"Address"`
+	Postcode  string  `json:"Postcode"`
+	BrandId   int     `json:"BrandId"`
+	RegionId  int     `json:"RegionId"`
+	Latitude  float64 `json:"Lat"`
+	Longitude float64 `json:"Lng"`
+}
+
+// SitesAddedData is the data of a site.added event.
+type SitesAddedData struct {
+	Sites []WebhookSite `json:"Sites"`
+}
+
+// SitesRemovedData is the data of a site.removed event.
+type SitesRemovedData struct {
+	SiteIds []int `json:"SiteIds"`
+}
+
+// WebhookDelivery is the delivery log entry of an event sent to a webhook. failed deliveries keep
+// the body they tried to send, so they can be replayed from the dead letters.
+type WebhookDelivery struct {
+	WebhookId      string
+	DeliveryId     string
+	EventId        string
+	EventType      string
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	Error          string
+	DeliveredAt    time.Time
+	DurationMs     int64
+	Body           string
+}
+
+// WebhookDispatcher queues the events of an update run and delivers them to the webhooks
+// subscribed to them. record is given every delivery, successful or not.
+type WebhookDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	sleep       func(context.Context, time.Duration) error
+	now         func() time.Time
+	record      func(context.Context, WebhookDelivery) error
+	events      []WebhookEvent
+}
+
+// isPublicAddress reports whether the ip is on the public internet, rather than being a private,
+// loopback, link-local or otherwise internal address.
+func isPublicAddress(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// refusePrivateAddresses is a dialer control that refuses to connect to anything but public
+// addresses. it checks the address being dialed after it has been resolved, so a webhook host
+// can't be pointed at the lambda's network after it was registered.
+func refusePrivateAddresses(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddress(net.ParseIP(host)) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newDeliveryTransport returns the transport deliveries are posted through. it doesn't go through
+// a proxy, so the dialer sees the webhook's own address, and only when running locally can it
+// deliver to private addresses.
+func newDeliveryTransport() *http.Transport {
+	dialer := &net.Dialer{Timeout: deliveryTimeout, KeepAlive: 30 * time.Second}
+	if !isLocal {
+		dialer.Control = refusePrivateAddresses
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// newWebhookDispatcher returns a dispatcher that logs its deliveries to dynamodb.
+func newWebhookDispatcher(dbClient *dynamodb.DynamoDB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		client:      &http.Client{Transport: tracingTransport{base: newDeliveryTransport()}, Timeout: deliveryTimeout},
+		maxAttempts: maxDeliveryAttempts,
+		baseDelay:   deliveryBaseDelay,
+		sleep:       sleepContext,
+		now:         time.Now,
+		record: func(ctx context.Context, delivery WebhookDelivery) error {
+			return recordDelivery(ctx, dbClient, delivery)
+		},
+	}
+}
+
+// sleepContext waits for the delay, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// randomId returns a random hex id.
+func randomId() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// signWebhook returns the hex encoded hmac-sha256 of the timestamp and body, keyed by the
+// webhook's secret. receivers check it against the signature header.
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhook.Wants reports whether the webhook is subscribed to the event type.
+func (hook Webhook) Wants(eventType string) bool {
+	return slices.Contains(hook.Events, eventType) || slices.Contains(hook.Events, eventAll)
+}
+
+// WebhookDispatcher.Queue adds an event to be delivered when the run is dispatched.
+func (d *WebhookDispatcher) Queue(eventType string, data interface{}) {
+	d.events = append(d.events, WebhookEvent{
+		Id:        randomId(),
+		Type:      eventType,
+		CreatedAt: d.now().UTC(),
+		Data:      data,
+	})
+}
+
+// WebhookDispatcher.QueueRun adds the update.completed event of the run. receivers are sent the
+// run as the status routes serve it, its errors stay in the logs.
+func (d *WebhookDispatcher) QueueRun(run *UpdateRun) {
+	d.Queue(eventUpdateCompleted, run.Served())
+}
+
+// isRetryable reports whether a delivery that got the status could succeed if tried again.
+func isRetryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= http.StatusInternalServerError
+}
+
+// WebhookDispatcher.send makes a single attempt at posting the body to the webhook.
+func (d *WebhookDispatcher) send(ctx context.Context, hook Webhook, event WebhookEvent, deliveryId string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "petrol-price-webhooks")
+	req.Header.Set(webhookEventHeader, event.Type)
+	req.Header.Set(webhookDeliveryHeader, deliveryId)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(hook.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(res.Body, int64(maxLoggedResponse)))
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return res.StatusCode, fmt.Errorf("webhook responded %d: %s", res.StatusCode, responseBody)
+	}
+	return res.StatusCode, nil
+}
+
+// WebhookDispatcher.Deliver posts the event to the webhook, retrying with exponential backoff
+// while the failures look temporary and the context isn't done.
+func (d *WebhookDispatcher) Deliver(ctx context.Context, hook Webhook, event WebhookEvent) WebhookDelivery {
+	started := d.now()
+	delivery := WebhookDelivery{
+		WebhookId:  hook.WebhookId,
+		DeliveryId: started.UTC().Format(runTimeLayout) + "#" + event.Id,
+		EventId:    event.Id,
+		EventType:  event.Type,
+		Status:     deliveryFailed,
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	for delivery.Attempts < d.maxAttempts {
+		if delivery.Attempts > 0 {
+			err = d.sleep(ctx, d.baseDelay<<(delivery.Attempts-1))
+			if err != nil {
+				delivery.Error = err.Error()
+				break
+			}
+		}
+		delivery.Attempts++
+
+		status, err := d.send(ctx, hook, event, delivery.DeliveryId, body)
+		delivery.ResponseStatus = status
+		if err == nil {
+			delivery.Status = deliverySucceeded
+			delivery.Error = ""
+			break
+		}
+
+		delivery.Error = err.Error()
+		if status != 0 && !isRetryable(status) {
+			break
+		}
+	}
+
+	delivery.DeliveredAt = d.now().UTC()
+	delivery.DurationMs = delivery.DeliveredAt.Sub(started).Milliseconds()
+	if delivery.Status == deliveryFailed {
+		delivery.Body = string(body)
+	}
+	return delivery
+}
+
+// WebhookDispatcher.Dispatch delivers the queued events to the webhooks that want them. each
+// webhook is sent its events in order, and the webhooks are sent to at the same time.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context, hooks []Webhook) []WebhookDelivery {
+	ctx, span := startSpan(ctx, "dispatchWebhooks", attribute.Int("events", len(d.events)), attribute.Int("webhooks", len(hooks)))
+	defer span.End()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	deliveries := []WebhookDelivery{}
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook Webhook) {
+			defer wg.Done()
+			for _, event := range d.events {
+				if !hook.Wants(event.Type) {
+					continue
+				}
+
+				delivery := d.Deliver(ctx, hook, event)
+				err := d.record(ctx, delivery)
+				if err != nil {
+					logger.Error("error while recording webhook delivery", "webhook_id", hook.WebhookId, "error", err)
+				}
+
+				mu.Lock()
+				deliveries = append(deliveries, delivery)
+				mu.Unlock()
+			}
+		}(hook)
+	}
+	wg.Wait()
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].DeliveryId < deliveries[j].DeliveryId })
+	d.events = nil
+	return deliveries
+}
+
+// WebhookDelivery.Marshal returns a dynamodb representation of the WebhookDelivery struct.
+func (delivery WebhookDelivery) Marshal() map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"WebhookId":      {S: aws.String(delivery.WebhookId)},
+		"DeliveryId":     {S: aws.String(delivery.DeliveryId)},
+		"EventId":        {S: aws.String(delivery.EventId)},
+		"EventType":      {S: aws.String(delivery.EventType)},
+		"Status":         {S: aws.String(delivery.Status)},
+		"Attempts":       {N: aws.String(strconv.Itoa(delivery.Attempts))},
+		"ResponseStatus": {N: aws.String(strconv.Itoa(delivery.ResponseStatus))},
+		"DeliveredAt":    {S: aws.String(delivery.DeliveredAt.UTC().Format(time.RFC3339))},
+		"DurationMs":     {N: aws.String(strconv.FormatInt(delivery.DurationMs, 10))},
+	}
+	if delivery.Error != "" {
+		item["Error"] = &dynamodb.AttributeValue{S: aws.String(delivery.Error)}
+	}
+	return item
+}
+
+// recordDelivery writes the delivery to the delivery log, and failed deliveries to the dead
+// letters along with the body that couldn't be delivered.
+func recordDelivery(ctx context.Context, dbClient *dynamodb.DynamoDB, delivery WebhookDelivery) error {
+	_, err := dbClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(deliveriesTableName),
+		Item:      delivery.Marshal(),
+	})
+	if err != nil || delivery.Status != deliveryFailed {
+		return err
+	}
+
+	deadLetter := delivery.Marshal()
+	deadLetter["Body"] = &dynamodb.AttributeValue{S: aws.String(delivery.Body)}
+	_, err = dbClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(deadLettersTableName),
+		Item:      deadLetter,
+	})
+	return err
+}
+
+func (hook *Webhook) Unmarshal(record map[string]*dynamodb.AttributeValue) error {
+	webhookIdRecord, ok := record["WebhookId"]
+	if !ok {
+		return errors.New("webhook record is missing WebhookId")
+	}
+	hook.WebhookId = aws.StringValue(webhookIdRecord.S)
+
+	if ownerRecord, ok := record["Owner"]; ok {
+		hook.Owner = aws.StringValue(ownerRecord.S)
+	}
+	if urlRecord, ok := record["Url"]; ok {
+		hook.Url = aws.StringValue(urlRecord.S)
+	}
+	if secretRecord, ok := record["Secret"]; ok {
+		hook.Secret = aws.StringValue(secretRecord.S)
+	}
+
+	hook.Events = []string{}
+	if eventsRecord, ok := record["Events"]; ok {
+		hook.Events = aws.StringValueSlice(eventsRecord.SS)
+	}
+	return nil
+}
+
+// getWebhooks returns every registered webhook, there being no webhooks table yet is the same as
+// there being no webhooks.
+func getWebhooks(ctx context.Context, dbClient *dynamodb.DynamoDB) ([]Webhook, error) {
+	hooks := []Webhook{}
+	records, err := scanTable(ctx, dbClient, webhooksTableName)
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return hooks, nil
+		}
+		return nil, err
+	}
+
+	for _, record := range records {
+		var hook Webhook
+		err = hook.Unmarshal(record)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// dispatchWebhooks delivers the run's events to the registered webhooks. a failed dispatch is
+// logged rather than failing the run, its prices have already been written.
+func dispatchWebhooks(ctx context.Context, dbClient *dynamodb.DynamoDB, dispatcher *WebhookDispatcher) {
+	hooks, err := getWebhooks(ctx, dbClient)
+	if err != nil {
+		logger.Error("error while getting webhooks", "error", err)
+		return
+	}
+
+	deliveries := dispatcher.Dispatch(ctx, hooks)
+	failed := 0
+	for _, delivery := range deliveries {
+		if delivery.Status == deliveryFailed {
+			failed++
+		}
+	}
+	logger.Info("dispatched webhooks", "webhooks", len(hooks), "deliveries", len(deliveries), "failed", failed)
+}
+
+// webhookSites returns the sites as they are sent in events, ordered by site id.
+func webhookSites(sites []PetrolStationSite) []WebhookSite {
+	result := []WebhookSite{}
+	for _, site := range sites {
+		result = append(result, WebhookSite{
+			SiteId:    site.SiteID,
+			Name:      site.Name,
+			Address:   site.Address,
+			Postcode:  site.Postcode,
+			BrandId:   site.BrandID,
+			RegionId:  site.RegionID,
+			Latitude:  site.Latitude,
+			Longitude: site.Longitude,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].SiteId < result[j].SiteId })
+	return result
+}
+
+// changedStations returns the stations of the list ordered by site id.
+func changedStations(prices FuelPriceList) []FuelStation {
+	stations := []FuelStation{}
+	for _, station := range prices.Sites {
+		stations = append(stations, station)
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].SiteID < stations[j].SiteID })
+	return stations
+}
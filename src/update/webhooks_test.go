@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// testReceiver is a local webhook receiver that checks signatures and fails the first failures
+// requests with the status.
+type testReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	failures int
+	status   int
+	requests int
+	events   []WebhookEvent
+	invalid  int
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+
+	body, _ := io.ReadAll(req.Body)
+	timestamp := req.Header.Get(webhookTimestampHeader)
+	if req.Header.Get(webhookSignatureHeader) != signWebhook(r.secret, timestamp, body) {
+		r.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.requests <= r.failures {
+		w.WriteHeader(r.status)
+		return
+	}
+
+	var event WebhookEvent
+	_ = json.Unmarshal(body, &event)
+	r.events = append(r.events, event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newTestDispatcher returns a dispatcher that doesn't wait between attempts and keeps its
+// deliveries in memory.
+func newTestDispatcher(recorded *[]WebhookDelivery, delays *[]time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		client:      http.DefaultClient,
+		maxAttempts: maxDeliveryAttempts,
+		baseDelay:   deliveryBaseDelay,
+		sleep: func(ctx context.Context, delay time.Duration) error {
+			*delays = append(*delays, delay)
+			return nil
+		},
+		now: time.Now,
+		record: func(ctx context.Context, delivery WebhookDelivery) error {
+			*recorded = append(*recorded, delivery)
+			return nil
+		},
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	receiver := &testReceiver{secret: "secret", failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	recorded, delays := []WebhookDelivery{}, []time.Duration{}
+	dispatcher := newTestDispatcher(&recorded, &delays)
+	dispatcher.Queue(eventPriceChanged, PriceChangedData{Sites: []FuelStation{{SiteID: 1}}})
+	dispatcher.Queue(eventSiteAdded, SitesAddedData{Sites: []WebhookSite{{SiteId: 2}}})
+
+	hook := Webhook{WebhookId: "hook", Url: server.URL, Secret: "secret", Events: []string{eventPriceChanged}}
+	deliveries := dispatcher.Dispatch(context.Background(), []Webhook{hook})
+
+	if len(deliveries) != 1 || len(recorded) != 1 {
+		t.Fatalf("expected only the price.changed event to be delivered, got %+v", deliveries)
+	}
+	delivery := deliveries[0]
+	if delivery.Status != deliverySucceeded || delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusNoContent || delivery.Body != "" {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+	if len(delays) != 2 || delays[0] != deliveryBaseDelay || delays[1] != 2*deliveryBaseDelay {
+		t.Errorf("expected exponential backoff, got %v", delays)
+	}
+	if receiver.invalid != 0 || len(receiver.events) != 1 || receiver.events[0].Type != eventPriceChanged {
+		t.Errorf("unexpected received events: %+v, %d invalid signatures", receiver.events, receiver.invalid)
+	}
+}
+
+func TestWebhookRunHidesErrors(t *testing.T) {
+	receiver := &testReceiver{secret: "secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	recorded, delays := []WebhookDelivery{}, []time.Duration{}
+	dispatcher := newTestDispatcher(&recorded, &delays)
+	dispatcher.QueueRun(&UpdateRun{RunId: "run-7", Status: runFailed, Errors: []string{"dial tcp 10.0.0.12:443: connection refused"}})
+
+	hook := Webhook{WebhookId: "hook", Url: server.URL, Secret: "secret", Events: []string{eventUpdateCompleted}}
+	deliveries := dispatcher.Dispatch(context.Background(), []Webhook{hook})
+	if len(deliveries) != 1 || len(receiver.events) != 1 {
+		t.Fatalf("expected the update.completed event to be delivered, got %+v", deliveries)
+	}
+
+	body, _ := json.Marshal(receiver.events[0].Data)
+	if strings.Contains(string(body), "10.0.0.12") || !strings.Contains(string(body), "see the logs of run run-7") {
+		t.Errorf("expected the run's errors to be hidden, got %s", body)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+	}{
+		{"server error", http.StatusInternalServerError, maxDeliveryAttempts},
+		{"client error", http.StatusGone, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := &testReceiver{secret: "secret", failures: 100, status: test.status}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			recorded, delays := []WebhookDelivery{}, []time.Duration{}
+			dispatcher := newTestDispatcher(&recorded, &delays)
+			dispatcher.Queue(eventUpdateCompleted, &UpdateRun{RunId: "run"})
+
+			hook := Webhook{WebhookId: "hook", Url: server.URL, Secret: "secret", Events: []string{eventAll}}
+			deliveries := dispatcher.Dispatch(context.Background(), []Webhook{hook})
+
+			if len(deliveries) != 1 {
+				t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+			}
+			delivery := deliveries[0]
+			if delivery.Status != deliveryFailed || delivery.Attempts != test.attempts || delivery.ResponseStatus != test.status {
+				t.Errorf("unexpected delivery: %+v", delivery)
+			}
+			if delivery.Body == "" || delivery.Error == "" {
+				t.Errorf("expected a dead letter with the body and error, got %+v", delivery)
+			}
+
+			item := delivery.Marshal()
+			if *item["Status"].S != deliveryFailed || *item["WebhookId"].S != "hook" {
+				t.Errorf("unexpected delivery item: %v", item)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveryStopsWithContext(t *testing.T) {
+	receiver := &testReceiver{secret: "secret", failures: 100, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	recorded, delays := []WebhookDelivery{}, []time.Duration{}
+	dispatcher := newTestDispatcher(&recorded, &delays)
+	dispatcher.baseDelay = time.Hour
+	dispatcher.sleep = sleepContext
+
+	// the first attempt fails, and the context is done before the retry is due.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	hook := Webhook{WebhookId: "hook", Url: server.URL, Secret: "secret", Events: []string{eventAll}}
+	delivery := dispatcher.Deliver(ctx, hook, WebhookEvent{Id: "event", Type: eventUpdateCompleted})
+
+	if delivery.Status != deliveryFailed || delivery.Attempts != 1 || delivery.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the delivery to give up when the context was done, got %+v", delivery)
+	}
+}
+
+func TestWebhookDeliveryRefusesPrivateAddresses(t *testing.T) {
+	receiver := &testReceiver{secret: "secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// the test server listens on loopback, which a real dispatcher mustn't connect to.
+	dispatcher := newWebhookDispatcher(nil)
+	dispatcher.sleep = func(context.Context, time.Duration) error { return nil }
+	hook := Webhook{WebhookId: "hook", Url: server.URL, Secret: "secret", Events: []string{eventAll}}
+	delivery := dispatcher.Deliver(context.Background(), hook, WebhookEvent{Id: "event", Type: eventUpdateCompleted})
+
+	if delivery.Status != deliveryFailed || !strings.Contains(delivery.Error, errPrivateAddress.Error()) {
+		t.Errorf("expected the delivery to be refused, got %+v", delivery)
+	}
+	if receiver.requests != 0 {
+		t.Errorf("expected nothing to reach the receiver, got %d requests", receiver.requests)
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.215.14":    true,
+		"2606:4700::1111":  true,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.0.1":      false,
+		"127.0.0.1":        false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	}
+	for address, expected := range tests {
+		if isPublicAddress(net.ParseIP(address)) != expected {
+			t.Errorf("expected %s to be public: %t", address, expected)
+		}
+	}
+}
+
+// testDynamo is a local dynamodb endpoint that accepts every request, and counts the items put to
+// each table. scans of a table in scans return its items, given in dynamodb's json.
+type testDynamo struct {
+	mu    sync.Mutex
+	puts  map[string]int
+	scans map[string]string
+}
+
+func (d *testDynamo) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var input struct {
+		TableName string
+	}
+	_ = json.NewDecoder(req.Body).Decode(&input)
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if items, ok := d.scans[input.TableName]; ok && strings.HasSuffix(req.Header.Get("X-Amz-Target"), ".Scan") {
+		w.Write([]byte(`{"Items":` + items + `}`))
+		return
+	}
+
+	d.mu.Lock()
+	d.puts[input.TableName]++
+	d.mu.Unlock()
+	w.Write([]byte("{}"))
+}
+
+// newTestDynamoClient returns a client of the local dynamodb endpoint.
+func newTestDynamoClient(t *testing.T, dynamo *testDynamo) *dynamodb.DynamoDB {
+	server := httptest.NewServer(dynamo)
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("ap-southeast-2").
+		WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamodb.New(sess)
+}
+
+// TestWebhookDispatchRecords delivers to several webhooks at once through an instrumented client,
+// so recording the deliveries puts metrics from every webhook's goroutine. run it with -race.
+func TestWebhookDispatchRecords(t *testing.T) {
+	dynamo := &testDynamo{puts: map[string]int{}}
+	client := newTestDynamoClient(t, dynamo)
+
+	receiver := &testReceiver{secret: "secret", failures: 2, status: http.StatusGone}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	metrics = newMetrics(map[string]string{})
+	dispatcher := newWebhookDispatcher(instrumentClient(client))
+	dispatcher.client = http.DefaultClient
+	dispatcher.sleep = func(context.Context, time.Duration) error { return nil }
+	dispatcher.Queue(eventPriceChanged, PriceChangedData{Sites: []FuelStation{{SiteID: 1}}})
+	dispatcher.Queue(eventUpdateCompleted, &UpdateRun{RunId: "run"})
+
+	hooks := []Webhook{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		hooks = append(hooks, Webhook{WebhookId: id, Url: server.URL, Secret: "secret", Events: []string{eventAll}})
+	}
+	deliveries := dispatcher.Dispatch(context.Background(), hooks)
+
+	if len(deliveries) != 8 {
+		t.Fatalf("expected 8 deliveries, got %d", len(deliveries))
+	}
+	if dynamo.puts[deliveriesTableName] != 8 || dynamo.puts[deadLettersTableName] != 2 {
+		t.Errorf("expected every delivery and the 2 failures to be recorded, got %v", dynamo.puts)
+	}
+
+	latencies := 0
+	for _, document := range metrics.Documents(time.Now()) {
+		if document["Operation"] == "PutItem" {
+			latencies = len(document["DynamoDBLatency"].([]float64))
+		}
+	}
+	if latencies != 10 {
+		t.Errorf("expected the latency of 10 puts, got %d", latencies)
+	}
+}
+
+func TestPetrolStationListAddedRemoved(t *testing.T) {
+	stored := PetrolStationList{Sites: []PetrolStationSite{{SiteID: 3}, {SiteID: 1}, {SiteID: 2}}}
+	latest := PetrolStationList{Sites: []PetrolStationSite{{SiteID: 2}, {SiteID: 4}}}
+
+	added := stored.Added(latest)
+	if len(added) != 1 || added[0].SiteID != 4 {
+		t.Errorf("unexpected added sites: %+v", added)
+	}
+
+	removed := stored.Removed(latest)
+	if len(removed) != 2 || removed[0] != 1 || removed[1] != 3 {
+		t.Errorf("unexpected removed sites: %v", removed)
+	}
+}